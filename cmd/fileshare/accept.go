@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pjmessi/go_file_share/receiver"
+)
+
+// acceptPrompt asks on the terminal whether each incoming file should be
+// received, with -select which of the offered entries, and which of several
+// senders found. Files the user doesn't answer for within the timeout are
+// declined, and no entries or senders are picked.
+type acceptPrompt struct {
+	ctx     context.Context
+	timeout time.Duration
+	lines   <-chan string
+
+	// concurrent daemon transfers take turns asking
+	mu sync.Mutex
+	// acceptAll holds the senders the user accepted all remaining files from
+	acceptAll map[string]bool
+	// senders names the senders found through discovery by address
+	senders map[string]string
+}
+
+func newAcceptPrompt(ctx context.Context, in io.Reader, timeout time.Duration) *acceptPrompt {
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	return &acceptPrompt{ctx: ctx, timeout: timeout, lines: lines, acceptAll: map[string]bool{}, senders: map[string]string{}}
+}
+
+// discovered is the receiver's PeerDiscovered callback, which lets the
+// prompt name the senders it asks about.
+func (p *acceptPrompt) discovered(ctx context.Context, peer receiver.Peer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.senders[peer.Addr] = peer.String()
+}
+
+// accept is the receiver's AcceptFunc.
+func (p *acceptPrompt) accept(name string, size int64, peer string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.acceptAll[peer] {
+		return true
+	}
+
+	p.drain()
+
+	// the log output clears the progress bar first
+	out := log.Writer()
+	sizeText := "size unknown"
+	if size >= 0 {
+		sizeText = formatBytes(uint64(size))
+	}
+	fmt.Fprintf(out, "accept %s (%s) from %s? [y]es, [n]o, [a]ll from this sender: ", name, sizeText, p.sender(peer))
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	select {
+	case line, ok := <-p.lines:
+		if !ok {
+			fmt.Fprintln(out)
+			log.Printf("no answer on stdin, declining %s (use -yes to accept everything)", name)
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true
+		case "a", "all":
+			p.acceptAll[peer] = true
+			return true
+		default:
+			return false
+		}
+	case <-timer.C:
+		fmt.Fprintln(out)
+		log.Printf("no answer within %s, declining %s", p.timeout, name)
+		return false
+	case <-p.ctx.Done():
+		fmt.Fprintln(out)
+		return false
+	}
+}
+
+// pick is the receiver's SelectFunc. It lists the offered entries and reads
+// the numbers of those to receive, or ranges of them: "1,3-5". "a" picks all
+// of them, and no answer none.
+func (p *acceptPrompt) pick(offer []receiver.OfferedEntry, peer string) []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.drain()
+
+	out := log.Writer()
+	fmt.Fprintf(out, "%s offers %d entries:\n", p.sender(peer), len(offer))
+	for i, entry := range offer {
+		switch {
+		case entry.Dir:
+			fmt.Fprintf(out, "%5d  %s/\n", i+1, entry.Name)
+		case entry.Symlink:
+			fmt.Fprintf(out, "%5d  %s (symlink)\n", i+1, entry.Name)
+		case entry.Size < 0:
+			fmt.Fprintf(out, "%5d  %s (size unknown)\n", i+1, entry.Name)
+		default:
+			fmt.Fprintf(out, "%5d  %s (%s)\n", i+1, entry.Name, formatBytes(uint64(entry.Size)))
+		}
+	}
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	for {
+		fmt.Fprint(out, "entries to receive, e.g. 1,3-5, [a]ll or nothing to end: ")
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				fmt.Fprintln(out)
+				log.Printf("no answer on stdin, receiving nothing from %s", p.sender(peer))
+				return nil
+			}
+			picked, err := parsePicks(line, len(offer))
+			if err != nil {
+				log.Printf("%s", err)
+				continue
+			}
+			return picked
+		case <-timer.C:
+			fmt.Fprintln(out)
+			log.Printf("no answer within %s, receiving nothing from %s", p.timeout, p.sender(peer))
+			return nil
+		case <-p.ctx.Done():
+			fmt.Fprintln(out)
+			return nil
+		}
+	}
+}
+
+// choosePeers is the receiver's WithSelectPeers func. It lists the senders
+// found, with what they offer, and reads the numbers of those to receive
+// from, as pick does. A single sender is taken without asking.
+func (p *acceptPrompt) choosePeers(peers []receiver.Peer) []receiver.Peer {
+	if len(peers) < 2 {
+		return peers
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.drain()
+
+	out := log.Writer()
+	fmt.Fprintf(out, "found %d senders:\n", len(peers))
+	for i, peer := range peers {
+		if offer := peer.Offer(); offer != "" {
+			fmt.Fprintf(out, "%5d  %s at %s: %s\n", i+1, peer.Name(), peer.Addr, offer)
+		} else {
+			fmt.Fprintf(out, "%5d  %s at %s\n", i+1, peer.Name(), peer.Addr)
+		}
+	}
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	for {
+		fmt.Fprint(out, "senders to receive from, e.g. 1,3, [a]ll or nothing to end: ")
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				fmt.Fprintln(out)
+				log.Printf("no answer on stdin, receiving from no sender (use -first to skip asking)")
+				return nil
+			}
+			picked, err := parsePicks(line, len(peers))
+			if err != nil {
+				log.Printf("%s", err)
+				continue
+			}
+			chosen := make([]receiver.Peer, 0, len(picked))
+			for _, i := range picked {
+				chosen = append(chosen, peers[i])
+			}
+			return chosen
+		case <-timer.C:
+			fmt.Fprintln(out)
+			log.Printf("no answer within %s, receiving from no sender", p.timeout)
+			return nil
+		case <-p.ctx.Done():
+			fmt.Fprintln(out)
+			return nil
+		}
+	}
+}
+
+// parsePicks parses the answer to pick or choosePeers for a list of n into
+// the indexes picked.
+func parsePicks(answer string, n int) ([]int, error) {
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	switch answer {
+	case "":
+		return nil, nil
+	case "a", "all":
+		picked := make([]int, n)
+		for i := range picked {
+			picked[i] = i
+		}
+		return picked, nil
+	}
+
+	var picked []int
+	for _, part := range strings.Split(answer, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %s", part)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+				return nil, fmt.Errorf("invalid range: %s", part)
+			}
+		}
+		if from < 1 || to > n || from > to {
+			return nil, fmt.Errorf("%s is not among 1-%d", strings.TrimSpace(part), n)
+		}
+		for i := from; i <= to; i++ {
+			picked = append(picked, i-1)
+		}
+	}
+	slices.Sort(picked)
+
+	return slices.Compact(picked), nil
+}
+
+// drain drops anything typed while nobody was asking.
+func (p *acceptPrompt) drain() {
+	for drained := false; !drained; {
+		select {
+		case _, ok := <-p.lines:
+			drained = !ok
+		default:
+			drained = true
+		}
+	}
+}
+
+// sender names peer as discovery found it, if it did.
+func (p *acceptPrompt) sender(peer string) string {
+	if known, ok := p.senders[peer]; ok {
+		return known
+	}
+	return peer
+}
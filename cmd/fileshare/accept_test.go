@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/receiver"
+)
+
+func TestParsePicks(t *testing.T) {
+	tests := []struct {
+		answer  string
+		want    []int
+		wantErr bool
+	}{
+		{answer: "", want: nil},
+		{answer: "2", want: []int{1}},
+		{answer: " 1, 3 ", want: []int{0, 2}},
+		{answer: "2-4", want: []int{1, 2, 3}},
+		{answer: "4,1-2", want: []int{0, 1, 3}},
+		{answer: "1-3,2,2", want: []int{0, 1, 2}},
+		{answer: "a", want: []int{0, 1, 2, 3, 4}},
+		{answer: "ALL", want: []int{0, 1, 2, 3, 4}},
+		{answer: "0", wantErr: true},
+		{answer: "6", wantErr: true},
+		{answer: "4-6", wantErr: true},
+		{answer: "3-2", wantErr: true},
+		{answer: "x", wantErr: true},
+		{answer: "1-x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.answer, func(t *testing.T) {
+			got, err := parsePicks(tt.answer, 5)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePicks(%q) = %v, want an error: %t", tt.answer, err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parsePicks(%q) = %v, want %v", tt.answer, got, tt.want)
+			}
+		})
+	}
+}
+
+// answerer types answers into a prompt, the next one every time the prompt
+// asks, so none is drained as typed ahead.
+type answerer struct {
+	mu      sync.Mutex
+	out     strings.Builder
+	prompt  string
+	answers []string
+	lines   chan string
+}
+
+func (a *answerer) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.out.Write(p)
+	if strings.Contains(string(p), a.prompt) && len(a.answers) > 0 {
+		answer := a.answers[0]
+		a.answers = a.answers[1:]
+		go func() { a.lines <- answer }()
+	}
+	return len(p), nil
+}
+
+func (a *answerer) String() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.out.String()
+}
+
+// answeredPrompt returns a prompt timing out after timeout whose questions
+// are answered in turn with answers, and the output it writes to, for the
+// rest of the test.
+func answeredPrompt(t *testing.T, timeout time.Duration, prompt string, answers ...string) (*acceptPrompt, *answerer) {
+	t.Helper()
+
+	a := &answerer{prompt: prompt, answers: answers, lines: make(chan string)}
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(a)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	})
+
+	return &acceptPrompt{
+		ctx:       context.Background(),
+		timeout:   timeout,
+		lines:     a.lines,
+		acceptAll: map[string]bool{},
+		senders:   map[string]string{},
+	}, a
+}
+
+func TestChoosePeers(t *testing.T) {
+	peers := []receiver.Peer{{Addr: "192.0.2.1:9000"}, {Addr: "192.0.2.2:9000"}, {Addr: "192.0.2.3:9000"}}
+	tests := []struct {
+		name    string
+		peers   []receiver.Peer
+		answers []string
+		want    []string
+		// wantOut is written to the terminal along the way
+		wantOut string
+	}{
+		{"picked", peers, []string{"1,3"}, []string{"192.0.2.1:9000", "192.0.2.3:9000"}, "found 3 senders"},
+		{"all", peers, []string{"a"}, []string{"192.0.2.1:9000", "192.0.2.2:9000", "192.0.2.3:9000"}, ""},
+		{"asked again", peers, []string{"4", "2"}, []string{"192.0.2.2:9000"}, "4 is not among 1-3"},
+		{"nothing", peers, []string{""}, nil, ""},
+		{"no answer", peers, nil, nil, "no answer within 50ms, receiving from no sender"},
+		{"single sender", peers[:1], nil, []string{"192.0.2.1:9000"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the prompt only times out where nobody answers
+			timeout := 10 * time.Second
+			if tt.answers == nil {
+				timeout = 50 * time.Millisecond
+			}
+			p, out := answeredPrompt(t, timeout, "senders to receive from", tt.answers...)
+
+			var got []string
+			for _, peer := range p.choosePeers(tt.peers) {
+				got = append(got, peer.Addr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("choosePeers = %q, want %q", got, tt.want)
+			}
+			if !strings.Contains(out.String(), tt.wantOut) {
+				t.Errorf("wrote %q, want it to say %q", out.String(), tt.wantOut)
+			}
+		})
+	}
+}
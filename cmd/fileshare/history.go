@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pjmessi/go_file_share/receiver"
+)
+
+// runHistory implements the history subcommand, which prints the records of
+// a file written with -history-file, and returns the exit code.
+func runHistory(args []string) int {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the records as a JSON array")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: fileshare history [-json] FILE")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	records, err := receiver.ReadHistory(flags.Arg(0))
+	if err != nil {
+		log.Printf("err reading history: %s", err)
+		return 1
+	}
+
+	if err := printHistory(os.Stdout, records, *asJSON); err != nil {
+		log.Printf("err printing history: %s", err)
+		return 1
+	}
+
+	return 0
+}
+
+// printHistory writes history records to w, one line each for people or as
+// a JSON array for scripts.
+func printHistory(w io.Writer, records []receiver.HistoryRecord, asJSON bool) error {
+	if asJSON {
+		if records == nil {
+			records = []receiver.HistoryRecord{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+
+	for _, rec := range records {
+		line := fmt.Sprintf("%s  %-8s  %s  %s  %s", rec.Time.Local().Format(time.DateTime), rec.Outcome, rec.Peer, rec.Name, formatBytes(rec.Size))
+		if rec.Outcome == receiver.OutcomeOK {
+			line += fmt.Sprintf("  %s  sha256:%s", rec.Duration.Round(time.Millisecond), rec.Checksum)
+		}
+		if rec.Error != "" {
+			line += "  " + rec.Error
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,625 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/pjmessi/go_file_share/receiver"
+	"github.com/pjmessi/go_file_share/retry"
+	"github.com/pjmessi/go_file_share/sender"
+)
+
+// Exit codes of the receiver and sender, one per class of failure so that scripts can
+// react to them. Anything else exits with 1.
+const (
+	// exitFileExists is used when -on-conflict=error refused to replace an
+	// existing file.
+	exitFileExists = 3
+	// exitNoSender is used when -discovery-timeout elapsed without any
+	// sender announcing itself.
+	exitNoSender = 4
+	// exitChecksumMismatch is used when a file arrived corrupted.
+	exitChecksumMismatch = 5
+	// exitFileTooLarge is used when a file exceeded -max-file-size.
+	exitFileTooLarge = 6
+	// exitDiskFull is used when the destination has no room for a file.
+	exitDiskFull = 7
+	// exitProtocol is used when the sender violated the protocol.
+	exitProtocol = 8
+	// exitAuth is used when the sender failed authentication or presented
+	// the wrong certificate.
+	exitAuth = 9
+	// exitNoReceiver is used when -timeout or -announce-for elapsed without
+	// any receiver connecting to the sender.
+	exitNoReceiver = 10
+	// exitInterrupted is used when the receiver was stopped by a signal
+	// during a transfer, following the shell's 128+SIGINT convention.
+	exitInterrupted = 130
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		os.Exit(runHistory(os.Args[2:]))
+	}
+
+	// "send" and "receive" pick the side up front instead of asking for it
+	args := os.Args[1:]
+	var purpose string
+	if len(args) > 0 && (args[0] == "send" || args[0] == "receive") {
+		purpose, args = args[0][:1], args[1:]
+	}
+
+	var port string
+	var preserveFilename bool
+	var destDir string
+	var onConflict string
+	var resume bool
+	var compress bool
+	var useTLS bool
+	var tlsCert, tlsKey string
+	var tlsInsecure bool
+	var tlsFingerprint string
+	var sharedKey string
+	var noPerms bool
+	var noModTime bool
+	var discoveryTimeout time.Duration
+	var discoveryWindow time.Duration
+	var maxPeers int
+	var discovery string
+	var multicastGroup string
+	var multicastIface string
+	var ipFamily string
+	var iface string
+	var peerAddr string
+	var noProgress bool
+	var daemon bool
+	var maxFiles int
+	var maxConcurrent int
+	var drainTimeout time.Duration
+	var idleTimeout time.Duration
+	var acceptAll bool
+	var acceptTimeout time.Duration
+	var picks []string
+	var allowedPeers []string
+	var deniedPeers []string
+	var selectEntries bool
+	var listOffers bool
+	var firstPeers bool
+	var clockTolerance time.Duration
+	var maxAnnounceAge time.Duration
+	var rateLimit int64
+	var maxFileSize uint64
+	var strictNames bool
+	var reconnects int
+	var historyFile string
+	var metricsAddr string
+	var webAddr string
+	var httpAddr string
+	var webhookURL string
+	var webhookSecret string
+	var notify bool
+	var keepPartials bool
+	var syncFiles bool
+	var noSpaceCheck bool
+	var nameTemplate string
+	var blocks bool
+	var transportName string
+	var mtu int
+	var streams int
+	var readModeName string
+	var symlinksName string
+	var tarDirs bool
+	var stdinName string
+	var extract bool
+	var noDelay bool
+	var readBuffer, writeBuffer int
+	var keepAlive time.Duration
+	var jsonOutput bool
+	var announceInterval, announceFor, receiverTimeout time.Duration
+	var waitForever bool
+	var serve bool
+	var maxTransfers int
+	var maxReceivers int
+	var totalRateLimit int64
+	var senderName string
+	var attempts int
+	var restartChanged bool
+	var watchDir string
+	var watchGrace time.Duration
+	var sentDir string
+	var queueConcurrency int
+	var includes, excludes []string
+	flag.StringVar(&port, "port", "0", "sender: port to listen on, 0 for a free one, which is announced")
+	flag.StringVar(&destDir, "dest", "", "directory to save received files in (default: current directory)")
+	flag.BoolVar(&preserveFilename, "preserve-name", false, "save received files under the sender's filename")
+	flag.StringVar(&onConflict, "on-conflict", "rename", "what to do when a received file already exists: rename, skip, overwrite or error")
+	flag.BoolVar(&resume, "resume", false, "continue interrupted transfers from their .part file (requires -preserve-name)")
+	flag.BoolVar(&blocks, "blocks", false, "sender: send content in CRC32C checked blocks, so the receiver only asks for corrupt blocks again")
+	flag.StringVar(&transportName, "transport", "tcp", "sender: serve over tcp, send file content over experimental reliable udp, or serve over quic; receiver: with -peer, quic connects using quic")
+	flag.IntVar(&streams, "streams", 1, "sender: send every file's content over this many tcp connections at once, for fast links with high latency")
+	flag.BoolVar(&noDelay, "tcp-nodelay", true, "send small writes right away rather than coalescing them into fewer packets")
+	flag.IntVar(&readBuffer, "rcvbuf", 0, "tcp receive buffer size in bytes, about bandwidth times round trip for large files over fast, distant links (default: the kernel's)")
+	flag.IntVar(&writeBuffer, "sndbuf", 0, "tcp send buffer size in bytes (default: the kernel's)")
+	flag.DurationVar(&keepAlive, "keepalive", 0, "interval of tcp keep-alive probes, negative to turn them off (default: 15s)")
+	flag.StringVar(&symlinksName, "symlinks", "skip", "sender: what to do with symlinks in sent directories: skip them, follow them, or preserve them for the receiver to recreate")
+	flag.StringVar(&readModeName, "read-mode", "buffered", "sender: read file content buffered, hand it to the kernel with sendfile (plain tcp on linux) or map it with mmap, the latter two for less cpu on large files")
+	flag.BoolVar(&tarDirs, "tar", false, "sender: send every directory as a single tar stream instead of entry by entry, to receivers that support it")
+	flag.StringVar(&senderName, "sender-name", "", "sender: name to announce the sender under, so receivers can tell whose files they are offered (default: the hostname)")
+	flag.StringVar(&stdinName, "name", "stdin", "sender: name to send the content read from stdin under, given as - among the files")
+	flag.BoolVar(&extract, "extract", false, "receiver: unpack directories sent as tar streams instead of saving them as NAME.tar")
+	flag.IntVar(&mtu, "mtu", protocol.DefaultMTU, "sender: size of the datagrams sent with -transport=udp")
+	flag.BoolVar(&compress, "compress", false, "gzip file content on the wire when the receiver supports it")
+	flag.BoolVar(&useTLS, "tls", false, "sender: serve transfers over tls; receiver: with -peer, connect using tls")
+	flag.StringVar(&tlsCert, "tls-cert", "", "sender: tls certificate file (default: generate a self-signed one)")
+	flag.StringVar(&tlsKey, "tls-key", "", "sender: tls private key file")
+	flag.BoolVar(&tlsInsecure, "tls-insecure", false, "receiver: accept any tls certificate")
+	flag.StringVar(&tlsFingerprint, "tls-fingerprint", "", "receiver: only accept the tls certificate with this sha256 fingerprint")
+	flag.StringVar(&sharedKey, "key", os.Getenv("FILESHARE_KEY"), "shared key senders must know to deliver files, and sign their announcements with (default: $FILESHARE_KEY)")
+	flag.DurationVar(&clockTolerance, "clock-tolerance", 2*time.Minute, "receiver: ignore announcements sent further than this from the local clock, to allow for clocks that are apart")
+	flag.DurationVar(&maxAnnounceAge, "max-announce-age", 10*time.Second, "receiver: ignore announcements sent this much before the freshest one of their sender, e.g. held back by a switch (0: take every one)")
+	flag.BoolVar(&noPerms, "no-perms", false, "receiver: don't apply the sender's file permissions")
+	flag.BoolVar(&noModTime, "no-mtime", false, "receiver: keep the time of arrival as modification time")
+	flag.DurationVar(&discoveryTimeout, "discovery-timeout", 0, "receiver: give up if no sender is found within this duration (default: wait forever)")
+	flag.DurationVar(&discoveryWindow, "discovery-window", 0, "receiver: after the first sender, keep collecting senders for this long and receive from all of them")
+	flag.IntVar(&maxPeers, "max-peers", 0, "receiver: stop collecting senders once this many were found (default: no limit)")
+	flag.StringVar(&discovery, "discovery", "broadcast", "comma-separated ways senders and receivers find each other: broadcast, multicast, mdns")
+	flag.StringVar(&multicastGroup, "multicast-group", protocol.DefaultMulticastGroup, "group used by -discovery=multicast")
+	flag.DurationVar(&announceInterval, "announce-interval", time.Second, "sender: how often to announce the sender to receivers")
+	flag.DurationVar(&announceFor, "announce-for", 0, "sender: stop announcing after this duration and give up if no receiver connected by then (default: announce for 10s, then keep waiting)")
+	flag.DurationVar(&receiverTimeout, "timeout", 0, "sender: give up if no receiver connected within this duration (default: wait forever)")
+	flag.BoolVar(&waitForever, "wait-forever", false, "sender: keep announcing the sender until interrupted")
+	flag.BoolVar(&serve, "serve", false, "sender: keep serving receivers and announcing the sender until interrupted, like a file server")
+	flag.IntVar(&maxTransfers, "max-transfers", 0, "sender: exit once this many receivers were served (default: serve until interrupted)")
+	flag.IntVar(&maxReceivers, "max-receivers", 0, "sender: how many receivers to serve at once, the others waiting their turn (default: no limit)")
+	flag.StringVar(&iface, "iface", "", "network interface to discover, announce and transfer through only, e.g. eth1 (default: chosen by the system)")
+	flag.StringVar(&multicastIface, "multicast-iface", "", "network interface used by -discovery=multicast (default: -iface, else chosen by the system)")
+	flag.StringVar(&ipFamily, "ip-family", "any", "IP versions -discovery=broadcast uses: ipv4, ipv6 or any (ipv6 announces to the all-nodes group, "+protocol.IPv6AllNodes+")")
+	flag.StringVar(&peerAddr, "peer", "", "receiver: connect straight to the sender at host:port instead of discovering it (add -tls or -transport=quic if it serves either)")
+	flag.BoolVar(&jsonOutput, "json", false, "receiver: print the transfer summary as json on stdout")
+	flag.BoolVar(&noProgress, "no-progress", false, "don't show transfer progress on stderr")
+	flag.BoolVar(&daemon, "daemon", false, "receiver: keep waiting for senders after each transfer until interrupted")
+	flag.IntVar(&maxFiles, "max-files", 0, "receiver: with -daemon, exit once this many files were received (default: no limit)")
+	flag.IntVar(&maxConcurrent, "max-concurrent", 4, "receiver: with -daemon, how many transfers may run at once")
+	flag.BoolVar(&acceptAll, "yes", false, "receiver: accept every incoming file instead of asking")
+	flag.DurationVar(&acceptTimeout, "accept-timeout", time.Minute, "receiver: decline a file nobody accepted within this duration")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 2*time.Minute, "receiver: give up on a transfer that made no progress for this long (0: wait forever)")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 30*time.Second, "receiver: with -daemon, how long an interrupt waits for transfers in flight to finish")
+	flag.Int64Var(&rateLimit, "rate-limit", 0, "cap the transfer rate in bytes per second, on the sender for each receiver (default: unlimited)")
+	flag.Int64Var(&totalRateLimit, "total-rate-limit", 0, "sender: cap the rate of all transfers together in bytes per second (default: unlimited)")
+	flag.Uint64Var(&maxFileSize, "max-file-size", 0, "receiver: reject files larger than this many bytes (default: unlimited)")
+	flag.BoolVar(&strictNames, "strict-names", false, "receiver: reject file names that aren't valid UTF-8 instead of percent-encoding the invalid bytes")
+	flag.IntVar(&reconnects, "reconnect", 0, "receiver: reconnect this many times when the connection drops mid-transfer (requires -resume and -preserve-name)")
+	flag.StringVar(&historyFile, "history-file", "", "receiver: append a JSON line per offered file to this file; print it with 'fileshare history FILE'")
+	flag.StringVar(&webhookURL, "webhook", "", "receiver: POST a JSON record to this url for every file saved, failed or declined")
+	flag.StringVar(&webhookSecret, "webhook-secret", os.Getenv("FILESHARE_WEBHOOK_SECRET"), "receiver: sign webhook bodies with this secret (default: $FILESHARE_WEBHOOK_SECRET)")
+	flag.StringVar(&nameTemplate, "name-template", "", "receiver: name received files after this template, e.g. {date}/{peer}/{name} or {stem}-{hash8}{ext}")
+	flag.BoolVar(&noSpaceCheck, "no-space-check", false, "receiver: accept files larger than the free disk space, e.g. sparse files")
+	flag.BoolVar(&syncFiles, "sync", false, "receiver: flush every file and its directory to disk before confirming it")
+	flag.BoolVar(&keepPartials, "keep-partials", false, "receiver: keep the partial file of a failed transfer as NAME.failed instead of removing it")
+	flag.BoolVar(&notify, "notify", false, "receiver: show a desktop notification for every file received")
+	flag.StringVar(&webAddr, "web", "", "receiver: also take files uploaded from a browser page served on this address, e.g. :8080 (best with -daemon)")
+	flag.StringVar(&httpAddr, "http", "", "sender: also serve the files over plain http on this address, e.g. :4101, for machines without fileshare")
+	flag.BoolVar(&restartChanged, "restart-changed", false, "sender: send a file that changed while it was sent again from the start, within -attempts, instead of failing the transfer")
+	flag.IntVar(&attempts, "attempts", retry.Default.MaxAttempts, "how often the receiver tries to connect to a sender, and the sender to send an entry the receiver failed to save")
+	flag.StringVar(&watchDir, "watch", "", "sender: keep sending the files showing up in this directory, each to the first receiver connecting for it (best with a receiver in -daemon mode)")
+	flag.DurationVar(&watchGrace, "watch-grace", 2*time.Second, "sender: with -watch, send a file once it stayed unchanged this long")
+	flag.StringVar(&sentDir, "sent-dir", "", "sender: with -watch, move sent files into this directory, relative to the watched one, e.g. sent")
+	flag.IntVar(&queueConcurrency, "queue-concurrency", 1, "sender: with -watch, send up to this many files at once, each on a free port")
+	flag.Func("exclude", "sender: leave out the entries of sent directories matching this gitignore-style pattern, e.g. '*.o' or 'node_modules'; repeatable", func(pattern string) error {
+		excludes = append(excludes, pattern)
+		return nil
+	})
+	flag.Func("include", "sender: only send the files of sent directories matching this gitignore-style pattern, e.g. '**/*.go'; repeatable, -exclude wins", func(pattern string) error {
+		includes = append(includes, pattern)
+		return nil
+	})
+	flag.Func("allow", "receiver: only take senders whose address is in this CIDR prefix, e.g. 192.168.1.0/24; repeatable", func(prefix string) error {
+		allowedPeers = append(allowedPeers, prefix)
+		return nil
+	})
+	flag.Func("deny", "receiver: ignore senders whose address is in this CIDR prefix, even if allowed; repeatable", func(prefix string) error {
+		deniedPeers = append(deniedPeers, prefix)
+		return nil
+	})
+	flag.Func("pick", "receiver: only receive the offered entries matching this gitignore-style pattern, e.g. '*.jpg', a directory with what is in it; repeatable", func(pattern string) error {
+		picks = append(picks, pattern)
+		return nil
+	})
+	flag.BoolVar(&listOffers, "list", false, "receiver: print the files the sender offers, with their size and modification time, and exit without receiving any")
+	flag.BoolVar(&firstPeers, "first", false, "receiver: don't ask which of several senders found to receive from, take the first one, or all of those found within -discovery-window")
+	flag.BoolVar(&selectEntries, "select", false, "receiver: list the offered entries and ask which to receive, e.g. 1,3-5")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "serve prometheus metrics at /metrics on this address, e.g. :9090")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: fileshare send [flags] FILE... (- for stdin)\n       fileshare receive [flags]\n       fileshare history [-json] FILE\nwithout send or receive, the side is asked for on stdin")
+		flag.PrintDefaults()
+	}
+	flag.CommandLine.Parse(args)
+
+	overwritePolicy, err := receiver.ParseOverwritePolicy(onConflict)
+	if err != nil {
+		log.Fatalf("invalid -on-conflict: %s", err)
+	}
+	transport, err := sender.ParseTransport(transportName)
+	if err != nil {
+		log.Fatalf("invalid -transport: %s", err)
+	}
+	readMode, err := sender.ParseReadMode(readModeName)
+	if err != nil {
+		log.Fatalf("invalid -read-mode: %s", err)
+	}
+	symlinks, err := sender.ParseSymlinkPolicy(symlinksName)
+	if err != nil {
+		log.Fatalf("invalid -symlinks: %s", err)
+	}
+
+	// stdout is reserved for the summary when it is machine readable
+	prompt := os.Stdout
+	if jsonOutput {
+		prompt = os.Stderr
+	}
+	if purpose == "" {
+		fmt.Fprintln(prompt, "Press 's' to send files and 'r' to receive files")
+		fmt.Scanln(&purpose)
+	}
+
+	// the receiver's dials and the sender's entries back off alike
+	retryPolicy := retry.Default
+	retryPolicy.MaxAttempts = attempts
+
+	udpDiscoveryPort := uint(protocol.DefaultDiscoveryPort)
+	chunkSize := uint(1024)
+	discoverers, announcers, err := discoveryBackends(discovery, ipFamily, udpDiscoveryPort, multicastGroup, multicastIface, announceInterval)
+	if err != nil {
+		log.Fatalf("invalid -discovery: %s", err)
+	}
+	receiverOpts := []receiver.Option{
+		receiver.WithDiscoveryPort(udpDiscoveryPort),
+		receiver.WithPreserveFilename(preserveFilename),
+		receiver.WithNameTemplate(nameTemplate),
+		receiver.WithDestDir(expandHome(destDir)),
+		receiver.WithOverwritePolicy(overwritePolicy),
+		receiver.WithResume(resume),
+		receiver.WithKeepPartials(keepPartials),
+		receiver.WithSync(syncFiles),
+		receiver.WithDiskSpaceCheck(!noSpaceCheck),
+		receiver.WithTLSInsecure(tlsInsecure),
+		receiver.WithTLSFingerprint(tlsFingerprint),
+		receiver.WithSharedKey(sharedKey),
+		receiver.WithClockTolerance(clockTolerance),
+		receiver.WithAnnouncementMaxAge(maxAnnounceAge),
+		receiver.WithPreservePermissions(!noPerms),
+		receiver.WithPreserveModTime(!noModTime),
+		receiver.WithDiscoveryTimeout(discoveryTimeout),
+		receiver.WithDiscoveryWindow(discoveryWindow),
+		receiver.WithMaxPeers(maxPeers),
+		receiver.WithAllowedPeers(allowedPeers...),
+		receiver.WithDeniedPeers(deniedPeers...),
+		receiver.WithInterface(iface),
+		receiver.WithDiscoverers(discoverers...),
+		receiver.WithDaemon(daemon),
+		receiver.WithMaxFiles(maxFiles),
+		receiver.WithMaxConcurrent(maxConcurrent),
+		receiver.WithDrainTimeout(drainTimeout),
+		receiver.WithIdleTimeout(idleTimeout),
+		receiver.WithRateLimit(rateLimit),
+		receiver.WithMaxFileSize(maxFileSize),
+		receiver.WithStrictNames(strictNames),
+		receiver.WithReconnect(reconnects),
+		receiver.WithHistoryFile(expandHome(historyFile)),
+		receiver.WithMetricsAddr(metricsAddr),
+		receiver.WithWebListener(webAddr),
+		receiver.WithWebhook(webhookURL),
+		receiver.WithWebhookSecret(webhookSecret),
+		receiver.WithTCPNoDelay(noDelay),
+		receiver.WithSocketBuffers(readBuffer, writeBuffer),
+		receiver.WithKeepAlive(keepAlive),
+		receiver.WithExtractArchives(extract),
+		receiver.WithDialRetry(retryPolicy),
+	}
+	if peerAddr != "" && transport == sender.TransportQUIC {
+		receiverOpts = append(receiverOpts, receiver.WithPeerQUIC(peerAddr))
+	} else if peerAddr != "" {
+		receiverOpts = append(receiverOpts, receiver.WithPeer(peerAddr, useTLS))
+	}
+	if notify {
+		receiverOpts = append(receiverOpts, receiver.WithNotifier(receiver.DesktopNotifier{}))
+	}
+	senderOpts := []sender.Option{
+		sender.WithChunkSize(chunkSize),
+		sender.WithDiscoveryPort(udpDiscoveryPort),
+		sender.WithCompression(compress),
+		sender.WithBlockChecksums(blocks),
+		sender.WithTransport(transport),
+		sender.WithMTU(mtu),
+		sender.WithParallelStreams(streams),
+		sender.WithReadMode(readMode),
+		sender.WithSymlinks(symlinks),
+		sender.WithDirectoryArchives(tarDirs),
+		sender.WithStdinName(stdinName),
+		sender.WithSenderName(senderName),
+		sender.WithTLS(useTLS),
+		sender.WithCertificate(tlsCert, tlsKey),
+		sender.WithSharedKey(sharedKey),
+		sender.WithAnnouncers(announcers...),
+		sender.WithInterface(iface),
+		sender.WithAnnounceDuration(announceFor),
+		sender.WithReceiverTimeout(receiverTimeout),
+		sender.WithWaitForever(waitForever),
+		sender.WithServeForever(serve),
+		sender.WithMaxTransfers(maxTransfers),
+		sender.WithMaxReceivers(maxReceivers),
+		sender.WithRateLimit(rateLimit),
+		sender.WithTotalRateLimit(totalRateLimit),
+		sender.WithMetricsAddr(metricsAddr),
+		sender.WithHTTPDownloads(httpAddr),
+		sender.WithTCPNoDelay(noDelay),
+		sender.WithSocketBuffers(readBuffer, writeBuffer),
+		sender.WithKeepAlive(keepAlive),
+		sender.WithTransferRetry(retryPolicy),
+		sender.WithRestartOnSourceChange(restartChanged),
+		sender.WithWatchGrace(watchGrace),
+		sender.WithSentDir(sentDir),
+		sender.WithQueueConcurrency(queueConcurrency),
+		sender.WithInclude(includes...),
+		sender.WithExclude(excludes...),
+	}
+	if !noProgress {
+		bar := newProgressBar()
+		receiverOpts = append(receiverOpts, receiver.WithProgress(bar.report))
+		senderOpts = append(senderOpts, sender.WithProgress(bar.report))
+	}
+
+	if purpose == "s" {
+		listenPort, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			log.Fatalf("invalid -port: %q", port)
+		}
+		fileSender, err := sender.New(senderOpts...)
+		if err != nil {
+			log.Fatalf("invalid sender options: %s", err)
+		}
+
+		ctx, stop := interruptContext()
+		defer stop()
+		if watchDir != "" {
+			if flag.NArg() > 0 {
+				log.Fatalf("-watch sends the files of its directory, not %s", strings.Join(flag.Args(), " "))
+			}
+			err = fileSender.Watch(ctx, uint16(listenPort), expandHome(watchDir))
+		} else {
+			err = fileSender.Send(ctx, uint16(listenPort), flag.Args())
+		}
+		if errors.Is(err, context.Canceled) {
+			os.Exit(exitInterrupted)
+		}
+		if errors.Is(err, sender.ErrNoReceivers) {
+			log.Printf("%s", err)
+			os.Exit(exitNoReceiver)
+		}
+		if err != nil {
+			log.Fatalf("err starting sender: %s", err)
+		}
+
+	} else if purpose == "r" {
+		// interrupting cancels the transfer cleanly instead of leaving a
+		// partial file behind, and is how daemon mode is stopped
+		ctx, stop := interruptContext()
+		defer stop()
+
+		// the prompt is only made when something is asked on stdin
+		askPeers := !firstPeers && peerAddr == "" && !daemon
+		if !acceptAll || selectEntries || askPeers {
+			prompt := newAcceptPrompt(ctx, os.Stdin, acceptTimeout)
+			receiverOpts = append(receiverOpts, receiver.WithEvents(receiver.Events{PeerDiscovered: prompt.discovered}))
+			if !acceptAll && !listOffers {
+				receiverOpts = append(receiverOpts, receiver.WithAcceptFunc(prompt.accept))
+			}
+			if selectEntries && !listOffers {
+				receiverOpts = append(receiverOpts, receiver.WithSelectFunc(prompt.pick))
+			}
+			if askPeers {
+				receiverOpts = append(receiverOpts, receiver.WithSelectPeers(prompt.choosePeers))
+			}
+		}
+		if listOffers {
+			fileReceiver, err := receiver.New(receiverOpts...)
+			if err != nil {
+				log.Fatalf("invalid receiver options: %s", err)
+			}
+			offers, err := fileReceiver.ListOffers(ctx)
+			if printErr := printOffers(os.Stdout, offers, jsonOutput); printErr != nil {
+				log.Printf("err printing offers: %s", printErr)
+			}
+			if errors.Is(err, receiver.ErrDiscoveryTimeout) {
+				log.Printf("no sender found on port %d", fileReceiver.DiscoveryPort())
+				os.Exit(exitNoSender)
+			}
+			if err != nil {
+				log.Fatalf("err listing offers: %s", err)
+			}
+			return
+		}
+		if selectEntries && len(picks) > 0 {
+			log.Fatalf("-select and -pick can't be combined")
+		}
+		if len(picks) > 0 {
+			selectFunc, err := receiver.SelectMatching(picks...)
+			if err != nil {
+				log.Fatalf("invalid pick pattern: %s", err)
+			}
+			receiverOpts = append(receiverOpts, receiver.WithSelectFunc(selectFunc))
+		}
+		fileReceiver, err := receiver.New(receiverOpts...)
+		if err != nil {
+			log.Fatalf("invalid receiver options: %s", err)
+		}
+		stats, err := fileReceiver.Receive(ctx)
+		if summaryErr := printSummary(os.Stdout, stats, jsonOutput); summaryErr != nil {
+			log.Printf("err printing transfer summary: %s", summaryErr)
+		}
+		if err != nil {
+			if errors.Is(err, receiver.ErrDiscoveryTimeout) {
+				log.Printf("no sender found on port %d", fileReceiver.DiscoveryPort())
+				os.Exit(exitNoSender)
+			}
+			code, reason := receiveFailure(err)
+			log.Printf("%s: %s", reason, err)
+			os.Exit(code)
+		}
+
+	} else {
+		log.Println("invalid input")
+	}
+}
+
+// interruptContext returns a context that is cancelled by the first SIGINT
+// or SIGTERM. A second one exits right away, without waiting for the
+// receiver to clean up.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+		log.Printf("stopping, interrupt again to exit immediately")
+		cancel()
+
+		<-signals
+		log.Printf("exiting without cleaning up")
+		os.Exit(exitInterrupted)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// receiveFailure classifies an error returned by the receiver into its exit
+// code and a short description for the log.
+func receiveFailure(err error) (int, string) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted, "interrupted"
+	case errors.Is(err, receiver.ErrFileExists):
+		return exitFileExists, "refused to replace an existing file"
+	case errors.Is(err, receiver.ErrChecksumMismatch):
+		return exitChecksumMismatch, "file arrived corrupted"
+	case errors.Is(err, receiver.ErrFileTooLarge):
+		return exitFileTooLarge, "file exceeds the size limit"
+	case errors.Is(err, receiver.ErrInsufficientSpace):
+		return exitDiskFull, "destination is out of space"
+	case errors.Is(err, receiver.ErrProtocol):
+		return exitProtocol, "sender violated the protocol"
+	case errors.Is(err, receiver.ErrAuthFailed), errors.Is(err, receiver.ErrFingerprintMismatch):
+		return exitAuth, "sender could not be authenticated"
+	default:
+		return 1, "err receiving file from the sender"
+	}
+}
+
+// expandHome resolves a leading "~" so that paths like ~/Downloads work even
+// when the shell did not expand them (e.g. -dest=~/Downloads).
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+// discoveryBackends returns the receiver and sender discovery backends
+// selected by the -discovery flag, broadcasting over the IP versions of the
+// -ip-family flag, the announcers sending every interval.
+func discoveryBackends(modes, family string, udpDiscoveryPort uint, multicastGroup, multicastIface string, interval time.Duration) ([]receiver.Discoverer, []sender.Announcer, error) {
+	networks := map[string]string{"any": "udp", "ipv4": "udp4", "ipv6": "udp6"}
+	network, ok := networks[family]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown ip family %q", family)
+	}
+
+	var broadcast, multicast, mdns bool
+	for _, mode := range strings.Split(modes, ",") {
+		switch strings.TrimSpace(mode) {
+		case "broadcast":
+			broadcast = true
+		case "multicast":
+			multicast = true
+		case "mdns":
+			mdns = true
+		default:
+			return nil, nil, fmt.Errorf("unknown discovery backend %q", mode)
+		}
+	}
+
+	var discoverers []receiver.Discoverer
+	var announcers []sender.Announcer
+
+	if broadcast {
+		announcers = append(announcers, sender.BroadcastAnnouncer{Port: udpDiscoveryPort, Network: network, Interval: interval})
+		listen := network
+		if multicast {
+			// the multicast listener hears broadcasts of its IP version on
+			// the same port too
+			listen = unheardNetwork(network, multicastGroup)
+		}
+		if listen != "" {
+			discoverers = append(discoverers, receiver.BroadcastDiscoverer{Port: udpDiscoveryPort, Network: listen})
+		}
+	}
+	if multicast {
+		announcers = append(announcers, sender.MulticastAnnouncer{Group: multicastGroup, Port: udpDiscoveryPort, Interface: multicastIface, Interval: interval})
+		discoverers = append(discoverers, receiver.MulticastDiscoverer{Group: multicastGroup, Port: udpDiscoveryPort, Interface: multicastIface})
+	}
+	if mdns {
+		discoverers = append(discoverers, receiver.MDNSDiscoverer{})
+		announcers = append(announcers, sender.MDNSAnnouncer{Interval: interval})
+	}
+
+	return discoverers, announcers, nil
+}
+
+// unheardNetwork returns the part of network, "udp", "udp4" or "udp6", whose
+// broadcasts a listener on group doesn't hear, "" if it hears them all.
+func unheardNetwork(network, group string) string {
+	groupAddr, err := netip.ParseAddr(group)
+	if err != nil {
+		// the multicast backend reports the group as invalid
+		return network
+	}
+	heard, unheard := "udp4", "udp6"
+	if groupAddr.Is6() {
+		heard, unheard = unheard, heard
+	}
+
+	switch network {
+	case heard:
+		return ""
+	case "udp":
+		return unheard
+	default:
+		return network
+	}
+}
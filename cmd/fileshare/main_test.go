@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/pjmessi/go_file_share/receiver"
+)
+
+func TestReceiveFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{err: context.Canceled, want: exitInterrupted},
+		{err: receiver.ErrFileExists, want: exitFileExists},
+		{err: receiver.ErrChecksumMismatch, want: exitChecksumMismatch},
+		{err: receiver.ErrFileTooLarge, want: exitFileTooLarge},
+		{err: receiver.ErrInsufficientSpace, want: exitDiskFull},
+		{err: fmt.Errorf("%w: %w", receiver.ErrProtocol, protocol.ErrBadMagic), want: exitProtocol},
+		{err: receiver.ErrAuthFailed, want: exitAuth},
+		{err: receiver.ErrFingerprintMismatch, want: exitAuth},
+		{err: receiver.ErrIncompleteTransfer, want: 1},
+		{err: errors.New("disk on fire"), want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			// as Receive returns them, one per sender
+			err := errors.Join(&receiver.PeerError{Addr: "192.0.2.1:9000", Err: fmt.Errorf("err saving file: %w", tt.err)})
+			if code, _ := receiveFailure(err); code != tt.want {
+				t.Errorf("exit code = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+)
+
+const (
+	// barWidth is the number of cells of the progress bar itself
+	barWidth = 30
+	// barRefresh is how often the in-place bar is redrawn
+	barRefresh = 250 * time.Millisecond
+	// logRefresh is how often a plain progress line is logged when stderr
+	// isn't a terminal
+	logRefresh = 5 * time.Second
+)
+
+// progressBar renders transfer progress on stderr: as bars redrawn in place
+// when stderr is a terminal, as periodic log lines otherwise so that logs
+// collected by e.g. systemd stay readable. Transfers to several receivers at
+// once get a bar each, named by the receiver's address.
+type progressBar struct {
+	out io.Writer
+	tty bool
+
+	// the sender reports transfers to several receivers concurrently
+	mu sync.Mutex
+	// bars holds the unfinished bar of every peer, in the order in which
+	// they appeared
+	bars  map[string]*peerBar
+	peers []string
+	// drawn counts the lines of bars above the cursor
+	drawn int
+}
+
+// peerBar is the progress of the file being transferred with a peer.
+type peerBar struct {
+	info       progress.Info
+	rate       float64
+	lastRender time.Time
+}
+
+// newProgressBar returns a bar for stderr. On a terminal it also takes over
+// the log output so log lines don't get appended to the bar.
+func newProgressBar() *progressBar {
+	b := &progressBar{out: os.Stderr, tty: isTerminal(os.Stderr), bars: map[string]*peerBar{}}
+	if b.tty {
+		log.SetOutput(b)
+	}
+	return b
+}
+
+// isTerminal reports whether f is a character device, which is as close to
+// "is a terminal" as the standard library gets.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// report is the progress callback handed to the sender and the receiver.
+func (b *progressBar) report(info progress.Info) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bar, ok := b.bars[info.Peer]
+	if !ok {
+		bar = &peerBar{}
+		b.bars[info.Peer] = bar
+		b.peers = append(b.peers, info.Peer)
+	}
+	// a new file is rendered right away
+	if info.Name != bar.info.Name {
+		bar.lastRender = time.Time{}
+	}
+	bar.info = info
+	bar.rate = info.AverageThroughput
+
+	refresh := barRefresh
+	if !b.tty {
+		refresh = logRefresh
+	}
+	now := time.Now()
+	if !info.Done && now.Sub(bar.lastRender) < refresh {
+		return
+	}
+	bar.lastRender = now
+
+	if !b.tty {
+		log.Print(bar.line(false))
+	} else {
+		// a finished bar stays above the ones still running
+		b.clear()
+		if info.Done {
+			fmt.Fprintf(b.out, "%s\x1b[K\n", bar.line(true))
+		}
+	}
+	if info.Done {
+		delete(b.bars, info.Peer)
+		b.peers = slices.DeleteFunc(b.peers, func(peer string) bool { return peer == info.Peer })
+	}
+	if b.tty {
+		b.draw()
+	}
+}
+
+// Write clears the bars before passing p on and draws them again below it.
+func (b *progressBar) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.clear()
+	n, err := b.out.Write(p)
+	b.draw()
+
+	return n, err
+}
+
+// draw writes the unfinished bars, a line each.
+func (b *progressBar) draw() {
+	for _, peer := range b.peers {
+		// \x1b[K clears what a longer previous line left behind
+		fmt.Fprintf(b.out, "%s\x1b[K\n", b.bars[peer].line(true))
+	}
+	b.drawn = len(b.peers)
+}
+
+// clear moves the cursor back to the first line of the bars drawn and
+// clears them.
+func (b *progressBar) clear() {
+	if b.drawn > 0 {
+		fmt.Fprintf(b.out, "\x1b[%dA\r\x1b[J", b.drawn)
+		b.drawn = 0
+	}
+}
+
+// line renders the bar, as a bar proper on a terminal and as a sentence for
+// the log otherwise.
+func (bar *peerBar) line(tty bool) string {
+	info := bar.info
+	name := info.Name
+	if info.Peer != "" {
+		name = info.Peer + " " + name
+	}
+
+	if info.SizeUnknown {
+		// there is nothing to fill a bar or count down to
+		if !tty {
+			return fmt.Sprintf("%s: %s so far, %s%s", name, formatBytes(info.Bytes), formatRate(bar.rate), overall(info))
+		}
+		return fmt.Sprintf("%s %s %s%s", name, formatBytes(info.Bytes), formatRate(bar.rate), overall(info))
+	}
+	if !tty {
+		return fmt.Sprintf("%s: %.0f%% (%s of %s), %s, ETA %s%s",
+			name, percent(info), formatBytes(info.Bytes), formatBytes(info.Total), formatRate(bar.rate), bar.eta(), overall(info))
+	}
+
+	filled := 0
+	if info.Total > 0 {
+		filled = int(float64(barWidth) * float64(info.Bytes) / float64(info.Total))
+	}
+	cells := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+
+	return fmt.Sprintf("%s [%s] %3.0f%% %s ETA %s%s", name, cells, percent(info), formatRate(bar.rate), bar.eta(), overall(info))
+}
+
+// overall describes the progress of the whole transfer a file is part of,
+// if it has several files, and of the transfers running along with it.
+func overall(info progress.Info) string {
+	var line string
+	if info.Transfers > 1 {
+		line = fmt.Sprintf(" | %d transfers, %s together", info.Transfers, formatBytes(info.GroupBytes))
+	}
+	if info.Files < 2 {
+		return line
+	}
+
+	if info.OverallTotal == 0 && info.OverallBytes > 0 {
+		// some file of the transfer has no size known up front
+		return fmt.Sprintf(" | file %d of %d, %s overall", info.File, info.Files, formatBytes(info.OverallBytes)) + line
+	}
+	done := 100.0
+	if info.OverallTotal > 0 {
+		done = min(100*float64(info.OverallBytes)/float64(info.OverallTotal), 100)
+	}
+	return fmt.Sprintf(" | file %d of %d, %.0f%% of %s overall", info.File, info.Files, done, formatBytes(info.OverallTotal)) + line
+}
+
+func (bar *peerBar) eta() string {
+	info := bar.info
+	if info.Done || info.Bytes >= info.Total {
+		return "0s"
+	}
+	if bar.rate <= 0 {
+		return "--"
+	}
+
+	remaining := float64(info.Total-info.Bytes) / bar.rate
+	return time.Duration(remaining * float64(time.Second)).Round(time.Second).String()
+}
+
+func percent(info progress.Info) float64 {
+	if info.Total == 0 {
+		return 100
+	}
+	return 100 * float64(info.Bytes) / float64(info.Total)
+}
+
+func formatRate(bytesPerSecond float64) string {
+	return progress.FormatSize(bytesPerSecond) + "/s"
+}
+
+func formatBytes(n uint64) string {
+	return progress.FormatSize(float64(n))
+}
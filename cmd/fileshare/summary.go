@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pjmessi/go_file_share/receiver"
+)
+
+// printSummary writes the stats of the received files to w, as a table for
+// people or as a JSON array for scripts.
+func printSummary(w io.Writer, stats []receiver.TransferStats, asJSON bool) error {
+	if asJSON {
+		if stats == nil {
+			// an empty array is easier on consumers than null
+			stats = []receiver.TransferStats{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+
+	if len(stats) == 0 {
+		return nil
+	}
+
+	var total uint64
+	for _, s := range stats {
+		if _, err := fmt.Fprintf(w, "%s  %s  %s  %s  sha256:%s\n",
+			s.Path, formatBytes(s.Size), s.Duration.Round(time.Millisecond), formatRate(s.Throughput), s.Checksum); err != nil {
+			return err
+		}
+		total += s.Bytes
+	}
+
+	_, err := fmt.Fprintf(w, "%d files, %s received\n", len(stats), formatBytes(total))
+	return err
+}
+
+// printOffers writes the files senders offer to w, as a table for people or
+// as a JSON array for scripts.
+func printOffers(w io.Writer, offers []receiver.FileInfo, asJSON bool) error {
+	if asJSON {
+		if offers == nil {
+			offers = []receiver.FileInfo{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(offers)
+	}
+
+	var total uint64
+	for _, offer := range offers {
+		if _, err := fmt.Fprintf(w, "%s  %s  %s  %s\n",
+			offer.Name, formatBytes(offer.Size), offer.ModTime.Local().Format(time.DateTime), offer.Peer); err != nil {
+			return err
+		}
+		total += offer.Size
+	}
+
+	_, err := fmt.Fprintf(w, "%d files, %s offered\n", len(offers), formatBytes(total))
+	return err
+}
@@ -0,0 +1,100 @@
+// Package chunk sizes the reads and writes file content is moved in after
+// the throughput they achieve, shared by the sender and the receiver.
+package chunk
+
+import "time"
+
+const (
+	// Window is how long throughput is measured over before the size is
+	// reconsidered.
+	Window = 250 * time.Millisecond
+	// Stall is how long a single chunk may take before the size is halved
+	// right away.
+	Stall = time.Second
+	// improvement is the factor throughput has to grow by over a window for
+	// the size to keep growing
+	improvement = 1.1
+)
+
+// Sizer picks the size of the next chunk of a transfer, much like a
+// congestion window: starting at the initial size, it doubles the size up to
+// its maximum after the first window and every window whose throughput beat
+// the previous one, holds it once throughput stays level and halves it down
+// to its minimum after a stall, when a chunk took Stall or throughput more
+// than halved, to probe upwards again from there. Large chunks save calls on
+// fast links; small ones keep progress and timeouts responsive on slow ones,
+// where larger chunks gain nothing.
+//
+// A Sizer is used by one transfer at a time.
+type Sizer struct {
+	size, min, max int
+
+	windowStart time.Time
+	windowBytes int
+	// rate is the throughput of the previous window, zero before the first
+	rate float64
+}
+
+// NewSizer returns a Sizer starting at initial, kept between min and max.
+// min equal to max keeps the size fixed. A min below 1 is taken as 1, as
+// chunks of no bytes would never move the content along, and a max below
+// min as min.
+func NewSizer(initial, min, max int) *Sizer {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return &Sizer{size: clamp(initial, min, max), min: min, max: max}
+}
+
+// Size returns the size of the next chunk.
+func (s *Sizer) Size() int {
+	return s.size
+}
+
+// Max returns the largest size the Sizer picks.
+func (s *Sizer) Max() int {
+	return s.max
+}
+
+// Record notes that a chunk of n bytes took the given time to move.
+func (s *Sizer) Record(n int, took time.Duration) {
+	now := time.Now()
+	if s.windowStart.IsZero() {
+		s.windowStart = now.Add(-took)
+	}
+	if took >= Stall {
+		s.shrink(now)
+		return
+	}
+
+	s.windowBytes += n
+	elapsed := now.Sub(s.windowStart)
+	if elapsed < Window {
+		return
+	}
+
+	rate := float64(s.windowBytes) / elapsed.Seconds()
+	switch {
+	case s.rate == 0, rate > s.rate*improvement:
+		s.size = min(s.size*2, s.max)
+	case rate < s.rate/2:
+		s.shrink(now)
+		return
+	}
+	s.rate = rate
+	s.windowStart, s.windowBytes = now, 0
+}
+
+// shrink halves the size after a stall and measures afresh.
+func (s *Sizer) shrink(now time.Time) {
+	s.size = max(s.size/2, s.min)
+	s.rate = 0
+	s.windowStart, s.windowBytes = now, 0
+}
+
+func clamp(size, lo, hi int) int {
+	return min(max(size, lo), hi)
+}
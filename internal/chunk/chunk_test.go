@@ -0,0 +1,63 @@
+package chunk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewSizerClampsBounds(t *testing.T) {
+	tests := []struct {
+		name                       string
+		initial, min, max          int
+		wantSize, wantMin, wantMax int
+	}{
+		{"within", 4096, 1024, 8192, 4096, 1024, 8192},
+		{"below min", 10, 1024, 8192, 1024, 1024, 8192},
+		{"above max", 1 << 20, 1024, 8192, 8192, 1024, 8192},
+		{"zero min", 4096, 0, 8192, 4096, 1, 8192},
+		{"negative min", 4096, -5, 8192, 4096, 1, 8192},
+		{"zero bounds", 0, 0, 0, 1, 1, 1},
+		{"max below min", 4096, 1024, 512, 1024, 1024, 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSizer(tt.initial, tt.min, tt.max)
+			if s.Size() != tt.wantSize || s.min != tt.wantMin || s.Max() != tt.wantMax {
+				t.Errorf("NewSizer(%d, %d, %d) = size %d, bounds %d-%d, want size %d, bounds %d-%d",
+					tt.initial, tt.min, tt.max, s.Size(), s.min, s.Max(), tt.wantSize, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestSizerNeverShrinksToZero(t *testing.T) {
+	s := NewSizer(8, 0, 8)
+	for range 10 {
+		s.Record(s.Size(), Stall)
+	}
+	if s.Size() != 1 {
+		t.Errorf("size after repeated stalls = %d, want 1", s.Size())
+	}
+}
+
+func TestSizerGrowsAfterFirstWindow(t *testing.T) {
+	s := NewSizer(1024, 1024, 8192)
+	s.Record(1024, Window)
+	if s.Size() != 2048 {
+		t.Errorf("size after the first window = %d, want 2048", s.Size())
+	}
+}
+
+func TestSizerShrinksAfterStall(t *testing.T) {
+	s := NewSizer(8192, 1024, 8192)
+	s.Record(8192, Stall)
+	if s.Size() != 4096 {
+		t.Errorf("size after a stall = %d, want 4096", s.Size())
+	}
+	s.Record(4096, 2*time.Second)
+	s.Record(2048, 2*time.Second)
+	s.Record(1024, 2*time.Second)
+	if s.Size() != 1024 {
+		t.Errorf("size after repeated stalls = %d, want the minimum 1024", s.Size())
+	}
+}
@@ -0,0 +1,55 @@
+// Package events delivers the lifecycle callbacks of the sender and the
+// receiver off their transfer path.
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// Queue runs functions one after another, in the order they were pushed, on a
+// goroutine of its own. A slow callback thereby delays the events after it
+// rather than the transfer that raised them. The goroutine only runs while
+// there is something to deliver. The zero Queue is ready to use.
+type Queue struct {
+	mu      sync.Mutex
+	pending []func()
+	running bool
+}
+
+// Push queues fn to run after everything pushed before it.
+func (q *Queue) Push(fn func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending = append(q.pending, fn)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+func (q *Queue) run() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		fn := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		fn()
+	}
+}
+
+// Emit queues a call of callback with ctx and event, unless callback is nil.
+func Emit[T any](q *Queue, ctx context.Context, callback func(context.Context, T), event T) {
+	if callback == nil {
+		return
+	}
+
+	q.Push(func() { callback(ctx, event) })
+}
@@ -0,0 +1,48 @@
+// Package lanurl spells out the http URLs a listener is reachable at from
+// the local network, for the sender and the receiver to print.
+package lanurl
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// HTTP returns the http URLs, without a path, that reach a listener on addr.
+// A listener on all interfaces yields one URL per IPv4 address of the
+// machine that isn't a loopback address, since a phone or a locked-down
+// laptop often can't resolve the host name, followed by one per IPv6 address
+// that isn't link-local either, which browsers can't be given a zone for, or
+// localhost if there is none.
+func HTTP(addr net.Addr) []string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return []string{"http://" + addr.String()}
+	}
+	port := strconv.Itoa(tcpAddr.Port)
+	if !tcpAddr.IP.IsUnspecified() {
+		return []string{"http://" + net.JoinHostPort(tcpAddr.IP.String(), port)}
+	}
+
+	var urls, urls6 []string
+	ifaceAddrs, _ := net.InterfaceAddrs()
+	for _, ifaceAddr := range ifaceAddrs {
+		prefix, err := netip.ParsePrefix(ifaceAddr.String())
+		if err != nil {
+			continue
+		}
+		ip := prefix.Addr().Unmap()
+		switch {
+		case ip.Is4() && !ip.IsLoopback():
+			urls = append(urls, "http://"+netip.AddrPortFrom(ip, uint16(tcpAddr.Port)).String())
+		case ip.Is6() && ip.IsGlobalUnicast():
+			urls6 = append(urls6, "http://"+netip.AddrPortFrom(ip, uint16(tcpAddr.Port)).String())
+		}
+	}
+	urls = append(urls, urls6...)
+	if len(urls) == 0 {
+		urls = append(urls, "http://"+net.JoinHostPort("localhost", port))
+	}
+
+	return urls
+}
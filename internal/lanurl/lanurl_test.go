@@ -0,0 +1,55 @@
+package lanurl
+
+import (
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestHTTPOfAnAddress(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		want string
+	}{
+		{&net.TCPAddr{IP: net.IPv4(192, 168, 1, 5), Port: 8080}, "http://192.168.1.5:8080"},
+		{&net.TCPAddr{IP: net.IPv6loopback, Port: 8080}, "http://[::1]:8080"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::5"), Port: 80}, "http://[2001:db8::5]:80"},
+	}
+	for _, tt := range tests {
+		if got := HTTP(tt.addr); !slices.Equal(got, []string{tt.want}) {
+			t.Errorf("HTTP(%s) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPOfAllInterfaces(t *testing.T) {
+	for _, ip := range []net.IP{net.IPv4zero, net.IPv6unspecified} {
+		urls := HTTP(&net.TCPAddr{IP: ip, Port: 8080})
+		if len(urls) == 0 {
+			t.Fatalf("no URL for a listener on %s", ip)
+		}
+		for _, u := range urls {
+			parsed, err := url.Parse(u)
+			if err != nil {
+				t.Errorf("%s doesn't parse: %v", u, err)
+				continue
+			}
+			if parsed.Port() != "8080" {
+				t.Errorf("%s isn't on the listener's port", u)
+			}
+			if parsed.Hostname() == "localhost" {
+				continue
+			}
+			addr, err := netip.ParseAddr(parsed.Hostname())
+			if err != nil {
+				t.Errorf("%s has no IP host: %v", u, err)
+				continue
+			}
+			if addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+				t.Errorf("%s isn't reachable from the network", u)
+			}
+		}
+	}
+}
@@ -0,0 +1,293 @@
+// Package mdns implements just enough of multicast DNS and DNS-SD to
+// advertise a single service and browse for instances of it.
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// GroupAddr is the well-known IPv4 mDNS group and port.
+var GroupAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// recordTTL is the TTL of the records we publish, short since our services
+// only live for a single transfer
+const recordTTL = 120
+
+// maxPacketSize is the largest mDNS message we read
+const maxPacketSize = 9000
+
+// Service describes a DNS-SD service instance.
+type Service struct {
+	// Instance is the instance label, e.g. the host name.
+	Instance string
+	// Type is the service type, e.g. "_fileshare._tcp".
+	Type string
+	// Port is the port the service listens on.
+	Port uint16
+	// TXT holds the key=value pairs of the TXT record.
+	TXT []string
+	// RefreshTXT, if set, replaces TXT in every message sent, e.g. to keep
+	// a timestamp in it current.
+	RefreshTXT func() []string
+}
+
+// Entry is a service instance found while browsing.
+type Entry struct {
+	// Instance is the full instance name.
+	Instance string
+	// Addr is the address the answer came from.
+	Addr net.IP
+	// Port is the port from the SRV record.
+	Port uint16
+	// TXT holds the key=value pairs of the TXT record.
+	TXT []string
+}
+
+func serviceName(serviceType string) string {
+	return serviceType + ".local."
+}
+
+func instanceName(svc Service) string {
+	// dots inside the instance label would start a new label on the wire
+	return strings.ReplaceAll(svc.Instance, ".", "-") + "." + serviceName(svc.Type)
+}
+
+func hostName(svc Service) string {
+	return strings.ReplaceAll(svc.Instance, ".", "-") + ".local."
+}
+
+// records returns the PTR, SRV, TXT and A records announcing svc.
+func records(svc Service) []record {
+	instance := instanceName(svc)
+	host := hostName(svc)
+
+	rrs := []record{
+		{name: serviceName(svc.Type), rtype: typePTR, class: classIN, ttl: recordTTL, data: ptrData(instance)},
+		{name: instance, rtype: typeSRV, class: classIN | classCacheFlush, ttl: recordTTL, data: srvData(svc.Port, host)},
+		{name: instance, rtype: typeTXT, class: classIN | classCacheFlush, ttl: recordTTL, data: txtData(svc.TXT)},
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return rrs
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			rrs = append(rrs, record{name: host, rtype: typeA, class: classIN | classCacheFlush, ttl: recordTTL, data: ip4})
+		}
+	}
+
+	return rrs
+}
+
+// listen joins the mDNS group and opens the socket to send from.
+// ListenMulticastUDP disables multicast loopback, which would hide us from
+// peers on the same host, so sending goes through a socket of its own.
+func listen() (con, out *net.UDPConn, err error) {
+	con, err = net.ListenMulticastUDP("udp4", nil, GroupAddr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("err joining mdns group: %s", err)
+	}
+
+	out, err = net.ListenUDP("udp4", nil)
+	if err != nil {
+		con.Close()
+		return nil, nil, fmt.Errorf("err opening mdns socket: %s", err)
+	}
+
+	return con, out, nil
+}
+
+// Announce publishes svc until ctx is done. It answers queries for the
+// service type and sends an unsolicited announcement every interval.
+func Announce(ctx context.Context, svc Service, interval time.Duration) error {
+	con, out, err := listen()
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+	defer out.Close()
+
+	stop := context.AfterFunc(ctx, func() { con.Close() })
+	defer stop()
+
+	encode := func() ([]byte, error) {
+		current := svc
+		if svc.RefreshTXT != nil {
+			current.TXT = svc.RefreshTXT()
+		}
+		return (&message{response: true, answers: records(current)}).encode()
+	}
+	response, err := encode()
+	if err != nil {
+		return fmt.Errorf("err encoding mdns response: %s", err)
+	}
+	// the announcements and the answers to queries share the response
+	refresh := func() []byte {
+		if svc.RefreshTXT == nil {
+			return response
+		}
+		current, err := encode()
+		if err != nil {
+			log.Printf("err encoding mdns response: %s", err)
+			return response
+		}
+		return current
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := out.WriteToUDP(refresh(), GroupAddr); err != nil && ctx.Err() == nil {
+				log.Printf("err sending mdns announcement: %s", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, src, err := con.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("err reading mdns query: %s", err)
+		}
+
+		msg, err := decode(buf[:n])
+		if err != nil || msg.response {
+			continue
+		}
+
+		for _, q := range msg.questions {
+			if !strings.EqualFold(q.name, serviceName(svc.Type)) || (q.qtype != typePTR && q.qtype != typeANY) {
+				continue
+			}
+
+			answer := refresh()
+			if _, err := out.WriteToUDP(answer, GroupAddr); err != nil {
+				log.Printf("err sending mdns response: %s", err)
+			}
+			// queriers that aren't on the mDNS port can't hear multicast
+			// answers, so they get theirs directly as well
+			if src.Port != GroupAddr.Port {
+				if _, err := out.WriteToUDP(answer, src); err != nil {
+					log.Printf("err sending mdns response: %s", err)
+				}
+			}
+			break
+		}
+	}
+}
+
+// Browse queries for instances of serviceType every interval and calls found
+// for each complete answer until ctx is done. The same instance may be
+// reported more than once.
+func Browse(ctx context.Context, serviceType string, interval time.Duration, found func(Entry)) error {
+	con, out, err := listen()
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+	defer out.Close()
+
+	stop := context.AfterFunc(ctx, func() { con.Close() })
+	defer stop()
+
+	query, err := (&message{questions: []question{{name: serviceName(serviceType), qtype: typePTR}}}).encode()
+	if err != nil {
+		return fmt.Errorf("err encoding mdns query: %s", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := out.WriteToUDP(query, GroupAddr); err != nil && ctx.Err() == nil {
+				log.Printf("err sending mdns query: %s", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, src, err := con.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("err reading mdns response: %s", err)
+		}
+
+		msg, err := decode(buf[:n])
+		if err != nil || !msg.response {
+			continue
+		}
+
+		for _, entry := range entries(msg, serviceType) {
+			entry.Addr = src.IP
+			found(entry)
+		}
+	}
+}
+
+// entries collects the instances of serviceType that msg fully describes,
+// i.e. those it holds both the SRV and the TXT record for.
+func entries(msg *message, serviceType string) []Entry {
+	var instances []string
+	srv := map[string]uint16{}
+	txt := map[string][]string{}
+
+	for _, rr := range msg.answers {
+		name := strings.ToLower(rr.name)
+		switch rr.rtype {
+		case typePTR:
+			if strings.EqualFold(rr.name, serviceName(serviceType)) {
+				instances = append(instances, strings.ToLower(rr.target))
+			}
+		case typeSRV:
+			srv[name] = rr.port
+		case typeTXT:
+			txt[name] = rr.txt
+		}
+	}
+
+	var found []Entry
+	for _, instance := range instances {
+		port, ok := srv[instance]
+		if !ok {
+			continue
+		}
+		txtRecord, ok := txt[instance]
+		if !ok {
+			continue
+		}
+		found = append(found, Entry{Instance: instance, Port: port, TXT: txtRecord})
+	}
+
+	return found
+}
@@ -0,0 +1,275 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// record types used for DNS-SD
+const (
+	typeA   uint16 = 1
+	typePTR uint16 = 12
+	typeTXT uint16 = 16
+	typeSRV uint16 = 33
+	typeANY uint16 = 255
+)
+
+const (
+	classIN uint16 = 1
+	// classCacheFlush marks a record as the only one of its name and type
+	classCacheFlush uint16 = 1 << 15
+)
+
+// flagResponse marks an authoritative response
+const flagResponse uint16 = 0x8400
+
+var errMalformed = errors.New("malformed dns message")
+
+type question struct {
+	name  string
+	qtype uint16
+}
+
+type record struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+	data  []byte
+
+	// decoded rdata, depending on rtype
+	target string   // PTR, SRV
+	port   uint16   // SRV
+	txt    []string // TXT
+	ip     []byte   // A
+}
+
+type message struct {
+	response  bool
+	questions []question
+	answers   []record
+}
+
+// encode serialises the message without name compression, which mDNS
+// responders have to accept and which keeps this encoder trivial.
+func (m *message) encode() ([]byte, error) {
+	buf := make([]byte, 12, 512)
+
+	var flags uint16
+	if m.response {
+		flags = flagResponse
+	}
+	binary.BigEndian.PutUint16(buf[2:], flags)
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(buf[6:], uint16(len(m.answers)))
+
+	var err error
+	for _, q := range m.questions {
+		if buf, err = appendName(buf, q.name); err != nil {
+			return nil, err
+		}
+		buf = binary.BigEndian.AppendUint16(buf, q.qtype)
+		buf = binary.BigEndian.AppendUint16(buf, classIN)
+	}
+
+	for _, rr := range m.answers {
+		if buf, err = appendName(buf, rr.name); err != nil {
+			return nil, err
+		}
+		buf = binary.BigEndian.AppendUint16(buf, rr.rtype)
+		buf = binary.BigEndian.AppendUint16(buf, rr.class)
+		buf = binary.BigEndian.AppendUint32(buf, rr.ttl)
+		if len(rr.data) > 0xffff {
+			return nil, fmt.Errorf("record data of %s too long", rr.name)
+		}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(rr.data)))
+		buf = append(buf, rr.data...)
+	}
+
+	return buf, nil
+}
+
+func appendName(buf []byte, name string) ([]byte, error) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		if len(label) > 63 {
+			return nil, fmt.Errorf("label %q too long", label)
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+
+	return append(buf, 0), nil
+}
+
+func ptrData(target string) []byte {
+	data, _ := appendName(nil, target)
+	return data
+}
+
+func srvData(port uint16, target string) []byte {
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint16(data[4:], port)
+	data, _ = appendName(data, target)
+	return data
+}
+
+func txtData(txt []string) []byte {
+	var data []byte
+	for _, s := range txt {
+		if len(s) > 255 {
+			s = s[:255]
+		}
+		data = append(data, byte(len(s)))
+		data = append(data, s...)
+	}
+	if len(data) == 0 {
+		// an empty TXT record still has to hold one empty string
+		data = []byte{0}
+	}
+	return data
+}
+
+// decode parses a DNS message, skipping authority and additional sections'
+// structure only as far as needed to read their records too.
+func decode(buf []byte) (*message, error) {
+	if len(buf) < 12 {
+		return nil, errMalformed
+	}
+
+	m := &message{response: buf[2]&0x80 != 0}
+	qdCount := int(binary.BigEndian.Uint16(buf[4:]))
+	rrCount := int(binary.BigEndian.Uint16(buf[6:])) +
+		int(binary.BigEndian.Uint16(buf[8:])) +
+		int(binary.BigEndian.Uint16(buf[10:]))
+
+	off := 12
+	for i := 0; i < qdCount; i++ {
+		name, next, err := readName(buf, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(buf) {
+			return nil, errMalformed
+		}
+		m.questions = append(m.questions, question{
+			name:  name,
+			qtype: binary.BigEndian.Uint16(buf[next:]),
+		})
+		off = next + 4
+	}
+
+	for i := 0; i < rrCount; i++ {
+		name, next, err := readName(buf, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(buf) {
+			return nil, errMalformed
+		}
+
+		rr := record{
+			name:  name,
+			rtype: binary.BigEndian.Uint16(buf[next:]),
+			class: binary.BigEndian.Uint16(buf[next+2:]),
+			ttl:   binary.BigEndian.Uint32(buf[next+4:]),
+		}
+		dataLen := int(binary.BigEndian.Uint16(buf[next+8:]))
+		dataOff := next + 10
+		if dataOff+dataLen > len(buf) {
+			return nil, errMalformed
+		}
+		rr.data = buf[dataOff : dataOff+dataLen]
+
+		if err := rr.decodeData(buf, dataOff); err != nil {
+			return nil, err
+		}
+
+		m.answers = append(m.answers, rr)
+		off = dataOff + dataLen
+	}
+
+	return m, nil
+}
+
+func (rr *record) decodeData(buf []byte, off int) error {
+	var err error
+
+	switch rr.rtype {
+	case typePTR:
+		rr.target, _, err = readName(buf, off)
+	case typeSRV:
+		if len(rr.data) < 7 {
+			return errMalformed
+		}
+		rr.port = binary.BigEndian.Uint16(rr.data[4:])
+		rr.target, _, err = readName(buf, off+6)
+	case typeTXT:
+		for data := rr.data; len(data) > 0; {
+			n := int(data[0])
+			if 1+n > len(data) {
+				return errMalformed
+			}
+			if n > 0 {
+				rr.txt = append(rr.txt, string(data[1:1+n]))
+			}
+			data = data[1+n:]
+		}
+	case typeA:
+		if len(rr.data) != 4 {
+			return errMalformed
+		}
+		rr.ip = rr.data
+	}
+
+	return err
+}
+
+// readName reads a possibly compressed name at off and returns it along with
+// the offset right after it.
+func readName(buf []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+
+	// every pointer has to jump backwards, so a message can't hold more
+	// jumps than bytes; this also rules out loops
+	for jumps := 0; jumps < len(buf); {
+		if off >= len(buf) {
+			return "", 0, errMalformed
+		}
+
+		length := int(buf[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case length&0xc0 == 0xc0:
+			if off+1 >= len(buf) {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			pointer := int(binary.BigEndian.Uint16(buf[off:]) & 0x3fff)
+			if pointer >= off {
+				return "", 0, errMalformed
+			}
+			off = pointer
+			jumps++
+		default:
+			if off+1+length > len(buf) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(buf[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+
+	return "", 0, errMalformed
+}
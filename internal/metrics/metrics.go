@@ -0,0 +1,116 @@
+// Package metrics keeps counters and gauges and exposes them in the
+// Prometheus text format, shared by the sender and the receiver.
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Counter is a value that only goes up.
+type Counter struct {
+	value atomic.Uint64
+}
+
+// Add increases the counter by n.
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Inc increases the counter by one.
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+// Set replaces the value of the gauge.
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+// Add changes the value of the gauge by delta.
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		if g.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value func() string
+}
+
+// Registry is a set of metrics in the order they were created. Metrics are
+// meant to be created up front, creating them isn't safe for concurrent use.
+type Registry struct {
+	metrics []metric
+}
+
+// Counter creates a counter named name, which should end in "_total".
+func (r *Registry) Counter(name, help string) *Counter {
+	c := &Counter{}
+	r.metrics = append(r.metrics, metric{name, help, "counter", func() string {
+		return strconv.FormatUint(c.value.Load(), 10)
+	}})
+	return c
+}
+
+// Gauge creates a gauge named name.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.metrics = append(r.metrics, metric{name, help, "gauge", func() string {
+		return strconv.FormatFloat(math.Float64frombits(g.bits.Load()), 'g', -1, 64)
+	}})
+	return g
+}
+
+// ServeHTTP writes every metric in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	out := bufio.NewWriter(w)
+	for _, m := range r.metrics {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", m.name, m.help, m.name, m.kind, m.name, m.value())
+	}
+	out.Flush()
+}
+
+// Serve listens on addr and serves the registry at /metrics until ctx is
+// done. Listening errors are returned right away, later ones are passed to
+// failed.
+func Serve(ctx context.Context, addr string, r *Registry, failed func(error)) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("err starting metrics listener: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", r)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			failed(err)
+		}
+	}()
+	context.AfterFunc(ctx, func() { server.Close() })
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestServeHTTP(t *testing.T) {
+	var r Registry
+	c := r.Counter("test_things_total", "Things counted.")
+	g := r.Gauge("test_level", "Current level.")
+	c.Add(41)
+	c.Inc()
+	g.Set(2.5)
+	g.Add(-1)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	want := `# HELP test_things_total Things counted.
+# TYPE test_things_total counter
+test_things_total 42
+# HELP test_level Current level.
+# TYPE test_level gauge
+test_level 1.5
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the text exposition format", ct)
+	}
+}
+
+func TestGaugeAddConcurrently(t *testing.T) {
+	var r Registry
+	g := r.Gauge("test_active", "")
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				g.Add(1)
+				g.Add(-0.5)
+			}
+		}()
+	}
+	wg.Wait()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, nil)
+	if !strings.Contains(rec.Body.String(), "\ntest_active 2500\n") {
+		t.Errorf("got\n%s\nwant test_active at 2500", rec.Body)
+	}
+}
+
+func TestServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var r Registry
+	r.Counter("test_served_total", "").Inc()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := Serve(ctx, addr, &r, func(err error) { t.Error(err) }); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || !strings.Contains(string(body), "test_served_total 1") {
+		t.Errorf("GET /metrics = %q, %v", body, err)
+	}
+	if err := Serve(ctx, addr, &r, nil); err == nil {
+		t.Error("Serve succeeded on a taken address")
+	}
+}
@@ -0,0 +1,258 @@
+// Package netif pins discovery and transfers to one network interface, for
+// machines whose Docker bridges, VPN tunnels or several NICs make the
+// system's choice of interface the wrong one.
+package netif
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Interface is a network interface along with the addresses it had when it
+// was looked up.
+type Interface struct {
+	Name string
+	// prefixes are the addresses with their networks, without zones,
+	// which prefixes can't hold
+	prefixes []netip.Prefix
+}
+
+// Lookup returns the interface named name. An unknown name is reported
+// along with the names of the interfaces there are.
+func Lookup(name string) (Interface, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		ifaces, listErr := net.Interfaces()
+		if listErr != nil {
+			return Interface{}, fmt.Errorf("unknown interface %q: %w", name, err)
+		}
+		names := make([]string, 0, len(ifaces))
+		for _, ifi := range ifaces {
+			names = append(names, ifi.Name)
+		}
+		return Interface{}, fmt.Errorf("unknown interface %q, available: %s", name, strings.Join(names, ", "))
+	}
+
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return Interface{}, fmt.Errorf("err listing addresses of %s: %w", name, err)
+	}
+	i := Interface{Name: name}
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err != nil {
+			continue
+		}
+		i.prefixes = append(i.prefixes, netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()))
+	}
+	if len(i.prefixes) == 0 {
+		return Interface{}, fmt.Errorf("interface %s has no ip address", name)
+	}
+
+	return i, nil
+}
+
+// Addr returns the first address of the interface of ip's version, the one
+// on ip's network if there is one, to reach ip from. ok is false if the
+// interface has no address of that version.
+func (i Interface) Addr(ip netip.Addr) (netip.Addr, bool) {
+	ip = ip.Unmap()
+	var first netip.Addr
+	for _, prefix := range i.prefixes {
+		if prefix.Addr().Is4() != ip.Is4() {
+			continue
+		}
+		if prefix.Contains(ip.WithZone("")) {
+			return i.zoned(prefix.Addr()), true
+		}
+		if !first.IsValid() {
+			first = i.zoned(prefix.Addr())
+		}
+	}
+
+	return first, first.IsValid()
+}
+
+// zoned returns ip zoned to the interface if it is link-local, since such
+// an address only means something together with its interface.
+func (i Interface) zoned(ip netip.Addr) netip.Addr {
+	if ip.IsLinkLocalUnicast() {
+		return ip.WithZone(i.Name)
+	}
+	return ip
+}
+
+// Reaches reports whether ip is on the network of one of the interface's
+// addresses, or is a link-local address zoned to it.
+func (i Interface) Reaches(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLinkLocalUnicast() && ip.Zone() != "" {
+		return ip.Zone() == i.Name
+	}
+
+	return slices.ContainsFunc(i.prefixes, func(prefix netip.Prefix) bool {
+		return prefix.Contains(ip.WithZone(""))
+	})
+}
+
+// Carries reports whether a connection between local and remote runs
+// through the interface: local is one of its addresses, or, if it is
+// unspecified, as sockets listening on every address may report, remote is
+// reached through it.
+func (i Interface) Carries(local, remote netip.Addr) bool {
+	local = local.Unmap()
+	if local.IsUnspecified() {
+		return i.Reaches(remote)
+	}
+
+	return slices.ContainsFunc(i.prefixes, func(prefix netip.Prefix) bool {
+		return prefix.Addr() == local.WithZone("")
+	})
+}
+
+// Listen listens on port of every address of the interface through listen,
+// which is handed the host:port to listen on, and returns the listeners as
+// one. Port 0 lets the first address pick a port, which the others then
+// listen on as well, so the listener is reached on the same port whichever
+// address a peer connects to.
+func (i Interface) Listen(port uint16, listen func(addr string) (net.Listener, error)) (net.Listener, error) {
+	var listeners []net.Listener
+	for _, prefix := range i.prefixes {
+		listener, err := listen(netip.AddrPortFrom(i.zoned(prefix.Addr()), port).String())
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, err
+		}
+		if port == 0 {
+			port = portOf(listener.Addr())
+		}
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 1 {
+		return listeners[0], nil
+	}
+
+	l := &Listener{listeners: listeners, accepted: make(chan accepted), done: make(chan struct{})}
+	for _, listener := range listeners {
+		go l.serve(listener)
+	}
+
+	return l, nil
+}
+
+// ListenOn listens on addr, a host:port, through listen, an empty or
+// unspecified host standing for every address of the interface, see Listen.
+func (i Interface) ListenOn(addr string, listen func(addr string) (net.Listener, error)) (net.Listener, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip, err := netip.ParseAddr(host); host != "" && (err != nil || !ip.IsUnspecified()) {
+		return listen(addr)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %s", portStr)
+	}
+
+	return i.Listen(uint16(port), listen)
+}
+
+// portOf returns the port of a TCP or UDP address.
+func portOf(addr net.Addr) uint16 {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return uint16(addr.Port)
+	case *net.UDPAddr:
+		return uint16(addr.Port)
+	}
+	return 0
+}
+
+// Listener accepts the connections of several listeners, those on the
+// addresses of an interface.
+type Listener struct {
+	listeners []net.Listener
+	accepted  chan accepted
+	done      chan struct{}
+	closing   sync.Once
+}
+
+// accepted is what a listener's Accept returned.
+type accepted struct {
+	con net.Conn
+	err error
+}
+
+// serve hands what listener accepts to Accept until either is closed.
+func (l *Listener) serve(listener net.Listener) {
+	for {
+		con, err := listener.Accept()
+		select {
+		case l.accepted <- accepted{con, err}:
+		case <-l.done:
+			if con != nil {
+				con.Close()
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Accept implements net.Listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case a := <-l.accepted:
+		return a.con, a.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener, closing every listener.
+func (l *Listener) Close() error {
+	var errs []error
+	l.closing.Do(func() {
+		close(l.done)
+		for _, listener := range l.listeners {
+			errs = append(errs, listener.Close())
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Addr implements net.Listener, returning the address of the first
+// listener, see Addrs.
+func (l *Listener) Addr() net.Addr {
+	return l.listeners[0].Addr()
+}
+
+// Addrs returns the addresses of every listener.
+func (l *Listener) Addrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(l.listeners))
+	for _, listener := range l.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
+}
+
+// Addrs returns the addresses listener listens on: those of a Listener, or
+// of a listener wrapping one that has an Addrs method as well, or its only
+// one.
+func Addrs(listener net.Listener) []net.Addr {
+	if l, ok := listener.(interface{ Addrs() []net.Addr }); ok {
+		return l.Addrs()
+	}
+	return []net.Addr{listener.Addr()}
+}
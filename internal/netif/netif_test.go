@@ -0,0 +1,160 @@
+package netif
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func testInterface() Interface {
+	return Interface{Name: "eth1", prefixes: []netip.Prefix{
+		netip.MustParsePrefix("192.168.7.5/24"),
+		netip.MustParsePrefix("fe80::5/64"),
+		netip.MustParsePrefix("2001:db8::5/64"),
+	}}
+}
+
+func TestReaches(t *testing.T) {
+	i := testInterface()
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"192.168.7.9", true},
+		{"::ffff:192.168.7.9", true},
+		{"192.168.8.9", false},
+		{"2001:db8::9", true},
+		{"2001:db9::9", false},
+		{"fe80::9%eth1", true},
+		{"fe80::9%eth0", false},
+		{"fe80::9", true},
+	}
+	for _, tt := range tests {
+		if got := i.Reaches(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("Reaches(%s) = %t, want %t", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestCarries(t *testing.T) {
+	i := testInterface()
+	tests := []struct {
+		local, remote string
+		want          bool
+	}{
+		{"192.168.7.5", "10.0.0.1", true},
+		{"192.168.7.6", "192.168.7.9", false},
+		{"fe80::5%eth1", "fe80::9%eth1", true},
+		{"0.0.0.0", "192.168.7.9", true},
+		{"::", "10.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := i.Carries(netip.MustParseAddr(tt.local), netip.MustParseAddr(tt.remote)); got != tt.want {
+			t.Errorf("Carries(%s, %s) = %t, want %t", tt.local, tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestAddr(t *testing.T) {
+	i := testInterface()
+	tests := []struct {
+		ip, want string
+		ok       bool
+	}{
+		{"192.168.7.9", "192.168.7.5", true},
+		{"10.0.0.1", "192.168.7.5", true},
+		{"2001:db8::9", "2001:db8::5", true},
+		{"fe80::9", "fe80::5%eth1", true},
+		{"::", "fe80::5%eth1", true},
+	}
+	for _, tt := range tests {
+		got, ok := i.Addr(netip.MustParseAddr(tt.ip))
+		if ok != tt.ok || ok && got.String() != tt.want {
+			t.Errorf("Addr(%s) = %s, %t, want %s, %t", tt.ip, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	v6Only := Interface{Name: "wg0", prefixes: []netip.Prefix{netip.MustParsePrefix("2001:db8::5/64")}}
+	if got, ok := v6Only.Addr(netip.MustParseAddr("192.168.7.9")); ok {
+		t.Errorf("Addr of an IPv4 address on an IPv6-only interface = %s", got)
+	}
+}
+
+func TestLookupUnknown(t *testing.T) {
+	if _, err := Lookup("no-such-interface0"); err == nil {
+		t.Error("Lookup of an unknown interface succeeded")
+	}
+}
+
+func TestListenEveryAddress(t *testing.T) {
+	// loopback addresses stand in for those of an interface
+	i := Interface{Name: "lo", prefixes: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/8")}}
+	if l, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		l.Close()
+		i.prefixes = append(i.prefixes, netip.MustParsePrefix("::1/128"))
+	}
+
+	listener, err := i.Listen(0, func(addr string) (net.Listener, error) {
+		return net.Listen("tcp", addr)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	addrs := Addrs(listener)
+	if len(addrs) != len(i.prefixes) {
+		t.Fatalf("listening on %v, want one address per prefix of %v", addrs, i.prefixes)
+	}
+	port := addrs[0].(*net.TCPAddr).Port
+	for _, addr := range addrs {
+		if addr.(*net.TCPAddr).Port != port {
+			t.Errorf("listening on %v, want the same port everywhere", addrs)
+		}
+
+		con, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accepted.LocalAddr().String() != addr.String() {
+			t.Errorf("accepted on %s, want %s", accepted.LocalAddr(), addr)
+		}
+		accepted.Close()
+		con.Close()
+	}
+
+	listener.Close()
+	if _, err := listener.Accept(); err == nil {
+		t.Error("Accept after Close succeeded")
+	}
+}
+
+func TestListenOnHost(t *testing.T) {
+	i := Interface{Name: "lo", prefixes: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/8")}}
+	var asked []string
+	listen := func(addr string) (net.Listener, error) {
+		asked = append(asked, addr)
+		return net.Listen("tcp", addr)
+	}
+
+	for _, addr := range []string{":0", "0.0.0.0:0", "127.0.0.2:0"} {
+		asked = nil
+		listener, err := i.ListenOn(addr, listen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener.Close()
+
+		want := "127.0.0.1:0"
+		if addr == "127.0.0.2:0" {
+			want = addr
+		}
+		if len(asked) != 1 || asked[0] != want {
+			t.Errorf("ListenOn(%s) listened on %v, want %s", addr, asked, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Package pathmatch matches slash-separated relative paths against
+// gitignore-style patterns, for the sender to filter the directories it
+// walks.
+package pathmatch
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Pattern is a compiled pattern. Its segments are matched with path.Match,
+// "**" matching any number of segments, none included. A pattern without a
+// slash, other than a trailing one, matches at any depth, e.g. "*.o" or
+// "node_modules"; one with a slash is anchored at the root, e.g.
+// "docs/*.md", unless it starts with "**/". A trailing slash only matches
+// directories.
+type Pattern struct {
+	raw      string
+	segments []string
+	dirOnly  bool
+}
+
+// Compile compiles pattern. Negated patterns, starting with "!", aren't
+// supported.
+func Compile(pattern string) (Pattern, error) {
+	p := Pattern{raw: pattern}
+
+	trimmed := strings.TrimSuffix(pattern, "/")
+	p.dirOnly = trimmed != pattern
+	anchored := strings.HasPrefix(trimmed, "/")
+	trimmed = strings.TrimPrefix(trimmed, "/")
+	switch {
+	case trimmed == "":
+		return Pattern{}, errors.New("empty pattern")
+	case strings.HasPrefix(trimmed, "!"):
+		return Pattern{}, fmt.Errorf("negated pattern %q isn't supported", pattern)
+	}
+
+	p.segments = strings.Split(trimmed, "/")
+	if !anchored && len(p.segments) == 1 {
+		p.segments = append([]string{"**"}, p.segments...)
+	}
+	for _, segment := range p.segments {
+		if segment == "" {
+			return Pattern{}, fmt.Errorf("pattern %q has an empty segment", pattern)
+		}
+		// a malformed segment is reported by any match
+		if _, err := path.Match(segment, ""); err != nil {
+			return Pattern{}, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+	}
+
+	return p, nil
+}
+
+// Match reports whether relPath, slash-separated and relative to the root
+// the pattern is anchored at, matches. isDir tells whether it names a
+// directory.
+func (p Pattern) Match(relPath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return matchSegments(p.segments, strings.Split(relPath, "/"))
+}
+
+func (p Pattern) String() string {
+	return p.raw
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+
+	return len(segments) == 0
+}
+
+// Set is a pattern list, matching a path that any of its patterns matches.
+type Set []Pattern
+
+// CompileSet compiles patterns.
+func CompileSet(patterns []string) (Set, error) {
+	set := make(Set, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, err := Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, p)
+	}
+
+	return set, nil
+}
+
+// Match reports whether any pattern of the set matches relPath.
+func (s Set) Match(relPath string, isDir bool) bool {
+	for _, p := range s {
+		if p.Match(relPath, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchWithin reports whether any pattern of the set matches relPath or one
+// of the directories it is in.
+func (s Set) MatchWithin(relPath string, isDir bool) bool {
+	if s.Match(relPath, isDir) {
+		return true
+	}
+	for dir := path.Dir(relPath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if s.Match(dir, true) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package pathmatch
+
+import "testing"
+
+func TestCompileRejects(t *testing.T) {
+	for _, pattern := range []string{"", "/", "!*.o", "docs//*.md", "[a"} {
+		if _, err := Compile(pattern); err == nil {
+			t.Errorf("Compile(%q) succeeded", pattern)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		// without a slash, at any depth
+		{"*.o", "main.o", false, true},
+		{"*.o", "build/obj/main.o", false, true},
+		{"*.o", "main.go", false, false},
+		{"node_modules", "node_modules", true, true},
+		{"node_modules", "web/node_modules", true, true},
+		{"node_modules", "web/node_modules_old", true, false},
+		// with a slash, at the root
+		{"docs/*.md", "docs/readme.md", false, true},
+		{"docs/*.md", "web/docs/readme.md", false, false},
+		{"docs/*.md", "docs/api/readme.md", false, false},
+		{"/build", "build", true, true},
+		{"/build", "src/build", true, false},
+		// "**" spans any number of segments, none included
+		{"docs/**/*.tmp", "docs/a.tmp", false, true},
+		{"docs/**/*.tmp", "docs/a/b/c.tmp", false, true},
+		{"docs/**/*.tmp", "src/a.tmp", false, false},
+		{"**/testdata", "testdata", true, true},
+		{"**/testdata", "pkg/x/testdata", true, true},
+		{"src/**", "src/a/b.go", false, true},
+		// a trailing slash only matches directories
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"build/", "cmd/build", true, true},
+		// segments are matched whole
+		{".git", ".github", true, false},
+		{"a?c", "abc", false, true},
+		{"a?c", "a/c", false, false},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) = %v", tt.pattern, err)
+		}
+		if got := p.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%q matching %q (directory: %t) = %t, want %t", tt.pattern, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	set, err := CompileSet([]string{"*.md", "docs/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set[0].String() != "*.md" {
+		t.Errorf("pattern compiled from %q prints as %q", "*.md", set[0])
+	}
+
+	tests := []struct {
+		path        string
+		isDir       bool
+		match       bool
+		matchWithin bool
+	}{
+		{"readme.md", false, true, true},
+		{"docs", true, true, true},
+		{"docs/guide.txt", false, false, true},
+		{"docs/api/index.html", false, false, true},
+		{"src/main.go", false, false, false},
+	}
+	for _, tt := range tests {
+		if got := set.Match(tt.path, tt.isDir); got != tt.match {
+			t.Errorf("Match(%q) = %t, want %t", tt.path, got, tt.match)
+		}
+		if got := set.MatchWithin(tt.path, tt.isDir); got != tt.matchWithin {
+			t.Errorf("MatchWithin(%q) = %t, want %t", tt.path, got, tt.matchWithin)
+		}
+	}
+
+	if _, err := CompileSet([]string{"*.md", "!keep.md"}); err == nil {
+		t.Error("CompileSet accepted a negated pattern")
+	}
+}
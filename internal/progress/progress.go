@@ -0,0 +1,416 @@
+// Package progress turns the byte counts of running transfers into rate
+// limited progress reports, shared by the sender and the receiver.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Interval is the minimum time between two progress reports.
+const Interval = 100 * time.Millisecond
+
+// RateWindow is the span AverageThroughput is averaged over.
+const RateWindow = 5 * time.Second
+
+// UnknownSize is the total to track content by whose size isn't known up
+// front, such as content read from a pipe.
+const UnknownSize uint64 = math.MaxUint64
+
+// Info describes the transfer of a single file.
+type Info struct {
+	// Name is the file's name as sent by the sender.
+	Name string
+	// Peer is the address of the other end of a transfer of a Group, such
+	// as the receiver a sender serves among others, and empty otherwise.
+	Peer string
+	// Bytes counts the bytes transferred so far, including any resumed from
+	// a partial file.
+	Bytes uint64
+	// Total is the size of the file, zero if SizeUnknown is set.
+	Total uint64
+	// SizeUnknown is set for content tracked with UnknownSize, of which
+	// Bytes tells how much passed so far.
+	SizeUnknown bool
+	// Elapsed is the time since the content started flowing.
+	Elapsed time.Duration
+	// Throughput is the rate in bytes per second since the previous report.
+	Throughput float64
+	// AverageThroughput is the rate in bytes per second over the last
+	// RateWindow, which unlike the average since the start reflects a stall
+	// or a speed-up within seconds.
+	AverageThroughput float64
+	// Done is set on the last report for the file, sent once its content has
+	// been received or the transfer failed.
+	Done bool
+	// File numbers the file among the Files of its transfer, counting from
+	// 1, and OverallBytes counts the bytes of all of them so far out of
+	// OverallTotal. They are zero where the transfer's files aren't known up
+	// front, see Batch, and OverallTotal is zero where their size isn't.
+	File, Files                int
+	OverallBytes, OverallTotal uint64
+	// Transfers counts the transfers of the file's Group running right now,
+	// this one included, and GroupBytes the bytes all of them got through
+	// so far, counted as OverallBytes counts them.
+	// They are zero where the transfer isn't part of a Group.
+	Transfers  int
+	GroupBytes uint64
+}
+
+// Group adds up the progress of transfers running at once, such as those of
+// a sender serving several receivers, so that reports on each of them tell
+// how all of them are doing as well. It is safe for concurrent use; the zero
+// Group is ready to use.
+type Group struct {
+	mu        sync.Mutex
+	transfers int
+	bytes     uint64
+}
+
+// NewBatch is NewBatch for a transfer of the group with peer, which counts
+// towards the group until End is called.
+func (g *Group) NewBatch(peer string, files int, total uint64) *Batch {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.transfers++
+	return &Batch{files: files, total: total, group: g, peer: peer}
+}
+
+func (g *Group) add(n uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.bytes += n
+}
+
+// remove takes a transfer that moved n bytes out of the group.
+func (g *Group) remove(n uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.transfers--
+	g.bytes -= n
+}
+
+func (g *Group) totals() (int, uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.transfers, g.bytes
+}
+
+// Batch adds up the progress of the files of a transfer, so that reports on
+// each of them tell how far the whole transfer got as well. It is safe for
+// concurrent use, for files transferred at once. A nil Batch adds up
+// nothing.
+type Batch struct {
+	files int
+	total uint64
+	// group is the Group the transfer belongs to, if any, with peer
+	group *Group
+	peer  string
+
+	mu    sync.Mutex
+	bytes uint64
+	ended bool
+}
+
+// NewBatch returns a Batch of a transfer of a number of files of total bytes.
+func NewBatch(files int, total uint64) *Batch {
+	return &Batch{files: files, total: total}
+}
+
+// Start is Start for file number file of the batch, counting from 1.
+func (b *Batch) Start(report func(Info), file int, name string, total, offset uint64) *Tracker {
+	t := Start(report, name, total, offset)
+	if b == nil || t == nil {
+		return t
+	}
+
+	t.batch, t.file = b, file
+	b.add(offset)
+
+	return t
+}
+
+// Skip counts n bytes the transfer won't move, e.g. of a file the receiver
+// already has, as done.
+func (b *Batch) Skip(n uint64) {
+	b.add(n)
+}
+
+// End takes the transfer out of its Group, once its files are done. It does
+// nothing for a Batch without a Group.
+func (b *Batch) End() {
+	if b == nil || b.group == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.ended {
+		b.ended = true
+		b.group.remove(b.bytes)
+	}
+}
+
+func (b *Batch) add(n uint64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bytes += n
+	if b.group != nil && !b.ended {
+		b.group.add(n)
+	}
+}
+
+func (b *Batch) transferred() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.bytes
+}
+
+// Tracker turns transferred byte counts into rate limited calls of a
+// progress callback. The callback runs on a goroutine of its own and reports
+// are dropped while it is busy, so a slow callback can't stall the transfer.
+// Add may be called from several goroutines at once, e.g. for content
+// arriving over parallel connections. A nil Tracker ignores all calls.
+type Tracker struct {
+	reports   chan Info
+	delivered chan struct{}
+
+	// mu guards the counts below
+	mu sync.Mutex
+
+	name            string
+	total           uint64
+	transferred     uint64
+	start           time.Time
+	lastReport      time.Time
+	lastTransferred uint64
+	rates           sampler
+
+	// batch is the Batch the file belongs to, if any, as file number file
+	batch *Batch
+	file  int
+}
+
+// Start tracks a file of total bytes, or UnknownSize, whose first offset
+// bytes were already there. It returns nil if report is nil.
+func Start(report func(Info), name string, total, offset uint64) *Tracker {
+	if report == nil {
+		return nil
+	}
+
+	now := time.Now()
+	t := &Tracker{
+		reports:         make(chan Info, 1),
+		delivered:       make(chan struct{}),
+		name:            name,
+		total:           total,
+		transferred:     offset,
+		start:           now,
+		lastReport:      now,
+		lastTransferred: offset,
+		rates:           sampler{window: RateWindow},
+	}
+	t.rates.add(now, offset)
+
+	go func() {
+		for info := range t.reports {
+			report(info)
+		}
+		close(t.delivered)
+	}()
+
+	return t
+}
+
+// Add records n more transferred bytes and reports them if the last report
+// is long enough ago.
+func (t *Tracker) Add(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.transferred += uint64(n)
+	t.batch.add(uint64(n))
+	if now := time.Now(); now.Sub(t.lastReport) >= Interval {
+		select {
+		case t.reports <- t.info(now, false):
+		default:
+			// the callback is still busy with the previous report
+		}
+	}
+}
+
+// Finish sends the final report and waits until the callback has returned
+// from it, so that whatever the caller does next comes after the report.
+func (t *Tracker) Finish() {
+	if t == nil {
+		return
+	}
+
+	// replace a pending report rather than waiting for the callback, the
+	// final one must not be dropped
+	t.mu.Lock()
+	select {
+	case <-t.reports:
+	default:
+	}
+	t.reports <- t.info(time.Now(), true)
+	t.mu.Unlock()
+	close(t.reports)
+	<-t.delivered
+}
+
+func (t *Tracker) info(now time.Time, done bool) Info {
+	var throughput float64
+	if elapsed := now.Sub(t.lastReport).Seconds(); elapsed > 0 {
+		throughput = float64(t.transferred-t.lastTransferred) / elapsed
+	}
+	t.lastReport = now
+	t.lastTransferred = t.transferred
+	t.rates.add(now, t.transferred)
+
+	info := Info{
+		Name:              t.name,
+		Bytes:             t.transferred,
+		Total:             t.total,
+		SizeUnknown:       t.total == UnknownSize,
+		Elapsed:           now.Sub(t.start),
+		Throughput:        throughput,
+		AverageThroughput: t.rates.rate(),
+		Done:              done,
+	}
+	if info.SizeUnknown {
+		info.Total = 0
+	}
+	if t.batch != nil {
+		info.File, info.Files = t.file, t.batch.files
+		info.OverallBytes, info.OverallTotal = t.batch.transferred(), t.batch.total
+		if t.batch.group != nil {
+			info.Peer = t.batch.peer
+			info.Transfers, info.GroupBytes = t.batch.group.totals()
+		}
+	}
+
+	return info
+}
+
+// sampleSlots is the capacity of a sampler, enough to cover RateWindow with
+// a sample every Interval
+const sampleSlots = 64
+
+// sample is the byte count of a transfer at a point in time.
+type sample struct {
+	at    time.Time
+	bytes uint64
+}
+
+// sampler keeps the samples of the last window in a ring buffer and averages
+// the rate over them. Samples are added in chronological order; once the
+// buffer is full, the oldest ones are dropped even if they are within the
+// window.
+type sampler struct {
+	window  time.Duration
+	samples [sampleSlots]sample
+	// first is the index of the oldest sample, n the number of samples
+	first, n int
+}
+
+func (s *sampler) add(at time.Time, bytes uint64) {
+	if s.n == sampleSlots {
+		s.first = (s.first + 1) % sampleSlots
+		s.n--
+	}
+	s.samples[(s.first+s.n)%sampleSlots] = sample{at: at, bytes: bytes}
+	s.n++
+
+	// keep a single sample at or before the window's start, so the average
+	// covers the whole window rather than only the samples inside it
+	for s.n > 1 && at.Sub(s.samples[(s.first+1)%sampleSlots].at) >= s.window {
+		s.first = (s.first + 1) % sampleSlots
+		s.n--
+	}
+}
+
+// rate returns the bytes per second between the oldest and the newest
+// sample, or 0 if they are fewer than two or no time passed between them.
+func (s *sampler) rate() float64 {
+	if s.n < 2 {
+		return 0
+	}
+
+	oldest := s.samples[s.first]
+	newest := s.samples[(s.first+s.n-1)%sampleSlots]
+	elapsed := newest.at.Sub(oldest.at)
+	if elapsed <= 0 || newest.bytes < oldest.bytes {
+		return 0
+	}
+
+	return float64(newest.bytes-oldest.bytes) / elapsed.Seconds()
+}
+
+// reader reports the bytes read through it to a Tracker.
+type reader struct {
+	r io.Reader
+	t *Tracker
+}
+
+// Reader returns a reader that reports the bytes read from r to t. It returns
+// r itself if t is nil.
+func (t *Tracker) Reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &reader{r: r, t: t}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.t.Add(n)
+	return n, err
+}
+
+// writer reports the bytes written through it to a Tracker.
+type writer struct {
+	w io.Writer
+	t *Tracker
+}
+
+// Writer returns a writer that reports the bytes written to w to t. It
+// returns w itself if t is nil.
+func (t *Tracker) Writer(w io.Writer) io.Writer {
+	if t == nil {
+		return w
+	}
+	return &writer{w: w, t: t}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.t.Add(n)
+	return n, err
+}
+
+// FormatSize renders n bytes in decimal units, e.g. "14.0 MB".
+func FormatSize(n float64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	unit := 0
+	for n >= 1000 && unit < len(units)-1 {
+		n /= 1000
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", n, units[unit])
+}
@@ -0,0 +1,193 @@
+package progress
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// feed adds a sample every step from start on, of as many bytes per second
+// as rate says at each point in time, and returns the time and byte count it
+// stopped at.
+func feed(s *sampler, start time.Time, bytes uint64, step, span time.Duration, rate float64) (time.Time, uint64) {
+	at := start
+	for elapsed := time.Duration(0); elapsed < span; elapsed += step {
+		at = at.Add(step)
+		bytes += uint64(rate * step.Seconds())
+		s.add(at, bytes)
+	}
+	return at, bytes
+}
+
+func TestSamplerRate(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name string
+		run  func(s *sampler)
+		want float64
+	}{
+		{name: "no samples", run: func(s *sampler) {}, want: 0},
+		{name: "one sample", run: func(s *sampler) { s.add(start, 100) }, want: 0},
+		{name: "no time passed", run: func(s *sampler) {
+			s.add(start, 100)
+			s.add(start, 200)
+		}, want: 0},
+		{name: "steady", run: func(s *sampler) {
+			s.add(start, 0)
+			feed(s, start, 0, Interval, 10*time.Second, 1000)
+		}, want: 1000},
+		{name: "shorter than the window", run: func(s *sampler) {
+			s.add(start, 0)
+			feed(s, start, 0, Interval, 2*time.Second, 1000)
+		}, want: 1000},
+		{name: "stalled for part of the window", run: func(s *sampler) {
+			// 2 of the last 5 seconds at 1000 B/s, then nothing
+			s.add(start, 0)
+			at, bytes := feed(s, start, 0, Interval, 10*time.Second, 1000)
+			feed(s, at, bytes, Interval, 3*time.Second, 0)
+		}, want: 400},
+		{name: "stalled for the whole window", run: func(s *sampler) {
+			s.add(start, 0)
+			at, bytes := feed(s, start, 0, Interval, 10*time.Second, 1000)
+			feed(s, at, bytes, Interval, 6*time.Second, 0)
+		}, want: 0},
+		{name: "sped up", run: func(s *sampler) {
+			s.add(start, 0)
+			at, bytes := feed(s, start, 0, Interval, 60*time.Second, 10)
+			feed(s, at, bytes, Interval, 5*time.Second, 1e6)
+		}, want: 1e6},
+		{name: "more samples than slots", run: func(s *sampler) {
+			// the oldest are dropped, the rate is that of the newest
+			s.add(start, 0)
+			feed(s, start, 0, 10*time.Millisecond, 10*time.Second, 1000)
+		}, want: 1000},
+		{name: "count going back", run: func(s *sampler) {
+			s.add(start, 1000)
+			s.add(start.Add(time.Second), 10)
+		}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := sampler{window: RateWindow}
+			tt.run(&s)
+			if got := s.rate(); math.Abs(got-tt.want) > tt.want*0.001 {
+				t.Errorf("rate = %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSamplerKeepsTheWindow(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	s := sampler{window: RateWindow}
+	s.add(start, 0)
+	at, _ := feed(&s, start, 0, Interval, 20*time.Second, 1000)
+
+	oldest := s.samples[s.first].at
+	if span := at.Sub(oldest); span != RateWindow {
+		t.Errorf("samples span %s, want the %s window", span, RateWindow)
+	}
+	if s.n > sampleSlots {
+		t.Errorf("%d samples in %d slots", s.n, sampleSlots)
+	}
+}
+
+// collector records the reports of a Tracker.
+type collector struct {
+	mu    sync.Mutex
+	infos []Info
+}
+
+func (c *collector) report(info Info) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.infos = append(c.infos, info)
+}
+
+func TestTrackerFinishReports(t *testing.T) {
+	var c collector
+	tracker := Start(c.report, "a.bin", 1000, 400)
+	tracker.Add(100)
+	tracker.Add(500)
+	tracker.Finish()
+
+	last := c.infos[len(c.infos)-1]
+	if !last.Done || last.Bytes != 1000 || last.Total != 1000 || last.Name != "a.bin" {
+		t.Errorf("last report = %+v, want all 1000 bytes of a.bin done", last)
+	}
+}
+
+func TestTrackerUnknownSize(t *testing.T) {
+	var c collector
+	tracker := Start(c.report, "stdin", UnknownSize, 0)
+	tracker.Add(42)
+	tracker.Finish()
+
+	last := c.infos[len(c.infos)-1]
+	if !last.SizeUnknown || last.Total != 0 || last.Bytes != 42 {
+		t.Errorf("last report = %+v, want 42 bytes of an unknown size", last)
+	}
+}
+
+func TestNilTracker(t *testing.T) {
+	var tracker *Tracker
+	if Start(nil, "a", 1, 0) != nil {
+		t.Error("Start without a callback returned a Tracker")
+	}
+	tracker.Add(1)
+	tracker.Finish()
+	var batch *Batch
+	if batch.Start(nil, 1, "a", 1, 0) != nil {
+		t.Error("Batch.Start without a callback returned a Tracker")
+	}
+}
+
+func TestBatchAndGroup(t *testing.T) {
+	var g Group
+	var c collector
+	batch := g.NewBatch("192.0.2.1:9000", 2, 300)
+	other := g.NewBatch("192.0.2.2:9000", 1, 50)
+	other.Skip(50)
+
+	first := batch.Start(c.report, 1, "a", 100, 0)
+	first.Add(100)
+	first.Finish()
+	batch.Skip(20)
+	second := batch.Start(c.report, 2, "b", 200, 30)
+	second.Add(170)
+	second.Finish()
+
+	last := c.infos[len(c.infos)-1]
+	if last.File != 2 || last.Files != 2 || last.OverallBytes != 320 || last.OverallTotal != 300 {
+		t.Errorf("last report = %+v, want file 2 of 2 and 320 bytes overall", last)
+	}
+	if last.Peer != "192.0.2.1:9000" || last.Transfers != 2 || last.GroupBytes != 370 {
+		t.Errorf("last report = %+v, want 2 transfers of 370 bytes in the group", last)
+	}
+
+	batch.End()
+	batch.End()
+	if transfers, bytes := g.totals(); transfers != 1 || bytes != 50 {
+		t.Errorf("group = %d transfers of %d bytes, want the other one left", transfers, bytes)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		n    float64
+		want string
+	}{
+		{0, "0.0 B"},
+		{999, "999.0 B"},
+		{1000, "1.0 kB"},
+		{14_000_000, "14.0 MB"},
+		{2.5e12, "2.5 TB"},
+		{3e15, "3000.0 TB"},
+	}
+	for _, tt := range tests {
+		if got := FormatSize(tt.n); got != tt.want {
+			t.Errorf("FormatSize(%g) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Package quicconn carries fileshare connections over QUIC, see
+// protocol.TransportQUIC. It hands out the streams of a connection as
+// net.Conns, so the sender and the receiver speak the protocol on them as on
+// any other connection.
+package quicconn
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/quic-go/quic-go"
+)
+
+// lingerTimeout bounds how long closing the receiver's end of a connection
+// waits for the sender to close it. Closing a QUIC connection drops what
+// wasn't delivered yet, such as the Ack of the last file.
+const lingerTimeout = 2 * time.Second
+
+// quicConfig keeps connections alive while a file is being prepared or
+// confirmed on a stream that carries nothing else.
+var quicConfig = &quic.Config{
+	KeepAlivePeriod: 15 * time.Second,
+	MaxIdleTimeout:  time.Minute,
+}
+
+// Conn is a stream of a QUIC connection. The control stream a connection
+// starts with closes the whole connection along with it, the streams opened
+// or accepted with it only close themselves.
+type Conn struct {
+	quic.Stream
+	conn quic.Connection
+	// control is set for the stream the connection started with
+	control bool
+	// linger is set for the control stream of the dialing side
+	linger bool
+	// transport is the one Dial set up on a socket of its own, closed
+	// along with the connection
+	transport *quic.Transport
+}
+
+// Dial connects to the QUIC listener at addr from laddr, or from an address
+// the system picks if it is nil, and opens the control stream.
+// config.NextProtos is set to protocol.QUICProtocol.
+func Dial(ctx context.Context, laddr *net.UDPAddr, addr string, config *tls.Config) (*Conn, error) {
+	config = config.Clone()
+	config.NextProtos = []string{protocol.QUICProtocol}
+
+	if laddr == nil {
+		conn, err := quic.DialAddr(ctx, addr, config, quicConfig.Clone())
+		if err != nil {
+			return nil, err
+		}
+		return openControl(ctx, conn, nil)
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	udp, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	transport := &quic.Transport{Conn: udp}
+	conn, err := transport.Dial(ctx, raddr, config, quicConfig.Clone())
+	if err != nil {
+		closeTransport(transport)
+		return nil, err
+	}
+
+	return openControl(ctx, conn, transport)
+}
+
+// openControl opens the control stream of the dialed conn, closing conn and
+// transport if that fails.
+func openControl(ctx context.Context, conn quic.Connection, transport *quic.Transport) (*Conn, error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		closeTransport(transport)
+		return nil, err
+	}
+
+	return &Conn{Stream: stream, conn: conn, control: true, linger: true, transport: transport}, nil
+}
+
+// closeTransport closes transport, if not nil, and its socket, which
+// transports don't close when they were handed it.
+func closeTransport(transport *quic.Transport) {
+	if transport == nil {
+		return
+	}
+	transport.Close()
+	transport.Conn.Close()
+}
+
+// HandshakeFailed reports whether Dial failed in the TLS handshake, e.g. on a
+// certificate that didn't verify, rather than for lack of an answer. Unlike
+// the latter, retrying won't help.
+func HandshakeFailed(err error) bool {
+	var transportErr *quic.TransportError
+	return errors.As(err, &transportErr) && transportErr.ErrorCode.IsCryptoError()
+}
+
+// OpenStream opens a stream of c's connection.
+func (c *Conn) OpenStream(ctx context.Context) (*Conn, error) {
+	stream, err := c.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{Stream: stream, conn: c.conn}, nil
+}
+
+// AcceptStream waits for the peer to open a stream of c's connection.
+func (c *Conn) AcceptStream(ctx context.Context) (*Conn, error) {
+	stream, err := c.conn.AcceptStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{Stream: stream, conn: c.conn}, nil
+}
+
+// LocalAddr implements net.Conn.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr implements net.Conn.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes both directions of the stream, and of the connection if c is
+// its control stream.
+func (c *Conn) Close() error {
+	c.Stream.CancelRead(0)
+	err := c.Stream.Close()
+	if !c.control {
+		return err
+	}
+
+	if c.linger {
+		timer := time.NewTimer(lingerTimeout)
+		select {
+		case <-c.conn.Context().Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+
+	err = c.conn.CloseWithError(0, "")
+	closeTransport(c.transport)
+	return err
+}
+
+// Listener accepts QUIC connections as their control streams.
+type Listener struct {
+	listener *quic.Listener
+	accepted chan *Conn
+	done     chan struct{}
+	// err is why the listener stopped, set before done is closed
+	err error
+}
+
+// Listen listens for QUIC connections on the UDP address addr.
+// config.NextProtos is set to protocol.QUICProtocol.
+func Listen(addr string, config *tls.Config) (*Listener, error) {
+	config = config.Clone()
+	config.NextProtos = []string{protocol.QUICProtocol}
+
+	listener, err := quic.ListenAddr(addr, config, quicConfig.Clone())
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Listener{listener: listener, accepted: make(chan *Conn), done: make(chan struct{})}
+	go l.serve()
+
+	return l, nil
+}
+
+// serve accepts connections and waits for their control streams, each on its
+// own goroutine so a peer that goes quiet doesn't hold up the others.
+func (l *Listener) serve() {
+	for {
+		conn, err := l.listener.Accept(context.Background())
+		if err != nil {
+			if errors.Is(err, quic.ErrServerClosed) {
+				err = net.ErrClosed
+			}
+			l.err = err
+			close(l.done)
+			return
+		}
+
+		go func() {
+			ctx, cancel := context.WithTimeout(conn.Context(), protocol.PreambleTimeout)
+			defer cancel()
+
+			stream, err := conn.AcceptStream(ctx)
+			if err != nil {
+				conn.CloseWithError(0, "")
+				return
+			}
+			select {
+			case l.accepted <- &Conn{Stream: stream, conn: conn, control: true}:
+			case <-l.done:
+				conn.CloseWithError(0, "")
+			}
+		}()
+	}
+}
+
+// Accept implements net.Listener. The connections it returns are *Conns.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accepted:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// Close implements net.Listener. Connections accepted before stay open.
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
+
+// Addr implements net.Listener.
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
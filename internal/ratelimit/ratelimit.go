@@ -0,0 +1,140 @@
+// Package ratelimit throttles transfers with a token bucket.
+package ratelimit
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// burstWindow is how much unused rate the bucket saves up while idle.
+const burstWindow = 100 * time.Millisecond
+
+// Limiter is a token bucket holding one token per byte. It is safe for
+// concurrent use, the rate is then shared by all callers. A nil Limiter
+// doesn't limit anything.
+type Limiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// New returns a Limiter allowing bytesPerSec bytes per second, or nil if
+// bytesPerSec is not positive.
+func New(bytesPerSec int64) *Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+
+	rate := float64(bytesPerSec)
+	burst := max(rate*burstWindow.Seconds(), 1)
+	return &Limiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until n more bytes fit the rate. Requests larger than the
+// bucket are let through and paid off by waiting afterwards, so the average
+// rate holds for any n.
+func (l *Limiter) Wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burst)
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / l.rate * float64(time.Second)))
+	}
+}
+
+// Delay returns how long Wait would block for n more bytes right now,
+// without taking them, for callers with something else to do meanwhile.
+// Requests larger than the bucket are delayed until it is full.
+func (l *Limiter) Delay(n int) time.Duration {
+	if l == nil || n <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tokens := min(l.tokens+time.Since(l.last).Seconds()*l.rate, l.burst)
+	deficit := min(float64(n), l.burst) - tokens
+	if deficit <= 0 {
+		return 0
+	}
+
+	return time.Duration(deficit / l.rate * float64(time.Second))
+}
+
+// reader throttles reads from r.
+type reader struct {
+	r io.Reader
+	l *Limiter
+}
+
+// NewReader returns a reader whose reads from r are limited by l. It returns
+// r itself if l is nil.
+func NewReader(r io.Reader, l *Limiter) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{r: r, l: l}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	// reading no more than the bucket holds keeps the rate smooth instead
+	// of alternating between big reads and long waits
+	if burst := int(r.l.burst); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := r.r.Read(p)
+	r.l.Wait(n)
+	return n, err
+}
+
+// writer throttles writes to w.
+type writer struct {
+	w io.Writer
+	l *Limiter
+}
+
+// NewWriter returns a writer whose writes to w are limited by l. It returns
+// w itself if l is nil.
+func NewWriter(w io.Writer, l *Limiter) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &writer{w: w, l: l}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	// writes no larger than the bucket keep the rate smooth, as for reads
+	burst := int(w.l.burst)
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), burst)]
+		w.l.Wait(len(chunk))
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// assertRate fails the test unless moving n bytes at bytesPerSec took
+// elapsed, give or take 10%. The bucket starts full, so its burst moves
+// right away.
+func assertRate(t *testing.T, n int, bytesPerSec int64, elapsed time.Duration) {
+	t.Helper()
+
+	want := time.Duration(float64(n)/float64(bytesPerSec)*float64(time.Second)) - burstWindow
+	if elapsed < want*9/10 || elapsed > want*11/10 {
+		t.Errorf("%d bytes at %d B/s took %s, want %s ± 10%%", n, bytesPerSec, elapsed, want)
+	}
+}
+
+func TestReaderRate(t *testing.T) {
+	const n, rate = 512 << 10, 1 << 20
+	r := NewReader(bytes.NewReader(make([]byte, n)), New(rate))
+
+	start := time.Now()
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	assertRate(t, n, rate, time.Since(start))
+}
+
+func TestWriterRate(t *testing.T) {
+	const n, rate = 512 << 10, 1 << 20
+	w := NewWriter(io.Discard, New(rate))
+
+	start := time.Now()
+	// one large write is split, not let through at once
+	if _, err := w.Write(make([]byte, n)); err != nil {
+		t.Fatal(err)
+	}
+	assertRate(t, n, rate, time.Since(start))
+}
+
+func TestSharedLimiter(t *testing.T) {
+	const n, rate = 256 << 10, 1 << 20
+	l := New(rate)
+
+	start := time.Now()
+	done := make(chan struct{})
+	for range 2 {
+		go func() {
+			io.Copy(io.Discard, NewReader(bytes.NewReader(make([]byte, n)), l))
+			done <- struct{}{}
+		}()
+	}
+	<-done
+	<-done
+	// the two share the rate
+	assertRate(t, 2*n, rate, time.Since(start))
+}
+
+func TestNilLimiter(t *testing.T) {
+	if New(0) != nil || New(-1) != nil {
+		t.Fatal("New of a non-positive rate returned a limiter")
+	}
+	src := bytes.NewReader(nil)
+	if NewReader(src, nil) != io.Reader(src) {
+		t.Error("NewReader wrapped the reader without a limiter")
+	}
+	var l *Limiter
+	l.Wait(1 << 30)
+	if d := l.Delay(1 << 30); d != 0 {
+		t.Errorf("Delay = %s, want none without a limiter", d)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	l := New(1000)
+	if d := l.Delay(50); d != 0 {
+		t.Errorf("Delay of a full bucket = %s, want none", d)
+	}
+	l.Wait(100)
+	// the bucket holds 100 bytes, refilled at 1000 a second
+	if d := l.Delay(100); d < 90*time.Millisecond || d > 100*time.Millisecond {
+		t.Errorf("Delay of an empty bucket = %s, want about 100ms", d)
+	}
+	if d := l.Delay(1 << 20); d > 100*time.Millisecond {
+		t.Errorf("Delay of more than the bucket holds = %s, want it capped at the bucket", d)
+	}
+}
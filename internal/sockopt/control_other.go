@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package sockopt
+
+const (
+	soRcvbuf = iota
+	soSndbuf
+)
+
+// setBuffer is a no-op where the standard library has no setsockopt; Apply
+// still sets the buffers on the connection.
+func setBuffer(fd uintptr, opt, size int) error {
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package sockopt
+
+import "syscall"
+
+const (
+	soRcvbuf = syscall.SO_RCVBUF
+	soSndbuf = syscall.SO_SNDBUF
+)
+
+func setBuffer(fd uintptr, opt, size int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, opt, size)
+}
@@ -0,0 +1,12 @@
+package sockopt
+
+import "syscall"
+
+const (
+	soRcvbuf = syscall.SO_RCVBUF
+	soSndbuf = syscall.SO_SNDBUF
+)
+
+func setBuffer(fd uintptr, opt, size int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, opt, size)
+}
@@ -0,0 +1,109 @@
+// Package sockopt tunes the TCP sockets transfers run over.
+package sockopt
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Options are the socket settings to apply. The zero value changes nothing,
+// leaving Go's and the kernel's defaults: TCP_NODELAY set, keep-alive probes
+// every 15 seconds and buffers sized by the kernel, which on Linux grows them
+// with the connection's throughput.
+//
+// Which knobs matter depends on the workload. Many small files are
+// latency bound, and Nagle's algorithm, turned back on with Delay, holds
+// back the last segment of every small write; leave it off. Large files over
+// links with a lot of bandwidth and latency are bound by the TCP window,
+// which the buffers cap: set them to about the bandwidth-delay product, e.g.
+// 8 MiB for a gigabit link with 64ms round trips, if the kernel doesn't grow
+// them that far on its own. KeepAlive only matters for idle connections,
+// such as one waiting for a file to be accepted, that a NAT or firewall on
+// the way drops after a while.
+type Options struct {
+	// Delay turns Nagle's algorithm back on, coalescing small writes.
+	Delay bool
+	// ReadBuffer and WriteBuffer are the sizes of SO_RCVBUF and SO_SNDBUF
+	// in bytes, zero keeps the kernel's.
+	ReadBuffer, WriteBuffer int
+	// KeepAlive is the interval of keep-alive probes, zero keeps the
+	// default and a negative one turns them off.
+	KeepAlive time.Duration
+}
+
+// Apply sets the options on c, after it was dialed or accepted. Connections
+// that aren't TCP, once any TLS is unwrapped, e.g. QUIC ones, are left
+// alone.
+func (o Options) Apply(c net.Conn) error {
+	if tlsCon, ok := c.(*tls.Conn); ok {
+		c = tlsCon.NetConn()
+	}
+	tcp, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	if o.Delay {
+		if err := tcp.SetNoDelay(false); err != nil {
+			return fmt.Errorf("err setting TCP_NODELAY: %w", err)
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tcp.SetReadBuffer(o.ReadBuffer); err != nil {
+			return fmt.Errorf("err setting SO_RCVBUF: %w", err)
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tcp.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return fmt.Errorf("err setting SO_SNDBUF: %w", err)
+		}
+	}
+	switch {
+	case o.KeepAlive > 0:
+		if err := tcp.SetKeepAlivePeriod(o.KeepAlive); err != nil {
+			return fmt.Errorf("err setting keep-alive interval: %w", err)
+		}
+	case o.KeepAlive < 0:
+		if err := tcp.SetKeepAlive(false); err != nil {
+			return fmt.Errorf("err turning off keep-alive: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Control sets the buffer sizes on a socket before it connects or listens,
+// for use as net.Dialer.Control and net.ListenConfig.Control; accepted
+// sockets inherit them from the listening one. The window scale of a TCP
+// connection is agreed on during its handshake, after the receive buffer it
+// starts with, so only a buffer set this early can open the window past
+// 64 KiB where the kernel wouldn't on its own. Apply sets them again either
+// way.
+func (o Options) Control(network, address string, c syscall.RawConn) error {
+	if o.ReadBuffer == 0 && o.WriteBuffer == 0 {
+		return nil
+	}
+
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if o.ReadBuffer > 0 {
+			if sockErr = setBuffer(fd, soRcvbuf, o.ReadBuffer); sockErr != nil {
+				sockErr = fmt.Errorf("err setting SO_RCVBUF: %w", sockErr)
+				return
+			}
+		}
+		if o.WriteBuffer > 0 {
+			if sockErr = setBuffer(fd, soSndbuf, o.WriteBuffer); sockErr != nil {
+				sockErr = fmt.Errorf("err setting SO_SNDBUF: %w", sockErr)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
@@ -0,0 +1,91 @@
+package sockopt
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// tcpPair returns both ends of a localhost TCP connection, the dialed one
+// first.
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	dialed, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { dialed.Close() })
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	t.Cleanup(func() { accepted.Close() })
+
+	return dialed.(*net.TCPConn), accepted.(*net.TCPConn)
+}
+
+func TestApplyLeavesOtherConnsAlone(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	o := Options{Delay: true, ReadBuffer: 1 << 20, WriteBuffer: 1 << 20, KeepAlive: time.Second}
+	if err := o.Apply(a); err != nil {
+		t.Errorf("Apply on a pipe = %v, want it left alone", err)
+	}
+}
+
+func TestApplyToClosedConn(t *testing.T) {
+	// a closed socket makes every setsockopt fail, which shows each option
+	// is actually attempted
+	tests := []struct {
+		name string
+		o    Options
+		want bool
+	}{
+		{"zero", Options{}, false},
+		{"delay", Options{Delay: true}, true},
+		{"read buffer", Options{ReadBuffer: 1 << 20}, true},
+		{"write buffer", Options{WriteBuffer: 1 << 20}, true},
+		{"keep-alive", Options{KeepAlive: time.Second}, true},
+		{"no keep-alive", Options{KeepAlive: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			con, _ := tcpPair(t)
+			con.Close()
+			if err := tt.o.Apply(con); (err != nil) != tt.want {
+				t.Errorf("Apply = %v, want an error: %t", err, tt.want)
+			}
+		})
+	}
+}
+
+// countingRawConn counts the calls of Control, running none of them.
+type countingRawConn struct {
+	syscall.RawConn
+	controls *int
+}
+
+func (c countingRawConn) Control(f func(fd uintptr)) error {
+	*c.controls++
+	return nil
+}
+
+func TestControlWithoutBuffers(t *testing.T) {
+	var controls int
+	o := Options{Delay: true, KeepAlive: time.Second}
+	if err := o.Control("tcp", "127.0.0.1:0", countingRawConn{controls: &controls}); err != nil {
+		t.Fatalf("Control = %v", err)
+	}
+	if controls != 0 {
+		t.Errorf("the socket was controlled %d times, want it left to Apply", controls)
+	}
+}
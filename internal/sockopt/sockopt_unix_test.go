@@ -0,0 +1,114 @@
+//go:build unix
+
+package sockopt
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// getsockopt returns the value of the integer option opt at level of c.
+func getsockopt(t *testing.T, c syscall.Conn, level, opt int) int {
+	t.Helper()
+
+	raw, err := c.SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn: %v", err)
+	}
+	var value int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		value, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+	}); err != nil {
+		t.Fatalf("Control: %v", err)
+	}
+	if sockErr != nil {
+		t.Fatalf("getsockopt: %v", sockErr)
+	}
+
+	return value
+}
+
+// assertBuffers fails the test unless SO_RCVBUF and SO_SNDBUF of c are
+// size, which is smaller than the kernel's defaults. Linux reports twice the
+// size asked for, to account for its bookkeeping.
+func assertBuffers(t *testing.T, c syscall.Conn, size int) {
+	t.Helper()
+
+	for _, opt := range []struct {
+		name string
+		opt  int
+	}{{"SO_RCVBUF", syscall.SO_RCVBUF}, {"SO_SNDBUF", syscall.SO_SNDBUF}} {
+		if got := getsockopt(t, c, syscall.SOL_SOCKET, opt.opt); got < size || got > 2*size {
+			t.Errorf("%s = %d, want %d", opt.name, got, size)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	const buffer = 16 << 10
+
+	tests := []struct {
+		name string
+		o    Options
+		// noDelay and keepAlive are the wanted TCP_NODELAY and SO_KEEPALIVE,
+		// buffers whether SO_RCVBUF and SO_SNDBUF are to be buffer
+		noDelay, keepAlive, buffers bool
+	}{
+		{"defaults", Options{}, true, true, false},
+		{"delay", Options{Delay: true}, false, true, false},
+		{"buffers", Options{ReadBuffer: buffer, WriteBuffer: buffer}, true, true, true},
+		{"keep-alive", Options{KeepAlive: time.Minute}, true, true, false},
+		{"no keep-alive", Options{KeepAlive: -1}, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			con, _ := tcpPair(t)
+			if err := tt.o.Apply(con); err != nil {
+				t.Fatalf("Apply = %v", err)
+			}
+
+			if got := getsockopt(t, con, syscall.IPPROTO_TCP, syscall.TCP_NODELAY) != 0; got != tt.noDelay {
+				t.Errorf("TCP_NODELAY = %t, want %t", got, tt.noDelay)
+			}
+			if got := getsockopt(t, con, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE) != 0; got != tt.keepAlive {
+				t.Errorf("SO_KEEPALIVE = %t, want %t", got, tt.keepAlive)
+			}
+			if !tt.buffers {
+				return
+			}
+			assertBuffers(t, con, buffer)
+		})
+	}
+}
+
+func TestControlSetsBuffersBeforeListening(t *testing.T) {
+	const buffer = 16 << 10
+
+	o := Options{ReadBuffer: buffer, WriteBuffer: buffer}
+	config := net.ListenConfig{Control: o.Control}
+	l, err := config.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen = %v", err)
+	}
+	defer l.Close()
+
+	// accepted connections inherit the buffers of the listening socket
+	dialed, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer dialed.Close()
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	defer accepted.Close()
+
+	for _, c := range []syscall.Conn{l.(*net.TCPListener), accepted.(*net.TCPConn)} {
+		assertBuffers(t, c, buffer)
+	}
+}
@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// BlockSize is the length of every content block sent under FlagBlocks but
+// the last one of a file, which holds the remainder.
+const BlockSize = 1 << 20
+
+// MaxRetransmitRounds is how often a receiver asks for corrupt blocks again
+// before it gives up on the file.
+const MaxRetransmitRounds = 3
+
+// castagnoli is the CRC32C table blocks are checksummed with.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// BlockCRC returns the CRC32C of a block's data.
+func BlockCRC(data []byte) uint32 {
+	return crc32.Checksum(data, castagnoli)
+}
+
+// BlockCount returns the number of blocks size bytes of content are split
+// into.
+func BlockCount(size uint64) uint64 {
+	return (size + BlockSize - 1) / BlockSize
+}
+
+// BlockLength returns the length of block index of size bytes of content.
+func BlockLength(size, index uint64) uint32 {
+	return uint32(min(BlockSize, size-index*BlockSize))
+}
+
+// BlockHeader precedes the data of every block. Index counts from the resume
+// offset the content starts at, CRC is the BlockCRC of the data.
+type BlockHeader struct {
+	Index  uint64
+	Length uint32
+	CRC    uint32
+}
+
+// WriteBlock writes block index and its data.
+func WriteBlock(w io.Writer, index uint64, data []byte) error {
+	header := BlockHeader{Index: index, Length: uint32(len(data)), CRC: BlockCRC(data)}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+// ReadBlockHeader reads the header of a block of size bytes of content. An
+// index or length that doesn't fit the content is rejected with
+// ErrInvalidFrame before the data is read.
+func ReadBlockHeader(r io.Reader, size uint64) (BlockHeader, error) {
+	var h BlockHeader
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return h, err
+	}
+
+	if h.Index >= BlockCount(size) {
+		return h, fmt.Errorf("%w: block %d of %d", ErrInvalidFrame, h.Index, BlockCount(size))
+	}
+	if want := BlockLength(size, h.Index); h.Length != want {
+		return h, fmt.Errorf("%w: block %d of %d bytes, want %d", ErrInvalidFrame, h.Index, h.Length, want)
+	}
+
+	return h, nil
+}
+
+// WriteBlockRequest asks for the blocks at indexes to be sent again: a
+// uint32 count followed by the uint64 indexes. An empty request ends the
+// content.
+func WriteBlockRequest(w io.Writer, indexes []uint64) error {
+	request := make([]byte, 0, 4+8*len(indexes))
+	request = binary.LittleEndian.AppendUint32(request, uint32(len(indexes)))
+	for _, index := range indexes {
+		request = binary.LittleEndian.AppendUint64(request, index)
+	}
+
+	_, err := w.Write(request)
+	return err
+}
+
+// ReadBlockRequest reads a request for blocks of size bytes of content.
+// Counts and indexes that don't fit the content are rejected with
+// ErrInvalidFrame before anything is allocated for them.
+func ReadBlockRequest(r io.Reader, size uint64) ([]uint64, error) {
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	if uint64(count) > BlockCount(size) {
+		return nil, fmt.Errorf("%w: %d blocks requested of %d", ErrInvalidFrame, count, BlockCount(size))
+	}
+
+	indexes := make([]uint64, count)
+	if err := binary.Read(r, binary.LittleEndian, indexes); err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		if index >= BlockCount(size) {
+			return nil, fmt.Errorf("%w: block %d requested of %d", ErrInvalidFrame, index, BlockCount(size))
+		}
+	}
+
+	return indexes, nil
+}
@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBlockCountAndLength(t *testing.T) {
+	tests := []struct {
+		size      uint64
+		count     uint64
+		lastBlock uint32
+	}{
+		{size: 1, count: 1, lastBlock: 1},
+		{size: BlockSize - 1, count: 1, lastBlock: BlockSize - 1},
+		{size: BlockSize, count: 1, lastBlock: BlockSize},
+		{size: BlockSize + 1, count: 2, lastBlock: 1},
+		{size: 1 << 40, count: 1 << 20, lastBlock: BlockSize},
+	}
+	for _, tt := range tests {
+		if got := BlockCount(tt.size); got != tt.count {
+			t.Errorf("BlockCount(%d) = %d, want %d", tt.size, got, tt.count)
+		}
+		if got := BlockLength(tt.size, tt.count-1); got != tt.lastBlock {
+			t.Errorf("BlockLength(%d, %d) = %d, want %d", tt.size, tt.count-1, got, tt.lastBlock)
+		}
+	}
+	if got := BlockCount(0); got != 0 {
+		t.Errorf("BlockCount(0) = %d, want 0", got)
+	}
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	const size = BlockSize + 10
+	data := []byte("last ten b")
+	var buf bytes.Buffer
+	if err := WriteBlock(&buf, 1, data); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := ReadBlockHeader(&buf, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Index != 1 || h.Length != 10 || h.CRC != BlockCRC(data) {
+		t.Errorf("header = %+v, want block 1 of 10 bytes", h)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("data = %q, want %q", buf.Bytes(), data)
+	}
+	// CRC32C, not the IEEE polynomial
+	if got := BlockCRC([]byte("123456789")); got != 0xE3069283 {
+		t.Errorf("BlockCRC = %#x, want the CRC32C check value", got)
+	}
+}
+
+func TestReadBlockHeaderRejects(t *testing.T) {
+	const size = BlockSize + 10
+	tests := []struct {
+		name  string
+		index uint64
+		data  []byte
+	}{
+		{name: "index past the end", index: 2, data: make([]byte, 10)},
+		{name: "short middle block", index: 0, data: make([]byte, 10)},
+		{name: "long last block", index: 1, data: make([]byte, 11)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			WriteBlock(&buf, tt.index, tt.data)
+			if _, err := ReadBlockHeader(&buf, size); !errors.Is(err, ErrInvalidFrame) {
+				t.Errorf("ReadBlockHeader = %v, want ErrInvalidFrame", err)
+			}
+		})
+	}
+}
+
+func TestBlockRequestRoundTrip(t *testing.T) {
+	const size = 10 * BlockSize
+	for _, indexes := range [][]uint64{{}, {0}, {3, 7, 9}} {
+		var buf bytes.Buffer
+		if err := WriteBlockRequest(&buf, indexes); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadBlockRequest(&buf, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, indexes) {
+			t.Errorf("ReadBlockRequest = %v, want %v", got, indexes)
+		}
+	}
+}
+
+func TestReadBlockRequestRejects(t *testing.T) {
+	const size = 2 * BlockSize
+	tests := []struct {
+		name    string
+		request []byte
+	}{
+		// nothing follows the count: it is refused before the indexes are
+		// read, let alone allocated for
+		{name: "more blocks than there are", request: []byte{0xff, 0xff, 0xff, 0xff}},
+		{name: "index past the end", request: func() []byte {
+			var buf bytes.Buffer
+			WriteBlockRequest(&buf, []uint64{1, 2})
+			return buf.Bytes()
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadBlockRequest(bytes.NewReader(tt.request), size); !errors.Is(err, ErrInvalidFrame) {
+				t.Errorf("ReadBlockRequest = %v, want ErrInvalidFrame", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DefaultMTU is the datagram size a sender uses under FlagUDP unless
+// configured otherwise, which leaves room for the IP and UDP headers within
+// the 1500 bytes of an Ethernet frame. MinMTU and MaxMTU bound it.
+const (
+	DefaultMTU = 1400
+	MinMTU     = 128
+	MaxMTU     = 65507
+)
+
+// TokenSize is the length of the token that opens every datagram of a file,
+// so datagrams of other transfers and strays are told apart.
+const TokenSize = 8
+
+// Datagram types.
+const (
+	// DatagramData carries a sequence number and a payload, from the sender
+	// to the receiver.
+	DatagramData uint8 = 0
+	// DatagramAck tells the sender the lowest sequence number the receiver
+	// is missing and, as a bit mask, which of the 64 after it arrived, along
+	// with the sequence number of the datagram it answers.
+	DatagramAck uint8 = 1
+	// DatagramNack lists sequence numbers the receiver found missing, to be
+	// sent again right away.
+	DatagramNack uint8 = 2
+)
+
+// DataHeaderSize is the overhead of a DatagramData: its type, token and
+// sequence number.
+const DataHeaderSize = 1 + TokenSize + 8
+
+// MaxNacks bounds the sequence numbers of a DatagramNack.
+const MaxNacks = 64
+
+// UDPOffer is the receiver's answer to the resume offset of a file sent
+// under FlagUDP: the port its datagrams go to and the token they must carry.
+// The sender answers with a uint16 payload size, at most its MTU minus
+// DataHeaderSize, and starts sending.
+type UDPOffer struct {
+	Port  uint16
+	Token [TokenSize]byte
+}
+
+// Datagram is a parsed datagram. Which fields are set depends on Type.
+type Datagram struct {
+	Type  uint8
+	Token [TokenSize]byte
+	// Seq and Payload are set for DatagramData.
+	Seq     uint64
+	Payload []byte
+	// Missing, Mask and Echo are set for DatagramAck: every sequence number
+	// below Missing arrived, bit i of Mask tells whether Missing+1+i did,
+	// and Echo is the datagram that prompted the ack, which the sender
+	// measures the round trip time with.
+	Missing uint64
+	Mask    uint64
+	Echo    uint64
+	// Nacks is set for DatagramNack.
+	Nacks []uint64
+}
+
+// PacketCount returns the number of DatagramData size bytes of content are
+// split into with payloads of payloadSize bytes.
+func PacketCount(size uint64, payloadSize int) uint64 {
+	return (size + uint64(payloadSize) - 1) / uint64(payloadSize)
+}
+
+// AppendData appends a DatagramData to b.
+func AppendData(b []byte, token [TokenSize]byte, seq uint64, payload []byte) []byte {
+	b = append(b, DatagramData)
+	b = append(b, token[:]...)
+	b = binary.LittleEndian.AppendUint64(b, seq)
+	return append(b, payload...)
+}
+
+// AppendAck appends a DatagramAck to b.
+func AppendAck(b []byte, token [TokenSize]byte, missing, mask, echo uint64) []byte {
+	b = append(b, DatagramAck)
+	b = append(b, token[:]...)
+	b = binary.LittleEndian.AppendUint64(b, missing)
+	b = binary.LittleEndian.AppendUint64(b, mask)
+	return binary.LittleEndian.AppendUint64(b, echo)
+}
+
+// AppendNack appends a DatagramNack for up to MaxNacks of seqs to b.
+func AppendNack(b []byte, token [TokenSize]byte, seqs []uint64) []byte {
+	seqs = seqs[:min(len(seqs), MaxNacks)]
+	b = append(b, DatagramNack)
+	b = append(b, token[:]...)
+	b = append(b, uint8(len(seqs)))
+	for _, seq := range seqs {
+		b = binary.LittleEndian.AppendUint64(b, seq)
+	}
+	return b
+}
+
+// ParseDatagram parses b. The payload of a DatagramData aliases b.
+// Truncated datagrams and unknown types are rejected with ErrInvalidFrame.
+func ParseDatagram(b []byte) (Datagram, error) {
+	var d Datagram
+	if len(b) < 1+TokenSize {
+		return d, fmt.Errorf("%w: datagram of %d bytes", ErrInvalidFrame, len(b))
+	}
+	d.Type = b[0]
+	copy(d.Token[:], b[1:])
+	body := b[1+TokenSize:]
+
+	switch d.Type {
+	case DatagramData:
+		if len(body) < 8 {
+			return d, fmt.Errorf("%w: data datagram of %d bytes", ErrInvalidFrame, len(b))
+		}
+		d.Seq = binary.LittleEndian.Uint64(body)
+		d.Payload = body[8:]
+	case DatagramAck:
+		if len(body) != 24 {
+			return d, fmt.Errorf("%w: ack datagram of %d bytes", ErrInvalidFrame, len(b))
+		}
+		d.Missing = binary.LittleEndian.Uint64(body)
+		d.Mask = binary.LittleEndian.Uint64(body[8:])
+		d.Echo = binary.LittleEndian.Uint64(body[16:])
+	case DatagramNack:
+		if len(body) < 1 || len(body) != 1+8*int(body[0]) {
+			return d, fmt.Errorf("%w: nack datagram of %d bytes", ErrInvalidFrame, len(b))
+		}
+		for i := range int(body[0]) {
+			d.Nacks = append(d.Nacks, binary.LittleEndian.Uint64(body[1+8*i:]))
+		}
+	default:
+		return d, fmt.Errorf("%w: unknown datagram type %d", ErrInvalidFrame, d.Type)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,353 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxAnnouncementSize is the largest discovery datagram a receiver reads.
+// Announcements are kept well below it so they fit a single unfragmented
+// datagram on any common link.
+const MaxAnnouncementSize = 1024
+
+// ErrInvalidAnnouncement is returned for datagrams on the discovery port that
+// are not a valid announcement.
+var ErrInvalidAnnouncement = errors.New("invalid announcement")
+
+// DefaultDiscoveryPort is the UDP port announcements are sent to unless
+// configured otherwise.
+const DefaultDiscoveryPort = 9999
+
+// DefaultMulticastGroup is the administratively scoped group announcements
+// are sent to when multicast discovery is used.
+const DefaultMulticastGroup = "239.255.77.77"
+
+// IPv6AllNodes is the link-local group announcements are broadcast to over
+// IPv6, which has no broadcast address. Every IPv6 host is in it, so
+// receivers hear it without joining.
+const IPv6AllNodes = "ff02::1"
+
+// MDNSService is the DNS-SD service type senders advertise over mDNS.
+const MDNSService = "_fileshare._tcp"
+
+// MaxAnnouncedFiles is how many names of offered files an announcement
+// lists at most. FileCount tells how many there are.
+const MaxAnnouncedFiles = 16
+
+// MaxSenderName is the longest name a sender may announce itself under.
+const MaxSenderName = 64
+
+// Announcement is the JSON payload a sender broadcasts on the discovery port.
+// Over mDNS the same fields travel as TXT record pairs keyed by their JSON
+// names.
+type Announcement struct {
+	Magic    string `json:"magic"`
+	Version  uint16 `json:"version"`
+	Port     uint16 `json:"port"`
+	Hostname string `json:"hostname,omitempty"`
+	// Name is what the sender's user asked to be known by, e.g. "alice",
+	// empty to go by Hostname.
+	Name string `json:"name,omitempty"`
+	TLS  bool   `json:"tls,omitempty"`
+	// Transport is TransportQUIC for senders serving QUIC, empty for TCP.
+	Transport string `json:"transport,omitempty"`
+	// HTTPPort is the port the sender also serves its files on over plain
+	// HTTP, for machines without fileshare, zero if it doesn't. See
+	// DownloadURL.
+	HTTPPort uint16 `json:"http_port,omitempty"`
+	// Session is random per sender run, so a receiver in daemon mode can
+	// tell a sender it already received from apart from a new one that
+	// reuses its address.
+	Session string `json:"session,omitempty"`
+	// FileName and FileSize describe the offer when a single file is sent.
+	FileName string `json:"file_name,omitempty"`
+	FileSize int64  `json:"file_size,omitempty"`
+	// FileCount and TotalSize sum up the files offered, whether one or
+	// many, when the sender knows them up front.
+	FileCount int   `json:"file_count,omitempty"`
+	TotalSize int64 `json:"total_size,omitempty"`
+	// FileNames lists the names of the first files offered, as many as
+	// fit, when the sender knows them up front.
+	FileNames []string `json:"file_names,omitempty"`
+	// Timestamp is when the announcement was sent, in seconds since the
+	// Unix epoch, for receivers to drop delayed or replayed ones; zero from
+	// senders that don't stamp them. Signature is the hex encoded
+	// HMAC-SHA256 of the announcement without it under the shared key, see
+	// SignedWith.
+	Timestamp int64  `json:"ts,omitempty"`
+	Signature string `json:"sig,omitempty"`
+
+	// key signs the announcement as it is encoded
+	key string
+}
+
+// SignedWith returns the announcement to be signed with key: Marshal and TXT
+// sign what they encode, so receivers knowing key can tell it from a forged
+// one with Verify. An empty key signs nothing.
+func (a Announcement) SignedWith(key string) Announcement {
+	a.key = key
+	return a
+}
+
+// stamp returns the announcement stamped with now and signed, if it is to
+// be.
+func (a Announcement) stamp(now time.Time) Announcement {
+	a.Timestamp = now.Unix()
+	if a.key != "" {
+		a.Signature = hex.EncodeToString(a.mac(a.key))
+	}
+	return a
+}
+
+// mac returns the HMAC-SHA256 under key of the JSON encoding of the
+// announcement without its signature.
+func (a Announcement) mac(key string) []byte {
+	a.Signature = ""
+	// an announcement is only strings, numbers and booleans, which always
+	// encode
+	content, _ := json.Marshal(a)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(content)
+	return mac.Sum(nil)
+}
+
+// Verify checks that the announcement was signed with key no further than
+// tolerance from now, either way, which lets clocks be somewhat apart while
+// bounding how long a recorded announcement can be replayed. Unsigned,
+// forged and stale announcements are rejected with ErrInvalidAnnouncement.
+func (a Announcement) Verify(key string, now time.Time, tolerance time.Duration) error {
+	if a.Signature == "" {
+		return fmt.Errorf("%w: not signed", ErrInvalidAnnouncement)
+	}
+	signature, err := hex.DecodeString(a.Signature)
+	if err != nil || !hmac.Equal(signature, a.mac(key)) {
+		return fmt.Errorf("%w: bad signature", ErrInvalidAnnouncement)
+	}
+	if skew := now.Sub(time.Unix(a.Timestamp, 0)); skew > tolerance || skew < -tolerance {
+		return fmt.Errorf("%w: signed %s away from now, the tolerance is %s", ErrInvalidAnnouncement, skew.Round(time.Second), tolerance)
+	}
+
+	return nil
+}
+
+// NewAnnouncement returns an announcement for a sender listening on port,
+// with Magic and Version filled in.
+func NewAnnouncement(port uint16) Announcement {
+	return Announcement{
+		Magic:   Magic,
+		Version: Version,
+		Port:    port,
+	}
+}
+
+// DownloadPath is where a sender serving HTTP downloads serves a single
+// offered file. Every offered file is listed at "/" and served at
+// DownloadPath + "/" + its name.
+const DownloadPath = "/file"
+
+// DownloadURL returns the URL the offered file can be downloaded from over
+// plain HTTP, given the host the announcement came from, or "" if the sender
+// doesn't serve HTTP downloads.
+func (a Announcement) DownloadURL(host string) string {
+	if a.HTTPPort == 0 {
+		return ""
+	}
+
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(int(a.HTTPPort))) + DownloadPath
+}
+
+// maxTXTString is the longest string a DNS TXT record can hold.
+const maxTXTString = 255
+
+// maxTXTFiles bounds the bytes of the file pairs of a TXT record, which
+// shares its response packet with the record's other pairs.
+const maxTXTFiles = 512
+
+// Marshal encodes the announcement for the wire, stamped with the time and
+// signed if it is to be. The file offer is only informational, so names too
+// long to fit the datagram are dropped, the last listed first, rather than
+// the whole announcement.
+func (a Announcement) Marshal() ([]byte, error) {
+	a.FileNames = a.FileNames[:min(len(a.FileNames), MaxAnnouncedFiles)]
+	now := time.Now()
+	for {
+		payload, err := json.Marshal(a.stamp(now))
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case len(payload) <= MaxAnnouncementSize:
+			return payload, nil
+		case len(a.FileNames) > 0:
+			a.FileNames = a.FileNames[:len(a.FileNames)-1]
+		case a.FileName != "":
+			a.FileName, a.FileSize = "", 0
+		default:
+			return nil, fmt.Errorf("announcement is %d bytes, the limit is %d", len(payload), MaxAnnouncementSize)
+		}
+	}
+}
+
+// ParseAnnouncement decodes and validates a discovery datagram.
+func ParseAnnouncement(payload []byte) (Announcement, error) {
+	var a Announcement
+	if err := json.Unmarshal(payload, &a); err != nil {
+		return Announcement{}, fmt.Errorf("%w: %s", ErrInvalidAnnouncement, err)
+	}
+
+	if err := a.validate(); err != nil {
+		return Announcement{}, err
+	}
+
+	return a, nil
+}
+
+// TXT encodes the announcement as key=value pairs for a DNS-SD TXT record,
+// stamped with the time and signed if it is to be. Values are carried
+// verbatim, so names may hold spaces, "=" or any other UTF-8. A file offer
+// whose pair would exceed the 255 bytes a TXT string holds is left out
+// instead of being cut short. Every listed file name is a "file" pair of its
+// own, for as many as fit.
+func (a Announcement) TXT() []string {
+	// what doesn't fit is left out first, so the signature covers what
+	// the pairs carry
+	if a.FileName != "" && len("file_name="+a.FileName) > maxTXTString {
+		a.FileName, a.FileSize = "", 0
+	}
+	budget := maxTXTFiles
+	for i, name := range a.FileNames[:min(len(a.FileNames), MaxAnnouncedFiles)] {
+		pair := "file=" + name
+		if len(pair) > maxTXTString || len(pair) > budget {
+			a.FileNames = a.FileNames[:i]
+			break
+		}
+		budget -= len(pair)
+	}
+	a.FileNames = a.FileNames[:min(len(a.FileNames), MaxAnnouncedFiles)]
+	a = a.stamp(time.Now())
+
+	txt := []string{
+		"magic=" + a.Magic,
+		"version=" + strconv.Itoa(int(a.Version)),
+		"port=" + strconv.Itoa(int(a.Port)),
+	}
+	if a.Hostname != "" {
+		txt = append(txt, "hostname="+a.Hostname)
+	}
+	if a.Name != "" {
+		txt = append(txt, "name="+a.Name)
+	}
+	if a.TLS {
+		txt = append(txt, "tls=true")
+	}
+	if a.Transport != "" {
+		txt = append(txt, "transport="+a.Transport)
+	}
+	if a.HTTPPort != 0 {
+		txt = append(txt, "http_port="+strconv.Itoa(int(a.HTTPPort)))
+	}
+	if a.Session != "" {
+		txt = append(txt, "session="+a.Session)
+	}
+	if a.FileName != "" {
+		txt = append(txt, "file_name="+a.FileName, "file_size="+strconv.FormatInt(a.FileSize, 10))
+	}
+	if a.FileCount != 0 {
+		txt = append(txt, "file_count="+strconv.Itoa(a.FileCount), "total_size="+strconv.FormatInt(a.TotalSize, 10))
+	}
+	for _, name := range a.FileNames {
+		txt = append(txt, "file="+name)
+	}
+	txt = append(txt, "ts="+strconv.FormatInt(a.Timestamp, 10))
+	if a.Signature != "" {
+		txt = append(txt, "sig="+a.Signature)
+	}
+
+	return txt
+}
+
+// ParseTXT decodes and validates an announcement from DNS-SD TXT pairs. Only
+// the first "=" separates a key from its value. Unknown keys are ignored.
+func ParseTXT(txt []string) (Announcement, error) {
+	var a Announcement
+	for _, pair := range txt {
+		key, value, _ := strings.Cut(pair, "=")
+
+		var err error
+		switch key {
+		case "magic":
+			a.Magic = value
+		case "version":
+			a.Version, err = parseUint16(value)
+		case "port":
+			a.Port, err = parseUint16(value)
+		case "hostname":
+			a.Hostname = value
+		case "name":
+			a.Name = value
+		case "tls":
+			a.TLS, err = strconv.ParseBool(value)
+		case "transport":
+			a.Transport = value
+		case "http_port":
+			a.HTTPPort, err = parseUint16(value)
+		case "session":
+			a.Session = value
+		case "file_name":
+			a.FileName = value
+		case "file_size":
+			a.FileSize, err = strconv.ParseInt(value, 10, 64)
+		case "file_count":
+			a.FileCount, err = strconv.Atoi(value)
+		case "total_size":
+			a.TotalSize, err = strconv.ParseInt(value, 10, 64)
+		case "file":
+			a.FileNames = append(a.FileNames, value)
+		case "ts":
+			a.Timestamp, err = strconv.ParseInt(value, 10, 64)
+		case "sig":
+			a.Signature = value
+		}
+		if err != nil {
+			return Announcement{}, fmt.Errorf("%w: bad %s: %s", ErrInvalidAnnouncement, key, err)
+		}
+	}
+
+	if err := a.validate(); err != nil {
+		return Announcement{}, err
+	}
+
+	return a, nil
+}
+
+func parseUint16(s string) (uint16, error) {
+	n, err := strconv.ParseUint(s, 10, 16)
+	return uint16(n), err
+}
+
+func (a Announcement) validate() error {
+	if a.Magic != Magic {
+		return fmt.Errorf("%w: unexpected magic %q", ErrInvalidAnnouncement, a.Magic)
+	}
+	if a.Port == 0 {
+		return fmt.Errorf("%w: missing port", ErrInvalidAnnouncement)
+	}
+	if a.Transport != "" && a.Transport != TransportQUIC {
+		return fmt.Errorf("%w: unknown transport %q", ErrInvalidAnnouncement, a.Transport)
+	}
+	if !Supports(a.Version) {
+		return fmt.Errorf("%w: sender speaks protocol v%d, this build supports %s",
+			ErrIncompatibleVersion, a.Version, SupportedVersions())
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// awkwardNames are names that splitting a text message on whitespace or "="
+// would corrupt.
+var awkwardNames = []string{
+	"my report.pdf",
+	"tab\tseparated.txt",
+	"🎉 party 🎂.mp4",
+	"  leading and trailing  ",
+	"a=b c=d.txt",
+	"line\nbreak.txt",
+}
+
+func testAnnouncement() Announcement {
+	a := NewAnnouncement(9000)
+	a.Hostname = "laptop"
+	a.Name = "Zoë's laptop 💻"
+	a.FileName = awkwardNames[0]
+	a.FileSize = 1234
+	a.FileCount = len(awkwardNames)
+	a.TotalSize = 5678
+	a.FileNames = awkwardNames
+	return a
+}
+
+func TestAnnouncementRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		encode func(a Announcement) (Announcement, error)
+	}{
+		{name: "json", encode: func(a Announcement) (Announcement, error) {
+			payload, err := a.Marshal()
+			if err != nil {
+				return Announcement{}, err
+			}
+			return ParseAnnouncement(payload)
+		}},
+		{name: "txt", encode: func(a Announcement) (Announcement, error) {
+			return ParseTXT(a.TXT())
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := testAnnouncement()
+			got, err := tt.encode(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != want.Name || got.FileName != want.FileName || got.FileSize != want.FileSize {
+				t.Errorf("got %q, file %q of %d bytes, want %q, file %q of %d bytes",
+					got.Name, got.FileName, got.FileSize, want.Name, want.FileName, want.FileSize)
+			}
+			if !reflect.DeepEqual(got.FileNames, want.FileNames) {
+				t.Errorf("FileNames = %q, want %q", got.FileNames, want.FileNames)
+			}
+		})
+	}
+}
+
+func TestMarshalDropsWhatDoesNotFit(t *testing.T) {
+	a := testAnnouncement()
+	a.FileNames = make([]string, MaxAnnouncedFiles+4)
+	for i := range a.FileNames {
+		a.FileNames[i] = strings.Repeat("é", 40) + " " + string(rune('a'+i))
+	}
+
+	payload, err := a.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) > MaxAnnouncementSize {
+		t.Fatalf("payload of %d bytes, the limit is %d", len(payload), MaxAnnouncementSize)
+	}
+	got, err := ParseAnnouncement(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.FileNames) == 0 || len(got.FileNames) >= MaxAnnouncedFiles {
+		t.Fatalf("kept %d names, want some dropped", len(got.FileNames))
+	}
+	// those that are kept are whole, and the first ones
+	if !reflect.DeepEqual(got.FileNames, a.FileNames[:len(got.FileNames)]) {
+		t.Errorf("FileNames = %q, want a prefix of %q", got.FileNames, a.FileNames)
+	}
+	if got.FileName != a.FileName {
+		t.Errorf("FileName = %q, want %q kept", got.FileName, a.FileName)
+	}
+}
+
+func TestTXTLeavesOutLongNames(t *testing.T) {
+	a := testAnnouncement()
+	a.FileName = strings.Repeat("x", maxTXTString)
+	a.FileNames = []string{"short one.txt", strings.Repeat("y", maxTXTString), "after.txt"}
+
+	txt := a.TXT()
+	for _, pair := range txt {
+		if len(pair) > maxTXTString {
+			t.Errorf("pair of %d bytes, a TXT string holds %d", len(pair), maxTXTString)
+		}
+	}
+	got, err := ParseTXT(txt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FileName != "" || got.FileSize != 0 {
+		t.Errorf("file offer %q of %d bytes kept, want it left out", got.FileName, got.FileSize)
+	}
+	if !reflect.DeepEqual(got.FileNames, []string{"short one.txt"}) {
+		t.Errorf("FileNames = %q, want the names before the long one", got.FileNames)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const key = "correct horse battery staple"
+	const tolerance = 2 * time.Minute
+	now := time.Unix(1_800_000_000, 0)
+	signed := testAnnouncement().SignedWith(key)
+
+	tests := []struct {
+		name string
+		// announcement returns the announcement as the receiver decoded it
+		announcement func(t *testing.T) Announcement
+		key          string
+		ok           bool
+	}{
+		{"valid", func(t *testing.T) Announcement { return signed.stamp(now) }, key, true},
+		{"sender clock behind", func(t *testing.T) Announcement { return signed.stamp(now.Add(-tolerance + time.Second)) }, key, true},
+		{"sender clock ahead", func(t *testing.T) Announcement { return signed.stamp(now.Add(tolerance - time.Second)) }, key, true},
+		{"tampered port", func(t *testing.T) Announcement {
+			a := signed.stamp(now)
+			a.Port = 6666
+			return a
+		}, key, false},
+		{"tampered timestamp", func(t *testing.T) Announcement {
+			a := signed.stamp(now.Add(-time.Hour))
+			a.Timestamp = now.Unix()
+			return a
+		}, key, false},
+		{"replayed", func(t *testing.T) Announcement { return signed.stamp(now.Add(-tolerance - time.Second)) }, key, false},
+		{"from the future", func(t *testing.T) Announcement { return signed.stamp(now.Add(tolerance + time.Second)) }, key, false},
+		{"unsigned", func(t *testing.T) Announcement { return testAnnouncement().stamp(now) }, key, false},
+		{"other key", func(t *testing.T) Announcement { return signed.stamp(now) }, "another key", false},
+		{"garbled signature", func(t *testing.T) Announcement {
+			a := signed.stamp(now)
+			a.Signature = "not hex"
+			return a
+		}, key, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.announcement(t).Verify(tt.key, now, tolerance)
+			if tt.ok && err != nil {
+				t.Errorf("Verify = %v, want the announcement taken", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidAnnouncement) {
+				t.Errorf("Verify = %v, want ErrInvalidAnnouncement", err)
+			}
+		})
+	}
+}
+
+func TestSignatureTravels(t *testing.T) {
+	const key = "shared"
+	encode := map[string]func(a Announcement) (Announcement, error){
+		"json": func(a Announcement) (Announcement, error) {
+			payload, err := a.Marshal()
+			if err != nil {
+				return Announcement{}, err
+			}
+			return ParseAnnouncement(payload)
+		},
+		"txt": func(a Announcement) (Announcement, error) { return ParseTXT(a.TXT()) },
+	}
+	for name, encode := range encode {
+		t.Run(name, func(t *testing.T) {
+			a, err := encode(testAnnouncement().SignedWith(key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Verify(key, time.Now(), time.Minute); err != nil {
+				t.Errorf("Verify of the decoded announcement = %v", err)
+			}
+		})
+	}
+}
+
+func TestAnnouncementsAreStamped(t *testing.T) {
+	before := time.Now().Unix()
+	txt, err := ParseTXT(testAnnouncement().TXT())
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := testAnnouncement().Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, err := ParseAnnouncement(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now().Unix()
+
+	for name, a := range map[string]Announcement{"json": json, "txt": txt} {
+		if a.Timestamp < before || a.Timestamp > after {
+			t.Errorf("%s announcement stamped %d, want between %d and %d", name, a.Timestamp, before, after)
+		}
+	}
+}
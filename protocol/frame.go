@@ -0,0 +1,188 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Frame starts every entry: its type followed by a uint16 length and the
+// slash separated name.
+type Frame struct {
+	Type uint8
+	Name string
+}
+
+// Header follows the frame of a file entry. Mode holds the PermMask bits and
+// ModTime is in nanoseconds since the Unix epoch.
+type Header struct {
+	Size    uint64
+	Flags   uint8
+	Mode    uint32
+	ModTime int64
+}
+
+// WriteFrame writes f. Names longer than MaxNameLength are rejected with
+// ErrInvalidFrame.
+func WriteFrame(w io.Writer, f Frame) error {
+	if len(f.Name) > MaxNameLength {
+		return fmt.Errorf("%w: name of %d bytes, the limit is %d", ErrInvalidFrame, len(f.Name), MaxNameLength)
+	}
+
+	frame := make([]byte, 0, 1+2+len(f.Name))
+	frame = append(frame, f.Type)
+	frame = binary.LittleEndian.AppendUint16(frame, uint16(len(f.Name)))
+	frame = append(frame, f.Name...)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadFrame reads a frame. A name that is empty or longer than maxNameLength
+// is rejected with ErrInvalidFrame before anything is allocated for it.
+func ReadFrame(r io.Reader, maxNameLength uint16) (Frame, error) {
+	var prefix [1 + 2]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return Frame{}, err
+	}
+
+	nameLen := binary.LittleEndian.Uint16(prefix[1:])
+	if nameLen == 0 {
+		return Frame{}, fmt.Errorf("%w: empty name", ErrInvalidFrame)
+	}
+	if nameLen > maxNameLength {
+		return Frame{}, fmt.Errorf("%w: name of %d bytes, the limit is %d", ErrInvalidFrame, nameLen, maxNameLength)
+	}
+
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return Frame{}, err
+	}
+
+	return Frame{Type: prefix[0], Name: string(name)}, nil
+}
+
+// WriteHeader writes h.
+func WriteHeader(w io.Writer, h Header) error {
+	return binary.Write(w, binary.LittleEndian, h)
+}
+
+// ReadHeader reads a file header. Its flags are not checked against
+// KnownFlags.
+func ReadHeader(r io.Reader) (Header, error) {
+	var h Header
+	err := binary.Read(r, binary.LittleEndian, &h)
+	return h, err
+}
+
+// LinkOutside flags a link whose target is outside the directory the
+// transfer sends, such as an absolute one or one climbing above it with "..".
+const LinkOutside uint8 = 1 << 0
+
+// Link follows the frame of a symlink entry: its flags, then a uint16 length
+// and the target as the link holds it, slash separated.
+type Link struct {
+	Flags  uint8
+	Target string
+}
+
+// LinkLeaves reports whether target, held by a link at the slash-separated
+// relPath below a directory, points outside of it.
+func LinkLeaves(relPath, target string) bool {
+	if path.IsAbs(target) || strings.HasPrefix(target, `\`) || len(target) > 1 && target[1] == ':' {
+		return true
+	}
+	resolved := path.Join(path.Dir(relPath), target)
+	return resolved == ".." || strings.HasPrefix(resolved, "../")
+}
+
+// WriteLink writes l. Targets longer than MaxNameLength are rejected with
+// ErrInvalidFrame.
+func WriteLink(w io.Writer, l Link) error {
+	if len(l.Target) > MaxNameLength {
+		return fmt.Errorf("%w: link target of %d bytes, the limit is %d", ErrInvalidFrame, len(l.Target), MaxNameLength)
+	}
+
+	link := make([]byte, 0, 1+2+len(l.Target))
+	link = append(link, l.Flags)
+	link = binary.LittleEndian.AppendUint16(link, uint16(len(l.Target)))
+	link = append(link, l.Target...)
+
+	_, err := w.Write(link)
+	return err
+}
+
+// ReadLink reads a link. A target that is empty or longer than
+// maxTargetLength is rejected with ErrInvalidFrame before anything is
+// allocated for it.
+func ReadLink(r io.Reader, maxTargetLength uint16) (Link, error) {
+	var prefix [1 + 2]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return Link{}, err
+	}
+
+	targetLen := binary.LittleEndian.Uint16(prefix[1:])
+	if targetLen == 0 {
+		return Link{}, fmt.Errorf("%w: empty link target", ErrInvalidFrame)
+	}
+	if targetLen > maxTargetLength {
+		return Link{}, fmt.Errorf("%w: link target of %d bytes, the limit is %d", ErrInvalidFrame, targetLen, maxTargetLength)
+	}
+
+	target := make([]byte, targetLen)
+	if _, err := io.ReadFull(r, target); err != nil {
+		return Link{}, err
+	}
+
+	return Link{Flags: prefix[0], Target: string(target)}, nil
+}
+
+// MaxAckMessageLength bounds the error message of an Ack.
+const MaxAckMessageLength = 1024
+
+// Ack is the receiver's last word on a file, sent once the file has been
+// verified and moved into place, or saving it failed after its content
+// arrived. Message explains an AckFailed status.
+type Ack struct {
+	Status  uint8
+	Message string
+}
+
+// WriteAck writes a. A message longer than MaxAckMessageLength is truncated.
+func WriteAck(w io.Writer, a Ack) error {
+	message := a.Message
+	if len(message) > MaxAckMessageLength {
+		message = message[:MaxAckMessageLength]
+	}
+
+	ack := make([]byte, 0, 1+2+len(message))
+	ack = append(ack, a.Status)
+	ack = binary.LittleEndian.AppendUint16(ack, uint16(len(message)))
+	ack = append(ack, message...)
+
+	_, err := w.Write(ack)
+	return err
+}
+
+// ReadAck reads an ack, rejecting messages longer than MaxAckMessageLength
+// with ErrInvalidFrame.
+func ReadAck(r io.Reader) (Ack, error) {
+	var prefix [1 + 2]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return Ack{}, err
+	}
+
+	messageLen := binary.LittleEndian.Uint16(prefix[1:])
+	if messageLen > MaxAckMessageLength {
+		return Ack{}, fmt.Errorf("%w: ack message of %d bytes, the limit is %d", ErrInvalidFrame, messageLen, MaxAckMessageLength)
+	}
+
+	message := make([]byte, messageLen)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return Ack{}, err
+	}
+
+	return Ack{Status: prefix[0], Message: string(message)}, nil
+}
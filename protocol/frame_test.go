@@ -0,0 +1,243 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	tests := []Frame{
+		{Type: EntryTypeFile, Name: "a"},
+		{Type: EntryTypeDir, Name: "photos/2024"},
+		{Type: EntryTypeSymlink, Name: "links/current"},
+		{Type: EntryTypeFile, Name: "spaces and\ttabs 📁.txt"},
+		{Type: EntryTypeFile, Name: strings.Repeat("n", MaxNameLength)},
+	}
+	for _, want := range tests {
+		var buf bytes.Buffer
+		if err := WriteFrame(&buf, want); err != nil {
+			t.Fatalf("WriteFrame(%.20q): %v", want.Name, err)
+		}
+		if buf.Len() != 1+2+len(want.Name) {
+			t.Errorf("frame of a %d byte name is %d bytes, want %d", len(want.Name), buf.Len(), 1+2+len(want.Name))
+		}
+		got, err := ReadFrame(&buf, MaxNameLength)
+		if err != nil {
+			t.Fatalf("ReadFrame(%.20q): %v", want.Name, err)
+		}
+		if got != want {
+			t.Errorf("ReadFrame = %d %.20q, want %d %.20q", got.Type, got.Name, want.Type, want.Name)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%d bytes left after the frame", buf.Len())
+		}
+	}
+}
+
+func TestWriteFrameRejectsLongNames(t *testing.T) {
+	err := WriteFrame(io.Discard, Frame{Name: strings.Repeat("n", MaxNameLength+1)})
+	if !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("WriteFrame = %v, want ErrInvalidFrame", err)
+	}
+}
+
+// rawFrame returns a frame prefix announcing a name of nameLen bytes,
+// followed by name.
+func rawFrame(nameLen uint16, name string) []byte {
+	frame := []byte{EntryTypeFile}
+	frame = binary.LittleEndian.AppendUint16(frame, nameLen)
+	return append(frame, name...)
+}
+
+func TestReadFrameRejectsLengths(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   []byte
+		max     uint16
+		wantErr error
+	}{
+		{name: "empty name", frame: rawFrame(0, ""), max: DefaultMaxNameLength, wantErr: ErrInvalidFrame},
+		{name: "over the limit", frame: rawFrame(DefaultMaxNameLength+1, ""), max: DefaultMaxNameLength, wantErr: ErrInvalidFrame},
+		{name: "largest length", frame: rawFrame(0xFFFF, ""), max: DefaultMaxNameLength, wantErr: ErrInvalidFrame},
+		{name: "largest length allowed but missing", frame: rawFrame(0xFFFF, "short"), max: MaxNameLength, wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated prefix", frame: []byte{EntryTypeFile, 1}, max: DefaultMaxNameLength, wantErr: io.ErrUnexpectedEOF},
+		{name: "nothing", frame: nil, max: DefaultMaxNameLength, wantErr: io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadFrame(bytes.NewReader(tt.frame), tt.max)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadFrame = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	want := Header{Size: 1<<40 + 3, Flags: FlagCompressed | FlagStreaming, Mode: 0o755, ModTime: -1}
+	var buf bytes.Buffer
+	if err := WriteHeader(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 8+1+4+8 {
+		t.Errorf("header is %d bytes, want 21", buf.Len())
+	}
+	got, err := ReadHeader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("ReadHeader = %+v, want %+v", got, want)
+	}
+}
+
+func TestLinkRoundTrip(t *testing.T) {
+	want := Link{Flags: LinkOutside, Target: "../../etc/hosts"}
+	var buf bytes.Buffer
+	if err := WriteLink(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadLink(&buf, DefaultMaxNameLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("ReadLink = %+v, want %+v", got, want)
+	}
+
+	if _, err := ReadLink(bytes.NewReader(rawFrame(0, "")), DefaultMaxNameLength); !errors.Is(err, ErrInvalidFrame) {
+		t.Errorf("ReadLink of an empty target = %v, want ErrInvalidFrame", err)
+	}
+}
+
+func TestLinkLeaves(t *testing.T) {
+	tests := []struct {
+		relPath, target string
+		want            bool
+	}{
+		{"dir/link", "file", false},
+		{"dir/link", "../file", false},
+		{"dir/link", "../../file", true},
+		{"link", "..", true},
+		{"dir/link", "/etc/passwd", true},
+		{"dir/link", `\\server\share`, true},
+		{"dir/link", `C:\Windows`, true},
+		{"dir/sub/link", "../sibling/./x", false},
+	}
+	for _, tt := range tests {
+		if got := LinkLeaves(tt.relPath, tt.target); got != tt.want {
+			t.Errorf("LinkLeaves(%q, %q) = %t, want %t", tt.relPath, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestAckRoundTrip(t *testing.T) {
+	tests := []struct {
+		ack  Ack
+		want Ack
+	}{
+		{ack: Ack{Status: AckOK}, want: Ack{Status: AckOK}},
+		{ack: Ack{Status: AckFailed, Message: "checksum mismatch"}, want: Ack{Status: AckFailed, Message: "checksum mismatch"}},
+		{
+			ack:  Ack{Status: AckFailed, Message: strings.Repeat("m", MaxAckMessageLength+10)},
+			want: Ack{Status: AckFailed, Message: strings.Repeat("m", MaxAckMessageLength)},
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteAck(&buf, tt.ack); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadAck(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("ReadAck = %d %.20q, want %d %.20q", got.Status, got.Message, tt.want.Status, tt.want.Message)
+		}
+	}
+}
+
+func TestReadAckRejectsLongMessages(t *testing.T) {
+	ack := binary.LittleEndian.AppendUint16([]byte{AckFailed}, MaxAckMessageLength+1)
+	if _, err := ReadAck(bytes.NewReader(ack)); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("ReadAck = %v, want ErrInvalidFrame", err)
+	}
+}
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add(rawFrame(5, "a.txt"), uint16(DefaultMaxNameLength))
+	f.Add(rawFrame(0, ""), uint16(DefaultMaxNameLength))
+	f.Add(rawFrame(0xFFFF, "x"), uint16(DefaultMaxNameLength))
+	f.Add(rawFrame(0xFFFF, "x"), uint16(MaxNameLength))
+	f.Add([]byte{EntryTypeDir, 0xFF}, uint16(1))
+	f.Fuzz(func(t *testing.T, data []byte, max uint16) {
+		frame, err := ReadFrame(bytes.NewReader(data), max)
+		if err != nil {
+			return
+		}
+		if len(frame.Name) == 0 || len(frame.Name) > int(max) {
+			t.Fatalf("read a name of %d bytes with a limit of %d", len(frame.Name), max)
+		}
+		var buf bytes.Buffer
+		if err := WriteFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, buf.Bytes()) {
+			t.Fatalf("frame re-encodes to %x, read from %x", buf.Bytes(), data)
+		}
+	})
+}
+
+func FuzzReadHeader(f *testing.F) {
+	var seed bytes.Buffer
+	WriteHeader(&seed, Header{Size: 42, Flags: FlagCompressed, Mode: 0o644, ModTime: 1700000000000000000})
+	f.Add(seed.Bytes())
+	f.Add(bytes.Repeat([]byte{0xFF}, 21))
+	f.Add([]byte{1, 2, 3})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		header, err := ReadHeader(bytes.NewReader(data))
+		if err != nil {
+			if len(data) >= 21 {
+				t.Fatalf("ReadHeader of %d bytes: %v", len(data), err)
+			}
+			return
+		}
+		var buf bytes.Buffer
+		if err := WriteHeader(&buf, header); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), data[:21]) {
+			t.Fatalf("header re-encodes to %x, read from %x", buf.Bytes(), data[:21])
+		}
+	})
+}
+
+func FuzzReadAck(f *testing.F) {
+	var seed bytes.Buffer
+	WriteAck(&seed, Ack{Status: AckFailed, Message: "disk full"})
+	f.Add(seed.Bytes())
+	f.Add(binary.LittleEndian.AppendUint16([]byte{AckFailed}, MaxAckMessageLength+1))
+	f.Add(binary.LittleEndian.AppendUint16([]byte{AckOK}, 0xFFFF))
+	f.Add([]byte{AckOK, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ack, err := ReadAck(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if len(ack.Message) > MaxAckMessageLength {
+			t.Fatalf("read a message of %d bytes", len(ack.Message))
+		}
+		var buf bytes.Buffer
+		if err := WriteAck(&buf, ack); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, buf.Bytes()) {
+			t.Fatalf("ack re-encodes to %x, read from %x", buf.Bytes(), data)
+		}
+	})
+}
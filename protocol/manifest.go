@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ManifestFollows takes the place of the entry count when the sender, asked
+// by a receiver announcing CapManifest, lists the entries up front: the
+// Manifest follows, the receiver answers with a Selection, and the count of
+// the entries selected only comes then. Older senders send the count right
+// away, which is never this high.
+const ManifestFollows uint32 = 1<<32 - 1
+
+// MaxManifestEntries bounds the entries of a manifest.
+const MaxManifestEntries = 1 << 20
+
+// UnknownSize is the size of a manifest entry listed before its content is
+// known, such as stdin.
+const UnknownSize uint64 = 1<<64 - 1
+
+// ManifestEntry lists an entry: its frame, then the size of a file and its
+// modification time in nanoseconds since the Unix epoch.
+type ManifestEntry struct {
+	Frame
+	Size    uint64
+	ModTime int64
+}
+
+// WriteManifest writes the number of entries followed by every entry.
+func WriteManifest(w io.Writer, entries []ManifestEntry) error {
+	if len(entries) > MaxManifestEntries {
+		return fmt.Errorf("%w: manifest of %d entries, the limit is %d", ErrInvalidFrame, len(entries), MaxManifestEntries)
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(entries))); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := WriteFrame(w, entry.Frame); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, [2]uint64{entry.Size, uint64(entry.ModTime)}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadManifest reads a manifest, rejecting more than MaxManifestEntries
+// entries, and names longer than maxNameLength, with ErrInvalidFrame.
+func ReadManifest(r io.Reader, maxNameLength uint16) ([]ManifestEntry, error) {
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	if count > MaxManifestEntries {
+		return nil, fmt.Errorf("%w: manifest of %d entries, the limit is %d", ErrInvalidFrame, count, MaxManifestEntries)
+	}
+
+	// entries are only allocated as they arrive
+	var entries []ManifestEntry
+	for range count {
+		frame, err := ReadFrame(r, maxNameLength)
+		if err != nil {
+			return nil, err
+		}
+		var sizeAndTime [2]uint64
+		if err := binary.Read(r, binary.LittleEndian, &sizeAndTime); err != nil {
+			return nil, err
+		}
+		entries = append(entries, ManifestEntry{Frame: frame, Size: sizeAndTime[0], ModTime: int64(sizeAndTime[1])})
+	}
+
+	return entries, nil
+}
+
+// WriteSelection writes the receiver's answer to a manifest: the number of
+// entries it selected followed by their indexes in the manifest, in
+// ascending order.
+func WriteSelection(w io.Writer, indexes []uint32) error {
+	selection := make([]byte, 0, 4+4*len(indexes))
+	selection = binary.LittleEndian.AppendUint32(selection, uint32(len(indexes)))
+	for _, i := range indexes {
+		selection = binary.LittleEndian.AppendUint32(selection, i)
+	}
+
+	_, err := w.Write(selection)
+	return err
+}
+
+// ReadSelection reads the answer to a manifest of n entries, rejecting
+// indexes out of range or out of order with ErrInvalidFrame.
+func ReadSelection(r io.Reader, n int) ([]uint32, error) {
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	if int64(count) > int64(n) {
+		return nil, fmt.Errorf("%w: selection of %d entries out of %d", ErrInvalidFrame, count, n)
+	}
+
+	indexes := make([]uint32, count)
+	if err := binary.Read(r, binary.LittleEndian, indexes); err != nil {
+		return nil, err
+	}
+	for j, i := range indexes {
+		if int64(i) >= int64(n) || j > 0 && i <= indexes[j-1] {
+			return nil, fmt.Errorf("%w: selected entry %d out of range or order", ErrInvalidFrame, i)
+		}
+	}
+
+	return indexes, nil
+}
@@ -0,0 +1,81 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	want := []ManifestEntry{
+		{Frame: Frame{Type: EntryTypeDir, Name: "album"}},
+		{Frame: Frame{Type: EntryTypeFile, Name: "album/cover.jpg"}, Size: 1 << 20, ModTime: 1700000000000000000},
+		{Frame: Frame{Type: EntryTypeFile, Name: "stdin"}, Size: UnknownSize},
+	}
+	var buf bytes.Buffer
+	if err := WriteManifest(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadManifest(&buf, DefaultMaxNameLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadManifest = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadManifestRejectsCounts(t *testing.T) {
+	for _, count := range []uint32{MaxManifestEntries + 1, ManifestFollows} {
+		manifest := binary.LittleEndian.AppendUint32(nil, count)
+		if _, err := ReadManifest(bytes.NewReader(manifest), DefaultMaxNameLength); !errors.Is(err, ErrInvalidFrame) {
+			t.Errorf("ReadManifest of %d entries = %v, want ErrInvalidFrame", count, err)
+		}
+	}
+}
+
+func TestSelectionRoundTrip(t *testing.T) {
+	want := []uint32{0, 2, 5}
+	var buf bytes.Buffer
+	if err := WriteSelection(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadSelection(&buf, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadSelection = %v, want %v", got, want)
+	}
+
+	for _, indexes := range [][]uint32{{6}, {2, 1}, {1, 1}, {0, 1, 2, 3, 4, 5, 6}} {
+		var buf bytes.Buffer
+		WriteSelection(&buf, indexes)
+		if _, err := ReadSelection(&buf, 6); !errors.Is(err, ErrInvalidFrame) {
+			t.Errorf("ReadSelection(%v) of 6 entries = %v, want ErrInvalidFrame", indexes, err)
+		}
+	}
+}
+
+func FuzzReadManifest(f *testing.F) {
+	var seed bytes.Buffer
+	WriteManifest(&seed, []ManifestEntry{{Frame: Frame{Name: "a.txt"}, Size: 3}})
+	f.Add(seed.Bytes())
+	f.Add(binary.LittleEndian.AppendUint32(nil, ManifestFollows))
+	f.Add(append(binary.LittleEndian.AppendUint32(nil, 1), rawFrame(0xFFFF, "x")...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		entries, err := ReadManifest(bytes.NewReader(data), DefaultMaxNameLength)
+		if err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		if err := WriteManifest(&buf, entries); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, buf.Bytes()) {
+			t.Fatalf("manifest re-encodes to %x, read from %x", buf.Bytes(), data)
+		}
+	})
+}
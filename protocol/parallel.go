@@ -0,0 +1,33 @@
+package protocol
+
+// MaxParallel bounds the connections a file is sent over under FlagParallel.
+const MaxParallel = 16
+
+// ParallelOffer is the receiver's answer to the resume offset of a file sent
+// under FlagParallel: the TCP port the parallel connections go to and the
+// token they must open with. The sender answers with a uint8 number of
+// streams, 1 to MaxParallel, and the content from the resume offset on is
+// split into that many contiguous ranges, see ParallelRange.
+//
+// Every connection opens with the token and the uint8 index of its range,
+// and the receiver answers with a uint64 count of the bytes of that range it
+// already holds, zero on the first connection for it. The sender sends the
+// rest of the range and the receiver confirms it with a single zero byte once
+// it is written. A connection that fails is replaced by a new one for the
+// same range, which continues where the receiver got to. Empty ranges get no
+// connection. Once every range was confirmed, the checksum follows on the
+// main connection as usual.
+type ParallelOffer struct {
+	Port  uint16
+	Token [TokenSize]byte
+}
+
+// ParallelRange returns the range of stream i of streams: size bytes split into
+// contiguous ranges of equal length, bar the last, as [start, end).
+func ParallelRange(size uint64, streams, i int) (start, end uint64) {
+	length := (size + uint64(streams) - 1) / uint64(streams)
+	start = min(uint64(i)*length, size)
+	end = min(start+length, size)
+
+	return start, end
+}
@@ -0,0 +1,212 @@
+// Package protocol holds the wire format constants shared by the sender and
+// the receiver so the two sides cannot drift apart.
+//
+// The wire protocol is versioned independently of this module. Version is its
+// major version in the semantic versioning sense: peers interoperate when
+// each one's [MinVersion, Version] range holds the other's Version, whichever
+// module release they were built from. Additions an older peer can ignore,
+// such as a new capability bit, are negotiated and don't bump it.
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Magic opens every connection, in both directions.
+const Magic = "FSHR"
+
+// Version is the protocol version spoken by this build. MinVersion is the
+// oldest version it still understands.
+//
+// v2 added the file mode to the file header, v3 the modification time, v4
+// the ReplyTooLarge status, v5 the ReplyDeclined status, v6 the Ack after
+// every file's checksum, v7 shrank the name length of frames to a uint16.
+// Content sizes, resume offsets and byte counts are uint64 throughout.
+const (
+	Version    uint16 = 7
+	MinVersion uint16 = 7
+)
+
+// PreambleTimeout bounds how long a freshly accepted connection may take to
+// send its preamble, so port scanners that connect and go quiet are dropped.
+const PreambleTimeout = 10 * time.Second
+
+// ErrBadMagic is returned when the peer does not start with Magic, i.e. it is
+// not speaking this protocol at all.
+var ErrBadMagic = errors.New("peer is not speaking the fileshare protocol")
+
+// ErrIncompatibleVersion is returned when the peer speaks a protocol version
+// outside [MinVersion, Version].
+var ErrIncompatibleVersion = errors.New("incompatible protocol version")
+
+// ErrInvalidFrame is returned when a length or count read off the wire is
+// outside the accepted range, before anything is allocated for it.
+var ErrInvalidFrame = errors.New("invalid frame")
+
+// MaxNameLength is the longest entry name, in bytes, the uint16 length of a
+// frame can describe. DefaultMaxNameLength is the longest a receiver accepts
+// unless configured otherwise.
+const (
+	MaxNameLength        = 1<<16 - 1
+	DefaultMaxNameLength = 4096
+)
+
+// Entry types, sent in front of every entry name.
+const (
+	EntryTypeFile uint8 = 0
+	EntryTypeDir  uint8 = 1
+	// EntryTypeSymlink is a symlink inside a directory transfer, whose Link
+	// follows the frame. It is only sent to receivers that announced
+	// CapSymlinks.
+	EntryTypeSymlink uint8 = 2
+)
+
+// Capabilities announced by the receiver after the preamble.
+const (
+	CapCompression uint32 = 1 << 0
+	// CapAuthRequired is followed by a nonce the sender has to answer with
+	// its HMAC under the shared key.
+	CapAuthRequired uint32 = 1 << 1
+	// CapBlocks announces that the receiver understands FlagBlocks.
+	CapBlocks uint32 = 1 << 2
+	// CapUDP announces that the receiver can take content in datagrams under
+	// FlagUDP.
+	CapUDP uint32 = 1 << 3
+	// CapParallel announces that the receiver can take content over parallel
+	// connections under FlagParallel.
+	CapParallel uint32 = 1 << 4
+	// CapStreaming announces that the receiver can take content of unknown
+	// size under FlagStreaming, including archives under FlagArchive.
+	CapStreaming uint32 = 1 << 5
+	// CapRetry announces that the receiver waits for a retry decision after
+	// every AckFailed it sends, and takes the entry again, from its frame
+	// on, after RetryEntry.
+	CapRetry uint32 = 1 << 6
+	// CapSymlinks announces that the receiver recreates EntryTypeSymlink
+	// entries.
+	CapSymlinks uint32 = 1 << 7
+	// CapManifest asks the sender to list the entries up front, see
+	// ManifestFollows, for the receiver to pick from.
+	CapManifest uint32 = 1 << 8
+)
+
+// NonceSize is the length of the authentication challenge.
+const NonceSize = 32
+
+// Per-file flags, sent after the content size.
+const (
+	FlagCompressed uint8 = 1 << 0
+	// FlagBlocks sends the content as BlockSize blocks, each with its index
+	// and CRC32C, see WriteBlock. The receiver checks every block as it
+	// arrives and, once they were all sent, asks for the corrupt ones again
+	// with WriteBlockRequest until it needs none or MaxRetransmitRounds are
+	// used up; the empty request ends the content and the checksum follows.
+	// It isn't combined with FlagCompressed.
+	FlagBlocks uint8 = 1 << 1
+	// FlagUDP sends the content in UDP datagrams rather than on the
+	// connection, see UDPOffer and Datagram. The receiver acknowledges what
+	// arrived and the sender retransmits what didn't within a sliding
+	// window; once it is all acknowledged, the checksum follows on the
+	// connection. It isn't combined with FlagCompressed or FlagBlocks.
+	FlagUDP uint8 = 1 << 2
+	// FlagParallel sends the content in contiguous ranges over parallel TCP
+	// connections rather than on the connection, see ParallelOffer; once they
+	// all arrived, the checksum follows on the connection. It isn't combined
+	// with any of the other flags.
+	FlagParallel uint8 = 1 << 3
+	// FlagStreaming sends content whose size isn't known up front: the
+	// header's size is zero and the content follows in chunks ended by an
+	// empty one, see StreamWriter, then the checksum. Such content can't be
+	// resumed, the receiver offers offset 0. It isn't combined with any of
+	// the other flags but FlagArchive.
+	FlagStreaming uint8 = 1 << 4
+	// FlagArchive marks content that is a tar archive of the directory the
+	// frame names, whose entries are all named below it. It always comes
+	// with FlagStreaming.
+	FlagArchive uint8 = 1 << 5
+)
+
+// KnownFlags is every per-file flag this build understands.
+const KnownFlags = FlagCompressed | FlagBlocks | FlagUDP | FlagParallel | FlagStreaming | FlagArchive
+
+// PermMask selects the bits of the file mode that are transmitted.
+const PermMask = 0o777
+
+// Reply statuses, sent by the receiver in answer to a file header.
+const (
+	ReplyAccept uint8 = 0
+	ReplySkip   uint8 = 1
+	// ReplyTooLarge rejects a file the receiver has no room for, because of
+	// its size limit or free disk space, and ends the transfer.
+	ReplyTooLarge uint8 = 2
+	// ReplyDeclined refuses a file the receiver's user didn't want, and ends
+	// the transfer.
+	ReplyDeclined uint8 = 3
+)
+
+// Ack statuses.
+const (
+	AckOK     uint8 = 0
+	AckFailed uint8 = 1
+)
+
+// Retry decisions, sent by the sender after an AckFailed to receivers that
+// announced CapRetry.
+const (
+	RetryGiveUp uint8 = 0
+	RetryEntry  uint8 = 1
+)
+
+// Reply is the receiver's answer to a file header. With ReplyAccept it
+// carries the number of bytes already held in a partial file and the SHA-256
+// of those bytes, so the sender can check they match its copy before only
+// sending the rest.
+type Reply struct {
+	Status     uint8
+	Offset     uint64
+	PrefixHash [32]byte
+}
+
+// WritePreamble writes Magic followed by Version.
+func WritePreamble(w io.Writer) error {
+	preamble := make([]byte, 0, len(Magic)+2)
+	preamble = append(preamble, Magic...)
+	preamble = binary.LittleEndian.AppendUint16(preamble, Version)
+
+	_, err := w.Write(preamble)
+	return err
+}
+
+// ReadPreamble reads the peer's preamble and returns the protocol version it
+// speaks. It does not check whether that version is supported.
+func ReadPreamble(r io.Reader) (uint16, error) {
+	preamble := make([]byte, len(Magic)+2)
+	if _, err := io.ReadFull(r, preamble); err != nil {
+		return 0, err
+	}
+
+	if string(preamble[:len(Magic)]) != Magic {
+		return 0, fmt.Errorf("%w: got %q", ErrBadMagic, preamble[:len(Magic)])
+	}
+
+	return binary.LittleEndian.Uint16(preamble[len(Magic):]), nil
+}
+
+// Supports reports whether this build can talk to a peer speaking version.
+func Supports(version uint16) bool {
+	return version >= MinVersion && version <= Version
+}
+
+// SupportedVersions describes the supported range for error messages, e.g.
+// "v1" or "v1–v2".
+func SupportedVersions() string {
+	if MinVersion == Version {
+		return fmt.Sprintf("v%d", Version)
+	}
+
+	return fmt.Sprintf("v%d–v%d", MinVersion, Version)
+}
@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestPreambleRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WritePreamble(&buf); err != nil {
+		t.Fatal(err)
+	}
+	version, err := ReadPreamble(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != Version || !Supports(version) {
+		t.Errorf("ReadPreamble = v%d, want the supported v%d", version, Version)
+	}
+}
+
+func TestReadPreambleRejectsOtherProtocols(t *testing.T) {
+	tests := []struct {
+		name     string
+		preamble []byte
+		wantErr  error
+	}{
+		{name: "http request", preamble: []byte("GET / HTTP/1.1\r\n"), wantErr: ErrBadMagic},
+		{name: "ssh banner", preamble: []byte("SSH-2.0-OpenSSH"), wantErr: ErrBadMagic},
+		{name: "short", preamble: []byte("FSH"), wantErr: io.ErrUnexpectedEOF},
+		{name: "magic without version", preamble: []byte(Magic), wantErr: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadPreamble(bytes.NewReader(tt.preamble))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadPreamble = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSupports(t *testing.T) {
+	for _, tt := range []struct {
+		version uint16
+		want    bool
+	}{
+		{MinVersion - 1, false},
+		{MinVersion, true},
+		{Version, true},
+		{Version + 1, false},
+	} {
+		if got := Supports(tt.version); got != tt.want {
+			t.Errorf("Supports(%d) = %t, want %t", tt.version, got, tt.want)
+		}
+	}
+}
+
+func FuzzReadPreamble(f *testing.F) {
+	var seed bytes.Buffer
+	WritePreamble(&seed)
+	f.Add(seed.Bytes())
+	f.Add([]byte("FSHR\xff\xff"))
+	f.Add([]byte("GET / HTTP/1.1\r\n"))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		version, err := ReadPreamble(bytes.NewReader(data))
+		if err != nil {
+			if len(data) >= len(Magic)+2 && string(data[:len(Magic)]) == Magic {
+				t.Fatalf("ReadPreamble of %q: %v", data, err)
+			}
+			return
+		}
+		if string(data[:len(Magic)]) != Magic {
+			t.Fatalf("accepted %q without the magic", data)
+		}
+		if got := uint16(data[4]) | uint16(data[5])<<8; got != version {
+			t.Fatalf("ReadPreamble = v%d, want v%d", version, got)
+		}
+	})
+}
@@ -0,0 +1,15 @@
+package protocol
+
+// TransportQUIC is the Transport of an Announcement from a sender serving
+// QUIC rather than TCP.
+//
+// Over QUIC the receiver opens the first stream of the connection and speaks
+// the protocol on it as it would on a TCP connection, up to and including the
+// entry count. The sender then opens a stream of its own for every entry, in
+// entry order, and sends the entry on it from its frame to the file's Ack, so
+// several files can be in flight at once. QUIC is always encrypted; the
+// certificate is the one the sender would serve TLS with.
+const TransportQUIC = "quic"
+
+// QUICProtocol is the ALPN protocol QUIC connections negotiate.
+const QUICProtocol = "fileshare"
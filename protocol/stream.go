@@ -0,0 +1,104 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxStreamChunk bounds the chunks content is split into under
+// FlagStreaming.
+const MaxStreamChunk = 1 << 20
+
+// StreamWriter sends content whose size isn't known up front, as under
+// FlagStreaming: every write is a chunk, a uint32 length followed by its
+// data, and Close writes the empty chunk that ends the content. Writes
+// larger than MaxStreamChunk are split.
+type StreamWriter struct {
+	w io.Writer
+}
+
+// NewStreamWriter returns a StreamWriter sending to w.
+func NewStreamWriter(w io.Writer) *StreamWriter {
+	return &StreamWriter{w: w}
+}
+
+// Write sends p as one chunk or more. An empty p sends nothing, only Close
+// ends the content.
+func (s *StreamWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), MaxStreamChunk)]
+		var length [4]byte
+		binary.LittleEndian.PutUint32(length[:], uint32(len(chunk)))
+		if _, err := s.w.Write(length[:]); err != nil {
+			return written, err
+		}
+		n, err := s.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// Close ends the content. It doesn't close the underlying writer.
+func (s *StreamWriter) Close() error {
+	var end [4]byte
+	_, err := s.w.Write(end[:])
+	return err
+}
+
+// StreamReader reads content sent by a StreamWriter. It returns io.EOF once
+// the empty chunk ending the content was read, without reading past it, and
+// io.ErrUnexpectedEOF if the connection ends before. A chunk longer than
+// MaxStreamChunk is rejected with ErrInvalidFrame.
+type StreamReader struct {
+	r io.Reader
+	// left is what remains of the current chunk
+	left uint32
+	done bool
+}
+
+// NewStreamReader returns a StreamReader reading from r.
+func NewStreamReader(r io.Reader) *StreamReader {
+	return &StreamReader{r: r}
+}
+
+func (s *StreamReader) Read(p []byte) (int, error) {
+	if s.done {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if s.left == 0 {
+		var length [4]byte
+		if _, err := io.ReadFull(s.r, length[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		s.left = binary.LittleEndian.Uint32(length[:])
+		if s.left > MaxStreamChunk {
+			return 0, fmt.Errorf("%w: stream chunk of %d bytes, the limit is %d", ErrInvalidFrame, s.left, MaxStreamChunk)
+		}
+		if s.left == 0 {
+			s.done = true
+			return 0, io.EOF
+		}
+	}
+
+	n, err := s.r.Read(p[:min(uint32(len(p)), s.left)])
+	s.left -= uint32(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
@@ -0,0 +1,427 @@
+package receiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/chunk"
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/internal/ratelimit"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// WithExtractArchives unpacks the directories a sender sends as tar
+// archives into the destination directory, under the name they were sent
+// with, instead of saving them as NAME.tar. Entry names are sanitized like
+// those of directories sent entry by entry, nothing is written through a
+// symlink from the archive, and the directory only shows up once the whole
+// archive arrived and its checksum was verified. Archives sent to a sink are
+// never unpacked.
+func WithExtractArchives(extract bool) Option {
+	return func(r *Receiver) {
+		r.extract = extract
+	}
+}
+
+// streamSource reads content sent under protocol.FlagStreaming, fed into a
+// digest, rate limited and reported to a tracker, and tells how it ended.
+type streamSource struct {
+	stream *protocol.StreamReader
+	r      io.Reader
+	n      uint64
+	max    uint64
+}
+
+func (r *Receiver) newStreamSource(con io.Reader, digest hash.Hash, tracker *progress.Tracker) *streamSource {
+	stream := protocol.NewStreamReader(con)
+	var src io.Reader = io.TeeReader(stream, digest)
+	src = ratelimit.NewReader(src, r.limiter)
+	src = tracker.Reader(src)
+
+	return &streamSource{stream: stream, r: src, max: r.maxFileSize}
+}
+
+// Read fails with ErrFileTooLarge past the size limit, dropping the bytes
+// beyond it.
+func (s *streamSource) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if s.max > 0 && s.n+uint64(n) > s.max {
+		return 0, fmt.Errorf("%w: streamed content exceeds the limit of %d bytes", ErrFileTooLarge, s.max)
+	}
+	s.n += uint64(n)
+
+	return n, err
+}
+
+// fail maps err, met while reading from s, to the error that ends the
+// transfer.
+func (s *streamSource) fail(err error) error {
+	switch {
+	case errors.Is(err, ErrFileTooLarge):
+		return err
+	case errors.Is(err, protocol.ErrInvalidFrame):
+		return fmt.Errorf("%w: %w", ErrProtocol, err)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%w: got %d bytes before the stream broke off", ErrIncompleteTransfer, s.n)
+	}
+	return fmt.Errorf("err receiving file content: %w", err)
+}
+
+// receiveStream saves content sent under protocol.FlagStreaming to file
+// until the sender ends it, in chunks sized by sizer, feeding it into digest
+// as well, and returns the final digest along with the number of bytes
+// received.
+func (r *Receiver) receiveStream(con io.Reader, file io.Writer, digest hash.Hash, tracker *progress.Tracker, sizer *chunk.Sizer) ([]byte, uint64, error) {
+	src := r.newStreamSource(con, digest, tracker)
+
+	var buf []byte
+	for {
+		if len(buf) < sizer.Size() {
+			buf = make([]byte, sizer.Size())
+		}
+
+		start := time.Now()
+		n, err := src.Read(buf[:sizer.Size()])
+		if _, err := file.Write(buf[:n]); err != nil {
+			return nil, 0, fmt.Errorf("err receiving file content: %w", err)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, src.fail(err)
+		}
+		sizer.Record(n, time.Since(start))
+	}
+
+	r.logger.Debug("received streamed content", "bytes", src.n, "chunk_size", sizer.Size())
+
+	return digest.Sum(nil), src.n, nil
+}
+
+// receiveArchive receives the tar archive of the directory filePath, sent
+// under protocol.FlagArchive, and unpacks it into the destination directory,
+// see WithExtractArchives. The archive is unpacked into a hidden directory
+// next to its destination first, which is renamed into place once its
+// checksum was verified, following the overwrite policy.
+func (r *Receiver) receiveArchive(con net.Conn, filePath string, start time.Time) (*TransferStats, error) {
+	// PREPARE PATH TO SAVE THE DIRECTORY
+	relPath, err := sanitizeRelativePath(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("err preparing dest directory path: %w", err)
+	}
+	if relPath == "" {
+		return nil, fmt.Errorf("%w: nothing left of archive name %q", ErrInvalidFileName, filePath)
+	}
+	destDirPath := filepath.Join(r.destDir, filepath.FromSlash(relPath))
+	if err := ensureInsideDir(r.destDir, destDirPath); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(destDirPath), 0o755); err != nil {
+		return nil, fmt.Errorf("err creating parent directory: %w", err)
+	}
+
+	if _, err := os.Lstat(destDirPath); err == nil {
+		switch r.overwritePolicy {
+		case PolicySkip:
+			r.logger.Info("skipping archive, its directory already exists", "file", filePath, "path", destDirPath)
+			if err := r.sendReply(con, protocol.Reply{Status: protocol.ReplySkip}); err != nil {
+				return nil, fmt.Errorf("err sending skip reply: %w", err)
+			}
+			return nil, nil
+		case PolicyError:
+			return nil, fmt.Errorf("%w: %s", ErrFileExists, destDirPath)
+		}
+	}
+
+	// CREATE THE STAGING DIRECTORY
+	staging, err := os.MkdirTemp(filepath.Dir(destDirPath), "."+filepath.Base(destDirPath)+partSuffix+"-*")
+	if err != nil {
+		return nil, fmt.Errorf("err creating staging directory: %w", err)
+	}
+	// empty once the directory moved into place
+	defer os.RemoveAll(staging)
+
+	// streamed content is never resumed, so only offset 0 is offered
+	digest := sha256.New()
+	if _, err := r.negotiateOffset(con, nil, 0, digest); err != nil {
+		return nil, fmt.Errorf("err negotiating resume offset: %w", err)
+	}
+
+	// UNPACK THE ARCHIVE
+	tracker := progress.Start(r.progress, filePath, progress.UnknownSize, 0)
+	src := r.newStreamSource(con, digest, tracker)
+	err = r.extractArchive(src, staging, relPath)
+	tracker.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("err unpacking archive: %w", err)
+	}
+	checksum := digest.Sum(nil)
+
+	// VERIFY FILE CHECKSUM
+	expectedChecksum, err := r.receiveFileChecksum(con)
+	if err != nil {
+		return nil, fmt.Errorf("err receiving file checksum: %w", err)
+	}
+	if !bytes.Equal(checksum, expectedChecksum) {
+		err := fmt.Errorf("%w: expected %x, got %x", ErrChecksumMismatch, expectedChecksum, checksum)
+		return nil, r.sendFailedAck(con, err)
+	}
+
+	// MOVE DIRECTORY INTO PLACE
+	if _, err := os.Lstat(destDirPath); err == nil {
+		switch r.overwritePolicy {
+		case PolicyOverwrite:
+			if err := os.RemoveAll(destDirPath); err != nil {
+				return nil, r.sendFailedAck(con, fmt.Errorf("err replacing %s: %w", destDirPath, err))
+			}
+		case PolicyRename:
+			if destDirPath, err = uniqueDirPath(destDirPath); err != nil {
+				return nil, r.sendFailedAck(con, err)
+			}
+		}
+	}
+	// a directory that showed up meanwhile makes the rename fail, unless it
+	// is empty
+	if err := os.Rename(staging, destDirPath); err != nil {
+		return nil, r.sendFailedAck(con, fmt.Errorf("err renaming %s to %s: %w", staging, destDirPath, err))
+	}
+	if r.fsync {
+		if err := syncDir(filepath.Dir(destDirPath)); err != nil {
+			return nil, r.sendFailedAck(con, fmt.Errorf("err syncing %s: %w", filepath.Dir(destDirPath), err))
+		}
+	}
+	r.filesReceived.Add(1)
+
+	stats := newTransferStats(filePath, destDirPath, src.n, 0, start, checksum)
+	r.logger.Info("saved archive", "file", stats)
+
+	// CONFIRM THE FILE
+	if err := protocol.WriteAck(con, protocol.Ack{Status: protocol.AckOK}); err != nil {
+		return stats, fmt.Errorf("err confirming file: %w", err)
+	}
+
+	return stats, nil
+}
+
+// extractArchive unpacks the tar archive read from src into dir, which
+// stands for relPath, the directory every entry must be named after or
+// below. Directories get their mode and modification time once everything
+// inside them was written. Hard links and special files are skipped. The
+// stream is read to its end.
+func (r *Receiver) extractArchive(src *streamSource, dir, relPath string) error {
+	// mode is zero for directories that keep the one they were created
+	// with
+	type dirMeta struct {
+		path    string
+		mode    os.FileMode
+		modTime time.Time
+	}
+	// the archive's own directory was created as the staging directory,
+	// whose mode must not stay
+	root := dirMeta{path: dir, mode: 0o755}
+	var dirs []dirMeta
+
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return archiveError(src, err)
+		}
+
+		// MAP THE ENTRY INTO THE DIRECTORY
+		name, err := sanitizeRelativePath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		rel, ok := strings.CutPrefix(name, relPath)
+		if !ok || rel != "" && rel[0] != '/' {
+			return fmt.Errorf("%w: archive entry %s is outside %s", ErrProtocol, hdr.Name, relPath)
+		}
+		rel = strings.TrimPrefix(rel, "/")
+		target := filepath.Join(dir, filepath.FromSlash(rel))
+		if rel != "" {
+			if err := ensureInsideDir(dir, target); err != nil {
+				return err
+			}
+			if err := noSymlinks(dir, rel); err != nil {
+				return err
+			}
+		}
+		if rel == "" && hdr.Typeflag != tar.TypeDir {
+			return fmt.Errorf("%w: archive entry %s isn't a directory", ErrProtocol, hdr.Name)
+		}
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeSymlink {
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return fmt.Errorf("err creating directory of %s: %w", target, err)
+			}
+		}
+
+		// WRITE THE ENTRY
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return fmt.Errorf("err creating directory %s: %w", target, err)
+			}
+			meta := dirMeta{path: target, modTime: hdr.ModTime}
+			if r.preservePerms {
+				meta.mode = hdr.FileInfo().Mode().Perm()
+			}
+			if rel == "" {
+				meta.mode = cmp.Or(meta.mode, root.mode)
+				root = meta
+				continue
+			}
+			dirs = append(dirs, meta)
+		case tar.TypeReg:
+			if err := r.extractFile(tr, target, hdr); err != nil {
+				return archiveError(src, err)
+			}
+		case tar.TypeSymlink:
+			if protocol.LinkLeaves(rel, filepath.ToSlash(hdr.Linkname)) {
+				r.logger.Warn("symlink points outside the transferred directory", "path", target, "target", hdr.Linkname)
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				r.logger.Warn("err creating symlink", "path", target, "target", hdr.Linkname, "err", err)
+			}
+		default:
+			r.logger.Warn("skipping archive entry, it is neither a file, a directory nor a symlink", "name", hdr.Name, "type", string(hdr.Typeflag))
+		}
+	}
+
+	// the tar trailer may be followed by padding up to the end of the stream
+	if _, err := io.Copy(io.Discard, src); err != nil {
+		return src.fail(err)
+	}
+
+	// APPLY DIRECTORY MODES AND MODIFICATION TIMES
+	// innermost first, as writing into a directory changes its time and a
+	// read-only one can't be written into
+	metas := append([]dirMeta{root}, dirs...)
+	for i := len(metas) - 1; i >= 0; i-- {
+		d := metas[i]
+		if d.mode != 0 && runtime.GOOS != "windows" {
+			if err := os.Chmod(d.path, d.mode); err != nil {
+				r.logger.Warn("err applying directory mode", "path", d.path, "mode", d.mode, "err", err)
+			}
+		}
+		if r.preserveModTime && !d.modTime.IsZero() {
+			if err := os.Chtimes(d.path, time.Time{}, d.modTime); err != nil {
+				r.logger.Warn("err applying modification time", "path", d.path, "err", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// extractFile writes the content of the regular file entry hdr, read from
+// tr, to target.
+func (r *Receiver) extractFile(tr *tar.Reader, target string, hdr *tar.Header) error {
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+	if err != nil {
+		return fmt.Errorf("err creating %s: %w", target, err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, tr); err != nil {
+		return err
+	}
+	if r.fsync {
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("err syncing %s: %w", target, err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("err closing %s: %w", target, err)
+	}
+
+	if r.preservePerms && runtime.GOOS != "windows" {
+		if err := os.Chmod(target, hdr.FileInfo().Mode().Perm()); err != nil {
+			r.logger.Warn("err applying file mode", "path", target, "mode", hdr.FileInfo().Mode().Perm(), "err", err)
+		}
+	}
+	if r.preserveModTime {
+		if err := os.Chtimes(target, time.Time{}, hdr.ModTime); err != nil {
+			r.logger.Warn("err applying modification time", "path", target, "err", err)
+		}
+	}
+
+	return nil
+}
+
+// archiveError maps err, met while reading the archive from src, to the
+// error that ends the transfer: the stream's own failures come first, a
+// stream that ended properly holding a broken archive is a protocol
+// violation.
+func archiveError(src *streamSource, err error) error {
+	if errors.Is(err, ErrFileTooLarge) || errors.Is(err, protocol.ErrInvalidFrame) {
+		return src.fail(err)
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// the stream only reports io.EOF once it was ended properly
+		if _, streamErr := src.stream.Read(nil); streamErr != io.EOF {
+			return src.fail(err)
+		}
+		return fmt.Errorf("%w: truncated archive: %w", ErrProtocol, err)
+	}
+	if errors.Is(err, tar.ErrHeader) {
+		return fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+	return err
+}
+
+// noSymlinks fails unless every element of rel below dir that exists is
+// something other than a symlink, so that nothing received is written
+// through a symlink received before, nor is a symlink replaced. "." checks
+// nothing.
+func noSymlinks(dir, rel string) error {
+	if rel == "." {
+		return nil
+	}
+	path := dir
+	for _, elem := range strings.Split(rel, "/") {
+		path = filepath.Join(path, elem)
+		info, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: entry %s goes through symlink %s", ErrProtocol, rel, path)
+		}
+	}
+
+	return nil
+}
+
+// uniqueDirPath returns dirPath, or "name (n)" with the lowest free n if it
+// is taken.
+func uniqueDirPath(dirPath string) (string, error) {
+	candidate := dirPath
+	for n := 1; n <= maxNameAttempts; n++ {
+		if _, err := os.Lstat(candidate); errors.Is(err, os.ErrNotExist) {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s (%d)", dirPath, n)
+	}
+
+	return dirPath, fmt.Errorf("no free name for %s after %d attempts", dirPath, maxNameAttempts)
+}
@@ -0,0 +1,204 @@
+package receiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// tarEntry is an entry of a crafted archive; files are given content.
+type tarEntry struct {
+	hdr     tar.Header
+	content string
+}
+
+// craftArchive returns the tar archive of entries.
+func craftArchive(t *testing.T, entries ...tarEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.content))
+		hdr.Mode = 0o755
+		hdr.ModTime = time.Now()
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func dirEntry(name string) tarEntry {
+	return tarEntry{hdr: tar.Header{Typeflag: tar.TypeDir, Name: name}}
+}
+
+func fileEntry(name, content string) tarEntry {
+	return tarEntry{hdr: tar.Header{Typeflag: tar.TypeReg, Name: name}, content: content}
+}
+
+// sendArchive offers the directory name as an archive and streams archive
+// as its content, followed by its checksum, unless hangUp is set, in which
+// case the connection ends in the middle of the stream. It returns the
+// receiver's ack.
+func (f *fakeSender) sendArchive(name string, archive []byte, hangUp bool) (protocol.Ack, bool) {
+	header := protocol.Header{Flags: protocol.FlagArchive | protocol.FlagStreaming, Mode: 0o644, ModTime: time.Now().UnixNano()}
+	rep, ok := f.offerFile(name, header)
+	if !ok || rep.Status != protocol.ReplyAccept {
+		return protocol.Ack{}, false
+	}
+	if !f.write(uint64(0)) {
+		return protocol.Ack{}, false
+	}
+	stream := protocol.NewStreamWriter(f.con)
+	if hangUp {
+		stream.Write(archive[:len(archive)/2])
+		return protocol.Ack{}, false
+	}
+	if _, err := stream.Write(archive); err != nil {
+		return protocol.Ack{}, false
+	}
+	checksum := sha256.Sum256(archive)
+	if stream.Close() != nil || !f.write(checksum[:]) {
+		return protocol.Ack{}, false
+	}
+	ack, err := protocol.ReadAck(f.con)
+	return ack, err == nil
+}
+
+func TestExtractArchive(t *testing.T) {
+	// outside is a directory next to the destination that nothing may be
+	// written to
+	outside := t.TempDir()
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		// wantFiles are the files the extracted directory holds, by path
+		// relative to the destination
+		wantFiles map[string]string
+		wantErr   error
+		hangUp    bool
+	}{
+		{
+			name:      "plain",
+			entries:   []tarEntry{dirEntry("photos/"), dirEntry("photos/2024/"), fileEntry("photos/2024/a.jpg", "jpeg")},
+			wantFiles: map[string]string{"photos/2024/a.jpg": "jpeg"},
+		},
+		{
+			// sanitized like names sent entry by entry, ".." is dropped
+			name:      "parent reference inside",
+			entries:   []tarEntry{dirEntry("photos/"), fileEntry("photos/../../evil.txt", "evil")},
+			wantFiles: map[string]string{"photos/evil.txt": "evil"},
+		},
+		{
+			name:    "parent reference",
+			entries: []tarEntry{dirEntry("photos/"), fileEntry("../evil.txt", "evil")},
+			wantErr: ErrProtocol,
+		},
+		{
+			name:    "outside the directory",
+			entries: []tarEntry{dirEntry("photos/"), fileEntry("music/evil.txt", "evil")},
+			wantErr: ErrProtocol,
+		},
+		{
+			name: "written through a symlink",
+			entries: []tarEntry{
+				dirEntry("photos/"),
+				{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "photos/link", Linkname: outside}},
+				fileEntry("photos/link/evil.txt", "evil"),
+			},
+			wantErr: ErrProtocol,
+		},
+		{
+			name: "hard link and device",
+			entries: []tarEntry{
+				dirEntry("photos/"),
+				fileEntry("photos/a.jpg", "jpeg"),
+				{hdr: tar.Header{Typeflag: tar.TypeLink, Name: "photos/passwd", Linkname: "/etc/passwd"}},
+				{hdr: tar.Header{Typeflag: tar.TypeChar, Name: "photos/null", Devmajor: 1, Devminor: 3}},
+			},
+			wantFiles: map[string]string{"photos/a.jpg": "jpeg"},
+		},
+		{
+			name:    "not starting with the directory",
+			entries: []tarEntry{fileEntry("photos", "not a directory")},
+			wantErr: ErrProtocol,
+		},
+		{
+			name:    "stream broken off",
+			entries: []tarEntry{dirEntry("photos/"), fileEntry("photos/a.jpg", string(testContent(64<<10)))},
+			hangUp:  true,
+			wantErr: ErrIncompleteTransfer,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, dest := newTestReceiver(t, WithExtractArchives(true))
+			archive := craftArchive(t, tt.entries...)
+
+			res := rawTransfer(t, r, func(f *fakeSender) {
+				if !f.handshake() || !f.write(uint32(1)) {
+					return
+				}
+				f.sendArchive("photos", archive, tt.hangUp)
+			})
+			if !errors.Is(res.err, tt.wantErr) {
+				t.Fatalf("ReceiveConn = %v, want %v", res.err, tt.wantErr)
+			}
+			if _, err := os.Lstat(filepath.Join(outside, "evil.txt")); err == nil {
+				t.Error("evil.txt written outside the destination")
+			}
+			if tt.wantErr != nil {
+				// the staging directory is gone along with everything in it
+				assertNoFiles(t, dest)
+				return
+			}
+
+			var got []string
+			filepath.WalkDir(dest, func(path string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					rel, _ := filepath.Rel(dest, path)
+					got = append(got, filepath.ToSlash(rel))
+				}
+				return nil
+			})
+			if len(got) != len(tt.wantFiles) {
+				t.Errorf("extracted %v, want %d files", got, len(tt.wantFiles))
+			}
+			for name, content := range tt.wantFiles {
+				assertFile(t, filepath.Join(dest, filepath.FromSlash(name)), []byte(content))
+			}
+		})
+	}
+}
+
+func TestTruncatedArchive(t *testing.T) {
+	r, dest := newTestReceiver(t, WithExtractArchives(true))
+	archive := craftArchive(t, dirEntry("photos/"), fileEntry("photos/a.jpg", string(testContent(64<<10))))
+
+	// the stream ends properly, but the archive in it is cut short
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if !f.handshake() || !f.write(uint32(1)) {
+			return
+		}
+		f.sendArchive("photos", archive[:len(archive)/2], false)
+	})
+	if !errors.Is(res.err, ErrProtocol) || errors.Is(res.err, ErrIncompleteTransfer) {
+		t.Fatalf("ReceiveConn = %v, want ErrProtocol rather than ErrIncompleteTransfer", res.err)
+	}
+	assertNoFiles(t, dest)
+}
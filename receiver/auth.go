@@ -0,0 +1,43 @@
+package receiver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// ErrAuthFailed is returned when a sender does not answer the shared key
+// challenge correctly.
+var ErrAuthFailed = errors.New("sender failed authentication")
+
+// authenticate challenges the sender with a random nonce and checks that it
+// answers with HMAC-SHA256(sharedKey, nonce).
+func (r *Receiver) authenticate(con net.Conn) error {
+	nonce := make([]byte, protocol.NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("err generating nonce: %w", err)
+	}
+
+	if _, err := con.Write(nonce); err != nil {
+		return fmt.Errorf("err sending nonce: %w", err)
+	}
+
+	answer := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(con, answer); err != nil {
+		return fmt.Errorf("%w: no answer to challenge: %w", ErrAuthFailed, err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(r.sharedKey))
+	mac.Write(nonce)
+	if !hmac.Equal(answer, mac.Sum(nil)) {
+		return fmt.Errorf("%w: wrong key", ErrAuthFailed)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package receiver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/pjmessi/go_file_share/sender"
+)
+
+func TestSharedKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		senderKey string
+		wantErr   bool
+	}{
+		{name: "correct key", senderKey: "s3cret"},
+		{name: "wrong key", senderKey: "guess", wantErr: true},
+		// such a sender hangs up before reading the challenge, which the
+		// pipe, unlike a socket, refuses to take then
+		{name: "missing key", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := testContent(1000)
+			path := writeTestFile(t, t.TempDir(), "secret.txt", content)
+			r, dest := newTestReceiver(t, WithSharedKey("s3cret"))
+			var opts []sender.Option
+			if tt.senderKey != "" {
+				opts = append(opts, sender.WithSharedKey(tt.senderKey))
+			}
+
+			res := pipeTransfer(t, r, newTestSender(t, opts...), path)
+			if !tt.wantErr {
+				if res.err != nil || res.senderErr != nil {
+					t.Fatalf("transfer failed: receiver %v, sender %v", res.err, res.senderErr)
+				}
+				assertFile(t, filepath.Join(dest, "secret.txt"), content)
+				return
+			}
+			if tt.senderKey != "" && !errors.Is(res.err, ErrAuthFailed) {
+				t.Errorf("ReceiveConn = %v, want ErrAuthFailed", res.err)
+			}
+			if res.err == nil {
+				t.Error("ReceiveConn succeeded without authenticating the sender")
+			}
+			if res.senderErr == nil {
+				t.Error("ServeConn succeeded without authenticating")
+			}
+			assertNoFiles(t, dest)
+		})
+	}
+}
+
+func TestSharedKeyAnswerIsNotReplayable(t *testing.T) {
+	key := "s3cret"
+	r, dest := newTestReceiver(t, WithSharedKey(key))
+
+	// the answer to one challenge doesn't answer the next
+	var answer []byte
+	for i := range 2 {
+		res := rawTransfer(t, r, func(f *fakeSender) {
+			if !f.handshake() {
+				return
+			}
+			if f.caps&protocol.CapAuthRequired == 0 {
+				t.Error("receiver with a shared key doesn't require authentication")
+				return
+			}
+			nonce := make([]byte, protocol.NonceSize)
+			if _, err := io.ReadFull(f.con, nonce); err != nil {
+				t.Error(err)
+				return
+			}
+			if answer == nil {
+				mac := hmac.New(sha256.New, []byte(key))
+				mac.Write(nonce)
+				answer = mac.Sum(nil)
+			}
+			if f.write(answer, uint32(1)) {
+				f.sendFile("a.txt", []byte("a"))
+			}
+		})
+		if i == 0 && res.err != nil {
+			t.Fatalf("first ReceiveConn: %v", res.err)
+		}
+		if i == 1 && !errors.Is(res.err, ErrAuthFailed) {
+			t.Fatalf("replayed ReceiveConn = %v, want ErrAuthFailed", res.err)
+		}
+	}
+	assertOnlyFile(t, dest, "a.txt")
+}
@@ -0,0 +1,276 @@
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/chunk"
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/internal/ratelimit"
+)
+
+// benchContentSize is the size of the content the benchmarks move per op.
+const benchContentSize = 16 << 20
+
+// tmpfsDir returns a directory on /dev/shm, where writes cost syscalls but
+// no disk, falling back to b.TempDir where there is none.
+func tmpfsDir(b *testing.B) string {
+	b.Helper()
+
+	if info, err := os.Stat("/dev/shm"); err != nil || !info.IsDir() {
+		return b.TempDir()
+	}
+	dir, err := os.MkdirTemp("/dev/shm", "fileshare-bench")
+	if err != nil {
+		return b.TempDir()
+	}
+	b.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+// quietSender silences the sender, which logs every file it sends, for the
+// rest of the benchmark.
+func quietSender(b *testing.B) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(out) })
+}
+
+// BenchmarkWriteBuffer saves content received in 4 KB chunks straight to
+// the file and through the default write buffer. The content is hashed with
+// CRC-32 rather than SHA-256, which would cost more than the writes.
+func BenchmarkWriteBuffer(b *testing.B) {
+	content := bytes.Repeat([]byte("fileshare"), benchContentSize/9+1)[:benchContentSize]
+	for _, tc := range []struct {
+		name       string
+		bufferSize int
+	}{
+		{"unbuffered", 0},
+		{"buffered", defaultWriteBufferSize},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			r, _ := newTestReceiver(b)
+			path := filepath.Join(tmpfsDir(b), "content")
+
+			b.SetBytes(benchContentSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				file, err := os.Create(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				var out io.Writer = file
+				buffered := bufio.NewWriterSize(file, max(tc.bufferSize, 1))
+				if tc.bufferSize > 0 {
+					out = buffered
+				}
+				sizer := chunk.NewSizer(4<<10, 4<<10, 4<<10)
+				if _, err := r.receiveAndSaveFileContent(bytes.NewReader(content), out, benchContentSize, crc32.NewIEEE(), nil, sizer); err != nil {
+					b.Fatal(err)
+				}
+				if err := buffered.Flush(); err != nil {
+					b.Fatal(err)
+				}
+				file.Close()
+			}
+		})
+	}
+}
+
+// tcpPair returns both ends of a localhost TCP connection.
+func tcpPair(b *testing.B) (net.Conn, net.Conn) {
+	b.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer listener.Close()
+
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	accepted, err := listener.Accept()
+	if err != nil {
+		dialed.Close()
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		dialed.Close()
+		accepted.Close()
+	})
+
+	return dialed, accepted
+}
+
+// BenchmarkContentOverTCP receives content over a localhost TCP connection
+// in fixed 32 KiB chunks, as the data path did first, through io.CopyN over
+// the same reader decorators, and in adaptively sized chunks, as it does now.
+// The content is discarded and hashed with CRC-32, leaving the data path
+// itself to be measured.
+func BenchmarkContentOverTCP(b *testing.B) {
+	content := testContent(benchContentSize)
+	for _, tc := range []struct {
+		name    string
+		receive func(r *Receiver, con net.Conn, tracker *progress.Tracker) error
+	}{
+		{"loop", func(r *Receiver, con net.Conn, tracker *progress.Tracker) error {
+			_, err := r.receiveAndSaveFileContent(con, io.Discard, benchContentSize, crc32.NewIEEE(), tracker, chunk.NewSizer(chunkSize, chunkSize, chunkSize))
+			return err
+		}},
+		{"copyN", func(r *Receiver, con net.Conn, tracker *progress.Tracker) error {
+			var src io.Reader = io.TeeReader(con, crc32.NewIEEE())
+			src = ratelimit.NewReader(src, r.limiter)
+			src = tracker.Reader(src)
+			_, err := io.CopyN(io.Discard, src, benchContentSize)
+			return err
+		}},
+		{"adaptive", func(r *Receiver, con net.Conn, tracker *progress.Tracker) error {
+			_, err := r.receiveAndSaveFileContent(con, io.Discard, benchContentSize, crc32.NewIEEE(), tracker, r.chunkSizer())
+			return err
+		}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			r, _ := newTestReceiver(b)
+			senderEnd, receiverEnd := tcpPair(b)
+
+			b.SetBytes(benchContentSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				sent := make(chan error, 1)
+				go func() {
+					_, err := senderEnd.Write(content)
+					sent <- err
+				}()
+				tracker := progress.Start(func(progress.Info) {}, "content", benchContentSize, 0)
+				if err := tc.receive(r, receiverEnd, tracker); err != nil {
+					b.Fatal(err)
+				}
+				tracker.Finish()
+				if err := <-sent; err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSync transfers one large and many small files to disk with and
+// without WithSync, whose fsyncs cost the most where there are many files.
+func BenchmarkSync(b *testing.B) {
+	for _, files := range []struct {
+		name  string
+		count int
+		size  int
+	}{
+		{"1x16MiB", 1, 16 << 20},
+		{"64x4KiB", 64, 4 << 10},
+	} {
+		srcDir := b.TempDir()
+		var paths []string
+		for i := 0; i < files.count; i++ {
+			paths = append(paths, writeTestFile(b, srcDir, fmt.Sprintf("file%d", i), testContent(files.size)))
+		}
+		for _, sync := range []bool{false, true} {
+			b.Run(fmt.Sprintf("%s/sync=%t", files.name, sync), func(b *testing.B) {
+				quietSender(b)
+				r, destDir := newTestReceiver(b, WithSync(sync))
+				s := newTestSender(b)
+
+				b.SetBytes(int64(files.count * files.size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					res := pipeTransfer(b, r, s, paths...)
+					if res.err != nil || res.senderErr != nil {
+						b.Fatalf("transfer: %v, sender: %v", res.err, res.senderErr)
+					}
+
+					b.StopTimer()
+					entries, err := os.ReadDir(destDir)
+					if err != nil {
+						b.Fatal(err)
+					}
+					for _, entry := range entries {
+						os.RemoveAll(filepath.Join(destDir, entry.Name()))
+					}
+					b.StartTimer()
+				}
+			})
+		}
+	}
+}
+
+// pipeContent sends size bytes of content to r over a net.Pipe, rate
+// limited by r if it is set up to, and returns the chunk size the receiving
+// end settled on. Content is hashed with CRC-32, as in
+// BenchmarkContentOverTCP.
+func pipeContent(b *testing.B, r *Receiver, content []byte, size uint64) int {
+	senderEnd, receiverEnd := net.Pipe()
+	defer receiverEnd.Close()
+	go func() {
+		defer senderEnd.Close()
+		for sent := uint64(0); sent < size; sent += uint64(len(content)) {
+			if _, err := senderEnd.Write(content); err != nil {
+				return
+			}
+		}
+	}()
+
+	sizer := r.chunkSizer()
+	if _, err := r.receiveAndSaveFileContent(receiverEnd, io.Discard, size, crc32.NewIEEE(), nil, sizer); err != nil {
+		b.Fatal(err)
+	}
+	return sizer.Size()
+}
+
+// BenchmarkAdaptiveChunkSize receives content over a net.Pipe for a few
+// seconds' worth of measurement windows, rate limited by the receiver and
+// unlimited, and reports the chunk size the sizer settled on. The unlimited
+// case is sized by the rate of a first, shorter transfer over the pipe.
+func BenchmarkAdaptiveChunkSize(b *testing.B) {
+	const seconds = 3
+	for _, tc := range []struct {
+		name string
+		rate int64
+	}{
+		{"1MBps", 1 << 20},
+		{"16MBps", 16 << 20},
+		{"unlimited", 0},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			var opts []Option
+			if tc.rate > 0 {
+				opts = append(opts, WithRateLimit(tc.rate))
+			}
+			r, _ := newTestReceiver(b, opts...)
+			content := testContent(1 << 20)
+
+			size := uint64(tc.rate) * seconds
+			if tc.rate == 0 {
+				const probe = 256 << 20
+				start := time.Now()
+				pipeContent(b, r, content, probe)
+				size = uint64(probe*seconds/time.Since(start).Seconds()) &^ (1<<20 - 1)
+			}
+
+			var chunkSize int
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				chunkSize = pipeContent(b, r, content, size)
+			}
+			b.ReportMetric(float64(chunkSize), "chunk-bytes")
+		})
+	}
+}
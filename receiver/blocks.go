@@ -0,0 +1,157 @@
+package receiver
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/internal/ratelimit"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// blockBitmap records which blocks of a file were received intact.
+type blockBitmap struct {
+	bits  []uint64
+	count uint64
+}
+
+func newBlockBitmap(count uint64) *blockBitmap {
+	return &blockBitmap{bits: make([]uint64, (count+63)/64), count: count}
+}
+
+func (b *blockBitmap) set(index uint64) {
+	b.bits[index/64] |= 1 << (index % 64)
+}
+
+func (b *blockBitmap) has(index uint64) bool {
+	return b.bits[index/64]&(1<<(index%64)) != 0
+}
+
+// missing returns the indexes of the blocks not received intact yet.
+func (b *blockBitmap) missing() []uint64 {
+	var indexes []uint64
+	for index := uint64(0); index < b.count; index++ {
+		if !b.has(index) {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// receiveBlocks receives the content from offset to contentSize as sent
+// under protocol.FlagBlocks, writing every intact block to its place in file
+// and asking for the corrupt ones again. It returns the digest of the whole
+// file, of which digest already covers the first offset bytes. Blocks that
+// arrive intact and in order are hashed on the fly, the rest is read back
+// from file at the end. On failure, file is cut down to the blocks received
+// in order, so a resumed transfer can continue from it.
+func (r *Receiver) receiveBlocks(con net.Conn, file *os.File, offset, contentSize uint64, digest hash.Hash, tracker *progress.Tracker) ([]byte, error) {
+	size := contentSize - offset
+	received := newBlockBitmap(protocol.BlockCount(size))
+	// hashed counts the blocks digest covers
+	hashed := uint64(0)
+
+	src := ratelimit.NewReader(con, r.limiter)
+	data := make([]byte, min(protocol.BlockSize, size))
+	receive := func(blocks uint64) error {
+		for range blocks {
+			header, err := protocol.ReadBlockHeader(src, size)
+			if err != nil {
+				return fmt.Errorf("err receiving block header: %w", err)
+			}
+			block := data[:header.Length]
+			if _, err := io.ReadFull(src, block); err != nil {
+				return fmt.Errorf("err receiving block %d: %w", header.Index, err)
+			}
+
+			if received.has(header.Index) {
+				continue
+			}
+			if protocol.BlockCRC(block) != header.CRC {
+				r.logger.Warn("received corrupt block", "path", file.Name(), "block", header.Index)
+				continue
+			}
+			if _, err := file.WriteAt(block, int64(offset+header.Index*protocol.BlockSize)); err != nil {
+				return fmt.Errorf("err writing block %d: %w", header.Index, err)
+			}
+			received.set(header.Index)
+			tracker.Add(len(block))
+			if header.Index == hashed {
+				digest.Write(block)
+				hashed++
+			}
+		}
+		return nil
+	}
+
+	err := r.receiveAllBlocks(con, received, receive)
+	inOrder := min(offset+hashed*protocol.BlockSize, contentSize)
+	if err != nil {
+		r.truncatePartFile(file, inOrder)
+		return nil, err
+	}
+
+	// HASH THE BLOCKS THAT CAME OUT OF ORDER
+	if err := hashFileRange(digest, file.Name(), inOrder, contentSize); err != nil {
+		return nil, fmt.Errorf("err hashing received blocks: %w", err)
+	}
+
+	r.logger.Debug("received file content", "bytes", size)
+
+	return digest.Sum(nil), nil
+}
+
+// hashFileRange feeds the bytes of the file at path from start to end into
+// digest. The file is opened anew since the one written to may be open for
+// writing only.
+func hashFileRange(digest hash.Hash, path string, start, end uint64) error {
+	if start >= end {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(digest, io.NewSectionReader(file, int64(start), int64(end-start)))
+	return err
+}
+
+// truncatePartFile cuts file down to the size bytes received in order, so
+// what a resumed transfer continues from holds no gaps.
+func (r *Receiver) truncatePartFile(file *os.File, size uint64) {
+	if err := file.Truncate(int64(size)); err != nil {
+		r.logger.Warn("err truncating partial file", "path", file.Name(), "err", err)
+	}
+}
+
+// receiveAllBlocks receives every block once, then asks for the ones still
+// missing until none are or protocol.MaxRetransmitRounds were used up.
+func (r *Receiver) receiveAllBlocks(con net.Conn, received *blockBitmap, receive func(blocks uint64) error) error {
+	if err := receive(received.count); err != nil {
+		return err
+	}
+
+	for round := 0; ; round++ {
+		missing := received.missing()
+		if len(missing) > 0 && round == protocol.MaxRetransmitRounds {
+			return fmt.Errorf("%w: %d blocks still corrupt after %d retransmissions", ErrChecksumMismatch, len(missing), round)
+		}
+		if err := protocol.WriteBlockRequest(con, missing); err != nil {
+			return fmt.Errorf("err requesting blocks: %w", err)
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+
+		r.logger.Info("requesting corrupt blocks again", "blocks", len(missing), "round", round+1)
+		if err := receive(uint64(len(missing))); err != nil {
+			return err
+		}
+	}
+}
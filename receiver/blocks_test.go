@@ -0,0 +1,102 @@
+package receiver
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/pjmessi/go_file_share/retry"
+	"github.com/pjmessi/go_file_share/sender"
+)
+
+func TestBlockBitmap(t *testing.T) {
+	b := newBlockBitmap(130)
+	for _, index := range []uint64{0, 63, 64, 129} {
+		b.set(index)
+	}
+	if !b.has(63) || !b.has(64) || b.has(65) {
+		t.Error("bits set across a word boundary got mixed up")
+	}
+	missing := b.missing()
+	if len(missing) != 126 || missing[0] != 1 || missing[len(missing)-1] != 128 {
+		t.Errorf("missing = %d blocks from %d to %d, want 126 from 1 to 128", len(missing), missing[0], missing[len(missing)-1])
+	}
+
+	empty := newBlockBitmap(0)
+	if got := empty.missing(); got != nil {
+		t.Errorf("missing of no blocks = %v", got)
+	}
+	b = newBlockBitmap(3)
+	b.set(1)
+	if got := b.missing(); !reflect.DeepEqual(got, []uint64{0, 2}) {
+		t.Errorf("missing = %v, want [0 2]", got)
+	}
+}
+
+// countingConn counts the bytes written to it.
+type countingConn struct {
+	net.Conn
+	written *atomic.Int64
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// blockCorruptingConn flips a byte of every block's data written to it, as
+// a link that corrupts whatever crosses it would.
+type blockCorruptingConn struct {
+	net.Conn
+}
+
+func (c blockCorruptingConn) Write(p []byte) (int, error) {
+	if len(p) == protocol.BlockSize {
+		p = append([]byte(nil), p...)
+		p[len(p)/2] ^= 0xFF
+	}
+	return c.Conn.Write(p)
+}
+
+func TestCorruptBlocksAreSentAgain(t *testing.T) {
+	const size = 5*protocol.BlockSize + 1234
+	content := testContent(size)
+	path := writeTestFile(t, t.TempDir(), "disk.img", content)
+	r, dest := newTestReceiver(t)
+	// the file is never sent again as a whole, only its corrupt blocks
+	s := newTestSender(t, sender.WithBlockChecksums(true), sender.WithTransferRetry(retry.Policy{}))
+
+	var written atomic.Int64
+	res := pipeTransferThrough(t, r, s, func(con net.Conn) net.Conn {
+		// corrupts blocks 1 and 3 on their first way through
+		con = &corruptingConn{Conn: con, at: 1*protocol.BlockSize + protocol.BlockSize/2}
+		con = &corruptingConn{Conn: con, at: 3*protocol.BlockSize + protocol.BlockSize/2}
+		return countingConn{Conn: con, written: &written}
+	}, path)
+	if res.err != nil || res.senderErr != nil {
+		t.Fatalf("transfer failed: receiver %v, sender %v", res.err, res.senderErr)
+	}
+	assertFile(t, filepath.Join(dest, "disk.img"), content)
+	if sent := written.Load(); sent < size+2*protocol.BlockSize || sent > size+3*protocol.BlockSize {
+		t.Errorf("sender wrote %d bytes, want the %d of the file and two blocks again", sent, size)
+	}
+}
+
+func TestPersistentlyCorruptBlocksFail(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "disk.img", testContent(2*protocol.BlockSize))
+	r, dest := newTestReceiver(t)
+	s := newTestSender(t, sender.WithBlockChecksums(true), sender.WithTransferRetry(retry.Policy{}))
+
+	res := pipeTransferThrough(t, r, s, func(con net.Conn) net.Conn {
+		return blockCorruptingConn{Conn: con}
+	}, path)
+	if !errors.Is(res.err, ErrChecksumMismatch) {
+		t.Fatalf("ReceiveConn = %v, want ErrChecksumMismatch once the retransmissions ran out", res.err)
+	}
+	assertNoFiles(t, dest)
+}
@@ -0,0 +1,328 @@
+package receiver
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/pjmessi/go_file_share/retry"
+	"github.com/pjmessi/go_file_share/sender"
+)
+
+// assertNoFiles fails the test if dir holds anything.
+func assertNoFiles(t *testing.T, dir string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("%s left behind in the destination directory", entry.Name())
+	}
+}
+
+func TestTruncatedStreamIsIncomplete(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "big.bin", testContent(1<<20))
+	r, dest := newTestReceiver(t)
+	s := newTestSender(t, sender.WithTransferRetry(retry.Policy{}))
+
+	res := pipeTransferThrough(t, r, s, func(con net.Conn) net.Conn {
+		return &truncatingConn{Conn: con, limit: 64 << 10}
+	}, path)
+	if !errors.Is(res.err, ErrIncompleteTransfer) {
+		t.Fatalf("ReceiveConn = %v, want ErrIncompleteTransfer", res.err)
+	}
+	if res.senderErr == nil {
+		t.Error("ServeConn succeeded over a broken connection")
+	}
+	assertNoFiles(t, dest)
+}
+
+func TestShortContentIsIncomplete(t *testing.T) {
+	r, dest := newTestReceiver(t)
+
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if !f.handshake() || !f.write(uint32(1)) {
+			return
+		}
+		rep, ok := f.offerFile("short.bin", protocol.Header{Size: 1000, Mode: 0o644})
+		if !ok || rep.Status != protocol.ReplyAccept {
+			t.Errorf("reply = %+v, %t, want the file accepted", rep, ok)
+			return
+		}
+		// half the announced size, then hang up
+		f.write(uint64(0), testContent(500))
+	})
+	if !errors.Is(res.err, ErrIncompleteTransfer) {
+		t.Fatalf("ReceiveConn = %v, want ErrIncompleteTransfer", res.err)
+	}
+	assertNoFiles(t, dest)
+}
+
+func TestMissingChecksumIsIncomplete(t *testing.T) {
+	content := testContent(1000)
+	r, _ := newTestReceiver(t)
+
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if !f.handshake() || !f.write(uint32(1)) {
+			return
+		}
+		if rep, ok := f.offerFile("nosum.bin", protocol.Header{Size: uint64(len(content))}); !ok || rep.Status != protocol.ReplyAccept {
+			return
+		}
+		// the content, then only part of the checksum
+		f.write(uint64(0), content, binary.LittleEndian.AppendUint64(nil, 42))
+	})
+	if !errors.Is(res.err, ErrIncompleteTransfer) {
+		t.Fatalf("ReceiveConn = %v, want ErrIncompleteTransfer", res.err)
+	}
+}
+
+func TestCorruptedContentFailsChecksum(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "photo.jpg", testContent(256<<10))
+	r, dest := newTestReceiver(t)
+	s := newTestSender(t, sender.WithTransferRetry(retry.Policy{}))
+
+	res := pipeTransferThrough(t, r, s, func(con net.Conn) net.Conn {
+		return &corruptingConn{Conn: con, at: 10000}
+	}, path)
+	if !errors.Is(res.err, ErrChecksumMismatch) {
+		t.Fatalf("ReceiveConn = %v, want ErrChecksumMismatch", res.err)
+	}
+	if !errors.Is(res.senderErr, sender.ErrNotConfirmed) {
+		t.Errorf("ServeConn = %v, want ErrNotConfirmed", res.senderErr)
+	}
+	assertNoFiles(t, dest)
+}
+
+func TestWrongChecksumIsRejected(t *testing.T) {
+	content := testContent(4096)
+	r, dest := newTestReceiver(t)
+
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if !f.handshake() || !f.write(uint32(1)) {
+			return
+		}
+		ack, ok := f.sendFileWithChecksum("doc.txt", content, make([]byte, 32))
+		if !ok || ack.Status != protocol.AckFailed {
+			t.Errorf("ack = %+v, %t, want the file failed", ack, ok)
+		}
+		f.write(protocol.RetryGiveUp)
+	})
+	if !errors.Is(res.err, ErrChecksumMismatch) {
+		t.Fatalf("ReceiveConn = %v, want ErrChecksumMismatch", res.err)
+	}
+	assertNoFiles(t, dest)
+}
+
+func TestCorruptedContentIsSentAgain(t *testing.T) {
+	content := testContent(256 << 10)
+	path := writeTestFile(t, t.TempDir(), "photo.jpg", content)
+	r, dest := newTestReceiver(t)
+	s := newTestSender(t, sender.WithTransferRetry(retry.Policy{MaxAttempts: 2}))
+
+	// only the first attempt is corrupted
+	res := pipeTransferThrough(t, r, s, func(con net.Conn) net.Conn {
+		return &corruptingConn{Conn: con, at: 10000}
+	}, path)
+	if res.err != nil || res.senderErr != nil {
+		t.Fatalf("transfer failed: receiver %v, sender %v", res.err, res.senderErr)
+	}
+	assertFile(t, filepath.Join(dest, "photo.jpg"), content)
+}
+
+func TestRateLimit(t *testing.T) {
+	const size, rate = 1 << 20, 2 << 20
+	content := testContent(size)
+	path := writeTestFile(t, t.TempDir(), "limited.bin", content)
+	r, dest := newTestReceiver(t, WithRateLimit(rate))
+
+	start := time.Now()
+	res := pipeTransfer(t, r, newTestSender(t), path)
+	elapsed := time.Since(start)
+	if res.err != nil || res.senderErr != nil {
+		t.Fatalf("transfer failed: receiver %v, sender %v", res.err, res.senderErr)
+	}
+	// the bucket starts out holding 100ms worth
+	want := time.Second*size/rate - 100*time.Millisecond
+	if elapsed < want*9/10 || elapsed > want*11/10 {
+		t.Errorf("%d bytes at %d B/s took %s, want %s ± 10%%", size, rate, elapsed, want)
+	}
+	assertFile(t, filepath.Join(dest, "limited.bin"), content)
+}
+
+func TestContentSizes(t *testing.T) {
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 2 * chunkSize} {
+		t.Run(fmt.Sprint(size, " bytes"), func(t *testing.T) {
+			content := testContent(size)
+			path := writeTestFile(t, t.TempDir(), "sized.bin", content)
+			r, dest := newTestReceiver(t)
+
+			res := pipeTransfer(t, r, newTestSender(t), path)
+			if res.err != nil || res.senderErr != nil {
+				t.Fatalf("transfer failed: receiver %v, sender %v", res.err, res.senderErr)
+			}
+			if len(res.stats) != 1 || res.stats[0].Size != uint64(size) || res.stats[0].Bytes != uint64(size) {
+				t.Fatalf("stats = %+v, want %d bytes", res.stats, size)
+			}
+			if want := fmt.Sprintf("%x", sha256.Sum256(content)); res.stats[0].Checksum != want {
+				t.Errorf("Checksum = %s, want %s", res.stats[0].Checksum, want)
+			}
+			assertFile(t, filepath.Join(dest, "sized.bin"), content)
+		})
+	}
+}
+
+func TestEmptyFileIsVerified(t *testing.T) {
+	r, dest := newTestReceiver(t)
+
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if !f.handshake() || !f.write(uint32(1)) {
+			return
+		}
+		// the checksum of nothing is still checked
+		ack, ok := f.sendFileWithChecksum("empty", nil, make([]byte, sha256.Size))
+		if !ok || ack.Status == protocol.AckOK {
+			t.Errorf("ack = %+v, %t, want the checksum refused", ack, ok)
+		}
+	})
+	if !errors.Is(res.err, ErrChecksumMismatch) {
+		t.Fatalf("ReceiveConn = %v, want ErrChecksumMismatch", res.err)
+	}
+	assertNoFiles(t, dest)
+}
+
+func TestGeneratedNamesDoNotCollide(t *testing.T) {
+	src := t.TempDir()
+	var paths []string
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		paths = append(paths, writeTestFile(t, src, name, []byte(name)))
+	}
+	// the files arrive within the same second, which the names are made of
+	r, dest := newTestReceiver(t, WithPreserveFilename(false), WithOverwritePolicy(PolicyError))
+
+	res := pipeTransfer(t, r, newTestSender(t), paths...)
+	if res.err != nil {
+		t.Fatalf("ReceiveConn: %v", res.err)
+	}
+	entries, err := os.ReadDir(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d files, want each saved under a name of its own", len(entries))
+	}
+	for i, stats := range res.stats {
+		assertFile(t, stats.Path, []byte(filepath.Base(paths[i])))
+	}
+}
+
+func TestNameOfNothingIsGenerated(t *testing.T) {
+	r, dest := newTestReceiver(t)
+
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if f.handshake() && f.write(uint32(1)) {
+			f.sendFile("...", []byte("x"))
+		}
+	})
+	if res.err != nil {
+		t.Fatalf("ReceiveConn: %v", res.err)
+	}
+	if len(res.stats) != 1 || filepath.Dir(res.stats[0].Path) != dest || filepath.Base(res.stats[0].Path) == "..." {
+		t.Fatalf("stats = %+v, want the file saved under a generated name", res.stats)
+	}
+	assertFile(t, res.stats[0].Path, []byte("x"))
+}
+
+// pipeStdin swaps os.Stdin for the reading end of a pipe for the rest of
+// the test and returns the writing end, which the test closes.
+func pipeStdin(t *testing.T) *os.File {
+	t.Helper()
+
+	stdinEnd, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = stdinEnd
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		stdinEnd.Close()
+		w.Close()
+	})
+	return w
+}
+
+func TestStdinRoundTrip(t *testing.T) {
+	const size = 3 << 20
+	content := testContent(size)
+	stdin := pipeStdin(t)
+	var mu sync.Mutex
+	var reports []ProgressInfo
+	r, dest := newTestReceiver(t, WithProgress(func(info ProgressInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, info)
+	}))
+	s := newTestSender(t, sender.WithStdinName("dump.sql"))
+
+	done := make(chan pipeResult, 1)
+	go func() {
+		done <- pipeTransfer(t, r, s, sender.StdinPath)
+	}()
+
+	// halfway through, the file holds no more than arrived, nothing was
+	// reserved for a size nobody knows
+	if _, err := stdin.Write(content[:size/2]); err != nil {
+		t.Fatal(err)
+	}
+	part := filepath.Join(dest, "dump.sql"+partSuffix)
+	deadline := time.Now().Add(testTimeout)
+	for {
+		info, err := os.Stat(part)
+		if err == nil && info.Size() > size/2 {
+			t.Fatalf("%s holds %d bytes of the %d sent so far", part, info.Size(), size/2)
+		}
+		if err == nil && info.Size() > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no content reached %s: %v", part, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := stdin.Write(content[size/2:]); err != nil {
+		t.Fatal(err)
+	}
+	stdin.Close()
+
+	res := <-done
+	if res.err != nil || res.senderErr != nil {
+		t.Fatalf("transfer: %v, sender: %v", res.err, res.senderErr)
+	}
+	assertFile(t, filepath.Join(dest, "dump.sql"), content)
+	if len(res.stats) != 1 || res.stats[0].Size != size || res.stats[0].Checksum != fmt.Sprintf("%x", sha256.Sum256(content)) {
+		t.Errorf("stats = %+v, want the %d bytes and checksum sent", res.stats, size)
+	}
+
+	// progress counts the bytes without a total to take a percentage of
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reports) == 0 || !reports[len(reports)-1].Done || reports[len(reports)-1].Bytes != size {
+		t.Fatalf("progress reports = %+v, want the last one done after %d bytes", reports, size)
+	}
+	for _, info := range reports {
+		if !info.SizeUnknown || info.Total != 0 {
+			t.Errorf("progress report %+v has a size", info)
+		}
+	}
+}
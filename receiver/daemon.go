@@ -0,0 +1,156 @@
+package receiver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/events"
+)
+
+// defaultMaxConcurrent is how many transfers daemon mode runs at once unless
+// configured otherwise
+const defaultMaxConcurrent = 4
+
+// defaultDrainTimeout is how long a stopping daemon waits for transfers in
+// flight unless configured otherwise
+const defaultDrainTimeout = 30 * time.Second
+
+// serve runs daemon mode. Discovery keeps running while transfers are in
+// flight, and every new sender is received from on a goroutine of its own. It
+// returns the stats of the files received from all of them.
+func (r *Receiver) serve(ctx context.Context) ([]TransferStats, error) {
+	discoveryCtx, stopDiscovery := context.WithCancel(ctx)
+	defer stopDiscovery()
+	found, stopped := r.startDiscovery(discoveryCtx)
+
+	// transfers outlive ctx for the drain timeout
+	transferCtx, abortTransfers := context.WithCancel(context.WithoutCancel(ctx))
+	defer abortTransfers()
+
+	maxConcurrent := r.maxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultMaxConcurrent
+	}
+	slots := make(chan struct{}, maxConcurrent)
+
+	var transfers sync.WaitGroup
+	var statsMu sync.Mutex
+	var stats []TransferStats
+	// collected returns the stats once no transfer is adding to them anymore
+	collected := func() []TransferStats {
+		transfers.Wait()
+		return stats
+	}
+	limitReached := make(chan struct{})
+	var reachLimit sync.Once
+
+	// senders already served, by peer key
+	served := map[string]bool{}
+	r.filesReceived.Store(0)
+	transferCount := 0
+	r.logger.Info("daemon mode: waiting for senders")
+
+	for {
+		select {
+		case <-ctx.Done():
+			stopDiscovery()
+			r.drain(&transfers, abortTransfers)
+			all := collected()
+			r.logger.Info("daemon stopped", "transfers", transferCount)
+			return all, nil
+		case <-limitReached:
+			// let the transfers in flight finish, but don't start new ones
+			stopDiscovery()
+			all := collected()
+			r.logger.Info("daemon stopped, file limit reached", "files", r.filesReceived.Load(), "limit", r.maxFiles)
+			return all, nil
+		case err := <-stopped:
+			r.reportError(ctx, err)
+			return collected(), fmt.Errorf("err searching for discovery msg: %w", err)
+		case peer := <-found:
+			key := peer.key()
+			if served[key] {
+				continue
+			}
+			served[key] = true
+			r.logOffer(peer)
+			events.Emit(&r.eventQueue, ctx, r.events.PeerDiscovered, peer)
+			transferCount++
+
+			transfers.Add(1)
+			go func(seq int) {
+				defer transfers.Done()
+
+				select {
+				case slots <- struct{}{}:
+				case <-discoveryCtx.Done():
+					return
+				}
+				defer func() { <-slots }()
+
+				transferStats := r.daemonTransfer(transferCtx, seq, peer)
+				statsMu.Lock()
+				stats = append(stats, transferStats...)
+				statsMu.Unlock()
+
+				if files := r.filesReceived.Load(); r.maxFiles > 0 && files >= int64(r.maxFiles) {
+					reachLimit.Do(func() { close(limitReached) })
+				}
+			}(transferCount)
+		}
+	}
+}
+
+// drain waits for the transfers in flight, aborting them once the drain
+// timeout has passed.
+func (r *Receiver) drain(transfers *sync.WaitGroup, abort context.CancelFunc) {
+	done := make(chan struct{})
+	go func() {
+		transfers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	default:
+	}
+
+	r.logger.Info("stopping, waiting for transfers in flight", "timeout", r.drainTimeout)
+	timer := time.NewTimer(r.drainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		r.logger.Warn("drain timeout passed, aborting transfers in flight")
+		abort()
+		<-done
+	}
+}
+
+// daemonTransfer receives from a single sender in daemon mode, reporting
+// rather than returning its error. The stats of the files that were saved are
+// returned either way.
+func (r *Receiver) daemonTransfer(ctx context.Context, seq int, peer Peer) []TransferStats {
+	r.logger.Info("transfer started", "transfer", seq, "peer", peer.Addr)
+
+	stats, err := r.receiveFromPeer(ctx, peer)
+	if ctx.Err() != nil {
+		r.logger.Info("transfer interrupted", "transfer", seq, "peer", peer.Addr)
+		return stats
+	}
+	if err != nil {
+		r.logger.Error("transfer failed", "transfer", seq, "peer", peer.Addr, "err", err)
+		r.reportError(ctx, &PeerError{Addr: peer.Addr, Err: err})
+		if r.onTransferError != nil {
+			r.onTransferError(peer, err)
+		}
+		return stats
+	}
+
+	r.logger.Info("transfer done", "transfer", seq, "peer", peer.Addr, "files", len(stats))
+	return stats
+}
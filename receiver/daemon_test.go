@@ -0,0 +1,135 @@
+package receiver
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDaemonReceivesConcurrently(t *testing.T) {
+	senders, peers := twoSenders(t)
+
+	// either file is only accepted once both were offered, which takes
+	// the two transfers running at once
+	var offered sync.WaitGroup
+	offered.Add(2)
+	bothOffered := make(chan struct{})
+	go func() {
+		offered.Wait()
+		close(bothOffered)
+	}()
+	accept := func(name string, size int64, peer string) bool {
+		offered.Done()
+		select {
+		case <-bothOffered:
+			return true
+		case <-time.After(5 * time.Second):
+			return false
+		}
+	}
+	r, dest := newTestReceiver(t,
+		WithDaemon(true),
+		WithDiscoverers(fakeDiscoverer{peers: peers}),
+		WithDialer(senders.dial),
+		WithAcceptFunc(accept),
+		WithMaxFiles(2),
+	)
+
+	stats, err := receiveDiscovered(t, r)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got stats of %d files, want one from each sender", len(stats))
+	}
+	assertFile(t, filepath.Join(dest, "a.txt"), []byte("from a"))
+	assertFile(t, filepath.Join(dest, "b.txt"), []byte("from b"))
+}
+
+func TestDaemonMaxConcurrent(t *testing.T) {
+	senders, peers := twoSenders(t)
+
+	var mu sync.Mutex
+	running, most := 0, 0
+	accept := func(name string, size int64, peer string) bool {
+		mu.Lock()
+		running++
+		most = max(most, running)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return true
+	}
+	r, _ := newTestReceiver(t,
+		WithDaemon(true),
+		WithDiscoverers(fakeDiscoverer{peers: peers}),
+		WithDialer(senders.dial),
+		WithAcceptFunc(accept),
+		WithMaxConcurrent(1),
+		WithMaxFiles(2),
+	)
+
+	stats, err := receiveDiscovered(t, r)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if len(stats) != 2 || most != 1 {
+		t.Fatalf("received %d files with up to %d transfers at once, want 2 files one at a time", len(stats), most)
+	}
+}
+
+func TestDaemonDrainsOnStop(t *testing.T) {
+	tests := []struct {
+		name         string
+		drainTimeout time.Duration
+		wantSaved    bool
+	}{
+		{"finishes within the drain timeout", 10 * time.Second, true},
+		{"aborted after the drain timeout", 50 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			senders, peers := twoSenders(t)
+
+			// the first file is taken once the daemon was told to stop
+			ctx, stop := context.WithTimeout(context.Background(), testTimeout)
+			defer stop()
+			accept := func(name string, size int64, peer string) bool {
+				stop()
+				time.Sleep(200 * time.Millisecond)
+				return true
+			}
+			r, dest := newTestReceiver(t,
+				WithDaemon(true),
+				WithDiscoverers(fakeDiscoverer{peers: peers}),
+				WithDialer(senders.dial),
+				WithAcceptFunc(accept),
+				WithMaxConcurrent(1),
+				WithDrainTimeout(tt.drainTimeout),
+			)
+
+			stats, err := r.Receive(ctx)
+			if err != nil {
+				t.Fatalf("Receive: %v", err)
+			}
+			if saved := len(stats) == 1; saved != tt.wantSaved {
+				t.Fatalf("got stats of %d files, want the file in flight saved: %t", len(stats), tt.wantSaved)
+			}
+			if tt.wantSaved {
+				assertOnlyFile(t, dest, stats[0].Name)
+			} else {
+				assertNoFiles(t, dest)
+			}
+
+			// the sender waiting for the transfer in flight isn't started
+			if dialed := senders.dialed(peers[0].Addr) + senders.dialed(peers[1].Addr); dialed != 1 {
+				t.Errorf("dialed %d senders, want only the one in flight", dialed)
+			}
+			senders.wait(peers[0].Addr)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package receiver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// partSuffix is appended to the destination path while a file is still being
+// received.
+const partSuffix = ".part"
+
+// maxNameAttempts bounds how many "name (n).ext" candidates createUniqueFile
+// tries before giving up.
+const maxNameAttempts = 10000
+
+var copySuffix = regexp.MustCompile(`^(.*) \((\d+)\)$`)
+
+// openDestFile creates the ".part" file that content is written to and
+// returns it along with the final path it should be renamed to, chosen
+// according to the overwrite policy. Under PolicySkip and PolicyError an
+// existing file is reported as os.ErrExist.
+func (r *Receiver) openDestFile(destFilePath string) (*os.File, string, error) {
+	switch r.overwritePolicy {
+	case PolicyOverwrite:
+		// a symlink in the way is replaced rather than written through
+		if info, err := os.Lstat(destFilePath + partSuffix); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(destFilePath + partSuffix); err != nil {
+				return nil, destFilePath, err
+			}
+		}
+		file, err := os.Create(destFilePath + partSuffix)
+		return file, destFilePath, err
+	case PolicySkip, PolicyError:
+		if _, err := os.Lstat(destFilePath); err == nil {
+			return nil, destFilePath, os.ErrExist
+		}
+		file, err := createExclusive(destFilePath + partSuffix)
+		return file, destFilePath, err
+	default:
+		return createUniqueFile(destFilePath)
+	}
+}
+
+// reserveFinalPath picks the path to rename a file to whose name was only
+// known once it was received, according to the overwrite policy, the way
+// openDestFile does for names known up front. The path is reserved by an
+// empty ".part" file until release is called. Under PolicySkip and
+// PolicyError an existing file is reported as os.ErrExist.
+func (r *Receiver) reserveFinalPath(destFilePath string) (string, func(), error) {
+	var file *os.File
+	var err error
+	switch r.overwritePolicy {
+	case PolicyOverwrite:
+		return destFilePath, func() {}, nil
+	case PolicySkip, PolicyError:
+		if _, err := os.Lstat(destFilePath); err == nil {
+			return destFilePath, nil, os.ErrExist
+		}
+		file, err = createExclusive(destFilePath + partSuffix)
+	default:
+		file, destFilePath, err = createUniqueFile(destFilePath)
+	}
+	if err != nil {
+		return destFilePath, nil, err
+	}
+	file.Close()
+
+	return destFilePath, func() { r.removePartFile(file.Name()) }, nil
+}
+
+// createUniqueFile reserves destFilePath, or "name (n).ext" with the lowest
+// free n if it is taken, by creating its ".part" file. O_EXCL makes the
+// existence check and the creation a single step, so concurrent receives
+// never pick the same name.
+func createUniqueFile(destFilePath string) (*os.File, string, error) {
+	dir, fileName := filepath.Split(destFilePath)
+
+	ext := filepath.Ext(fileName)
+	stem := strings.TrimSuffix(fileName, ext)
+	if stem == "" {
+		// dotfiles such as ".bashrc" have no extension to preserve
+		stem, ext = fileName, ""
+	}
+
+	// continue counting from an existing suffix instead of stacking them
+	next := 1
+	if match := copySuffix.FindStringSubmatch(stem); match != nil {
+		if n, err := strconv.Atoi(match[2]); err == nil {
+			stem, next = match[1], n+1
+		}
+	}
+
+	candidate := destFilePath
+	for attempt := 0; attempt < maxNameAttempts; attempt++ {
+		if _, err := os.Lstat(candidate); errors.Is(err, os.ErrNotExist) {
+			file, err := createExclusive(candidate + partSuffix)
+			if err == nil {
+				return file, candidate, nil
+			}
+			if !errors.Is(err, os.ErrExist) {
+				return nil, candidate, err
+			}
+		}
+
+		candidate = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, next, ext))
+		next++
+	}
+
+	return nil, destFilePath, fmt.Errorf("no free name for %s after %d attempts", destFilePath, maxNameAttempts)
+}
+
+// inFlightPaths is the set of destination paths transfers are currently
+// writing to.
+type inFlightPaths struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+// claim adds path to the set and reports whether it wasn't in it yet.
+func (p *inFlightPaths) claim(path string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paths[path] {
+		return false
+	}
+	if p.paths == nil {
+		p.paths = map[string]bool{}
+	}
+	p.paths[path] = true
+	return true
+}
+
+func (p *inFlightPaths) release(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.paths, path)
+}
+
+func createExclusive(filePath string) (*os.File, error) {
+	return os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+}
+
+// failedSuffix replaces partSuffix on the partial files of failed transfers
+// kept with WithKeepPartials.
+const failedSuffix = ".failed"
+
+// discardPartFile cleans up the ".part" file of a failed transfer. With
+// resume enabled it is kept if the failure is resumable, so the next attempt
+// can pick up where this one stopped. Otherwise it is renamed to ".failed"
+// with WithKeepPartials, or removed.
+func (r *Receiver) discardPartFile(partFilePath string, resumable bool) {
+	switch {
+	case resumable && r.resume:
+		r.logger.Info("keeping partial file to resume later", "path", partFilePath)
+	case r.keepPartials:
+		failedPath := strings.TrimSuffix(partFilePath, partSuffix) + failedSuffix
+		if err := os.Rename(partFilePath, failedPath); err != nil {
+			r.logger.Warn("err keeping partial file", "path", partFilePath, "err", err)
+			return
+		}
+		r.logger.Info("kept partial file of failed transfer", "path", failedPath)
+	default:
+		r.removePartFile(partFilePath)
+	}
+}
+
+// removePartFile deletes an unfinished ".part" file, logging instead of
+// failing since the caller is already returning a more relevant error.
+func (r *Receiver) removePartFile(partFilePath string) {
+	if err := os.Remove(partFilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		r.logger.Warn("err removing partial file", "path", partFilePath, "err", err)
+	}
+}
+
+// syncDir flushes the entries of the directory at path to disk. Windows
+// can't open directories for syncing and makes renames durable on its own.
+func syncDir(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
@@ -0,0 +1,239 @@
+package receiver
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+func TestCreateUniqueFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		path     string
+		want     string
+	}{
+		{name: "free", path: "a.txt", want: "a.txt"},
+		{name: "taken", existing: []string{"a.txt"}, path: "a.txt", want: "a (1).txt"},
+		{name: "several taken", existing: []string{"a.txt", "a (1).txt", "a (2).txt"}, path: "a.txt", want: "a (3).txt"},
+		{name: "counting on", existing: []string{"a (4).txt"}, path: "a (4).txt", want: "a (5).txt"},
+		{name: "dotfile", existing: []string{".bashrc"}, path: ".bashrc", want: ".bashrc (1)"},
+		{name: "no extension", existing: []string{"Makefile"}, path: "Makefile", want: "Makefile (1)"},
+		{name: "part file in the way", existing: []string{"a.txt.part"}, path: "a.txt", want: "a (1).txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.existing {
+				writeTestFile(t, dir, name, nil)
+			}
+
+			file, got, err := createUniqueFile(filepath.Join(dir, tt.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+			if got != filepath.Join(dir, tt.want) {
+				t.Errorf("createUniqueFile = %s, want %s", filepath.Base(got), tt.want)
+			}
+			if file.Name() != got+partSuffix {
+				t.Errorf("created %s, want the part file of %s", file.Name(), got)
+			}
+		})
+	}
+}
+
+func TestCreateUniqueFileConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "same.txt")
+	const n = 20
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	picked := map[string]bool{}
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			file, got, err := createUniqueFile(path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			file.Close()
+			mu.Lock()
+			defer mu.Unlock()
+			if picked[got] {
+				t.Errorf("%s picked twice", got)
+			}
+			picked[got] = true
+		}()
+	}
+	wg.Wait()
+	if len(picked) != n {
+		t.Errorf("%d distinct names for %d files", len(picked), n)
+	}
+}
+
+func TestSameNameTwiceIsRenamed(t *testing.T) {
+	first, second := testContent(100), testContent(200)
+	r, dest := newTestReceiver(t)
+
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if !f.handshake() || !f.write(uint32(2)) {
+			return
+		}
+		f.sendFile("same.txt", first)
+		f.sendFile("same.txt", second)
+	})
+	if res.err != nil {
+		t.Fatalf("ReceiveConn: %v", res.err)
+	}
+	assertFile(t, filepath.Join(dest, "same.txt"), first)
+	assertFile(t, filepath.Join(dest, "same (1).txt"), second)
+}
+
+func TestPartFileUntilSaved(t *testing.T) {
+	content := testContent(1000)
+	r, dest := newTestReceiver(t)
+
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if !f.handshake() || !f.write(uint32(1)) {
+			return
+		}
+		rep, ok := f.offerFile("x.bin", protocol.Header{Size: 1000, Mode: 0o644})
+		if !ok || rep.Status != protocol.ReplyAccept || !f.write(uint64(0), content) {
+			return
+		}
+		// nothing goes by the final name before the checksum matched
+		if _, err := os.Stat(filepath.Join(dest, "x.bin"+partSuffix)); err != nil {
+			t.Errorf("no part file while receiving: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dest, "x.bin")); err == nil {
+			t.Error("file saved under its final name before the checksum")
+		}
+		checksum := sha256.Sum256(content)
+		if f.write(checksum[:]) {
+			protocol.ReadAck(f.con)
+		}
+	})
+	if res.err != nil {
+		t.Fatalf("ReceiveConn: %v", res.err)
+	}
+	assertOnlyFile(t, dest, "x.bin")
+}
+
+func TestFailedTransferCleansUp(t *testing.T) {
+	content := testContent(1000)
+	tests := []struct {
+		name    string
+		send    func(f *fakeSender)
+		wantErr error
+		// kept is what the ".failed" file holds with WithKeepPartials
+		kept []byte
+	}{
+		{
+			name: "cut short",
+			send: func(f *fakeSender) {
+				if !f.handshake() || !f.write(uint32(1)) {
+					return
+				}
+				if rep, ok := f.offerFile("x.bin", protocol.Header{Size: 1000, Mode: 0o644}); ok && rep.Status == protocol.ReplyAccept {
+					f.write(uint64(0), content[:600])
+				}
+			},
+			wantErr: ErrIncompleteTransfer,
+			kept:    content[:600],
+		},
+		{
+			name: "wrong checksum",
+			send: func(f *fakeSender) {
+				if f.handshake() && f.write(uint32(1)) {
+					f.sendFileWithChecksum("x.bin", content, make([]byte, sha256.Size))
+				}
+			},
+			wantErr: ErrChecksumMismatch,
+			kept:    content,
+		},
+	}
+	for _, tt := range tests {
+		for _, keep := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s keeping partials %t", tt.name, keep), func(t *testing.T) {
+				r, dest := newTestReceiver(t, WithKeepPartials(keep))
+
+				res := rawTransfer(t, r, tt.send)
+				if !errors.Is(res.err, tt.wantErr) {
+					t.Fatalf("ReceiveConn = %v, want %v", res.err, tt.wantErr)
+				}
+				if !keep {
+					assertNoFiles(t, dest)
+					return
+				}
+				assertOnlyFile(t, dest, "x.bin"+failedSuffix)
+				assertFile(t, filepath.Join(dest, "x.bin"+failedSuffix), tt.kept)
+			})
+		}
+	}
+}
+
+func TestInterruptedTransferCleansUp(t *testing.T) {
+	content := testContent(1000)
+	tests := []struct {
+		name      string
+		interrupt func(r *Receiver, cancel context.CancelFunc)
+	}{
+		{"cancelled", func(r *Receiver, cancel context.CancelFunc) { cancel() }},
+		{"closed", func(r *Receiver, cancel context.CancelFunc) { r.Close() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+			var r *Receiver
+			sent := make(chan struct{})
+			dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+				senderEnd, receiverEnd := net.Pipe()
+				go func() {
+					defer close(sent)
+					defer senderEnd.Close()
+					f := &fakeSender{t: t, con: senderEnd}
+					if !f.handshake() || !f.write(uint32(1)) {
+						return
+					}
+					rep, ok := f.offerFile("x.bin", protocol.Header{Size: 1000, Mode: 0o644})
+					if !ok || rep.Status != protocol.ReplyAccept || !f.write(uint64(0), content[:600]) {
+						return
+					}
+					// interrupted halfway through the content, the receiver
+					// hangs up
+					if _, err := os.Stat(filepath.Join(r.destDir, "x.bin"+partSuffix)); err != nil {
+						t.Errorf("no part file while receiving: %v", err)
+					}
+					tt.interrupt(r, cancel)
+					io.Copy(io.Discard, senderEnd)
+				}()
+				return receiverEnd, nil
+			}
+			var dest string
+			r, dest = newTestReceiver(t,
+				WithDiscoverers(fakeDiscoverer{peers: []Peer{{Addr: "192.0.2.10:9000"}}}),
+				WithDialer(dial),
+			)
+
+			if _, err := r.Receive(ctx); !errors.Is(err, context.Canceled) {
+				t.Errorf("Receive = %v, want context.Canceled", err)
+			}
+			<-sent
+			assertNoFiles(t, dest)
+		})
+	}
+}
@@ -0,0 +1,551 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/events"
+	"github.com/pjmessi/go_file_share/internal/mdns"
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// Peer is a sender found through discovery.
+type Peer struct {
+	// Addr is the host:port to connect to.
+	Addr string
+	// TLS is set when the sender requires TLS.
+	TLS bool
+	// QUIC is set when the sender serves QUIC rather than TCP, which is
+	// always encrypted.
+	QUIC         bool
+	Announcement protocol.Announcement
+}
+
+// Name returns what to call the sender by: the name it announced, else its
+// hostname, else the host of its address.
+func (p Peer) Name() string {
+	switch {
+	case p.Announcement.Name != "":
+		return p.Announcement.Name
+	case p.Announcement.Hostname != "":
+		return p.Announcement.Hostname
+	default:
+		return p.host()
+	}
+}
+
+// String describes the sender by name and host, e.g.
+// "alice-laptop (192.168.1.50)".
+func (p Peer) String() string {
+	if name := p.Name(); name != p.host() {
+		return name + " (" + p.host() + ")"
+	}
+	return p.host()
+}
+
+// key identifies the sender run behind p: its session, so a sender heard at
+// several addresses or through several backends is one peer, else its
+// address for senders that don't announce one.
+func (p Peer) key() string {
+	if p.Announcement.Session != "" {
+		return "session " + p.Announcement.Session
+	}
+	return p.Addr
+}
+
+// completedPeers holds the keys of the sender runs received from, which
+// aren't connected to again when they keep announcing. Senders without a
+// session aren't held, since a new run at the same address can't be told
+// apart from them.
+type completedPeers struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func (c *completedPeers) add(peer Peer) {
+	if peer.Announcement.Session == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.keys == nil {
+		c.keys = map[string]bool{}
+	}
+	c.keys[peer.key()] = true
+}
+
+func (c *completedPeers) has(peer Peer) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.keys[peer.key()]
+}
+
+func (p Peer) host() string {
+	host, _, err := net.SplitHostPort(p.Addr)
+	if err != nil {
+		return p.Addr
+	}
+	return host
+}
+
+// offerNames is how many file names an offer is described with at most
+const offerNames = 3
+
+// Offer describes the files the sender announced, e.g. "report.pdf, 14.0 MB"
+// or "a.txt, b.txt, c.txt and 2 more, 5 files, 14.0 MB", or returns "" if it
+// didn't announce them.
+func (p Peer) Offer() string {
+	a := p.Announcement
+	names := a.FileNames[:min(len(a.FileNames), offerNames)]
+	if len(names) == 0 && a.FileName != "" {
+		names = []string{a.FileName}
+	}
+	if a.FileCount == 0 {
+		return ""
+	}
+
+	size := progress.FormatSize(float64(a.TotalSize))
+	if a.FileCount == 1 && len(names) == 1 {
+		return names[0] + ", " + size
+	}
+	list := strings.Join(names, ", ")
+	if more := a.FileCount - len(names); more > 0 && len(names) > 0 {
+		list += fmt.Sprintf(" and %d more", more)
+	}
+	if list == "" {
+		return fmt.Sprintf("%d files, %s", a.FileCount, size)
+	}
+	return fmt.Sprintf("%s, %d files, %s", list, a.FileCount, size)
+}
+
+// logOffer logs who peer is and what it offers, before connecting to it.
+func (r *Receiver) logOffer(peer Peer) {
+	if offer := peer.Offer(); offer != "" {
+		r.logger.Info("offer from " + peer.String() + ": " + offer)
+		return
+	}
+	r.logger.Info("offer from " + peer.String())
+}
+
+// Discoverer is a discovery backend. The receiver runs all configured
+// backends at once and merges the senders they find.
+type Discoverer interface {
+	// Discover calls found for every sender it hears of until ctx is done
+	// or it fails. The same sender may be reported more than once.
+	Discover(ctx context.Context, found func(Peer)) error
+}
+
+// BroadcastDiscoverer listens for JSON announcements broadcast to Port, over
+// IPv4 and to protocol.IPv6AllNodes over IPv6. Network is "udp4" or "udp6"
+// to listen over one IP version only; empty or "udp" listens over both.
+// Datagrams that aren't announcements are logged to Logger at Debug level,
+// or to slog.Default() if it is nil.
+type BroadcastDiscoverer struct {
+	Port    uint
+	Network string
+	Logger  *slog.Logger
+}
+
+// MulticastDiscoverer joins Group, an IPv4 or IPv6 multicast group, and
+// listens for the same JSON announcements BroadcastDiscoverer gets, sent to
+// Port. Interface names the network interface to join the group on; empty
+// lets the system choose. Since it listens on the wildcard address it hears
+// broadcasts to Port of the group's IP version as well, so it replaces rather
+// than accompanies a BroadcastDiscoverer of that version on the same port.
+// Logger is used as in BroadcastDiscoverer.
+type MulticastDiscoverer struct {
+	Group     string
+	Port      uint
+	Interface string
+	Logger    *slog.Logger
+}
+
+// MDNSDiscoverer browses for senders advertising protocol.MDNSService over
+// multicast DNS. Services with unusable TXT records are logged to Logger at
+// Debug level, or to slog.Default() if it is nil.
+type MDNSDiscoverer struct {
+	Logger *slog.Logger
+}
+
+// mdnsQueryInterval is how often MDNSDiscoverer repeats its query
+const mdnsQueryInterval = time.Second
+
+// startDiscovery runs the discovery backends until ctx is done. The senders
+// they find are sent on the first channel. The second one receives a single
+// value once every backend has stopped: nil if they all stopped because ctx
+// is done, their joined errors otherwise. One backend failing, e.g. because
+// its port is taken, leaves the others running.
+func (r *Receiver) startDiscovery(ctx context.Context) (<-chan Peer, <-chan error) {
+	found := make(chan Peer)
+	stopped := make(chan error, 1)
+	if len(r.discoverers) == 0 {
+		stopped <- errors.New("no discovery backend configured")
+		return found, stopped
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	// rejected senders are logged once, not for every announcement
+	rejected := map[string]bool{}
+	firstRejection := func(addr string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		first := !rejected[addr]
+		rejected[addr] = true
+		return first
+	}
+	fresh := newFreshness()
+	for _, d := range r.discoverers {
+		d = r.onInterface(d)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := d.Discover(ctx, func(p Peer) {
+				if ok, rule := r.peerFilter.check(p.Addr); !ok {
+					if firstRejection(p.Addr) {
+						r.logger.Info("ignoring rejected peer", "peer", p.Addr, "rule", rule)
+					}
+					return
+				}
+				if !r.reachedThrough(p.Addr) {
+					if firstRejection(p.Addr) {
+						r.logger.Info("ignoring peer heard through another interface", "peer", p.Addr, "interface", r.ifaceName)
+					}
+					return
+				}
+				now := time.Now()
+				if r.sharedKey != "" {
+					if err := p.Announcement.Verify(r.sharedKey, now, r.clockTolerance); err != nil {
+						r.logger.Debug("ignoring announcement", "peer", p.Addr, "err", err)
+						return
+					}
+				}
+				if r.maxAnnounceAge > 0 {
+					if err := fresh.check(p, now, r.maxAnnounceAge, r.clockTolerance); err != nil {
+						r.logger.Debug("ignoring stale announcement", "peer", p.Addr, "err", err)
+						return
+					}
+				}
+				r.metrics.discovered.Inc()
+				select {
+				case found <- p:
+				case <-ctx.Done():
+				}
+			})
+			if err != nil {
+				r.logger.Warn("discovery backend stopped", "err", err)
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		stopped <- errors.Join(errs...)
+	}()
+
+	return found, stopped
+}
+
+// freshness tells stale announcements from fresh ones by the time they
+// were sent, learning how far each sender's clock is from ours from the
+// freshest announcement heard from its address.
+type freshness struct {
+	mu sync.Mutex
+	// lag holds the smallest lag from a sender's stamp to our clock seen
+	// per address: how far its clock is behind ours, plus the delivery
+	lag map[string]time.Duration
+}
+
+func newFreshness() *freshness {
+	return &freshness{lag: map[string]time.Duration{}}
+}
+
+// check rejects the announcement of peer, heard at now, if it was sent
+// more than maxAge before the freshest one of its address, or further from
+// now than tolerance.
+func (f *freshness) check(peer Peer, now time.Time, maxAge, tolerance time.Duration) error {
+	if peer.Announcement.Timestamp == 0 {
+		return nil
+	}
+	lag := now.Sub(time.Unix(peer.Announcement.Timestamp, 0))
+	if lag > tolerance || lag < -tolerance {
+		return fmt.Errorf("sent %s away from now, the tolerance is %s", lag.Round(time.Second), tolerance)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	freshest, ok := f.lag[peer.Addr]
+	if !ok || lag < freshest {
+		f.lag[peer.Addr] = lag
+		return nil
+	}
+	// stamps are in whole seconds
+	if behind := lag - freshest; behind > maxAge+time.Second {
+		return fmt.Errorf("sent %s before the freshest announcement of %s", behind.Round(time.Second), peer.Addr)
+	}
+
+	return nil
+}
+
+// discover runs the discovery backends. It returns as soon as the first
+// sender is found unless a discovery window is configured, in which case
+// every distinct sender heard within the window after the first is returned.
+// Senders are told apart by their session, and those already received from
+// are passed over.
+func (r *Receiver) discover(ctx context.Context) ([]Peer, error) {
+	backendCtx, stopBackends := context.WithCancel(ctx)
+	defer stopBackends()
+	found, stopped := r.startDiscovery(backendCtx)
+
+	var timeout <-chan time.Time
+	if r.discoveryTimeout > 0 {
+		timer := time.NewTimer(r.discoveryTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var window <-chan time.Time
+
+	var peers []Peer
+	// senders already received from are logged once, not for every
+	// announcement
+	passed := map[string]bool{}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("discovery stopped: %w", ctx.Err())
+		case <-timeout:
+			return nil, fmt.Errorf("%w within %s", ErrDiscoveryTimeout, r.discoveryTimeout)
+		case <-window:
+			// the collection window is over
+			return peers, nil
+		case err := <-stopped:
+			if len(peers) > 0 {
+				return peers, nil
+			}
+			return nil, err
+		case discovered := <-found:
+			key := discovered.key()
+			if slices.ContainsFunc(peers, func(p Peer) bool { return p.key() == key }) {
+				continue
+			}
+			if r.completed.has(discovered) {
+				if !passed[key] {
+					passed[key] = true
+					r.logger.Debug("ignoring sender already received from", "peer", discovered.Addr, "session", discovered.Announcement.Session)
+				}
+				continue
+			}
+			peers = append(peers, discovered)
+			r.logOffer(discovered)
+			events.Emit(&r.eventQueue, ctx, r.events.PeerDiscovered, discovered)
+
+			if r.discoveryWindow <= 0 || (r.maxPeers > 0 && len(peers) >= r.maxPeers) {
+				return peers, nil
+			}
+
+			if len(peers) == 1 {
+				r.logger.Info("collecting senders", "window", r.discoveryWindow)
+				timeout = nil
+				timer := time.NewTimer(r.discoveryWindow)
+				defer timer.Stop()
+				window = timer.C
+			}
+		}
+	}
+}
+
+// findPeers returns the fixed peer, or else the senders discovery found that
+// the WithSelectPeers func picked.
+func (r *Receiver) findPeers(ctx context.Context) ([]Peer, error) {
+	if r.directPeer != nil {
+		return []Peer{*r.directPeer}, nil
+	}
+
+	peers, err := r.discover(ctx)
+	if err != nil || r.selectPeers == nil {
+		return peers, err
+	}
+	selected := r.selectPeers(peers)
+	if len(selected) == 0 {
+		r.logger.Info("no sender selected", "found", len(peers))
+	}
+
+	return selected, nil
+}
+
+// Discover implements Discoverer.
+func (d BroadcastDiscoverer) Discover(ctx context.Context, found func(Peer)) error {
+	/*
+		The net.UDPAddr structure requires an IP address as part of its
+		configuration to specify where the UDP listener should bind. Here’s a
+		more detailed explanation of why the IP address is needed and its
+		purpose in this context:
+
+		Purpose of the IP Address in net.UDPAddr
+		1.	Binding to a Specific Network Interface:
+		•	The IP address in net.UDPAddr allows you to bind the UDP listener
+		to a specific network interface on the machine.
+		•	For example, if a machine has multiple network interfaces
+		(e.g., Ethernet, Wi-Fi), you might want to bind to one specific interface.
+		2.	Listening on All Interfaces:
+		•	Leaving the IP address unset, like "0.0.0.0" or "::", specifies
+		that the listener should bind to all available network interfaces.
+		•	This means the UDP listener will receive packets sent to any of
+		the machine’s IP addresses, whether they come through Ethernet, Wi-Fi,
+		or any other interface.
+		•	On the "udp" network the listener is dual-stack: it binds "::"
+		and receives IPv4 packets as well, falling back to "0.0.0.0" on
+		machines without IPv6.
+	*/
+	network := d.Network
+	if network == "" {
+		network = "udp"
+	}
+	addr := net.UDPAddr{Port: int(d.Port)}
+	con, err := net.ListenUDP(network, &addr)
+	if err != nil {
+		return fmt.Errorf("err starting up udp listener: %w", err)
+	}
+	defer con.Close()
+
+	stopClosing := context.AfterFunc(ctx, func() { con.Close() })
+	defer stopClosing()
+
+	return readAnnouncements(ctx, con, loggerOrDefault(d.Logger), found)
+}
+
+// Discover implements Discoverer.
+func (d MulticastDiscoverer) Discover(ctx context.Context, found func(Peer)) error {
+	group, err := netip.ParseAddr(d.Group)
+	if err != nil || !group.IsMulticast() {
+		return fmt.Errorf("invalid multicast group: %q", d.Group)
+	}
+	network := "udp4"
+	if group.Is6() {
+		network = "udp6"
+	}
+
+	var ifi *net.Interface
+	if d.Interface != "" {
+		if ifi, err = net.InterfaceByName(d.Interface); err != nil {
+			return fmt.Errorf("err looking up interface: %w", err)
+		}
+	}
+
+	con, err := net.ListenMulticastUDP(network, ifi, net.UDPAddrFromAddrPort(netip.AddrPortFrom(group, uint16(d.Port))))
+	if err != nil {
+		return fmt.Errorf("err joining multicast group: %w", err)
+	}
+	defer con.Close()
+
+	stopClosing := context.AfterFunc(ctx, func() { con.Close() })
+	defer stopClosing()
+
+	return readAnnouncements(ctx, con, loggerOrDefault(d.Logger), found)
+}
+
+// loggerOrDefault returns logger, or slog.Default() if it is nil.
+func loggerOrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// readAnnouncements reports the sender behind every valid announcement
+// arriving on con until ctx is done.
+func readAnnouncements(ctx context.Context, con *net.UDPConn, logger *slog.Logger, found func(Peer)) error {
+	buffer := make([]byte, protocol.MaxAnnouncementSize)
+	for {
+		byteSize, senderAddr, err := con.ReadFromUDP(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("err reading from udp: %w", err)
+		}
+
+		discovered, err := parseDiscoveryMsg(buffer[:byteSize], senderAddr)
+		if err != nil {
+			logger.Debug("ignoring datagram", "from", senderAddr, "err", err)
+			continue
+		}
+		found(discovered)
+	}
+}
+
+// parseDiscoveryMsg turns an announcement into the peer it describes.
+func parseDiscoveryMsg(msg []byte, senderAddr *net.UDPAddr) (Peer, error) {
+	announcement, err := protocol.ParseAnnouncement(msg)
+	if err != nil {
+		return Peer{}, err
+	}
+
+	// The broadcast leaves the sender through whichever interface routes to
+	// our segment, so its source IP is the address the sender is reachable
+	// on from here, regardless of how many interfaces it has. Over IPv6 it is
+	// usually link-local, which only reaches the sender through the
+	// interface it arrived on, kept as its zone.
+	return Peer{
+		Addr:         peerAddr(senderAddr.AddrPort().Addr(), announcement.Port),
+		TLS:          announcement.TLS,
+		QUIC:         announcement.Transport == protocol.TransportQUIC,
+		Announcement: announcement,
+	}, nil
+}
+
+// peerAddr returns the host:port of the sender announcing port from ip, with
+// IPv4 addresses heard on an IPv6 socket spelled as IPv4 ones.
+func peerAddr(ip netip.Addr, port uint16) string {
+	return netip.AddrPortFrom(ip.Unmap(), port).String()
+}
+
+// Discover implements Discoverer.
+func (d MDNSDiscoverer) Discover(ctx context.Context, found func(Peer)) error {
+	logger := loggerOrDefault(d.Logger)
+	return mdns.Browse(ctx, protocol.MDNSService, mdnsQueryInterval, func(entry mdns.Entry) {
+		announcement, err := protocol.ParseTXT(entry.TXT)
+		if err != nil {
+			logger.Debug("ignoring mdns service", "instance", entry.Instance, "err", err)
+			return
+		}
+
+		// the port is the TXT record's, which the signature covers, rather
+		// than the SRV record's, which anyone could answer with, so a
+		// service whose two disagree is dropped; as with broadcasts the
+		// answer's source IP is how the sender is reached
+		if entry.Port != announcement.Port {
+			logger.Debug("ignoring mdns service", "instance", entry.Instance, "srv_port", entry.Port, "port", announcement.Port)
+			return
+		}
+		source, ok := netip.AddrFromSlice(entry.Addr)
+		if !ok {
+			logger.Debug("ignoring mdns service", "instance", entry.Instance, "addr", entry.Addr)
+			return
+		}
+		found(Peer{
+			Addr:         peerAddr(source, announcement.Port),
+			TLS:          announcement.TLS,
+			QUIC:         announcement.Transport == protocol.TransportQUIC,
+			Announcement: announcement,
+		})
+	})
+}
@@ -0,0 +1,684 @@
+package receiver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/pjmessi/go_file_share/retry"
+)
+
+// announce returns the wire encoding of an announcement of port.
+func announce(t *testing.T, port uint16) []byte {
+	t.Helper()
+
+	payload, err := protocol.NewAnnouncement(port).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestParseDiscoveryMsgUsesSourceAddress(t *testing.T) {
+	tests := []struct {
+		name   string
+		source netip.AddrPort
+		want   string
+	}{
+		{name: "ipv4", source: netip.MustParseAddrPort("192.168.1.50:54321"), want: "192.168.1.50:9000"},
+		{name: "ipv4 on an ipv6 socket", source: netip.MustParseAddrPort("[::ffff:10.0.0.7]:54321"), want: "10.0.0.7:9000"},
+		{name: "ipv6", source: netip.MustParseAddrPort("[2001:db8::1]:54321"), want: "[2001:db8::1]:9000"},
+		{name: "link-local ipv6", source: netip.MustParseAddrPort("[fe80::1%eth0]:54321"), want: "[fe80::1%eth0]:9000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peer, err := parseDiscoveryMsg(announce(t, 9000), net.UDPAddrFromAddrPort(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if peer.Addr != tt.want {
+				t.Errorf("Addr = %s, want %s", peer.Addr, tt.want)
+			}
+		})
+	}
+}
+
+// freeUDPPort returns a UDP port that was free a moment ago.
+func freeUDPPort(t *testing.T) uint {
+	t.Helper()
+
+	con, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+	return uint(con.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// discoverOne runs d until it reports a peer, sending payload to to until
+// then, and returns the peer.
+func discoverOne(t *testing.T, d Discoverer, to netip.AddrPort, payload []byte) Peer {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	peers := make(chan Peer, 1)
+	go d.Discover(ctx, func(p Peer) {
+		select {
+		case peers <- p:
+		default:
+		}
+	})
+
+	con, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+	// the discoverer may not listen yet when the first datagrams go out
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		con.Write(payload)
+		select {
+		case p := <-peers:
+			return p
+		case <-ctx.Done():
+			t.Fatal("no peer discovered")
+		case <-ticker.C:
+		}
+	}
+}
+
+func TestBroadcastDiscovererReportsSender(t *testing.T) {
+	port := freeUDPPort(t)
+	d := BroadcastDiscoverer{Port: port, Network: "udp4", Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	peer := discoverOne(t, d, netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(port)), announce(t, 9123))
+	if peer.Addr != "127.0.0.1:9123" {
+		t.Errorf("Addr = %s, want the datagram's source with the announced port", peer.Addr)
+	}
+}
+
+func TestDiscoveryTimeout(t *testing.T) {
+	r, _ := newTestReceiver(t,
+		WithDiscoverers(fakeDiscoverer{}),
+		WithDiscoveryTimeout(50*time.Millisecond),
+	)
+
+	start := time.Now()
+	_, err := receiveDiscovered(t, r)
+	if !errors.Is(err, ErrDiscoveryTimeout) {
+		t.Fatalf("Receive = %v, want ErrDiscoveryTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Receive gave up after %s, the timeout is 50ms", elapsed)
+	}
+}
+
+func TestDiscoveryStopsWithContext(t *testing.T) {
+	r, _ := newTestReceiver(t, WithDiscoverers(fakeDiscoverer{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := r.Receive(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Receive = %v, want it to wrap the context's error", err)
+	}
+	if errors.Is(err, ErrDiscoveryTimeout) {
+		t.Errorf("Receive = %v, a cancelled discovery didn't time out", err)
+	}
+}
+
+func TestDiscoveryTimeoutValidation(t *testing.T) {
+	if _, err := New(WithDiscoveryTimeout(-time.Second)); err == nil {
+		t.Error("New accepted a negative discovery timeout")
+	}
+}
+
+// twoSenders serves a.txt and b.txt from two pipe senders, returns them
+// along with the peers they are discovered as.
+func twoSenders(t *testing.T) (*pipeSenders, []Peer) {
+	t.Helper()
+
+	src := t.TempDir()
+	senders := newPipeSenders()
+	peers := []Peer{
+		senders.add("192.0.2.1:9000", newTestSender(t), writeTestFile(t, src, "a.txt", []byte("from a"))),
+		senders.add("192.0.2.2:9000", newTestSender(t), writeTestFile(t, src, "b.txt", []byte("from b"))),
+	}
+	return senders, peers
+}
+
+func TestReceiveFromSeveralSenders(t *testing.T) {
+	senders, peers := twoSenders(t)
+	r, dest := newTestReceiver(t,
+		WithDiscoverers(fakeDiscoverer{peers: peers}),
+		WithDialer(senders.dial),
+		WithDiscoveryWindow(100*time.Millisecond),
+	)
+
+	stats, err := receiveDiscovered(t, r)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got stats of %d files, want one from each sender", len(stats))
+	}
+	assertFile(t, filepath.Join(dest, "a.txt"), []byte("from a"))
+	assertFile(t, filepath.Join(dest, "b.txt"), []byte("from b"))
+}
+
+func TestReceiveFromMaxPeers(t *testing.T) {
+	senders, peers := twoSenders(t)
+	r, dest := newTestReceiver(t,
+		WithDiscoverers(fakeDiscoverer{peers: peers}),
+		WithDialer(senders.dial),
+		WithDiscoveryWindow(time.Minute),
+		WithMaxPeers(1),
+	)
+
+	// the window is cut short by reaching the limit
+	if _, err := receiveDiscovered(t, r); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if senders.dialed(peers[0].Addr)+senders.dialed(peers[1].Addr) != 1 {
+		t.Errorf("dialed %d and %d times, want a single sender", senders.dialed(peers[0].Addr), senders.dialed(peers[1].Addr))
+	}
+	assertOnlyFile(t, dest, "a.txt")
+}
+
+func TestSelectPeers(t *testing.T) {
+	tests := []struct {
+		name string
+		// pick returns the peers to receive from out of those found
+		pick       func(peers []Peer) []Peer
+		wantFiles  []string
+		wantDialed []bool
+	}{
+		{"all", func(peers []Peer) []Peer { return peers }, []string{"a.txt", "b.txt"}, []bool{true, true}},
+		{"subset", func(peers []Peer) []Peer { return peers[1:] }, []string{"b.txt"}, []bool{false, true}},
+		{"none", func(peers []Peer) []Peer { return nil }, nil, []bool{false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			senders, peers := twoSenders(t)
+			var offered []string
+			r, dest := newTestReceiver(t,
+				WithDiscoverers(fakeDiscoverer{peers: peers}),
+				WithDialer(senders.dial),
+				WithDiscoveryWindow(100*time.Millisecond),
+				WithSelectPeers(func(found []Peer) []Peer {
+					for _, peer := range found {
+						offered = append(offered, peer.Addr)
+					}
+					return tt.pick(found)
+				}),
+			)
+
+			// picking none ends Receive without an error
+			stats, err := receiveDiscovered(t, r)
+			if err != nil {
+				t.Fatalf("Receive: %v", err)
+			}
+			if want := []string{peers[0].Addr, peers[1].Addr}; !slices.Equal(offered, want) {
+				t.Errorf("offered %q, want %q in the order found", offered, want)
+			}
+			var got []string
+			for _, s := range stats {
+				got = append(got, s.Name)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.wantFiles) {
+				t.Errorf("received %q, want %q", got, tt.wantFiles)
+			}
+			// the senders left out are never connected to
+			for i, peer := range peers {
+				if dialed := senders.dialed(peer.Addr); (dialed > 0) != tt.wantDialed[i] {
+					t.Errorf("%s dialed %d times, want it dialed: %t", peer.Addr, dialed, tt.wantDialed[i])
+				}
+			}
+			if len(tt.wantFiles) == 0 {
+				assertNoFiles(t, dest)
+			}
+		})
+	}
+}
+
+func TestFailingSenderDoesNotStopOthers(t *testing.T) {
+	senders, peers := twoSenders(t)
+	// nothing serves the first one
+	peers = append([]Peer{{Addr: "192.0.2.99:9000"}}, peers...)
+	r, dest := newTestReceiver(t,
+		WithDiscoverers(fakeDiscoverer{peers: peers}),
+		WithDialer(senders.dial),
+		WithDialRetry(retry.Policy{}),
+		WithDiscoveryWindow(100*time.Millisecond),
+	)
+
+	stats, err := receiveDiscovered(t, r)
+	var peerErr *PeerError
+	if !errors.As(err, &peerErr) || peerErr.Addr != "192.0.2.99:9000" {
+		t.Fatalf("Receive = %v, want a PeerError of the missing sender", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got stats of %d files, want one from each working sender", len(stats))
+	}
+	assertFile(t, filepath.Join(dest, "b.txt"), []byte("from b"))
+}
+
+// lateSender serves path on a localhost port from after delay on, connections
+// until then being refused. It returns the sender's address.
+func lateSender(t *testing.T, delay time.Duration, path string) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	s := newTestSender(t)
+	done := make(chan struct{})
+	t.Cleanup(func() { <-done })
+	go func() {
+		defer close(done)
+		time.Sleep(delay)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Errorf("port %s taken in the meantime: %v", addr, err)
+			return
+		}
+		defer l.Close()
+		l.(*net.TCPListener).SetDeadline(time.Now().Add(testTimeout))
+		con, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := s.ServeConn(con, []string{path}); err != nil {
+			t.Errorf("ServeConn: %v", err)
+		}
+	}()
+
+	return addr
+}
+
+func TestDialRetriesLateListener(t *testing.T) {
+	content := testContent(1000)
+	addr := lateSender(t, 300*time.Millisecond, writeTestFile(t, t.TempDir(), "late.txt", content))
+	r, dest := newTestReceiver(t,
+		WithDiscoverers(fakeDiscoverer{peers: []Peer{{Addr: addr, Announcement: protocol.NewAnnouncement(0)}}}),
+		WithDialRetry(retry.Policy{MaxAttempts: 20, InitialDelay: 50 * time.Millisecond, MaxDelay: 100 * time.Millisecond}),
+	)
+
+	if _, err := receiveDiscovered(t, r); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	assertFile(t, filepath.Join(dest, "late.txt"), content)
+}
+
+func TestDialRetryGivesUp(t *testing.T) {
+	dials := 0
+	refuse := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials++
+		return nil, errors.New("connection refused")
+	}
+	r, _ := newTestReceiver(t,
+		WithDiscoverers(fakeDiscoverer{peers: []Peer{{Addr: "192.0.2.1:9000"}}}),
+		WithDialer(refuse),
+		WithDialRetry(retry.Policy{MaxAttempts: 3, InitialDelay: time.Millisecond}),
+	)
+
+	_, err := receiveDiscovered(t, r)
+	var peerErr *PeerError
+	if !errors.As(err, &peerErr) || !strings.Contains(err.Error(), "3 attempts") {
+		t.Fatalf("Receive = %v, want a PeerError after 3 attempts", err)
+	}
+	if dials != 3 {
+		t.Errorf("dialed %d times, want 3", dials)
+	}
+}
+
+// onceDiscoverer reports its peers, then stops.
+type onceDiscoverer struct {
+	peers []Peer
+}
+
+func (d onceDiscoverer) Discover(ctx context.Context, found func(Peer)) error {
+	for _, p := range d.peers {
+		found(p)
+	}
+	return nil
+}
+
+// heard returns the peers discovery passes on when the announcements of
+// peers are heard, in order.
+func heard(t *testing.T, r *Receiver, peers ...Peer) []Peer {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	r.discoverers = []Discoverer{onceDiscoverer{peers}}
+	found, stopped := r.startDiscovery(ctx)
+	var passed []Peer
+	for {
+		select {
+		case p := <-found:
+			passed = append(passed, p)
+		case err := <-stopped:
+			if err != nil {
+				t.Fatalf("discovery: %v", err)
+			}
+			return passed
+		case <-ctx.Done():
+			t.Fatal("discovery didn't stop within the test timeout")
+		}
+	}
+}
+
+// signedPeer returns a peer at addr announcing port, signed with key at
+// sentAt, as decoded from the wire.
+func signedPeer(t *testing.T, addr string, port uint16, key string, sentAt time.Time) Peer {
+	t.Helper()
+
+	a := protocol.NewAnnouncement(port)
+	a.Timestamp = sentAt.Unix()
+	if key != "" {
+		// signed here rather than by Marshal, which stamps the current time
+		content, err := json.Marshal(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(content)
+		a.Signature = hex.EncodeToString(mac.Sum(nil))
+	}
+	payload, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := protocol.ParseAnnouncement(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Peer{Addr: addr, Announcement: decoded}
+}
+
+func TestSignedAnnouncements(t *testing.T) {
+	const key = "shared"
+	now := time.Now()
+	tampered := signedPeer(t, "192.0.2.2:9000", 9000, key, now)
+	tampered.Announcement.Port = 6666
+
+	tests := []struct {
+		name string
+		peer Peer
+		// taken is whether a receiver with the key takes the announcement,
+		// one without takes them all
+		taken bool
+	}{
+		{"valid", signedPeer(t, "192.0.2.1:9000", 9000, key, now), true},
+		{"tampered", tampered, false},
+		{"replayed", signedPeer(t, "192.0.2.3:9000", 9000, key, now.Add(-3*time.Minute)), false},
+		{"unsigned", signedPeer(t, "192.0.2.4:9000", 9000, "", now), false},
+		{"other key", signedPeer(t, "192.0.2.5:9000", 9000, "other", now), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTestReceiver(t, WithSharedKey(key))
+			if got := heard(t, r, tt.peer); (len(got) == 1) != tt.taken {
+				t.Errorf("receiver with the key passed on %d announcements, want it taken: %t", len(got), tt.taken)
+			}
+
+			r, _ = newTestReceiver(t)
+			if tt.name == "replayed" {
+				// stale whether signed or not
+				return
+			}
+			if got := heard(t, r, tt.peer); len(got) != 1 {
+				t.Errorf("receiver without a key passed on %d announcements, want it taken", len(got))
+			}
+		})
+	}
+}
+
+func TestClockTolerance(t *testing.T) {
+	const key = "shared"
+	now := time.Now()
+	r, _ := newTestReceiver(t, WithSharedKey(key), WithClockTolerance(10*time.Minute))
+	got := heard(t, r,
+		signedPeer(t, "192.0.2.1:9000", 9000, key, now.Add(-5*time.Minute)),
+		signedPeer(t, "192.0.2.2:9000", 9000, key, now.Add(5*time.Minute)),
+		signedPeer(t, "192.0.2.3:9000", 9000, key, now.Add(-15*time.Minute)),
+	)
+	if len(got) != 2 || got[0].Addr != "192.0.2.1:9000" || got[1].Addr != "192.0.2.2:9000" {
+		t.Errorf("passed on %v, want the two announcements within the tolerance", got)
+	}
+}
+
+func TestFreshness(t *testing.T) {
+	const maxAge, tolerance = 10 * time.Second, 2 * time.Minute
+	now := time.Unix(1_800_000_000, 0)
+	// stamped returns the announcement of addr sent at sentAt
+	stamped := func(addr string, sentAt time.Time) Peer {
+		a := protocol.NewAnnouncement(9000)
+		if !sentAt.IsZero() {
+			a.Timestamp = sentAt.Unix()
+		}
+		return Peer{Addr: addr, Announcement: a}
+	}
+
+	steps := []struct {
+		name string
+		peer Peer
+		ok   bool
+	}{
+		{"unstamped", stamped("192.0.2.1:9000", time.Time{}), true},
+		{"long gone", stamped("192.0.2.1:9000", now.Add(-tolerance-time.Second)), false},
+		{"far ahead", stamped("192.0.2.1:9000", now.Add(tolerance+time.Second)), false},
+		// a sender whose clock is a minute behind ours
+		{"first", stamped("192.0.2.1:9000", now.Add(-time.Minute)), true},
+		{"slightly older", stamped("192.0.2.1:9000", now.Add(-time.Minute-maxAge)), true},
+		{"held back", stamped("192.0.2.1:9000", now.Add(-time.Minute-maxAge-2*time.Second)), false},
+		{"fresher", stamped("192.0.2.1:9000", now.Add(-30*time.Second)), true},
+		{"old next to fresher", stamped("192.0.2.1:9000", now.Add(-time.Minute)), false},
+		// another sender is measured against its own announcements
+		{"other sender", stamped("192.0.2.2:9000", now.Add(-time.Minute)), true},
+	}
+	f := newFreshness()
+	for _, step := range steps {
+		if err := f.check(step.peer, now, maxAge, tolerance); (err == nil) != step.ok {
+			t.Errorf("%s: check = %v, want the announcement taken: %t", step.name, err, step.ok)
+		}
+	}
+}
+
+func TestStaleAnnouncementsAreIgnored(t *testing.T) {
+	now := time.Now()
+	peers := []Peer{
+		signedPeer(t, "192.0.2.1:9000", 9000, "", now),
+		// delayed by a switch, and replayed long after the sender exited
+		signedPeer(t, "192.0.2.1:9000", 9000, "", now.Add(-30*time.Second)),
+		signedPeer(t, "192.0.2.2:9000", 9000, "", now.Add(-time.Hour)),
+	}
+
+	r, _ := newTestReceiver(t)
+	if got := heard(t, r, peers...); len(got) != 1 || got[0].Announcement.Timestamp != now.Unix() {
+		t.Errorf("passed on %v, want the fresh announcement only", got)
+	}
+	r, _ = newTestReceiver(t, WithAnnouncementMaxAge(time.Minute))
+	if got := heard(t, r, peers...); len(got) != 2 {
+		t.Errorf("passed on %d announcements, want those within a minute", len(got))
+	}
+	r, _ = newTestReceiver(t, WithAnnouncementMaxAge(0))
+	if got := heard(t, r, peers...); len(got) != 3 {
+		t.Errorf("passed on %d announcements, want all of them", len(got))
+	}
+}
+
+func TestRepeatedAnnouncementsTransferOnce(t *testing.T) {
+	senders := newPipeSenders()
+	path := writeTestFile(t, t.TempDir(), "once.txt", []byte("sent once"))
+	peer := senders.add("192.0.2.1:9000", newTestSender(t), path)
+	r, dest := newTestReceiver(t,
+		WithDiscoverers(fakeDiscoverer{peers: []Peer{peer, peer, peer, peer, peer}}),
+		WithDialer(senders.dial),
+		WithDiscoveryWindow(100*time.Millisecond),
+	)
+
+	stats, err := receiveDiscovered(t, r)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if n := senders.dialed(peer.Addr); n != 1 || len(stats) != 1 {
+		t.Errorf("dialed %d times for %d files, want a single transfer", n, len(stats))
+	}
+	assertOnlyFile(t, dest, "once.txt")
+}
+
+func TestSessionsTellSendersApart(t *testing.T) {
+	// a sender that restarted at the same address is a new one, while
+	// another address announcing the same session is the same sender
+	peers := []Peer{
+		{Addr: "192.0.2.1:9000", Announcement: protocol.Announcement{Session: "first run"}},
+		{Addr: "192.0.2.1:9000", Announcement: protocol.Announcement{Session: "second run"}},
+		{Addr: "[2001:db8::1]:9000", Announcement: protocol.Announcement{Session: "first run"}},
+		{Addr: "192.0.2.2:9000"},
+		{Addr: "192.0.2.2:9000"},
+	}
+	r, _ := newTestReceiver(t,
+		WithDiscoverers(onceDiscoverer{peers}),
+		WithDiscoveryWindow(time.Minute),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	got, err := r.discover(ctx)
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	var keys []string
+	for _, p := range got {
+		keys = append(keys, p.key())
+	}
+	if want := []string{"session first run", "session second run", "192.0.2.2:9000"}; !slices.Equal(keys, want) {
+		t.Errorf("discovered %q, want %q", keys, want)
+	}
+}
+
+func TestSenderReceivedFromIsPassedOver(t *testing.T) {
+	senders := newPipeSenders()
+	path := writeTestFile(t, t.TempDir(), "once.txt", []byte("sent once"))
+	peer := senders.add("192.0.2.1:9000", newTestSender(t), path)
+	r, _ := newTestReceiver(t,
+		WithDiscoverers(fakeDiscoverer{peers: []Peer{peer}}),
+		WithDialer(senders.dial),
+		WithDiscoveryTimeout(200*time.Millisecond),
+	)
+
+	if _, err := receiveDiscovered(t, r); err != nil {
+		t.Fatalf("first Receive: %v", err)
+	}
+	// the sender keeps announcing the same session
+	if _, err := receiveDiscovered(t, r); !errors.Is(err, ErrDiscoveryTimeout) {
+		t.Errorf("second Receive = %v, want ErrDiscoveryTimeout", err)
+	}
+	if n := senders.dialed(peer.Addr); n != 1 {
+		t.Errorf("dialed %d times, want the sender received from once", n)
+	}
+}
+
+// needIPv6 skips the test on machines without an IPv6 loopback address.
+func needIPv6(t *testing.T) {
+	t.Helper()
+
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("no ipv6 loopback: %v", err)
+	}
+	l.Close()
+}
+
+func TestPeerHost(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.0.2.1:9000":      "192.0.2.1",
+		"[2001:db8::1]:9000":  "2001:db8::1",
+		"[fe80::1%eth0]:9000": "fe80::1%eth0",
+		"sender.example:9000": "sender.example",
+	} {
+		if got := (Peer{Addr: addr}).host(); got != want {
+			t.Errorf("host of %s = %s, want %s", addr, got, want)
+		}
+	}
+}
+
+func TestBroadcastDiscovererOverIPv6(t *testing.T) {
+	needIPv6(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// the dual-stack default hears both versions
+	for _, network := range []string{"udp6", "udp"} {
+		t.Run(network, func(t *testing.T) {
+			port := freeUDPPort(t)
+			d := BroadcastDiscoverer{Port: port, Network: network, Logger: logger}
+			peer := discoverOne(t, d, netip.AddrPortFrom(netip.IPv6Loopback(), uint16(port)), announce(t, 9123))
+			if peer.Addr != "[::1]:9123" {
+				t.Errorf("Addr = %s, want the datagram's source with the announced port", peer.Addr)
+			}
+		})
+	}
+	port := freeUDPPort(t)
+	d := BroadcastDiscoverer{Port: port, Logger: logger}
+	peer := discoverOne(t, d, netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(port)), announce(t, 9123))
+	if peer.Addr != "127.0.0.1:9123" {
+		t.Errorf("Addr over ipv4 on a dual-stack socket = %s, want it unmapped", peer.Addr)
+	}
+}
+
+func TestReceiveOverIPv6(t *testing.T) {
+	needIPv6(t)
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	path := writeTestFile(t, t.TempDir(), "v6.txt", []byte("over ipv6"))
+	s := newTestSender(t)
+	served := make(chan error, 1)
+	go func() {
+		con, err := l.Accept()
+		if err != nil {
+			served <- err
+			return
+		}
+		defer con.Close()
+		served <- s.ServeConn(con, []string{path})
+	}()
+
+	// the peer address is dialed as it is, brackets and all
+	port := uint16(l.Addr().(*net.TCPAddr).Port)
+	peer := Peer{Addr: peerAddr(netip.IPv6Loopback(), port), Announcement: protocol.NewAnnouncement(port)}
+	r, dest := newTestReceiver(t, WithDiscoverers(fakeDiscoverer{peers: []Peer{peer}}))
+	if _, err := receiveDiscovered(t, r); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("ServeConn: %v", err)
+	}
+	assertFile(t, filepath.Join(dest, "v6.txt"), []byte("over ipv6"))
+}
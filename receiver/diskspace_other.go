@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package receiver
+
+import "errors"
+
+// diskFreeSpace can't tell the free space here, so the check is skipped.
+func diskFreeSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,98 @@
+package receiver
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+func TestDiskSpaceCheck(t *testing.T) {
+	const size = 10 << 20
+	tests := []struct {
+		name        string
+		free        uint64
+		freeErr     error
+		opts        []Option
+		wantRefused bool
+	}{
+		{name: "plenty", free: 1 << 40},
+		{name: "exactly enough", free: size + diskSpaceMargin},
+		{name: "short of the margin", free: size + diskSpaceMargin - 1, wantRefused: true},
+		{name: "less than the file", free: size / 2, wantRefused: true},
+		{name: "check turned off", free: 0, opts: []Option{WithDiskSpaceCheck(false)}},
+		{name: "unsupported", freeErr: errors.ErrUnsupported},
+		{name: "probe failing", freeErr: errors.New("statfs: input/output error")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := testContent(size)
+			asked := false
+			opts := append([]Option{WithAcceptFunc(func(string, int64, string) bool {
+				asked = true
+				return true
+			})}, tt.opts...)
+			r, dest := newTestReceiver(t, opts...)
+			var probed string
+			r.freeSpace = func(dir string) (uint64, error) {
+				probed = dir
+				return tt.free, tt.freeErr
+			}
+
+			var rep protocol.Reply
+			res := rawTransfer(t, r, func(f *fakeSender) {
+				if !f.handshake() || !f.write(uint32(1)) {
+					return
+				}
+				if tt.wantRefused {
+					rep, _ = f.offerFile("big.bin", protocol.Header{Size: size, Mode: 0o644})
+					return
+				}
+				f.sendFile("big.bin", content)
+			})
+			if !tt.wantRefused {
+				if res.err != nil {
+					t.Fatalf("ReceiveConn: %v", res.err)
+				}
+				assertFile(t, filepath.Join(dest, "big.bin"), content)
+				return
+			}
+
+			if !errors.Is(res.err, ErrInsufficientSpace) {
+				t.Fatalf("ReceiveConn = %v, want ErrInsufficientSpace", res.err)
+			}
+			if rep.Status != protocol.ReplyTooLarge {
+				t.Errorf("reply = %d, want the sender told the file is too large", rep.Status)
+			}
+			if asked {
+				t.Error("the accept func was asked about a file that doesn't fit")
+			}
+			if probed != dest {
+				t.Errorf("free space probed in %q, want %q", probed, dest)
+			}
+			assertNoFiles(t, dest)
+		})
+	}
+}
+
+func TestDiskSpaceCountsHeldBytes(t *testing.T) {
+	const size = 1 << 20
+	content := testContent(size)
+	path := writeTestFile(t, t.TempDir(), "big.bin", content)
+	r, dest := newTestReceiver(t, WithResume(true))
+	writeTestFile(t, dest, "big.bin"+partSuffix, content[:size/2])
+	// room for the half the part file doesn't hold, and no more
+	r.freeSpace = func(string) (uint64, error) {
+		return size/2 + diskSpaceMargin, nil
+	}
+
+	res := pipeTransfer(t, r, newTestSender(t), path)
+	if res.err != nil {
+		t.Fatalf("ReceiveConn = %v, want the rest of the file to fit", res.err)
+	}
+	if len(res.stats) != 1 || res.stats[0].Bytes != size/2 {
+		t.Errorf("stats = %+v, want the second half received", res.stats)
+	}
+	assertFile(t, filepath.Join(dest, "big.bin"), content)
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package receiver
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// diskFreeSpace returns the bytes available to the current user on the
+// volume holding dir.
+func diskFreeSpace(dir string) (uint64, error) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var available uint64
+	ok, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&available)), 0, 0)
+	if ok == 0 {
+		return 0, err
+	}
+
+	return available, nil
+}
@@ -0,0 +1,69 @@
+package receiver
+
+import "errors"
+
+// ErrIncompleteTransfer is returned when the connection is closed before the
+// advertised number of content bytes has arrived.
+var ErrIncompleteTransfer = errors.New("connection closed before the whole file was received")
+
+// ErrDiscoveryTimeout is returned when no sender announced itself within the
+// configured discovery timeout.
+var ErrDiscoveryTimeout = errors.New("no sender found")
+
+// ErrFileExists is returned under PolicyError when the destination file is
+// already present.
+var ErrFileExists = errors.New("destination file already exists")
+
+// ErrFileTooLarge is returned when a file is larger than the limit set with
+// WithMaxFileSize, or a compressed stream holds more than the size its sender
+// announced. No partial file is kept.
+var ErrFileTooLarge = errors.New("file too large")
+
+// ErrInsufficientSpace is returned when the destination filesystem has no
+// room for a file, as told by its free space or by failing to reserve it.
+// The file is refused before its content is sent.
+var ErrInsufficientSpace = errors.New("not enough disk space")
+
+// ErrChecksumMismatch is returned when the SHA-256 digest announced by the
+// sender does not match the bytes that were received. The corrupt output file
+// is removed before the error is returned.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrIdleTimeout is returned when a sender stops sending, or reading what we
+// send, for longer than the timeout set with WithIdleTimeout.
+var ErrIdleTimeout = errors.New("connection idle for too long")
+
+// errDeclined ends a transfer whose file the AcceptFunc declined
+var errDeclined = errors.New("file declined")
+
+// ackedError is an error saving a file that the sender was told of with an
+// AckFailed, after which it decides whether to send the entry again.
+type ackedError struct {
+	error
+}
+
+func (e ackedError) Unwrap() error {
+	return e.error
+}
+
+// ErrProtocol is returned when the sender violates the protocol, e.g. with a
+// bad preamble, an unsupported version or a malformed frame. The error also
+// wraps the more specific protocol error where there is one, such as
+// protocol.ErrIncompatibleVersion or protocol.ErrInvalidFrame.
+var ErrProtocol = errors.New("protocol violation")
+
+// PeerError is a failed transfer from a single sender. Receive returns one per
+// failed sender, joined when it received from several.
+type PeerError struct {
+	// Addr is the sender's address.
+	Addr string
+	Err  error
+}
+
+func (e *PeerError) Error() string {
+	return e.Addr + ": " + e.Err.Error()
+}
+
+func (e *PeerError) Unwrap() error {
+	return e.Err
+}
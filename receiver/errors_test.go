@@ -0,0 +1,130 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/pjmessi/go_file_share/retry"
+	"github.com/pjmessi/go_file_share/sender"
+)
+
+func TestFailuresWrapSentinels(t *testing.T) {
+	content := testContent(1000)
+	tests := []struct {
+		name    string
+		opts    []Option
+		send    func(f *fakeSender)
+		wantErr error
+	}{
+		{
+			name: "bad preamble",
+			send: func(f *fakeSender) {
+				protocol.ReadPreamble(f.con)
+				f.write([]byte("HELO\x00\x00"))
+			},
+			wantErr: ErrProtocol,
+		},
+		{
+			name: "too large",
+			opts: []Option{WithMaxFileSize(999)},
+			send: func(f *fakeSender) {
+				if f.handshake() && f.write(uint32(1)) {
+					f.offerFile("big.bin", protocol.Header{Size: 1000, Mode: 0o644})
+				}
+			},
+			wantErr: ErrFileTooLarge,
+		},
+		{
+			name: "wrong checksum",
+			send: func(f *fakeSender) {
+				if f.handshake() && f.write(uint32(1)) {
+					f.sendFileWithChecksum("bad.bin", content, make([]byte, 32))
+				}
+			},
+			wantErr: ErrChecksumMismatch,
+		},
+		{
+			name: "cut short",
+			send: func(f *fakeSender) {
+				if !f.handshake() || !f.write(uint32(1)) {
+					return
+				}
+				if rep, ok := f.offerFile("short.bin", protocol.Header{Size: 1000, Mode: 0o644}); ok && rep.Status == protocol.ReplyAccept {
+					f.write(uint64(0), content[:500])
+				}
+			},
+			wantErr: ErrIncompleteTransfer,
+		},
+		{
+			name: "existing file",
+			opts: []Option{WithOverwritePolicy(PolicyError)},
+			send: func(f *fakeSender) {
+				if f.handshake() && f.write(uint32(1)) {
+					f.offerFile("taken.txt", protocol.Header{Size: 1000, Mode: 0o644})
+				}
+			},
+			wantErr: ErrFileExists,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, dest := newTestReceiver(t, tt.opts...)
+			writeTestFile(t, dest, "taken.txt", []byte("mine"))
+
+			res := rawTransfer(t, r, tt.send)
+			if !errors.Is(res.err, tt.wantErr) {
+				t.Fatalf("ReceiveConn = %v, want %v", res.err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReceiveConnKeepsCause(t *testing.T) {
+	r, _ := newTestReceiver(t)
+	senderEnd, receiverEnd := net.Pipe()
+	senderEnd.Close()
+
+	_, err := r.ReceiveConn(context.Background(), receiverEnd)
+	if !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("ReceiveConn = %v, want it to wrap io.ErrClosedPipe", err)
+	}
+}
+
+func TestPeerErrorOfFailedSender(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "big.bin", testContent(1000))
+	senders := newPipeSenders()
+	peer := senders.add("192.0.2.7:9000", newTestSender(t, sender.WithTransferRetry(retry.Policy{})), path)
+	r, _ := newTestReceiver(t,
+		WithDiscoverers(fakeDiscoverer{peers: []Peer{peer}}),
+		WithDialer(senders.dial),
+		WithMaxFileSize(10),
+	)
+
+	_, err := receiveDiscovered(t, r)
+	senders.wait(peer.Addr)
+	var peerErr *PeerError
+	if !errors.As(err, &peerErr) {
+		t.Fatalf("Receive = %v, want a PeerError", err)
+	}
+	if peerErr.Addr != peer.Addr || !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("PeerError = %v of %s, want ErrFileTooLarge of %s", peerErr.Err, peerErr.Addr, peer.Addr)
+	}
+}
+
+func TestPeerError(t *testing.T) {
+	err := error(&PeerError{Addr: "192.0.2.1:9000", Err: ErrChecksumMismatch})
+	if got, want := err.Error(), "192.0.2.1:9000: checksum mismatch"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	joined := errors.Join(&PeerError{Addr: "192.0.2.2:9000", Err: ErrIdleTimeout}, err)
+	if !errors.Is(joined, ErrChecksumMismatch) || !errors.Is(joined, ErrIdleTimeout) {
+		t.Errorf("joined PeerErrors %v lost a cause", joined)
+	}
+	if errors.Is(joined, ErrProtocol) {
+		t.Errorf("joined PeerErrors %v matched an unrelated sentinel", joined)
+	}
+}
@@ -0,0 +1,79 @@
+package receiver
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/events"
+)
+
+// Events are callbacks for the milestones of a receiver, set with WithEvents.
+// Every field is optional. ctx is done once the Receive or ReceiveConn call
+// that raised the event returns or is cancelled, or the receiver is closed,
+// so work started from a callback ends along with it.
+//
+// The callbacks run one at a time, in the order of their events, on a
+// goroutine of their own. A slow callback never holds up a transfer, it only
+// delays the events after it, which may then arrive after Receive returned.
+// Progress is reported through WithProgress.
+type Events struct {
+	// PeerDiscovered is called for every distinct sender discovery finds,
+	// before it is connected to.
+	PeerDiscovered func(ctx context.Context, peer Peer)
+	// TransferStarted is called once a file was accepted, before its
+	// content flows.
+	TransferStarted func(ctx context.Context, file FileInfo)
+	// TransferCompleted is called for every file that was saved.
+	TransferCompleted func(ctx context.Context, stats TransferStats)
+	// Error is called with the error of every sender that couldn't be
+	// received from, a *PeerError, and with discovery failures. Errors
+	// caused by cancelling the receiver are not reported.
+	Error func(ctx context.Context, err error)
+}
+
+// FileInfo describes a file a sender offers.
+type FileInfo struct {
+	// Name is the file's name as sent by the sender.
+	Name string `json:"name"`
+	// Size is the size of the file.
+	Size uint64 `json:"size"`
+	// ModTime is the modification time the sender listed the file with,
+	// only set by ListOffers. It is left out of the JSON encoding when zero.
+	ModTime time.Time `json:"mod_time"`
+	// Peer is the address of the sender.
+	Peer string `json:"peer"`
+}
+
+// MarshalJSON implements json.Marshaler, leaving out a zero ModTime.
+func (f FileInfo) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Name    string     `json:"name"`
+		Size    uint64     `json:"size"`
+		ModTime *time.Time `json:"mod_time,omitempty"`
+		Peer    string     `json:"peer"`
+	}{Name: f.Name, Size: f.Size, Peer: f.Peer}
+	if !f.ModTime.IsZero() {
+		v.ModTime = &f.ModTime
+	}
+
+	return json.Marshal(v)
+}
+
+// WithEvents calls the callbacks of events at the milestones of every
+// transfer, see Events.
+func WithEvents(events Events) Option {
+	return func(r *Receiver) {
+		r.events = events
+	}
+}
+
+// reportError passes err to the Error callback unless ctx is done, in which
+// case err is merely the cancellation.
+func (r *Receiver) reportError(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	events.Emit(&r.eventQueue, ctx, r.events.Error, err)
+}
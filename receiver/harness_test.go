@@ -0,0 +1,459 @@
+package receiver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/pjmessi/go_file_share/sender"
+)
+
+// testTimeout bounds every transfer of the tests, so a deadlocked pipe
+// fails the test instead of hanging it
+const testTimeout = 30 * time.Second
+
+// newTestReceiver returns a receiver saving into a fresh temporary
+// directory, which it returns as well, under the names the sender sent, and
+// logging nothing.
+func newTestReceiver(t testing.TB, opts ...Option) (*Receiver, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	opts = append([]Option{
+		WithDestDir(dir),
+		WithPreserveFilename(true),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	}, opts...)
+	r, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	return r, dir
+}
+
+// newTestSender returns a sender configured by opts.
+func newTestSender(t testing.TB, opts ...sender.Option) *sender.Sender {
+	t.Helper()
+
+	s, err := sender.New(opts...)
+	if err != nil {
+		t.Fatalf("sender.New: %v", err)
+	}
+
+	return s
+}
+
+// writeTestFile creates the file name under dir, along with its parent
+// directories, holding content, and returns its path.
+func writeTestFile(t testing.TB, dir, name string, content []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// testContent returns size bytes of content that differs from one offset
+// to the next, so misplaced bytes show in the checksum.
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i*7 + i/251)
+	}
+	return content
+}
+
+// assertFile fails the test unless the file at path holds content.
+func assertFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading received file: %v", err)
+	}
+	if sha256.Sum256(got) != sha256.Sum256(content) {
+		t.Fatalf("%s holds %d bytes that differ from the %d sent", path, len(got), len(content))
+	}
+}
+
+// pipeResult is what both ends of a transfer over a net.Pipe returned.
+type pipeResult struct {
+	stats     []TransferStats
+	err       error
+	senderErr error
+}
+
+// pipeTransfer sends paths from s to r over a net.Pipe, through the
+// sender's ServeConn and the receiver's ReceiveConn, and returns once both
+// ends are done.
+func pipeTransfer(t testing.TB, r *Receiver, s *sender.Sender, paths ...string) pipeResult {
+	t.Helper()
+
+	return pipeTransferThrough(t, r, s, nil, paths...)
+}
+
+// pipeTransferThrough is pipeTransfer with the sender's end of the pipe
+// wrapped by wrap, if it is set, to tamper with what the sender sends.
+func pipeTransferThrough(t testing.TB, r *Receiver, s *sender.Sender, wrap func(net.Conn) net.Conn, paths ...string) pipeResult {
+	t.Helper()
+
+	senderEnd, receiverEnd := net.Pipe()
+	senderErr := make(chan error, 1)
+	go func() {
+		con := senderEnd
+		if wrap != nil {
+			con = wrap(senderEnd)
+		}
+		senderErr <- s.ServeConn(con, paths)
+	}()
+
+	return finishPipe(t, r, receiverEnd, senderEnd, senderErr)
+}
+
+// tcpTransfer is pipeTransfer over a localhost TCP connection, which
+// content sent over UDP or parallel connections needs.
+func tcpTransfer(t *testing.T, r *Receiver, s *sender.Sender, paths ...string) pipeResult {
+	t.Helper()
+
+	return tcpTransferOn(t, "127.0.0.1", r, s, paths...)
+}
+
+// tcpTransferOn is tcpTransfer over the loopback address host.
+func tcpTransferOn(t *testing.T, host string, r *Receiver, s *sender.Sender, paths ...string) pipeResult {
+	t.Helper()
+
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	senderEnd := make(chan net.Conn, 1)
+	senderErr := make(chan error, 1)
+	go func() {
+		con, err := l.Accept()
+		if err != nil {
+			close(senderEnd)
+			senderErr <- err
+			return
+		}
+		senderEnd <- con
+		senderErr <- s.ServeConn(con, paths)
+	}()
+	receiverEnd, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	con, ok := <-senderEnd
+	if !ok {
+		t.Fatalf("Accept: %v", <-senderErr)
+	}
+	defer con.Close()
+
+	// unlike a pipe's, the sender's end may still hold the final ack, so the
+	// receiver's end is the one closed to unblock the sender
+	return finishPipe(t, r, receiverEnd, receiverEnd, senderErr)
+}
+
+// truncatingConn closes the connection once limit bytes were written to
+// it, as a sender dying mid-transfer would.
+type truncatingConn struct {
+	net.Conn
+	limit int
+}
+
+func (c *truncatingConn) Write(p []byte) (int, error) {
+	if len(p) <= c.limit {
+		c.limit -= len(p)
+		return c.Conn.Write(p)
+	}
+	n, _ := c.Conn.Write(p[:c.limit])
+	c.limit = 0
+	c.Conn.Close()
+	return n, net.ErrClosed
+}
+
+// corruptingConn flips the bits of the byte at offset at of what is
+// written to the connection, as a faulty middle box would.
+type corruptingConn struct {
+	net.Conn
+	at      int
+	written int
+}
+
+func (c *corruptingConn) Write(p []byte) (int, error) {
+	if i := c.at - c.written; i >= 0 && i < len(p) {
+		p = append([]byte(nil), p...)
+		p[i] ^= 0xFF
+	}
+	n, err := c.Conn.Write(p)
+	c.written += n
+	return n, err
+}
+
+// fakeSender speaks the sender's side of the protocol by hand, for the
+// transfers a real sender can't be made to botch. Its methods fail the test
+// with Errorf, since they run off the test's goroutine.
+type fakeSender struct {
+	t   *testing.T
+	con net.Conn
+	// caps are the receiver's capabilities, known after handshake
+	caps uint32
+}
+
+// rawTransfer runs fn as the sender at the other end of a net.Pipe from r,
+// closing the sender's end once fn returns, and returns once both ends are
+// done.
+func rawTransfer(t *testing.T, r *Receiver, fn func(f *fakeSender)) pipeResult {
+	t.Helper()
+
+	senderEnd, receiverEnd := net.Pipe()
+	senderErr := make(chan error, 1)
+	go func() {
+		defer senderEnd.Close()
+		fn(&fakeSender{t: t, con: senderEnd})
+		senderErr <- nil
+	}()
+
+	return finishPipe(t, r, receiverEnd, senderEnd, senderErr)
+}
+
+// finishPipe runs r.ReceiveConn on receiverEnd, and waits for the sender
+// behind senderEnd to report to senderErr. A transfer outlasting
+// testTimeout fails the test.
+func finishPipe(t testing.TB, r *Receiver, receiverEnd, senderEnd net.Conn, senderErr <-chan error) pipeResult {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	var res pipeResult
+	res.stats, res.err = r.ReceiveConn(ctx, receiverEnd)
+	// a receiver that gave up early leaves the sender blocked on the pipe
+	senderEnd.Close()
+	select {
+	case res.senderErr = <-senderErr:
+	case <-ctx.Done():
+		t.Fatal("sender didn't finish within the test timeout")
+	}
+	if ctx.Err() != nil {
+		t.Fatal("transfer didn't finish within the test timeout")
+	}
+
+	return res
+}
+
+// handshake exchanges preambles with the receiver and reads its
+// capabilities, answering no authentication challenge.
+func (f *fakeSender) handshake() bool {
+	if _, err := protocol.ReadPreamble(f.con); err != nil {
+		f.t.Errorf("reading receiver preamble: %v", err)
+		return false
+	}
+	if err := protocol.WritePreamble(f.con); err != nil {
+		f.t.Errorf("writing sender preamble: %v", err)
+		return false
+	}
+	if err := binary.Read(f.con, binary.LittleEndian, &f.caps); err != nil {
+		f.t.Errorf("reading receiver capabilities: %v", err)
+		return false
+	}
+	return true
+}
+
+// write writes the values in order, as the protocol encodes them, and
+// reports whether the receiver took them all. A receiver that hung up is
+// not an error, since that is what some tests expect of it.
+func (f *fakeSender) write(values ...any) bool {
+	for _, v := range values {
+		var err error
+		if b, ok := v.([]byte); ok {
+			_, err = f.con.Write(b)
+		} else {
+			err = binary.Write(f.con, binary.LittleEndian, v)
+		}
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// offerFile sends the frame and header of the file name, of header.Size
+// bytes, and returns the receiver's reply.
+func (f *fakeSender) offerFile(name string, header protocol.Header) (protocol.Reply, bool) {
+	if err := protocol.WriteFrame(f.con, protocol.Frame{Type: protocol.EntryTypeFile, Name: name}); err != nil {
+		return protocol.Reply{}, false
+	}
+	if err := protocol.WriteHeader(f.con, header); err != nil {
+		return protocol.Reply{}, false
+	}
+	var rep protocol.Reply
+	if err := binary.Read(f.con, binary.LittleEndian, &rep); err != nil {
+		return protocol.Reply{}, false
+	}
+	return rep, true
+}
+
+// sendFile sends the file name holding content from the start, with the
+// checksum its content has, after the entry count has been sent, and
+// returns the receiver's ack.
+func (f *fakeSender) sendFile(name string, content []byte) (protocol.Ack, bool) {
+	checksum := sha256.Sum256(content)
+	return f.sendFileWithChecksum(name, content, checksum[:])
+}
+
+// sendFileWithChecksum is sendFile claiming checksum for content.
+func (f *fakeSender) sendFileWithChecksum(name string, content, checksum []byte) (protocol.Ack, bool) {
+	header := protocol.Header{Size: uint64(len(content)), Mode: 0o644, ModTime: time.Now().UnixNano()}
+	rep, ok := f.offerFile(name, header)
+	if !ok {
+		return protocol.Ack{}, false
+	}
+	if rep.Status != protocol.ReplyAccept {
+		f.t.Errorf("receiver replied %d to %s, want it accepted", rep.Status, name)
+		return protocol.Ack{}, false
+	}
+	if !f.write(uint64(0), content, checksum) {
+		return protocol.Ack{}, false
+	}
+	ack, err := protocol.ReadAck(f.con)
+	if err != nil {
+		return protocol.Ack{}, false
+	}
+	return ack, true
+}
+
+// pipeSenders stands in for senders on the network: dialing the address of
+// one of them through dial hands the sender's end of a new net.Pipe to its
+// ServeConn. Together with fakeDiscoverer it runs Receive end to end without
+// a socket.
+type pipeSenders struct {
+	mu      sync.Mutex
+	senders map[string]pipeSender
+	dials   map[string]int
+	errs    map[string][]error
+	wg      sync.WaitGroup
+}
+
+// pipeSender is a sender behind pipeSenders and the paths it sends.
+type pipeSender struct {
+	sender *sender.Sender
+	paths  []string
+	// wrap, if set, wraps the sender's end of the dial-th connection to it,
+	// counting from 1
+	wrap func(dial int, con net.Conn) net.Conn
+}
+
+func newPipeSenders() *pipeSenders {
+	return &pipeSenders{
+		senders: map[string]pipeSender{},
+		dials:   map[string]int{},
+		errs:    map[string][]error{},
+	}
+}
+
+// add serves paths from s at addr, a host:port, and returns the peer a
+// discoverer reports it as.
+func (p *pipeSenders) add(addr string, s *sender.Sender, paths ...string) Peer {
+	return p.addThrough(addr, s, nil, paths...)
+}
+
+// addThrough is add with the sender's end of every connection to it
+// wrapped by wrap, if it is set.
+func (p *pipeSenders) addThrough(addr string, s *sender.Sender, wrap func(dial int, con net.Conn) net.Conn, paths ...string) Peer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.senders[addr] = pipeSender{sender: s, paths: paths, wrap: wrap}
+	announcement := protocol.NewAnnouncement(0)
+	announcement.Session = addr
+	return Peer{Addr: addr, Announcement: announcement}
+}
+
+// dial implements the WithDialer func.
+func (p *pipeSenders) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.senders[addr]
+	if !ok {
+		return nil, fmt.Errorf("dial %s %s: connection refused", network, addr)
+	}
+	p.dials[addr]++
+	senderEnd, receiverEnd := net.Pipe()
+	var con net.Conn = senderEnd
+	if s.wrap != nil {
+		con = s.wrap(p.dials[addr], senderEnd)
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		err := s.sender.ServeConn(con, s.paths)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.errs[addr] = append(p.errs[addr], err)
+	}()
+
+	return receiverEnd, nil
+}
+
+// wait waits for every sender connection to finish and returns the errors
+// of the sender at addr, one per connection.
+func (p *pipeSenders) wait(addr string) []error {
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.errs[addr]
+}
+
+// dialed returns how often the sender at addr was connected to.
+func (p *pipeSenders) dialed(addr string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dials[addr]
+}
+
+// fakeDiscoverer reports its peers as soon as discovery starts, then waits
+// for discovery to stop.
+type fakeDiscoverer struct {
+	peers []Peer
+}
+
+func (d fakeDiscoverer) Discover(ctx context.Context, found func(Peer)) error {
+	for _, p := range d.peers {
+		found(p)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// receiveDiscovered runs r.Receive within testTimeout.
+func receiveDiscovered(t *testing.T, r *Receiver) ([]TransferStats, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	stats, err := r.Receive(ctx)
+	if ctx.Err() != nil {
+		t.Fatal("receive didn't finish within the test timeout")
+	}
+	return stats, err
+}
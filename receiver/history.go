@@ -0,0 +1,151 @@
+package receiver
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Outcomes of a HistoryRecord.
+const (
+	OutcomeOK       = "ok"
+	OutcomeFailed   = "failed"
+	OutcomeDeclined = "declined"
+)
+
+// HistoryRecord is a line of the history file written with WithHistoryFile,
+// describing a single file a sender offered.
+type HistoryRecord struct {
+	// Time is when the transfer ended.
+	Time time.Time `json:"time"`
+	// Peer is the address of the sender.
+	Peer string `json:"peer"`
+	// Name is the file's name as sent by the sender.
+	Name string `json:"name"`
+	// Path is where the file was saved, empty unless it was.
+	Path string `json:"path,omitempty"`
+	// Size is the size of the file.
+	Size uint64 `json:"size"`
+	// Duration is how long the file took to save. It is encoded in
+	// nanoseconds and zero unless the file was saved.
+	Duration time.Duration `json:"duration,omitempty"`
+	// Checksum is the hex encoded SHA-256 of the saved file.
+	Checksum string `json:"sha256,omitempty"`
+	// Outcome is OutcomeOK, OutcomeFailed or OutcomeDeclined.
+	Outcome string `json:"outcome"`
+	// Error describes why the transfer failed.
+	Error string `json:"error,omitempty"`
+}
+
+// historyMu serializes the history writes of this process; lockFile keeps
+// other processes out.
+var historyMu sync.Mutex
+
+// WithHistoryFile appends a HistoryRecord to the JSON lines file at path for
+// every file that was saved, failed or was declined, in daemon and one-shot
+// mode alike. Files skipped under the overwrite policy aren't recorded. The
+// file is created if needed and locked while a record is written, so
+// receivers on the same machine can share it. ReadHistory reads it back.
+func WithHistoryFile(path string) Option {
+	return func(r *Receiver) {
+		r.historyFile = path
+	}
+}
+
+// newHistoryRecord describes the outcome of the file named name, which is
+// saved if stats is set and failed or was declined if err is.
+func newHistoryRecord(con net.Conn, name string, size uint64, stats *TransferStats, err error) HistoryRecord {
+	record := HistoryRecord{
+		Time:    time.Now(),
+		Peer:    con.RemoteAddr().String(),
+		Name:    name,
+		Size:    size,
+		Outcome: OutcomeOK,
+	}
+	switch {
+	case errors.Is(err, errDeclined):
+		record.Outcome = OutcomeDeclined
+	case err != nil:
+		record.Outcome = OutcomeFailed
+		record.Error = err.Error()
+	}
+	if stats != nil {
+		record.Path, record.Duration, record.Checksum = stats.Path, stats.Duration, stats.Checksum
+	}
+
+	return record
+}
+
+// recordHistory appends record to the history file, if one is configured.
+// Failing to do so is logged, the transfer itself is unaffected.
+func (r *Receiver) recordHistory(record HistoryRecord) {
+	if r.historyFile == "" {
+		return
+	}
+
+	if err := appendHistory(r.historyFile, record); err != nil {
+		r.logger.Warn("err writing history", "path", r.historyFile, "err", err)
+	}
+}
+
+func appendHistory(path string, record HistoryRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	// closing the file releases the lock
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return fmt.Errorf("err locking: %w", err)
+	}
+
+	// a single write, so a reader never sees half a record
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// ReadHistory reads the records of the history file at path, oldest first.
+func ReadHistory(path string) ([]HistoryRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []HistoryRecord
+	scanner := bufio.NewScanner(file)
+	// error messages may make for long lines
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return records, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
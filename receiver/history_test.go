@@ -0,0 +1,105 @@
+package receiver
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/retry"
+	"github.com/pjmessi/go_file_share/sender"
+)
+
+func TestHistoryRecordsEveryOutcome(t *testing.T) {
+	history := filepath.Join(t.TempDir(), "history.jsonl")
+	src := t.TempDir()
+	saved := writeTestFile(t, src, "saved.txt", testContent(1000))
+	corrupted := writeTestFile(t, src, "corrupted.bin", testContent(64<<10))
+	declined := writeTestFile(t, src, "declined.exe", testContent(100))
+	r, dest := newTestReceiver(t,
+		WithHistoryFile(history),
+		WithAcceptFunc(func(name string, size int64, peer string) bool { return name != "declined.exe" }),
+	)
+
+	if res := pipeTransfer(t, r, newTestSender(t), saved); res.err != nil {
+		t.Fatalf("ReceiveConn: %v", res.err)
+	}
+	pipeTransferThrough(t, r, newTestSender(t, sender.WithTransferRetry(retry.Policy{})), func(con net.Conn) net.Conn {
+		return &corruptingConn{Conn: con, at: 10000}
+	}, corrupted)
+	pipeTransfer(t, r, newTestSender(t), declined)
+
+	records, err := ReadHistory(history)
+	if err != nil {
+		t.Fatalf("ReadHistory: %v", err)
+	}
+	want := []struct{ name, outcome string }{
+		{"saved.txt", OutcomeOK},
+		{"corrupted.bin", OutcomeFailed},
+		{"declined.exe", OutcomeDeclined},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("history holds %+v, want %d records", records, len(want))
+	}
+	for i, w := range want {
+		record := records[i]
+		if record.Name != w.name || record.Outcome != w.outcome || record.Peer != "pipe" || record.Time.IsZero() {
+			t.Errorf("record %d = %+v, want %s %s from the pipe", i, record, w.name, w.outcome)
+		}
+	}
+	if ok := records[0]; ok.Path != filepath.Join(dest, "saved.txt") || ok.Size != 1000 || len(ok.Checksum) != 64 || ok.Error != "" {
+		t.Errorf("saved record = %+v, want its path, size and checksum", ok)
+	}
+	if failed := records[1]; !strings.Contains(failed.Error, "checksum") || failed.Path != "" {
+		t.Errorf("failed record = %+v, want the checksum failure and no path", failed)
+	}
+	if refused := records[2]; refused.Error != "" || refused.Path != "" {
+		t.Errorf("declined record = %+v, want neither an error nor a path", refused)
+	}
+}
+
+func TestConcurrentHistoryAppends(t *testing.T) {
+	const writers, each = 8, 50
+	history := filepath.Join(t.TempDir(), "history.jsonl")
+	// long records, beyond what a pipe writes atomically
+	message := strings.Repeat("x", 16<<10)
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				record := HistoryRecord{
+					Time:    time.Now(),
+					Name:    fmt.Sprintf("writer%d-%d", w, i),
+					Outcome: OutcomeFailed,
+					Error:   message,
+				}
+				if err := appendHistory(history, record); err != nil {
+					t.Errorf("appendHistory: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every line parses, so none was interleaved with another
+	records, err := ReadHistory(history)
+	if err != nil {
+		t.Fatalf("ReadHistory: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, record := range records {
+		if record.Error != message {
+			t.Fatalf("record %s holds a mangled error", record.Name)
+		}
+		seen[record.Name] = true
+	}
+	if len(records) != writers*each || len(seen) != writers*each {
+		t.Errorf("history holds %d records, %d distinct, want %d", len(records), len(seen), writers*each)
+	}
+}
@@ -0,0 +1,46 @@
+package receiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// defaultIdleTimeout is how long a connection may go without progress unless
+// configured otherwise
+const defaultIdleTimeout = 2 * time.Minute
+
+// idleConn fails reads and writes that make no progress for timeout. The
+// deadline is pushed back before every call, so a slow transfer is fine as
+// long as it keeps moving. A net.Pipe refuses deadlines once its other end
+// is closed, which the call itself then reports as the io.EOF it is.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return 0, err
+	}
+	n, err := c.Conn.Read(p)
+	return n, c.idleErr(err)
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return 0, err
+	}
+	n, err := c.Conn.Write(p)
+	return n, c.idleErr(err)
+}
+
+func (c *idleConn) idleErr(err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%w: no progress from %s for %s", ErrIdleTimeout, c.RemoteAddr(), c.timeout)
+	}
+	return err
+}
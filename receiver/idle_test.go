@@ -0,0 +1,104 @@
+package receiver
+
+import (
+	"crypto/sha256"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// goQuiet blocks the fake sender until the receiver hung up.
+func goQuiet(f *fakeSender) {
+	f.con.Read(make([]byte, 1))
+}
+
+func TestIdleTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	content := testContent(1000)
+	tests := []struct {
+		name string
+		send func(f *fakeSender)
+	}{
+		{
+			name: "after the handshake",
+			send: func(f *fakeSender) {
+				if f.handshake() {
+					goQuiet(f)
+				}
+			},
+		},
+		{
+			name: "mid content",
+			send: func(f *fakeSender) {
+				if !f.handshake() || !f.write(uint32(1)) {
+					return
+				}
+				if rep, ok := f.offerFile("stalled.bin", protocol.Header{Size: 1000, Mode: 0o644}); ok && rep.Status == protocol.ReplyAccept {
+					f.write(uint64(0), content[:300])
+					goQuiet(f)
+				}
+			},
+		},
+		{
+			name: "not reading the reply",
+			send: func(f *fakeSender) {
+				if f.handshake() && f.write(uint32(1)) {
+					protocol.WriteFrame(f.con, protocol.Frame{Type: protocol.EntryTypeFile, Name: "deaf.bin"})
+					protocol.WriteHeader(f.con, protocol.Header{Size: 1000, Mode: 0o644})
+					time.Sleep(5 * timeout)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, dest := newTestReceiver(t, WithIdleTimeout(timeout))
+
+			start := time.Now()
+			res := rawTransfer(t, r, tt.send)
+			elapsed := time.Since(start)
+			if !errors.Is(res.err, ErrIdleTimeout) {
+				t.Fatalf("ReceiveConn = %v, want ErrIdleTimeout", res.err)
+			}
+			if msg := res.err.Error(); !strings.Contains(msg, "pipe") || !strings.Contains(msg, timeout.String()) {
+				t.Errorf("error %q names neither the peer nor how long it waited", msg)
+			}
+			if elapsed > 10*timeout {
+				t.Errorf("giving up took %s with a timeout of %s", elapsed, timeout)
+			}
+			assertNoFiles(t, dest)
+		})
+	}
+}
+
+func TestIdleTimeoutAllowsSlowTransfers(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	content := testContent(1000)
+	r, dest := newTestReceiver(t, WithIdleTimeout(timeout))
+
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if !f.handshake() || !f.write(uint32(1)) {
+			return
+		}
+		rep, ok := f.offerFile("slow.bin", protocol.Header{Size: 1000, Mode: 0o644})
+		if !ok || rep.Status != protocol.ReplyAccept || !f.write(uint64(0)) {
+			return
+		}
+		// five times the timeout in all, never more than half of it at once
+		for i := 0; i < len(content); i += 100 {
+			time.Sleep(timeout / 2)
+			f.write(content[i : i+100])
+		}
+		checksum := sha256.Sum256(content)
+		f.write(checksum[:])
+		protocol.ReadAck(f.con)
+	})
+	if res.err != nil {
+		t.Fatalf("ReceiveConn: %v", res.err)
+	}
+	assertFile(t, filepath.Join(dest, "slow.bin"), content)
+}
@@ -0,0 +1,122 @@
+package receiver
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/pjmessi/go_file_share/internal/netif"
+)
+
+// WithInterface discovers and connects to senders through the network
+// interface named name only, e.g. "eth1", rather than through whichever one
+// the system picks, for machines with Docker bridges, VPN tunnels or several
+// NICs. Announcements heard through other interfaces are ignored, multicast
+// groups are joined on it unless MulticastDiscoverer.Interface says
+// otherwise, and connections are made from its address. The WithWebListener
+// listener listens on its addresses, turning away connections through other
+// interfaces. Discovery still listens on the wildcard address, since a
+// socket bound to a unicast address doesn't receive broadcasts. The addresses of the interface are looked up
+// once, when the receiver is created, which fails for an unknown name.
+func WithInterface(name string) Option {
+	return func(r *Receiver) {
+		r.ifaceName = name
+	}
+}
+
+// onInterface returns d joining its multicast group on the WithInterface
+// interface, if one is configured and d names none itself.
+func (r *Receiver) onInterface(d Discoverer) Discoverer {
+	if m, ok := d.(MulticastDiscoverer); ok && r.ifaceName != "" && m.Interface == "" {
+		m.Interface = r.ifaceName
+		return m
+	}
+	return d
+}
+
+// reachedThrough reports whether the sender at addr, a host:port with an
+// IP host, is heard through the WithInterface interface, if one is
+// configured.
+func (r *Receiver) reachedThrough(addr string) bool {
+	if r.ifaceName == "" {
+		return true
+	}
+	ip, err := netip.ParseAddrPort(addr)
+	return err == nil && r.iface.Reaches(ip.Addr())
+}
+
+// localAddr returns the address of the WithInterface interface to connect
+// to host from, if one is configured. Host names are connected to over
+// IPv4 if the interface has an IPv4 address, the dialer keeping to the
+// addresses of its version.
+func (r *Receiver) localAddr(host string) (netip.Addr, bool) {
+	if r.ifaceName == "" {
+		return netip.Addr{}, false
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		if local, ok := r.iface.Addr(netip.IPv4Unspecified()); ok {
+			return local, true
+		}
+		ip = netip.IPv6Unspecified()
+	}
+	return r.iface.Addr(ip)
+}
+
+// localTCPAddr returns the local address of net.Dialer connecting to host, nil
+// to let the system pick one.
+func (r *Receiver) localTCPAddr(host string) net.Addr {
+	local, ok := r.localAddr(host)
+	if !ok {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(local, 0))
+}
+
+// localUDPAddr is localTCPAddr for QUIC.
+func (r *Receiver) localUDPAddr(host string) *net.UDPAddr {
+	local, ok := r.localAddr(host)
+	if !ok {
+		return nil
+	}
+	return net.UDPAddrFromAddrPort(netip.AddrPortFrom(local, 0))
+}
+
+// listenOn listens for TCP connections on addr, a host:port. With the
+// WithInterface interface configured, an empty or unspecified host stands
+// for every address of the interface rather than of the machine; the
+// connections that don't run through it are turned away by
+// filteredListener.
+func (r *Receiver) listenOn(addr string) (net.Listener, error) {
+	listen := func(addr string) (net.Listener, error) {
+		return net.Listen("tcp", addr)
+	}
+	if r.ifaceName == "" {
+		return listen(addr)
+	}
+	return r.iface.ListenOn(addr, listen)
+}
+
+// carries reports whether con runs through the WithInterface interface, if
+// one is configured.
+func (r *Receiver) carries(con net.Conn) bool {
+	if r.ifaceName == "" {
+		return true
+	}
+	local, err := netip.ParseAddrPort(con.LocalAddr().String())
+	if err != nil {
+		return false
+	}
+	remote, err := netip.ParseAddrPort(con.RemoteAddr().String())
+	return err == nil && r.iface.Carries(local.Addr(), remote.Addr())
+}
+
+// lookupInterface looks up the WithInterface interface, if one is
+// configured.
+func (r *Receiver) lookupInterface() error {
+	if r.ifaceName == "" {
+		return nil
+	}
+	var err error
+	r.iface, err = netif.Lookup(r.ifaceName)
+	return err
+}
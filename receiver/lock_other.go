@@ -0,0 +1,11 @@
+//go:build !unix
+
+package receiver
+
+import "os"
+
+// lockFile is a no-op where there is no advisory locking in the standard
+// library; records are still appended with a single write each.
+func lockFile(file *os.File) error {
+	return nil
+}
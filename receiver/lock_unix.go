@@ -0,0 +1,14 @@
+//go:build unix
+
+package receiver
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock on file, waiting for other
+// processes holding it. Closing file releases the lock.
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
@@ -0,0 +1,277 @@
+package receiver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/pathmatch"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// OfferedEntry is an entry a sender lists before sending anything, for a
+// SelectFunc to pick from. Name is slash-separated, Size is -1 for content
+// whose size isn't known up front, such as stdin, and zero for directories
+// and symlinks.
+type OfferedEntry struct {
+	Name    string
+	Dir     bool
+	Symlink bool
+	Size    int64
+	ModTime time.Time
+}
+
+// SelectFunc picks the entries to receive out of those a sender offers,
+// returning their indexes in offer. Picking a directory picks everything in
+// it, and the directories a picked entry is in come along. Picked files are
+// accepted without asking the AcceptFunc. Picking none ends the transfer
+// without an error. In daemon mode it may be called concurrently.
+type SelectFunc func(offer []OfferedEntry, peer string) []int
+
+// WithSelectFunc has senders list their entries up front and fn pick the
+// ones to receive. Senders that can't list them send everything, as without
+// it.
+func WithSelectFunc(fn SelectFunc) Option {
+	return func(r *Receiver) {
+		r.selectEntries = fn
+	}
+}
+
+// SelectMatching returns a SelectFunc picking the entries whose name matches
+// any of patterns, gitignore-style, see pathmatch.Pattern: "*.jpg" picks
+// every JPEG, "photos/2024" that directory.
+func SelectMatching(patterns ...string) (SelectFunc, error) {
+	set, err := pathmatch.CompileSet(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(offer []OfferedEntry, _ string) []int {
+		var picked []int
+		for i, entry := range offer {
+			if set.Match(entry.Name, entry.Dir) {
+				picked = append(picked, i)
+			}
+		}
+		return picked
+	}, nil
+}
+
+// pickEntries reads the manifest the sender lists its entries with, has
+// the SelectFunc pick from it and answers with the selection, marking the
+// picked files accepted in state. On a reconnect, state picks the entries
+// the first connection did instead.
+func (r *Receiver) pickEntries(con net.Conn, state *resumeState) error {
+	// RECEIVE MANIFEST
+	offer, err := r.readOffer(con)
+	if err != nil {
+		return err
+	}
+
+	// PICK ENTRIES
+	var picked []int
+	if names, ok := state.pickedBefore(); ok {
+		for i, entry := range offer {
+			if names[entry.Name] {
+				picked = append(picked, i)
+			}
+		}
+	} else {
+		picked = r.selectEntries(offer, con.RemoteAddr().String())
+	}
+	selected, err := expandSelection(offer, picked)
+	if err != nil {
+		return err
+	}
+
+	// SEND SELECTION
+	indexes := make([]uint32, 0, len(offer))
+	names := make(map[string]bool, len(offer))
+	for i, entry := range offer {
+		if !selected[i] {
+			continue
+		}
+		indexes = append(indexes, uint32(i))
+		names[entry.Name] = true
+		if !entry.Dir && !entry.Symlink {
+			state.markAccepted(entry.Name)
+		}
+	}
+	if err := protocol.WriteSelection(con, indexes); err != nil {
+		return fmt.Errorf("err sending selection: %w", err)
+	}
+	state.markPicked(names)
+
+	r.logger.Info("picked entries", "peer", con.RemoteAddr(), "picked", len(indexes), "offered", len(offer))
+
+	return nil
+}
+
+// readOffer reads the manifest the sender lists its entries with.
+func (r *Receiver) readOffer(con net.Conn) ([]OfferedEntry, error) {
+	manifest, err := protocol.ReadManifest(con, r.maxNameLength)
+	if errors.Is(err, protocol.ErrInvalidFrame) {
+		return nil, fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("err receiving manifest: %w", err)
+	}
+
+	offer := make([]OfferedEntry, len(manifest))
+	for i, entry := range manifest {
+		name, err := decodeName(entry.Name, r.strictNames)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrProtocol, err)
+		}
+		offer[i] = OfferedEntry{
+			Name:    name,
+			Dir:     entry.Type == protocol.EntryTypeDir,
+			Symlink: entry.Type == protocol.EntryTypeSymlink,
+			Size:    int64(entry.Size),
+			ModTime: time.Unix(0, entry.ModTime),
+		}
+		if entry.Size == protocol.UnknownSize {
+			offer[i].Size = -1
+		}
+	}
+
+	return offer, nil
+}
+
+// ListOffers finds senders the way Receive does and returns the files they
+// offer, without receiving any: every sender lists its entries, is told
+// none were picked and is disconnected from. Nothing is written to disk.
+// Directories and symlinks aren't listed, and the size of content streamed
+// without one known up front, such as stdin, is zero. Senders that can't
+// list their entries fail with ErrProtocol; one failing sender doesn't
+// keep the others from being listed.
+func (r *Receiver) ListOffers(ctx context.Context) ([]FileInfo, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopClose := context.AfterFunc(r.closed, cancel)
+	defer stopClose()
+
+	peers, err := r.findPeers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("err searching for discovery msg: %w", err)
+	}
+
+	var offers []FileInfo
+	var errs []error
+	for _, peer := range peers {
+		peerOffers, err := r.listPeer(ctx, peer)
+		offers = append(offers, peerOffers...)
+		if err != nil {
+			if ctx.Err() != nil {
+				return offers, err
+			}
+			errs = append(errs, &PeerError{Addr: peer.Addr, Err: err})
+		}
+	}
+
+	return offers, errors.Join(errs...)
+}
+
+// listPeer connects to peer and returns the files it offers.
+func (r *Receiver) listPeer(ctx context.Context, peer Peer) ([]FileInfo, error) {
+	con, err := r.dial(ctx, peer)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("connecting to %s: %w", peer.Addr, ctx.Err())
+		}
+		return nil, fmt.Errorf("err connecting to peer: %w", err)
+	}
+	defer con.Close()
+	stopClosing := context.AfterFunc(ctx, func() { con.Close() })
+	defer stopClosing()
+	con = newBufferedConn(con)
+
+	// EXCHANGE PROTOCOL VERSIONS
+	if err := r.handshake(con); err != nil {
+		return nil, fmt.Errorf("err during handshake: %w", err)
+	}
+
+	// ASK FOR THE MANIFEST
+	if err := r.sendCapabilities(con, r.capabilities(con)|protocol.CapManifest); err != nil {
+		return nil, err
+	}
+	var entryCount uint32
+	if err := binary.Read(con, binary.LittleEndian, &entryCount); err != nil {
+		return nil, fmt.Errorf("err receiving entry count: %w", err)
+	}
+	if entryCount != protocol.ManifestFollows {
+		return nil, fmt.Errorf("%w: sender can't list its entries", ErrProtocol)
+	}
+	offer, err := r.readOffer(con)
+	if err != nil {
+		return nil, err
+	}
+
+	// PICK NONE
+	// the sender answers with an empty transfer
+	if err := protocol.WriteSelection(con, nil); err != nil {
+		return nil, fmt.Errorf("err sending selection: %w", err)
+	}
+	if err := binary.Read(con, binary.LittleEndian, &entryCount); err != nil {
+		return nil, fmt.Errorf("err receiving entry count: %w", err)
+	}
+
+	var offers []FileInfo
+	for _, entry := range offer {
+		if entry.Dir || entry.Symlink {
+			continue
+		}
+		offers = append(offers, FileInfo{Name: entry.Name, Size: uint64(max(entry.Size, 0)), ModTime: entry.ModTime, Peer: peer.Addr})
+	}
+	r.logger.Debug("listed offer", "peer", peer.Addr, "files", len(offers))
+
+	return offers, con.Close()
+}
+
+// expandSelection marks the entries of offer picked, along with those in
+// picked directories and the directories picked entries are in.
+func expandSelection(offer []OfferedEntry, picked []int) ([]bool, error) {
+	selected := make([]bool, len(offer))
+	pickedDirs := map[string]bool{}
+	for _, i := range picked {
+		if i < 0 || i >= len(offer) {
+			return nil, fmt.Errorf("picked entry %d of an offer of %d", i, len(offer))
+		}
+		selected[i] = true
+		if offer[i].Dir {
+			pickedDirs[offer[i].Name] = true
+		}
+	}
+
+	dirs := map[string]int{}
+	for i, entry := range offer {
+		if entry.Dir {
+			dirs[entry.Name] = i
+		}
+	}
+	for i, entry := range offer {
+		for dir := path.Dir(entry.Name); dir != "." && dir != "/" && !selected[i]; dir = path.Dir(dir) {
+			selected[i] = pickedDirs[dir]
+		}
+	}
+	for i, entry := range offer {
+		if !selected[i] {
+			continue
+		}
+		for dir := path.Dir(entry.Name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if j, ok := dirs[dir]; ok {
+				selected[j] = true
+			}
+		}
+	}
+
+	return selected, nil
+}
@@ -0,0 +1,188 @@
+package receiver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// savedFiles returns the slash-separated names of the files under dir,
+// sorted.
+func savedFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var names []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		names = append(names, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestSelectMatching(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"photos/a.jpg", "photos/b.png", "photos/2024/c.jpg", "notes.txt"} {
+		if err := os.MkdirAll(filepath.Join(src, filepath.Dir(name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, src, name, []byte(name))
+	}
+	paths := []string{filepath.Join(src, "photos"), filepath.Join(src, "notes.txt")}
+
+	tests := []struct {
+		name      string
+		patterns  []string
+		wantFiles []string
+	}{
+		{"files", []string{"*.jpg"}, []string{"photos/2024/c.jpg", "photos/a.jpg"}},
+		{"directory", []string{"photos/2024"}, []string{"photos/2024/c.jpg"}},
+		{"several", []string{"notes.txt", "*.png"}, []string{"notes.txt", "photos/b.png"}},
+		{"nothing", []string{"*.mp4"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selectFunc, err := SelectMatching(tt.patterns...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, destDir := newTestReceiver(t, WithSelectFunc(selectFunc))
+
+			// picking nothing ends the transfer on both ends without an error
+			res := pipeTransfer(t, r, newTestSender(t), paths...)
+			if res.err != nil || res.senderErr != nil {
+				t.Fatalf("transfer: %v, sender: %v", res.err, res.senderErr)
+			}
+			if got := savedFiles(t, destDir); !slices.Equal(got, tt.wantFiles) {
+				t.Errorf("saved %q, want %q", got, tt.wantFiles)
+			}
+			for _, name := range tt.wantFiles {
+				assertFile(t, filepath.Join(destDir, filepath.FromSlash(name)), []byte(name))
+			}
+			if len(res.stats) != len(tt.wantFiles) {
+				t.Errorf("%d files in the stats, want %d", len(res.stats), len(tt.wantFiles))
+			}
+		})
+	}
+}
+
+func TestExpandSelection(t *testing.T) {
+	offer := []OfferedEntry{
+		{Name: "photos", Dir: true},
+		{Name: "photos/2024", Dir: true},
+		{Name: "photos/2024/c.jpg"},
+		{Name: "photos/a.jpg"},
+		{Name: "notes.txt"},
+	}
+	tests := []struct {
+		name    string
+		picked  []int
+		want    []bool
+		wantErr bool
+	}{
+		{"nothing", nil, []bool{false, false, false, false, false}, false},
+		{"file with its directories", []int{2}, []bool{true, true, true, false, false}, false},
+		{"directory with its contents", []int{1}, []bool{true, true, true, false, false}, false},
+		{"top directory", []int{0}, []bool{true, true, true, true, false}, false},
+		{"twice", []int{4, 4}, []bool{false, false, false, false, true}, false},
+		{"out of range", []int{5}, nil, true},
+		{"negative", []int{-1}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandSelection(offer, tt.picked)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandSelection = %v, want an error: %t", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expandSelection = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListOffers(t *testing.T) {
+	src := t.TempDir()
+	if err := os.Mkdir(filepath.Join(src, "photos"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, src, "photos/a.jpg", testContent(100))
+	notes := writeTestFile(t, src, "notes.txt", testContent(10))
+	senders := newPipeSenders()
+	first := senders.add("192.0.2.10:9000", newTestSender(t), filepath.Join(src, "photos"))
+	second := senders.add("192.0.2.11:9000", newTestSender(t), notes)
+	r, destDir := newTestReceiver(t,
+		WithDiscoverers(fakeDiscoverer{peers: []Peer{first, second}}),
+		WithDialer(senders.dial),
+		WithDiscoveryWindow(100*time.Millisecond),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	offers, err := r.ListOffers(ctx)
+	if err != nil {
+		t.Fatalf("ListOffers: %v", err)
+	}
+	var got []string
+	for _, offer := range offers {
+		got = append(got, fmt.Sprintf("%s %s %d", offer.Peer, offer.Name, offer.Size))
+	}
+	slices.Sort(got)
+	want := []string{"192.0.2.10:9000 photos/a.jpg 100", "192.0.2.11:9000 notes.txt 10"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ListOffers = %q, want %q", got, want)
+	}
+	assertNoFiles(t, destDir)
+	for _, addr := range []string{first.Addr, second.Addr} {
+		for _, err := range senders.wait(addr) {
+			if err != nil {
+				t.Errorf("sender %s: %v after only being listed", addr, err)
+			}
+		}
+	}
+}
+
+func TestFileInfoJSON(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		info FileInfo
+		want string
+	}{
+		{"with mtime", FileInfo{Name: "a.txt", Size: 3, ModTime: modTime, Peer: "10.0.0.2:9410"},
+			`{"name":"a.txt","size":3,"mod_time":"2024-05-01T12:30:00Z","peer":"10.0.0.2:9410"}`},
+		{"without mtime", FileInfo{Name: "a.txt", Size: 3, Peer: "10.0.0.2:9410"},
+			`{"name":"a.txt","size":3,"peer":"10.0.0.2:9410"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("json.Marshal = %s, want %s", got, tt.want)
+			}
+
+			var back FileInfo
+			if err := json.Unmarshal(got, &back); err != nil {
+				t.Fatal(err)
+			}
+			if !back.ModTime.Equal(tt.info.ModTime) || back.Name != tt.info.Name {
+				t.Errorf("round trip = %+v, want %+v", back, tt.info)
+			}
+		})
+	}
+}
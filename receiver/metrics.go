@@ -0,0 +1,62 @@
+package receiver
+
+import (
+	"errors"
+
+	"github.com/pjmessi/go_file_share/internal/metrics"
+)
+
+// receiverMetrics are the metrics served with WithMetricsAddr.
+type receiverMetrics struct {
+	registry metrics.Registry
+
+	started      *metrics.Counter
+	completed    *metrics.Counter
+	failed       *metrics.Counter
+	bytes        *metrics.Counter
+	discovered   *metrics.Counter
+	active       *metrics.Gauge
+	lastDuration *metrics.Gauge
+}
+
+func newReceiverMetrics() *receiverMetrics {
+	m := &receiverMetrics{}
+	m.started = m.registry.Counter("fileshare_receiver_transfers_started_total", "Files accepted from senders.")
+	m.completed = m.registry.Counter("fileshare_receiver_transfers_completed_total", "Files received and saved.")
+	m.failed = m.registry.Counter("fileshare_receiver_transfers_failed_total", "Files that failed to be received.")
+	m.bytes = m.registry.Counter("fileshare_receiver_bytes_received_total", "Content bytes of the files saved.")
+	m.discovered = m.registry.Counter("fileshare_receiver_discovery_packets_total", "Valid announcements heard by the discovery backends.")
+	m.active = m.registry.Gauge("fileshare_receiver_active_transfers", "Files being received right now.")
+	m.lastDuration = m.registry.Gauge("fileshare_receiver_last_transfer_duration_seconds", "How long the last saved file took.")
+	return m
+}
+
+// WithMetricsAddr serves metrics in the Prometheus text format at /metrics
+// on addr, e.g. ":9090", while Receive runs. The metric names are stable:
+//
+//	fileshare_receiver_transfers_started_total
+//	fileshare_receiver_transfers_completed_total
+//	fileshare_receiver_transfers_failed_total
+//	fileshare_receiver_bytes_received_total
+//	fileshare_receiver_discovery_packets_total
+//	fileshare_receiver_active_transfers
+//	fileshare_receiver_last_transfer_duration_seconds
+//
+// Declined files count as neither completed nor failed.
+func WithMetricsAddr(addr string) Option {
+	return func(r *Receiver) {
+		r.metricsAddr = addr
+	}
+}
+
+// recordMetrics counts the outcome of a file the sender offered.
+func (r *Receiver) recordMetrics(stats *TransferStats, err error) {
+	switch {
+	case stats != nil:
+		r.metrics.completed.Inc()
+		r.metrics.bytes.Add(stats.Bytes)
+		r.metrics.lastDuration.Set(stats.Duration.Seconds())
+	case err != nil && !errors.Is(err, errDeclined):
+		r.metrics.failed.Inc()
+	}
+}
@@ -0,0 +1,93 @@
+package receiver
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// scrape returns the metrics r serves, by name, in the order served.
+func scrape(t *testing.T, r *Receiver) ([]string, map[string]float64) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	r.metrics.registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	var names []string
+	values := make(map[string]float64)
+	for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, _ := strings.Cut(line, " ")
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("metric line %q: %v", line, err)
+		}
+		names = append(names, name)
+		values[name] = v
+	}
+	return names, values
+}
+
+func TestMetricNames(t *testing.T) {
+	r, _ := newTestReceiver(t)
+
+	names, _ := scrape(t, r)
+	want := []string{
+		"fileshare_receiver_transfers_started_total",
+		"fileshare_receiver_transfers_completed_total",
+		"fileshare_receiver_transfers_failed_total",
+		"fileshare_receiver_bytes_received_total",
+		"fileshare_receiver_discovery_packets_total",
+		"fileshare_receiver_active_transfers",
+		"fileshare_receiver_last_transfer_duration_seconds",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("metrics = %q, want %q", names, want)
+	}
+}
+
+func TestMetricsCountTransfers(t *testing.T) {
+	src := t.TempDir()
+	paths := []string{
+		writeTestFile(t, src, "a.txt", testContent(1000)),
+		writeTestFile(t, src, "b.txt", testContent(234)),
+	}
+	r, _ := newTestReceiver(t)
+
+	if res := pipeTransfer(t, r, newTestSender(t), paths...); res.err != nil {
+		t.Fatalf("ReceiveConn: %v", res.err)
+	}
+	rawTransfer(t, r, func(f *fakeSender) {
+		if f.handshake() && f.write(uint32(1)) {
+			f.sendFileWithChecksum("bad.bin", []byte("x"), make([]byte, 32))
+		}
+	})
+	rawTransfer(t, r, func(f *fakeSender) {
+		if f.handshake() && f.write(uint32(1)) {
+			// refused for want of room before it is accepted
+			f.offerFile("big.bin", protocol.Header{Size: 1 << 62})
+		}
+	})
+
+	_, values := scrape(t, r)
+	want := map[string]float64{
+		"fileshare_receiver_transfers_started_total":   3,
+		"fileshare_receiver_transfers_completed_total": 2,
+		"fileshare_receiver_transfers_failed_total":    2,
+		"fileshare_receiver_bytes_received_total":      1234,
+		"fileshare_receiver_active_transfers":          0,
+	}
+	for name, v := range want {
+		if values[name] != v {
+			t.Errorf("%s = %g, want %g", name, values[name], v)
+		}
+	}
+	if values["fileshare_receiver_last_transfer_duration_seconds"] <= 0 {
+		t.Error("last transfer duration not recorded")
+	}
+}
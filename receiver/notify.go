@@ -0,0 +1,99 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+)
+
+// notifyTimeout bounds a single notification
+const notifyTimeout = 10 * time.Second
+
+// Notifier shows the user a short notification, see WithNotifier.
+type Notifier interface {
+	Notify(ctx context.Context, title, message string) error
+}
+
+// WithNotifier notifies the user through n of every file that was saved,
+// e.g. "Received report.pdf, 14.0 MB from 192.168.1.50", which is handy for
+// a daemon running in the background. Notifications run alongside the
+// transfers, and failing to show one is logged at debug level only.
+// DesktopNotifier uses the notifications of the operating system.
+func WithNotifier(n Notifier) Option {
+	return func(r *Receiver) {
+		r.notifier = n
+	}
+}
+
+// DesktopNotifier shows native desktop notifications through notify-send on
+// Linux, osascript on macOS and a PowerShell toast on Windows. Other systems
+// fail with an error.
+type DesktopNotifier struct{}
+
+// windowsToast shows a toast whose title and message are passed in the
+// environment, which spares quoting them for PowerShell.
+const windowsToast = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode($env:FILESHARE_TITLE)) > $null
+$text.Item(1).AppendChild($template.CreateTextNode($env:FILESHARE_MESSAGE)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('fileshare').Show([Windows.UI.Notifications.ToastNotification]::new($template))`
+
+// Notify implements Notifier.
+func (DesktopNotifier) Notify(ctx context.Context, title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.CommandContext(ctx, "notify-send", "--app-name=fileshare", "--", title, message)
+	case "darwin":
+		// the texts are arguments of the script, so they need no quoting
+		cmd = exec.CommandContext(ctx, "osascript",
+			"-e", "on run argv",
+			"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+			"-e", "end run",
+			title, message)
+	case "windows":
+		cmd = exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", windowsToast)
+		cmd.Env = append(os.Environ(), "FILESHARE_TITLE="+title, "FILESHARE_MESSAGE="+message)
+	default:
+		return errors.New("desktop notifications aren't supported on " + runtime.GOOS)
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+
+	return nil
+}
+
+// notifyDesktop tells the notifier, if one is set, that the file named name
+// was saved, without waiting for the notification.
+func (r *Receiver) notifyDesktop(con net.Conn, name string, size uint64) {
+	if r.notifier == nil {
+		return
+	}
+
+	peer := con.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	message := fmt.Sprintf("Received %s, %s from %s", name, progress.FormatSize(float64(size)), peer)
+
+	r.deliveries.Add(1)
+	go func() {
+		defer r.deliveries.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+		if err := r.notifier.Notify(ctx, "fileshare", message); err != nil {
+			r.logger.Debug("err showing notification", "err", err)
+		}
+	}()
+}
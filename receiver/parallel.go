@@ -0,0 +1,349 @@
+package receiver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+const (
+	// parallelIdleTimeout bounds the wait for the parallel connections of a
+	// file to make progress, including for a failed one to be replaced
+	parallelIdleTimeout = 30 * time.Second
+	// parallelOpenTimeout bounds the wait for a connection to name its range
+	parallelOpenTimeout = 10 * time.Second
+	// parallelBufferSize is the size of the reads a range is written in
+	parallelBufferSize = 64 << 10
+)
+
+// parallelRanges tracks the ranges of a file received over parallel
+// connections.
+type parallelRanges struct {
+	mu sync.Mutex
+	// start and end bound every range in the file, have counts the bytes of
+	// it written so far and confirmed is set once the sender was told it
+	// arrived
+	start, end, have []uint64
+	confirmed        []bool
+	// conns holds the connection receiving every range, if any, and gens
+	// counts the connections that took it over
+	conns     []net.Conn
+	gens      []int
+	remaining int
+	// active is when the last bytes arrived
+	active time.Time
+	// done is closed once every range was written, failed takes the first
+	// error that dooms the file
+	done   chan struct{}
+	failed chan error
+}
+
+func newParallelRanges(offset, size uint64, streams int) *parallelRanges {
+	p := &parallelRanges{
+		start:     make([]uint64, streams),
+		end:       make([]uint64, streams),
+		have:      make([]uint64, streams),
+		confirmed: make([]bool, streams),
+		conns:     make([]net.Conn, streams),
+		gens:      make([]int, streams),
+		active:    time.Now(),
+		done:      make(chan struct{}),
+		failed:    make(chan error, 1),
+	}
+	for i := range streams {
+		start, end := protocol.ParallelRange(size, streams, i)
+		p.start[i], p.end[i] = offset+start, offset+end
+		if start < end {
+			p.remaining++
+		} else {
+			p.confirmed[i] = true
+		}
+	}
+	if p.remaining == 0 {
+		close(p.done)
+	}
+
+	return p
+}
+
+// claim hands range i to c and returns how much of it was written and the
+// generation c writes under. The sender only opens another connection for
+// a range once it gave up on the previous one, which is closed, so one that
+// hangs doesn't hold the range until it times out.
+func (p *parallelRanges) claim(i int, c net.Conn) (have uint64, gen int, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i >= len(p.start) {
+		return 0, 0, false
+	}
+	if p.conns[i] != nil {
+		p.conns[i].Close()
+	}
+	p.conns[i] = c
+	p.gens[i]++
+
+	return p.have[i], p.gens[i], true
+}
+
+// release frees range i unless another connection took it over.
+func (p *parallelRanges) release(i, gen int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.gens[i] == gen {
+		p.conns[i] = nil
+	}
+}
+
+// add records n more bytes written to range i, unless another connection
+// took it over, in which case the bytes may already be counted and false is
+// returned.
+func (p *parallelRanges) add(i, gen, n int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.gens[i] != gen {
+		return false
+	}
+	p.have[i] += uint64(n)
+	p.active = time.Now()
+
+	return true
+}
+
+// confirm records that the sender was told range i arrived. Only then is the
+// connection that carried it no longer needed.
+func (p *parallelRanges) confirm(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.confirmed[i] {
+		return
+	}
+	p.confirmed[i] = true
+	p.remaining--
+	if p.remaining == 0 {
+		close(p.done)
+	}
+}
+
+// fail dooms the file with err, unless it already failed.
+func (p *parallelRanges) fail(err error) {
+	select {
+	case p.failed <- err:
+	default:
+	}
+}
+
+// idle returns how long no bytes arrived for.
+func (p *parallelRanges) idle() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return time.Since(p.active)
+}
+
+// prefix returns the end of the content written without gaps.
+func (p *parallelRanges) prefix() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := range p.start {
+		if p.start[i]+p.have[i] < p.end[i] {
+			return p.start[i] + p.have[i]
+		}
+	}
+
+	return p.end[len(p.end)-1]
+}
+
+// receiveParallel receives the content from offset to contentSize as sent
+// under protocol.FlagParallel, writing every range to its place in file as
+// it arrives. It returns the digest of the whole file, of which digest
+// already covers the first offset bytes. On failure, file is cut down to the
+// content received without gaps, so a resumed transfer can continue from it.
+func (r *Receiver) receiveParallel(con net.Conn, file *os.File, offset, contentSize uint64, digest hash.Hash, tracker *progress.Tracker) ([]byte, error) {
+	local, ok := con.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("%w: parallel connections offered on a %s connection", ErrProtocol, con.LocalAddr().Network())
+	}
+
+	// OFFER A PORT
+	config := net.ListenConfig{Control: r.socket.Control}
+	listener, err := config.Listen(context.Background(), "tcp", netip.AddrPortFrom(local.AddrPort().Addr().Unmap(), 0).String())
+	if err != nil {
+		return nil, fmt.Errorf("err listening for parallel connections: %w", err)
+	}
+	defer listener.Close()
+
+	offer := protocol.ParallelOffer{Port: uint16(listener.Addr().(*net.TCPAddr).Port)}
+	rand.Read(offer.Token[:])
+	if err := binary.Write(con, binary.LittleEndian, offer); err != nil {
+		return nil, fmt.Errorf("err offering parallel connections: %w", err)
+	}
+	var streams uint8
+	if err := binary.Read(con, binary.LittleEndian, &streams); err != nil {
+		return nil, fmt.Errorf("err receiving stream count: %w", err)
+	}
+	if streams == 0 || streams > protocol.MaxParallel {
+		return nil, fmt.Errorf("%w: %d parallel connections", ErrProtocol, streams)
+	}
+
+	// RECEIVE THE RANGES
+	ranges := newParallelRanges(offset, contentSize-offset, int(streams))
+	var conns sync.WaitGroup
+	var mu sync.Mutex
+	open := map[net.Conn]bool{}
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if err := r.socket.Apply(c); err != nil {
+				r.logger.Warn("err tuning parallel connection", "peer", c.RemoteAddr(), "err", err)
+			}
+
+			mu.Lock()
+			open[c] = true
+			conns.Add(1)
+			mu.Unlock()
+			go func() {
+				defer conns.Done()
+				if err := r.receiveRange(c, file, offer.Token, ranges, tracker); err != nil {
+					r.logger.Debug("parallel connection failed", "peer", c.RemoteAddr(), "err", err)
+				}
+				c.Close()
+
+				mu.Lock()
+				defer mu.Unlock()
+				delete(open, c)
+			}()
+		}
+	}()
+
+	err = r.awaitRanges(ranges)
+
+	// the ranges still arriving are cut off, and none are written to once
+	// this returns
+	listener.Close()
+	mu.Lock()
+	for c := range open {
+		c.Close()
+	}
+	mu.Unlock()
+	conns.Wait()
+	if err != nil {
+		r.truncatePartFile(file, ranges.prefix())
+		return nil, err
+	}
+
+	// HASH THE ASSEMBLED CONTENT
+	if err := hashFileRange(digest, file.Name(), offset, contentSize); err != nil {
+		return nil, fmt.Errorf("err hashing received ranges: %w", err)
+	}
+
+	r.logger.Debug("received file content", "bytes", contentSize-offset, "connections", streams)
+
+	return digest.Sum(nil), nil
+}
+
+// awaitRanges waits until every range was written, a write failed or no
+// bytes arrived for parallelIdleTimeout.
+func (r *Receiver) awaitRanges(ranges *parallelRanges) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ranges.done:
+			return nil
+		case err := <-ranges.failed:
+			return err
+		case <-ticker.C:
+			if idle := ranges.idle(); idle >= parallelIdleTimeout {
+				return fmt.Errorf("%w: no bytes over any parallel connection for %s", ErrIncompleteTransfer, idle.Round(time.Second))
+			}
+		}
+	}
+}
+
+// receiveRange receives the rest of the range the connection c names and
+// confirms it, even if it was complete already. A connection that breaks
+// off leaves the range to the next one naming it; only failing to write to
+// file dooms the whole file.
+func (r *Receiver) receiveRange(c net.Conn, file *os.File, token [protocol.TokenSize]byte, ranges *parallelRanges, tracker *progress.Tracker) error {
+	// READ THE RANGE INDEX
+	if err := c.SetReadDeadline(time.Now().Add(parallelOpenTimeout)); err != nil {
+		return fmt.Errorf("err setting open deadline: %w", err)
+	}
+	var open struct {
+		Token [protocol.TokenSize]byte
+		Index uint8
+	}
+	if err := binary.Read(c, binary.LittleEndian, &open); err != nil {
+		return fmt.Errorf("err reading range index: %w", err)
+	}
+	if open.Token != token {
+		return errors.New("wrong token")
+	}
+	i := int(open.Index)
+	have, gen, ok := ranges.claim(i, c)
+	if !ok {
+		return fmt.Errorf("%w: unknown range %d", ErrProtocol, i)
+	}
+	defer ranges.release(i, gen)
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		return fmt.Errorf("err clearing open deadline: %w", err)
+	}
+
+	// TELL THE SENDER WHERE TO CONTINUE
+	if r.idleTimeout > 0 {
+		c = &idleConn{Conn: c, timeout: r.idleTimeout}
+	}
+	if err := binary.Write(c, binary.LittleEndian, have); err != nil {
+		return fmt.Errorf("err sending range offset: %w", err)
+	}
+
+	// SAVE THE RANGE TO THE FILE
+	buf := make([]byte, parallelBufferSize)
+	pos, end := ranges.start[i]+have, ranges.end[i]
+	for pos < end {
+		n, err := c.Read(buf[:min(uint64(len(buf)), end-pos)])
+		if n > 0 {
+			if _, err := file.WriteAt(buf[:n], int64(pos)); err != nil {
+				err = fmt.Errorf("err writing range %d: %w", i, err)
+				ranges.fail(err)
+				return err
+			}
+			pos += uint64(n)
+			if !ranges.add(i, gen, n) {
+				return fmt.Errorf("range %d taken over by another connection", i)
+			}
+			tracker.Add(n)
+		}
+		if err != nil {
+			return fmt.Errorf("err receiving range %d: %w", i, err)
+		}
+	}
+
+	// CONFIRM THE RANGE
+	if _, err := c.Write([]byte{0}); err != nil {
+		return fmt.Errorf("err confirming range %d: %w", i, err)
+	}
+	ranges.confirm(i)
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package receiver
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// WithAllowedPeers only takes senders whose address is in one of prefixes,
+// such as "192.168.1.0/24" or "10.0.0.5/32": announcements from any other
+// address are ignored, so they are never connected to, and connections to
+// the web listener from them are dropped before anything is read. A sender
+// in both the allowed and the denied prefixes is denied. Empty, the
+// default, allows every address that isn't denied. A fixed peer is always
+// connected to.
+func WithAllowedPeers(prefixes ...string) Option {
+	return func(r *Receiver) {
+		r.allowedPeers = append(r.allowedPeers, prefixes...)
+	}
+}
+
+// WithDeniedPeers ignores the senders whose address is in one of prefixes,
+// the way WithAllowedPeers ignores those outside its prefixes.
+func WithDeniedPeers(prefixes ...string) Option {
+	return func(r *Receiver) {
+		r.deniedPeers = append(r.deniedPeers, prefixes...)
+	}
+}
+
+// peerFilter is the parsed WithAllowedPeers and WithDeniedPeers prefixes.
+type peerFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func newPeerFilter(allow, deny []string) (peerFilter, error) {
+	var f peerFilter
+	var err error
+	if f.allow, err = parsePrefixes(allow); err != nil {
+		return peerFilter{}, fmt.Errorf("invalid allowed peer: %w", err)
+	}
+	if f.deny, err = parsePrefixes(deny); err != nil {
+		return peerFilter{}, fmt.Errorf("invalid denied peer: %w", err)
+	}
+
+	return f, nil
+}
+
+func parsePrefixes(prefixes []string) ([]netip.Prefix, error) {
+	parsed := make([]netip.Prefix, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		p, err := netip.ParsePrefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, p.Masked())
+	}
+	return parsed, nil
+}
+
+// check reports whether the sender at addr, a host:port with an IP host, is
+// let through, and otherwise the rule that rejected it.
+func (f peerFilter) check(addr string) (bool, string) {
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return true, ""
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false, "address is not an IP"
+	}
+	// IPv4 senders reached over IPv6 sockets match IPv4 prefixes
+	ip = ip.Unmap().WithZone("")
+
+	for _, prefix := range f.deny {
+		if prefix.Contains(ip) {
+			return false, "denied " + prefix.String()
+		}
+	}
+	if len(f.allow) == 0 {
+		return true, ""
+	}
+	for _, prefix := range f.allow {
+		if prefix.Contains(ip) {
+			return true, ""
+		}
+	}
+	return false, "not in the allowed peers"
+}
+
+// filteredListener drops the connections its filter doesn't let through,
+// and those not through the WithInterface interface, as they are accepted,
+// logging each with the rule that rejected it.
+type filteredListener struct {
+	net.Listener
+	r *Receiver
+}
+
+func (l filteredListener) Accept() (net.Conn, error) {
+	for {
+		con, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if ok, rule := l.r.peerFilter.check(con.RemoteAddr().String()); !ok {
+			l.r.logger.Info("dropping connection from rejected peer", "peer", con.RemoteAddr(), "rule", rule)
+			con.Close()
+			continue
+		}
+		if !l.r.carries(con) {
+			l.r.logger.Info("dropping connection not through the interface", "peer", con.RemoteAddr(), "iface", l.r.ifaceName)
+			con.Close()
+			continue
+		}
+		return con, nil
+	}
+}
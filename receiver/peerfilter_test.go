@@ -0,0 +1,78 @@
+package receiver
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeerFilterCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow, deny []string
+		addr        string
+		want        bool
+	}{
+		{"no rules", nil, nil, "203.0.113.9:9000", true},
+		{"no rules, not an IP", nil, nil, "sender.local:9000", true},
+		{"allowed", []string{"192.168.1.0/24"}, nil, "192.168.1.20:9000", true},
+		{"not allowed", []string{"192.168.1.0/24"}, nil, "192.168.2.20:9000", false},
+		{"denied", nil, []string{"10.0.0.5/32"}, "10.0.0.5:9000", false},
+		{"not denied", nil, []string{"10.0.0.5/32"}, "10.0.0.6:9000", true},
+		{"allowed and denied", []string{"10.0.0.0/8"}, []string{"10.0.0.5/32"}, "10.0.0.5:9000", false},
+		{"allowed next to a denied one", []string{"10.0.0.0/8"}, []string{"10.0.0.5/32"}, "10.0.0.4:9000", true},
+		{"mapped IPv4 allowed", []string{"192.168.1.0/24"}, nil, "[::ffff:192.168.1.20]:9000", true},
+		{"mapped IPv4 denied", nil, []string{"192.168.1.0/24"}, "[::ffff:192.168.1.20]:9000", false},
+		{"zoned IPv6", []string{"fe80::/10"}, nil, "[fe80::1%eth0]:9000", true},
+		{"without a port", []string{"192.168.1.0/24"}, nil, "192.168.1.20", true},
+		{"not an IP", []string{"192.168.1.0/24"}, nil, "sender.local:9000", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newPeerFilter(tt.allow, tt.deny)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, rule := f.check(tt.addr); got != tt.want {
+				t.Errorf("check(%q) = %t (%s), want %t", tt.addr, got, rule, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidPeerPrefixes(t *testing.T) {
+	for _, opt := range []Option{WithAllowedPeers("192.168.1.0/33"), WithDeniedPeers("sender.local")} {
+		if _, err := New(opt); err == nil {
+			t.Error("New accepted an invalid prefix")
+		}
+	}
+}
+
+func TestDeniedAnnouncementIsNeverDialed(t *testing.T) {
+	senders, peers := twoSenders(t)
+	denied := peers[1]
+	var logs bytes.Buffer
+	r, dest := newTestReceiver(t,
+		// the denied sender keeps announcing itself
+		WithDiscoverers(fakeDiscoverer{peers: []Peer{denied, peers[0], denied, denied}}),
+		WithDialer(senders.dial),
+		WithDiscoveryWindow(100*time.Millisecond),
+		WithDeniedPeers("192.0.2.2/32"),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+
+	if _, err := receiveDiscovered(t, r); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if n := senders.dialed(denied.Addr); n != 0 {
+		t.Errorf("denied sender dialed %d times", n)
+	}
+	assertOnlyFile(t, dest, "a.txt")
+	assertFile(t, filepath.Join(dest, "a.txt"), []byte("from a"))
+	if n := strings.Count(logs.String(), "ignoring rejected peer"); n != 1 {
+		t.Errorf("rejection logged %d times, want once for the address", n)
+	}
+}
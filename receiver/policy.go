@@ -0,0 +1,47 @@
+package receiver
+
+import "fmt"
+
+// OverwritePolicy decides what the receiver does when the destination path of
+// an incoming file already exists.
+type OverwritePolicy int
+
+const (
+	// PolicyRename saves the file as "name (n).ext" using the lowest free n.
+	// This is the default.
+	PolicyRename OverwritePolicy = iota
+	// PolicySkip leaves the existing file alone and discards the incoming
+	// content.
+	PolicySkip
+	// PolicyOverwrite replaces the existing file.
+	PolicyOverwrite
+	// PolicyError aborts the transfer with ErrFileExists.
+	PolicyError
+)
+
+var overwritePolicyNames = map[OverwritePolicy]string{
+	PolicyRename:    "rename",
+	PolicySkip:      "skip",
+	PolicyOverwrite: "overwrite",
+	PolicyError:     "error",
+}
+
+func (p OverwritePolicy) String() string {
+	if name, ok := overwritePolicyNames[p]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("OverwritePolicy(%d)", int(p))
+}
+
+// ParseOverwritePolicy maps "rename", "skip", "overwrite" or "error" to the
+// corresponding policy.
+func ParseOverwritePolicy(name string) (OverwritePolicy, error) {
+	for policy, policyName := range overwritePolicyNames {
+		if policyName == name {
+			return policy, nil
+		}
+	}
+
+	return PolicyRename, fmt.Errorf("unknown overwrite policy: %s", name)
+}
@@ -0,0 +1,101 @@
+package receiver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// policyTransfer sends a file named a.txt holding incoming to a receiver
+// under policy whose destination already holds an a.txt with existing, and
+// returns the result and the destination directory.
+func policyTransfer(t *testing.T, policy OverwritePolicy, existing, incoming []byte) (pipeResult, string) {
+	t.Helper()
+
+	path := writeTestFile(t, t.TempDir(), "a.txt", incoming)
+	r, dest := newTestReceiver(t, WithOverwritePolicy(policy))
+	writeTestFile(t, dest, "a.txt", existing)
+
+	return pipeTransfer(t, r, newTestSender(t), path), dest
+}
+
+func TestPolicyRename(t *testing.T) {
+	existing, incoming := []byte("old"), []byte("new content")
+
+	res, dest := policyTransfer(t, PolicyRename, existing, incoming)
+	if res.err != nil || res.senderErr != nil {
+		t.Fatalf("transfer failed: receiver %v, sender %v", res.err, res.senderErr)
+	}
+	assertFile(t, filepath.Join(dest, "a.txt"), existing)
+	assertFile(t, filepath.Join(dest, "a (1).txt"), incoming)
+}
+
+func TestPolicySkip(t *testing.T) {
+	existing, incoming := []byte("old"), []byte("new content")
+
+	res, dest := policyTransfer(t, PolicySkip, existing, incoming)
+	if res.err != nil || res.senderErr != nil {
+		t.Fatalf("transfer failed: receiver %v, sender %v", res.err, res.senderErr)
+	}
+	if len(res.stats) != 0 {
+		t.Errorf("got stats of %d files, want the file skipped", len(res.stats))
+	}
+	assertFile(t, filepath.Join(dest, "a.txt"), existing)
+	assertOnlyFile(t, dest, "a.txt")
+}
+
+func TestPolicyOverwrite(t *testing.T) {
+	existing, incoming := []byte("old"), []byte("new content")
+
+	res, dest := policyTransfer(t, PolicyOverwrite, existing, incoming)
+	if res.err != nil || res.senderErr != nil {
+		t.Fatalf("transfer failed: receiver %v, sender %v", res.err, res.senderErr)
+	}
+	assertFile(t, filepath.Join(dest, "a.txt"), incoming)
+	assertOnlyFile(t, dest, "a.txt")
+}
+
+func TestPolicyError(t *testing.T) {
+	existing, incoming := []byte("old"), []byte("new content")
+
+	res, dest := policyTransfer(t, PolicyError, existing, incoming)
+	if !errors.Is(res.err, ErrFileExists) {
+		t.Fatalf("ReceiveConn = %v, want ErrFileExists", res.err)
+	}
+	if res.senderErr == nil {
+		t.Error("ServeConn succeeded though the receiver refused the file")
+	}
+	assertFile(t, filepath.Join(dest, "a.txt"), existing)
+	assertOnlyFile(t, dest, "a.txt")
+}
+
+// assertOnlyFile fails the test unless name is all dir holds.
+func assertOnlyFile(t *testing.T, dir, name string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != name {
+			t.Errorf("%s found next to %s", entry.Name(), name)
+		}
+	}
+}
+
+func TestParseOverwritePolicy(t *testing.T) {
+	for _, policy := range []OverwritePolicy{PolicyRename, PolicySkip, PolicyOverwrite, PolicyError} {
+		got, err := ParseOverwritePolicy(policy.String())
+		if err != nil || got != policy {
+			t.Errorf("ParseOverwritePolicy(%q) = %v, %v, want %v", policy.String(), got, err, policy)
+		}
+	}
+	if _, err := ParseOverwritePolicy("clobber"); err == nil {
+		t.Error("ParseOverwritePolicy accepted an unknown policy")
+	}
+	if _, err := New(WithOverwritePolicy(PolicyError + 1)); err == nil {
+		t.Error("New accepted an unknown policy")
+	}
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package receiver
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE, which reserves the blocks without
+// changing the file size, so a ".part" file's size keeps telling how much of
+// it has been received.
+const fallocKeepSize = 0x1
+
+// reserveSpace allocates size bytes for file up front, failing with ENOSPC
+// right away when they don't fit. Filesystems without fallocate support are
+// left to allocate as the data arrives.
+func reserveSpace(file *os.File, size int64) error {
+	if size == 0 {
+		return nil
+	}
+
+	err := syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package receiver
+
+import "os"
+
+// reserveSpace is a no-op where there is no portable way to reserve blocks;
+// preallocate's truncate still tells the filesystem the final size.
+func reserveSpace(file *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,82 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/pjmessi/go_file_share/internal/quicconn"
+)
+
+// receiveStreams receives entryCount entries from a sender serving QUIC,
+// each on a stream the sender opens, as many at once as it sends. Once a
+// file fails or is declined, the streams the sender opened before it learned
+// about it are closed right away; the ones in flight are finished and the
+// first error is returned.
+func (r *Receiver) receiveStreams(ctx context.Context, streams *quicconn.Conn, entryCount uint32, state *resumeState) ([]TransferStats, error) {
+	var mu sync.Mutex
+	var stats []TransferStats
+	totalBytesReceived := uint64(0)
+	var failure error
+	declined := false
+
+	var inFlight sync.WaitGroup
+	for i := range entryCount {
+		// ACCEPT THE ENTRY'S STREAM
+		stream, err := streams.AcceptStream(ctx)
+		if err != nil {
+			mu.Lock()
+			// a sender that stopped after a failure or a decline closes
+			// the connection rather than opening further streams
+			if failure == nil && !declined {
+				failure = fmt.Errorf("entry %d of %d: err accepting stream: %w", i+1, entryCount, err)
+			}
+			mu.Unlock()
+			break
+		}
+		mu.Lock()
+		stop := failure != nil || declined
+		mu.Unlock()
+		if stop {
+			stream.Close()
+			continue
+		}
+
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			var con net.Conn = stream
+			defer con.Close()
+			if r.idleTimeout > 0 {
+				con = &idleConn{Conn: con, timeout: r.idleTimeout}
+			}
+
+			fileStats, err := r.receiveRetriedEntry(ctx, newBufferedConn(con), state)
+			mu.Lock()
+			defer mu.Unlock()
+			if fileStats != nil {
+				r.completeEntry(ctx, con, fileStats, state)
+				stats = append(stats, *fileStats)
+				totalBytesReceived += fileStats.Bytes
+			}
+			switch {
+			case errors.Is(err, errDeclined):
+				// declining isn't a failure, the sender has been told
+				declined = true
+			case err != nil && failure == nil:
+				failure = fmt.Errorf("entry %d of %d: %w", i+1, entryCount, err)
+			}
+		}()
+	}
+	inFlight.Wait()
+
+	if failure != nil || declined {
+		return stats, failure
+	}
+
+	r.logger.Info("received entries", "peer", streams.RemoteAddr(), "entries", entryCount, "bytes", totalBytesReceived)
+
+	return stats, nil
+}
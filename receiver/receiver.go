@@ -0,0 +1,1772 @@
+// Package receiver discovers fileshare senders on the local network and
+// receives the files they offer.
+//
+// A Receiver is built with New and functional options, and Receive runs it
+// until the transfer is done or its context is cancelled:
+//
+//	r, err := receiver.New(receiver.WithDestDir("incoming"), receiver.WithPreserveFilename(true))
+//	if err != nil {
+//		return err
+//	}
+//	stats, err := r.Receive(ctx)
+//
+// WithProgress reports the progress of every file, and WithAcceptFunc decides
+// which files to take. Failures can be told apart with errors.Is against the
+// Err variables of this package, and a failing sender is reported as a
+// *PeerError. The wire format is described by package protocol.
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/chunk"
+	"github.com/pjmessi/go_file_share/internal/events"
+	"github.com/pjmessi/go_file_share/internal/metrics"
+	"github.com/pjmessi/go_file_share/internal/netif"
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/internal/quicconn"
+	"github.com/pjmessi/go_file_share/internal/ratelimit"
+	"github.com/pjmessi/go_file_share/internal/sockopt"
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/pjmessi/go_file_share/retry"
+)
+
+// defaultWriteBufferSize is the default size of the destination file buffer
+const defaultWriteBufferSize = 256 << 10
+
+// Content is read in chunks that start at chunkSize and adapt between the
+// bounds set with WithChunkSizeBounds, by default these.
+const (
+	chunkSize           = 32 << 10
+	defaultMinChunkSize = 4 << 10
+	defaultMaxChunkSize = 1 << 20
+)
+
+// Receiver receives files from fileshare senders. Build one with New.
+type Receiver struct {
+	udpDiscoveryPort uint
+	preserveFilename bool
+	strictNames      bool
+	destDir          string
+	overwritePolicy  OverwritePolicy
+	resume           bool
+	tlsInsecure      bool
+	tlsFingerprint   string
+	sharedKey        string
+	preservePerms    bool
+	preserveModTime  bool
+	discoveryTimeout time.Duration
+	discoveryWindow  time.Duration
+	maxPeers         int
+	selectPeers      func(peers []Peer) []Peer
+	allowedPeers     []string
+	deniedPeers      []string
+	peerFilter       peerFilter
+	clockTolerance   time.Duration
+	maxAnnounceAge   time.Duration
+	discoverers      []Discoverer
+	ifaceName        string
+	iface            netif.Interface
+	directPeer       *Peer
+	progress         func(ProgressInfo)
+	daemon           bool
+	maxFiles         int
+	maxConcurrent    int
+	drainTimeout     time.Duration
+	idleTimeout      time.Duration
+	onTransferError  func(Peer, error)
+	accept           AcceptFunc
+	selectEntries    SelectFunc
+	sink             Sink
+	historyFile      string
+	nameTemplate     string
+	keepPartials     bool
+	fsync            bool
+	checkSpace       bool
+	metricsAddr      string
+	webAddr          string
+	webhookURL       string
+	webhookSecret    string
+	notifier         Notifier
+	events           Events
+	limiter          *ratelimit.Limiter
+	maxFileSize      uint64
+	maxNameLength    uint16
+	writeBufferSize  int
+	chunkMin         int
+	chunkMax         int
+	socket           sockopt.Options
+	logger           *slog.Logger
+	dialer           func(ctx context.Context, network, addr string) (net.Conn, error)
+	dialRetry        retry.Policy
+	reconnects       int
+	extract          bool
+
+	// freeSpace reports the bytes available in a directory, replaceable to
+	// fake a full disk
+	freeSpace func(dir string) (uint64, error)
+	// listenDatagrams listens for FlagUDP content, replaceable to lose and
+	// reorder datagrams
+	listenDatagrams func(addr *net.UDPAddr) (net.PacketConn, error)
+
+	// closed is cancelled by Close
+	closed context.Context
+	close  context.CancelFunc
+
+	// deliveries tracks the webhooks and notifications in flight, which
+	// Receive and ReceiveConn wait for before returning
+	deliveries sync.WaitGroup
+
+	// metrics are always kept, and served if metricsAddr is set
+	metrics *receiverMetrics
+
+	// eventQueue delivers the callbacks of events
+	eventQueue events.Queue
+
+	// inFlight holds the destination paths of the running transfers
+	inFlight inFlightPaths
+
+	// filesReceived counts the files saved by the running daemon
+	filesReceived atomic.Int64
+
+	// completed holds the sender runs received from by Receive
+	completed completedPeers
+}
+
+// Option configures optional Receiver behaviour.
+type Option func(*Receiver)
+
+// WithDiscoveryPort listens for announcements on port. The default is
+// protocol.DefaultDiscoveryPort. It only changes the default discovery
+// backend, not those given with WithDiscoverers.
+func WithDiscoveryPort(port uint) Option {
+	return func(r *Receiver) {
+		r.udpDiscoveryPort = port
+	}
+}
+
+// WithPreserveFilename saves received files under the basename transmitted by
+// the sender instead of a unix timestamp. The timestamp name is still used
+// when nothing is left of the sender's name after sanitizing it.
+func WithPreserveFilename(preserve bool) Option {
+	return func(r *Receiver) {
+		r.preserveFilename = preserve
+	}
+}
+
+// WithDestDir saves received files into dir instead of the current working
+// directory. The directory is created if it does not exist yet.
+func WithDestDir(dir string) Option {
+	return func(r *Receiver) {
+		r.destDir = dir
+	}
+}
+
+// WithOverwritePolicy decides what happens when a received file would land on
+// an existing path. The default is PolicyRename.
+func WithOverwritePolicy(policy OverwritePolicy) Option {
+	return func(r *Receiver) {
+		r.overwritePolicy = policy
+	}
+}
+
+// WithResume continues interrupted transfers from the ".part" file they left
+// behind instead of starting over, and keeps the ".part" file around when a
+// transfer is interrupted. It only has an effect together with
+// WithPreserveFilename, since generated names never match a previous attempt.
+func WithResume(resume bool) Option {
+	return func(r *Receiver) {
+		r.resume = resume
+	}
+}
+
+// WithSync makes a saved file durable before it is confirmed to the sender
+// and recorded in the history file: its content is synced to disk before it
+// is closed, and its directory after it was renamed into place, so a
+// reported success survives a power cut. Every file then waits for the disk
+// to flush, about 0.3ms more per 64 KiB file on an SSD and tens of
+// milliseconds on a spinning disk or a network file system, so it is off by
+// default. It has no effect on a Sink.
+func WithSync(sync bool) Option {
+	return func(r *Receiver) {
+		r.fsync = sync
+	}
+}
+
+// WithDiskSpaceCheck decides whether a file is refused up front when the
+// destination filesystem has less free space than its size plus a margin of
+// 64 MiB, failing with ErrInsufficientSpace rather than after the bandwidth
+// was spent. It is on by default; turn it off when files end up smaller on
+// disk than their size, e.g. sparse files or a compressing filesystem.
+// Filesystems that can't report their free space are never refused.
+func WithDiskSpaceCheck(check bool) Option {
+	return func(r *Receiver) {
+		r.checkSpace = check
+	}
+}
+
+// WithKeepPartials renames the ".part" file of a failed transfer to
+// "<name>.failed" rather than removing it, e.g. to inspect what arrived.
+// Partial files kept to resume from under WithResume stay as they are.
+func WithKeepPartials(keep bool) Option {
+	return func(r *Receiver) {
+		r.keepPartials = keep
+	}
+}
+
+// WithTLSInsecure accepts any certificate from senders that require TLS. The
+// connection is still encrypted but the sender is not authenticated.
+func WithTLSInsecure(insecure bool) Option {
+	return func(r *Receiver) {
+		r.tlsInsecure = insecure
+	}
+}
+
+// WithTLSFingerprint only accepts a TLS sender whose certificate has the given
+// SHA-256 fingerprint, as logged by the sender on startup.
+func WithTLSFingerprint(fingerprint string) Option {
+	return func(r *Receiver) {
+		r.tlsFingerprint = fingerprint
+	}
+}
+
+// WithSharedKey only accepts files from senders that prove knowledge of key
+// by answering a random challenge with its HMAC-SHA256. Announcements that
+// aren't signed with key are ignored, see WithClockTolerance, so a
+// forged one can't lead the receiver elsewhere.
+func WithSharedKey(key string) Option {
+	return func(r *Receiver) {
+		r.sharedKey = key
+	}
+}
+
+// defaultClockTolerance is how far the time of an announcement may be from
+// ours unless WithClockTolerance says otherwise
+const defaultClockTolerance = 2 * time.Minute
+
+// WithClockTolerance sets how far the time an announcement was sent at may
+// be from the receiver's clock, either way, before it is ignored as
+// replayed or long delayed. The default is 2 minutes, which allows for
+// clocks that are somewhat apart. It bounds signed announcements, see
+// WithSharedKey, and every stamped one, see WithAnnouncementMaxAge.
+func WithClockTolerance(tolerance time.Duration) Option {
+	return func(r *Receiver) {
+		r.clockTolerance = tolerance
+	}
+}
+
+// defaultAnnouncementMaxAge is how much older an announcement may be than
+// the freshest one of its sender unless WithAnnouncementMaxAge says
+// otherwise
+const defaultAnnouncementMaxAge = 10 * time.Second
+
+// WithAnnouncementMaxAge ignores announcements sent more than maxAge before
+// the freshest one heard from the same address, such as those a switch
+// held back, and those further from the receiver's clock than
+// WithClockTolerance allows, such as those of a sender that exited long
+// ago, so neither is connected to. How far the sender's clock is from ours
+// is learned from its freshest announcement. Announcements of senders that
+// don't stamp them are always taken. The default is 10 seconds, zero takes
+// every announcement however old.
+func WithAnnouncementMaxAge(maxAge time.Duration) Option {
+	return func(r *Receiver) {
+		r.maxAnnounceAge = maxAge
+	}
+}
+
+// WithPreservePermissions applies the permission bits sent by the sender to
+// received files. It is enabled by default and ignored on Windows.
+func WithPreservePermissions(preserve bool) Option {
+	return func(r *Receiver) {
+		r.preservePerms = preserve
+	}
+}
+
+// WithPreserveModTime sets the modification time of received files to the
+// one they had on the sender instead of the time they arrived. It is enabled
+// by default.
+func WithPreserveModTime(preserve bool) Option {
+	return func(r *Receiver) {
+		r.preserveModTime = preserve
+	}
+}
+
+// WithDiscoveryTimeout gives up discovery with ErrDiscoveryTimeout if no
+// sender announced itself within timeout. Zero, the default, waits forever.
+func WithDiscoveryTimeout(timeout time.Duration) Option {
+	return func(r *Receiver) {
+		r.discoveryTimeout = timeout
+	}
+}
+
+// WithDiscoveryWindow keeps listening for window after the first sender
+// announced itself and then receives from every distinct sender heard, one
+// after the other. Zero, the default, only uses the first sender.
+func WithDiscoveryWindow(window time.Duration) Option {
+	return func(r *Receiver) {
+		r.discoveryWindow = window
+	}
+}
+
+// WithMaxPeers ends the discovery window early once max distinct senders
+// have been found. Zero means no limit.
+func WithMaxPeers(max int) Option {
+	return func(r *Receiver) {
+		r.maxPeers = max
+	}
+}
+
+// WithSelectPeers passes the senders discovery found, in the order they
+// were found, to fn, which returns those to receive from, e.g. after asking
+// the user. Returning none ends Receive without an error. Daemon mode and a
+// fixed peer don't ask. The default receives from every sender found.
+func WithSelectPeers(fn func(peers []Peer) []Peer) Option {
+	return func(r *Receiver) {
+		r.selectPeers = fn
+	}
+}
+
+// WithDiscoverers replaces the discovery backends. By default only a
+// BroadcastDiscoverer on the discovery port is used.
+func WithDiscoverers(discoverers ...Discoverer) Option {
+	return func(r *Receiver) {
+		r.discoverers = discoverers
+	}
+}
+
+// WithPeer skips discovery and connects straight to the sender at addr, a
+// host:port pair. A host name resolving to several addresses is tried address
+// by address, in order, until one accepts. useTLS has to match the sender's
+// setting since there is no announcement to learn it from.
+func WithPeer(addr string, useTLS bool) Option {
+	return func(r *Receiver) {
+		r.directPeer = &Peer{Addr: addr, TLS: useTLS}
+	}
+}
+
+// WithPeerQUIC is WithPeer for a sender serving QUIC, see
+// sender.TransportQUIC. Its certificate is verified as configured with
+// WithTLSFingerprint or WithTLSInsecure.
+func WithPeerQUIC(addr string) Option {
+	return func(r *Receiver) {
+		r.directPeer = &Peer{Addr: addr, QUIC: true}
+	}
+}
+
+// WithDaemon makes Receive keep discovering and receiving from senders
+// instead of returning after the first, until its context is cancelled.
+// Cancelling lets the transfers in flight finish, see WithDrainTimeout.
+// Transfers run concurrently, see WithMaxConcurrent, and a failed one is
+// logged and reported to the WithTransferErrorHandler callback rather than
+// ending the daemon. Senders whose announcement was already served are
+// ignored, so a sender that keeps announcing isn't received from twice. The
+// discovery timeout and window don't apply in daemon mode.
+func WithDaemon(daemon bool) Option {
+	return func(r *Receiver) {
+		r.daemon = daemon
+	}
+}
+
+// WithDrainTimeout bounds how long daemon mode lets the transfers in flight
+// finish once its context is cancelled, after which they are aborted. New
+// senders are no longer received from in the meantime. The default is 30s.
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(r *Receiver) {
+		r.drainTimeout = timeout
+	}
+}
+
+// WithIdleTimeout fails a transfer with ErrIdleTimeout once its connection
+// made no progress for timeout, e.g. because the sender hangs. Slow transfers
+// are fine as long as data keeps moving. The default is 2 minutes, zero waits
+// forever.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(r *Receiver) {
+		r.idleTimeout = timeout
+	}
+}
+
+// AcceptFunc decides whether an incoming file is received. It is passed the
+// name the sender gave the file, its size, or -1 for content streamed without
+// a size known up front, and the sender's address, and is
+// called before anything is written. In daemon mode, and for senders serving
+// QUIC, it may be called concurrently.
+type AcceptFunc func(name string, size int64, peer string) bool
+
+// AcceptAll accepts every file.
+func AcceptAll(string, int64, string) bool {
+	return true
+}
+
+// WithAcceptFunc asks fn about every incoming file. Declining one tells the
+// sender and ends the transfer from it without an error; the files received
+// until then are kept. The default is AcceptAll.
+func WithAcceptFunc(fn AcceptFunc) Option {
+	return func(r *Receiver) {
+		r.accept = fn
+	}
+}
+
+// WithMaxFiles ends daemon mode once max files have been received, after
+// the transfer that reached the limit. Zero means no limit.
+func WithMaxFiles(max int) Option {
+	return func(r *Receiver) {
+		r.maxFiles = max
+	}
+}
+
+// WithMaxConcurrent limits how many transfers daemon mode runs at once;
+// further senders wait for a free slot. The default is 4.
+func WithMaxConcurrent(max int) Option {
+	return func(r *Receiver) {
+		r.maxConcurrent = max
+	}
+}
+
+// WithTransferErrorHandler calls fn with the sender and the error of every
+// transfer that fails in daemon mode. fn may be called concurrently.
+func WithTransferErrorHandler(fn func(Peer, error)) Option {
+	return func(r *Receiver) {
+		r.onTransferError = fn
+	}
+}
+
+// WithRateLimit caps how fast file content is received, in bytes per second.
+// The cap is shared by concurrent transfers. With compression the network
+// carries less than that. Zero means unlimited.
+func WithRateLimit(bytesPerSec int64) Option {
+	return func(r *Receiver) {
+		r.limiter = ratelimit.New(bytesPerSec)
+	}
+}
+
+// WithMaxFileSize rejects files larger than max bytes before anything is
+// written, telling the sender why, and fails the transfer with
+// ErrFileTooLarge. Zero means unlimited.
+func WithMaxFileSize(max uint64) Option {
+	return func(r *Receiver) {
+		r.maxFileSize = max
+	}
+}
+
+// WithMaxNameLength rejects entry names longer than max bytes with
+// protocol.ErrInvalidFrame. The default is protocol.DefaultMaxNameLength.
+func WithMaxNameLength(max uint16) Option {
+	return func(r *Receiver) {
+		r.maxNameLength = max
+	}
+}
+
+// WithSink streams the content of received files into the writers sink opens
+// instead of saving them in the destination directory. The destination
+// directory, the overwrite policy, resume and the preserve options don't
+// apply then, and directory entries are skipped since there is nothing to
+// write for them; empty directories are lost. Checksums are verified, and
+// progress and stats reported, as for files on disk. The stats carry an empty
+// Path.
+func WithSink(sink Sink) Option {
+	return func(r *Receiver) {
+		r.sink = sink
+	}
+}
+
+// WithStrictNames rejects entry names that aren't valid UTF-8 with
+// ErrInvalidFileName, ending the transfer, instead of percent-encoding their
+// invalid bytes.
+func WithStrictNames(strict bool) Option {
+	return func(r *Receiver) {
+		r.strictNames = strict
+	}
+}
+
+// WithWriteBufferSize sets how many bytes are buffered before they are
+// written to the destination file. The default is 256 KiB.
+func WithWriteBufferSize(size int) Option {
+	return func(r *Receiver) {
+		r.writeBufferSize = size
+	}
+}
+
+// WithChunkSizeBounds lets the size content is read from the connection in
+// adapt to the throughput it achieves, starting at 32 KiB, between min and
+// max bytes. The default bounds are 4 KiB and 1 MiB; min equal to max keeps
+// the size fixed.
+func WithChunkSizeBounds(min, max int) Option {
+	return func(r *Receiver) {
+		r.chunkMin, r.chunkMax = min, max
+	}
+}
+
+// WithLogger sends the receiver's log messages to logger instead of
+// slog.Default(). Per connection details are logged at Debug level. The
+// discovery backends take a logger of their own.
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *Receiver) {
+		if logger != nil {
+			r.logger = logger
+		}
+	}
+}
+
+// WithDialer connects to senders through dial instead of a net.Dialer, e.g.
+// to run transfers over in-memory connections. TLS is still layered on top
+// for senders that require it. Senders serving QUIC are dialed over UDP
+// regardless.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(r *Receiver) {
+		r.dialer = dial
+	}
+}
+
+// WithDialRetry retries connecting to a sender according to policy, since a
+// sender may announce itself before its listener accepts connections. Only
+// the connection is retried, not a failing TLS handshake. The default is
+// retry.Default; the zero Policy tries once.
+func WithDialRetry(policy retry.Policy) Option {
+	return func(r *Receiver) {
+		r.dialRetry = policy
+	}
+}
+
+// WithReconnect reconnects to a sender up to attempts times when the
+// connection is lost mid-transfer, e.g. while roaming between access points,
+// instead of failing. The interrupted file continues from its ".part" file,
+// which needs WithResume and WithPreserveFilename unless a sink is used, and
+// files saved before the connection dropped aren't received again. Zero, the
+// default, never reconnects.
+func WithReconnect(attempts int) Option {
+	return func(r *Receiver) {
+		r.reconnects = attempts
+	}
+}
+
+// ProgressInfo describes the transfer of a single file.
+type ProgressInfo = progress.Info
+
+// WithProgress calls fn with the progress of every file being received, at
+// most every 100ms and once more with Done set when its transfer ends. fn
+// runs on a separate goroutine; reports arriving while it is busy are
+// dropped.
+func WithProgress(fn func(ProgressInfo)) Option {
+	return func(r *Receiver) {
+		r.progress = fn
+	}
+}
+
+// DiscoveryPort returns the UDP port senders are expected to announce on.
+func (r *Receiver) DiscoveryPort() uint {
+	return r.udpDiscoveryPort
+}
+
+// New returns a Receiver configured by opts. It fails if the options
+// contradict each other or are out of range.
+func New(opts ...Option) (*Receiver, error) {
+	r := newReceiver(opts)
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// NewReceiver returns a Receiver listening for announcements on
+// udpDiscoveryPort. chunkSize is ignored, content is read in chunks of an
+// adaptive size, see WithChunkSizeBounds.
+//
+// Deprecated: use New with WithDiscoveryPort, which also validates the
+// options.
+func NewReceiver(chunkSize, udpDiscoveryPort uint, opts ...Option) *Receiver {
+	return newReceiver(append([]Option{WithDiscoveryPort(udpDiscoveryPort)}, opts...))
+}
+
+func newReceiver(opts []Option) *Receiver {
+	r := &Receiver{
+		udpDiscoveryPort: protocol.DefaultDiscoveryPort,
+		preservePerms:    true,
+		preserveModTime:  true,
+		maxNameLength:    protocol.DefaultMaxNameLength,
+		writeBufferSize:  defaultWriteBufferSize,
+		chunkMin:         defaultMinChunkSize,
+		chunkMax:         defaultMaxChunkSize,
+		drainTimeout:     defaultDrainTimeout,
+		idleTimeout:      defaultIdleTimeout,
+		clockTolerance:   defaultClockTolerance,
+		maxAnnounceAge:   defaultAnnouncementMaxAge,
+		dialRetry:        retry.Default,
+		metrics:          newReceiverMetrics(),
+		checkSpace:       true,
+		freeSpace:        diskFreeSpace,
+		listenDatagrams:  listenUDP,
+		accept:           AcceptAll,
+		logger:           slog.Default(),
+	}
+	r.closed, r.close = context.WithCancel(context.Background())
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	// the default backend depends on the configured port
+	if r.discoverers == nil {
+		r.discoverers = []Discoverer{BroadcastDiscoverer{Port: r.udpDiscoveryPort}}
+	}
+
+	return r
+}
+
+// validate reports the first contradictory or out of range option.
+func (r *Receiver) validate() error {
+	switch {
+	case r.udpDiscoveryPort == 0 || r.udpDiscoveryPort > 65535:
+		return fmt.Errorf("invalid discovery port: %d", r.udpDiscoveryPort)
+	case r.overwritePolicy < PolicyRename || r.overwritePolicy > PolicyError:
+		return fmt.Errorf("invalid overwrite policy: %d", r.overwritePolicy)
+	case r.daemon && r.directPeer != nil:
+		return errors.New("daemon mode needs discovery, it can't be combined with a fixed peer")
+	case r.directPeer == nil && len(r.discoverers) == 0:
+		return errors.New("no discovery backend configured")
+	case r.discoveryTimeout < 0 || r.discoveryWindow < 0 || r.drainTimeout < 0 || r.idleTimeout < 0:
+		return errors.New("timeouts and the discovery window can't be negative")
+	case r.clockTolerance <= 0:
+		return errors.New("clock tolerance must be positive")
+	case r.maxAnnounceAge < 0:
+		return errors.New("maximum announcement age can't be negative")
+	case r.maxPeers < 0 || r.maxFiles < 0 || r.maxConcurrent < 0 || r.reconnects < 0:
+		return errors.New("peer, file, concurrency and reconnect limits can't be negative")
+	case r.reconnects > 0 && r.sink == nil && !(r.resume && r.preserveFilename):
+		return errors.New("reconnecting needs resume and preserved file names to continue from the partial file")
+	case r.accept == nil:
+		return errors.New("no accept func configured")
+	case r.maxNameLength == 0:
+		return errors.New("maximum name length must be positive")
+	case r.writeBufferSize <= 0:
+		return errors.New("write buffer size must be positive")
+	case r.chunkMin <= 0 || r.chunkMin > r.chunkMax:
+		return fmt.Errorf("chunk size bounds must be positive and in order: %d, %d", r.chunkMin, r.chunkMax)
+	case r.socket.ReadBuffer < 0 || r.socket.WriteBuffer < 0:
+		return fmt.Errorf("socket buffer sizes can't be negative: %d, %d", r.socket.ReadBuffer, r.socket.WriteBuffer)
+	}
+
+	if err := r.dialRetry.Validate(); err != nil {
+		return err
+	}
+
+	if r.directPeer != nil {
+		if _, _, err := net.SplitHostPort(r.directPeer.Addr); err != nil {
+			return fmt.Errorf("invalid peer address: %w", err)
+		}
+	}
+	if err := validateNameTemplate(r.nameTemplate); err != nil {
+		return err
+	}
+	var err error
+	if r.peerFilter, err = newPeerFilter(r.allowedPeers, r.deniedPeers); err != nil {
+		return err
+	}
+	if err := r.lookupInterface(); err != nil {
+		return err
+	}
+	if r.webhookURL != "" {
+		if err := validateWebhookURL(r.webhookURL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Receive discovers a sender, or uses the one given with WithPeer, and receives
+// its files. In daemon mode it keeps doing so, see WithDaemon. Discovery passes
+// over the senders an earlier Receive received from as long as they announce
+// the same run, so their files aren't fetched twice. It returns the stats of
+// every file saved, also when it fails part way. Cancelling ctx stops
+// discovery or aborts the running transfer, removing its partial file unless
+// resume is enabled, and makes Receive return an error wrapping ctx.Err().
+// Close has the same effect.
+func (r *Receiver) Receive(ctx context.Context) ([]TransferStats, error) {
+	// receivers from the deprecated constructor haven't been validated yet
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	defer r.deliveries.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopClose := context.AfterFunc(r.closed, cancel)
+	defer stopClose()
+
+	if r.metricsAddr != "" {
+		err := metrics.Serve(ctx, r.metricsAddr, &r.metrics.registry, func(err error) {
+			r.logger.Error("metrics listener failed", "err", err)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// fail before discovery rather than after the sender started streaming
+	if err := r.prepareDestDir(); err != nil {
+		return nil, fmt.Errorf("err preparing destination directory: %w", err)
+	}
+
+	if r.webAddr == "" {
+		return r.receive(ctx)
+	}
+	uploads, err := r.serveWeb(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := r.receive(ctx)
+	stats = append(stats, uploads.stop(r)...)
+
+	return stats, err
+}
+
+// receive is Receive once the receiver is set up.
+func (r *Receiver) receive(ctx context.Context) ([]TransferStats, error) {
+	if r.daemon {
+		return r.serve(ctx)
+	}
+
+	peers, err := r.findPeers(ctx)
+	if err != nil {
+		r.reportError(ctx, err)
+		return nil, fmt.Errorf("err searching for discovery msg: %w", err)
+	}
+
+	// one failing sender must not keep us from receiving from the others
+	var stats []TransferStats
+	var errs []error
+	for _, peer := range peers {
+		peerStats, err := r.receiveFromPeer(ctx, peer)
+		stats = append(stats, peerStats...)
+		if err != nil {
+			if ctx.Err() != nil {
+				return stats, err
+			}
+
+			r.logger.Error("err receiving from peer", "peer", peer.Addr, "err", err)
+			peerErr := &PeerError{Addr: peer.Addr, Err: err}
+			r.reportError(ctx, peerErr)
+			errs = append(errs, peerErr)
+			continue
+		}
+		r.completed.add(peer)
+	}
+
+	return stats, errors.Join(errs...)
+}
+
+// Handle is the former name of Receive.
+//
+// Deprecated: use Receive.
+func (r *Receiver) Handle(ctx context.Context) ([]TransferStats, error) {
+	return r.Receive(ctx)
+}
+
+// receiveFromPeer connects to a single sender and receives everything it
+// sends. A connection lost mid-transfer is reconnected as configured with
+// WithReconnect.
+func (r *Receiver) receiveFromPeer(ctx context.Context, peer Peer) ([]TransferStats, error) {
+	var state *resumeState
+	if r.reconnects > 0 {
+		state = newResumeState()
+	}
+
+	var stats []TransferStats
+	for attempt := 0; ; attempt++ {
+		// CONNECT TO SENDER
+		con, err := r.dial(ctx, peer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return stats, fmt.Errorf("connecting to %s: %w", peer.Addr, ctx.Err())
+			}
+			return stats, fmt.Errorf("err connecting to peer: %w", err)
+		}
+
+		r.logger.Debug("connected to peer", "peer", peer.Addr)
+
+		sessionStats, err := r.receiveConn(ctx, con, state)
+		stats = append(stats, sessionStats...)
+		if err == nil || ctx.Err() != nil || attempt >= r.reconnects || !connectionLost(err) {
+			return stats, err
+		}
+
+		r.logger.Warn("connection to sender lost, reconnecting", "peer", peer.Addr, "attempt", attempt+1, "of", r.reconnects, "err", err)
+	}
+}
+
+// Close stops the receiver as if the context of its running Receive or
+// ReceiveConn calls was cancelled. Calls made after Close fail right away.
+func (r *Receiver) Close() error {
+	r.close()
+	return nil
+}
+
+// ReceiveConn receives everything the sender at the other end of con sends,
+// the way Receive does once it is connected, and closes con. It lets the
+// transfer run over any connection, e.g. one end of a net.Pipe whose other
+// end is passed to the sender's ServeConn.
+func (r *Receiver) ReceiveConn(ctx context.Context, con net.Conn) ([]TransferStats, error) {
+	if err := r.validate(); err != nil {
+		con.Close()
+		return nil, err
+	}
+	if err := r.prepareDestDir(); err != nil {
+		con.Close()
+		return nil, fmt.Errorf("err preparing destination directory: %w", err)
+	}
+	defer r.deliveries.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopClose := context.AfterFunc(r.closed, cancel)
+	defer stopClose()
+
+	stats, err := r.receiveConn(ctx, con, nil)
+	if err != nil {
+		r.reportError(ctx, &PeerError{Addr: con.RemoteAddr().String(), Err: err})
+	}
+
+	return stats, err
+}
+
+func (r *Receiver) receiveConn(ctx context.Context, con net.Conn, state *resumeState) ([]TransferStats, error) {
+	defer con.Close()
+
+	// over QUIC the entries arrive on streams of their own
+	streams, _ := con.(*quicconn.Conn)
+
+	if r.idleTimeout > 0 {
+		con = &idleConn{Conn: con, timeout: r.idleTimeout}
+	}
+
+	// RECEIVE FILES FROM SENDER
+	// closing the connection unblocks any pending read when ctx is done
+	stopClosing := context.AfterFunc(ctx, func() { con.Close() })
+	stats, err := r.receiveFiles(ctx, con, streams, state)
+	stopClosing()
+	if ctx.Err() != nil {
+		return stats, fmt.Errorf("transfer from %s interrupted: %w", con.RemoteAddr(), ctx.Err())
+	}
+	if err != nil {
+		return stats, fmt.Errorf("err receiving file: %w", err)
+	}
+
+	if err = con.Close(); err != nil {
+		return stats, fmt.Errorf("err closing connection: %w", err)
+	}
+
+	return stats, nil
+}
+
+// handshake sends our preamble and checks the one the sender answers with.
+// Nothing is read from the sender before its magic has been verified.
+func (r *Receiver) handshake(con net.Conn) error {
+	if err := protocol.WritePreamble(con); err != nil {
+		return fmt.Errorf("err sending preamble: %w", err)
+	}
+
+	senderVersion, err := protocol.ReadPreamble(con)
+	if errors.Is(err, protocol.ErrBadMagic) {
+		return fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+	if err != nil {
+		return fmt.Errorf("err reading preamble: %w", err)
+	}
+
+	if !protocol.Supports(senderVersion) {
+		return fmt.Errorf("%w: %w: sender speaks protocol v%d, this receiver supports %s",
+			ErrProtocol, protocol.ErrIncompatibleVersion, senderVersion, protocol.SupportedVersions())
+	}
+
+	return nil
+}
+
+// capabilities returns what the receiver announces to the sender at the
+// other end of con, short of CapManifest.
+func (r *Receiver) capabilities(con net.Conn) uint32 {
+	caps := protocol.CapCompression | protocol.CapStreaming | protocol.CapRetry
+	if r.sink == nil {
+		// blocks are written to their place in the file, and symlinks
+		// next to files, which a sink doesn't have
+		caps |= protocol.CapBlocks | protocol.CapSymlinks
+	}
+	if r.sideChannelCapable(con) {
+		caps |= protocol.CapUDP | protocol.CapParallel
+	}
+	if r.sharedKey != "" {
+		caps |= protocol.CapAuthRequired
+	}
+
+	return caps
+}
+
+// sendCapabilities announces caps and authenticates the sender if a shared
+// key is configured.
+func (r *Receiver) sendCapabilities(con net.Conn, caps uint32) error {
+	if err := binary.Write(con, binary.LittleEndian, caps); err != nil {
+		return fmt.Errorf("err sending capabilities: %w", err)
+	}
+
+	// AUTHENTICATE SENDER
+	if r.sharedKey != "" {
+		if err := r.authenticate(con); err != nil {
+			r.logger.Warn("rejecting peer", "peer", con.RemoteAddr(), "err", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// receiveFiles receives everything the sender at the other end of con sends.
+// If streams is set, the entries arrive on streams of its connection rather
+// than on con.
+func (r *Receiver) receiveFiles(ctx context.Context, con net.Conn, streams *quicconn.Conn, state *resumeState) ([]TransferStats, error) {
+	con = newBufferedConn(con)
+
+	// EXCHANGE PROTOCOL VERSIONS
+	if err := r.handshake(con); err != nil {
+		return nil, fmt.Errorf("err during handshake: %w", err)
+	}
+
+	// SEND CAPABILITIES
+	caps := r.capabilities(con)
+	if r.selectEntries != nil {
+		caps |= protocol.CapManifest
+	}
+	if err := r.sendCapabilities(con, caps); err != nil {
+		return nil, err
+	}
+
+	// RECEIVE ENTRY COUNT
+	var entryCount uint32
+	if err := binary.Read(con, binary.LittleEndian, &entryCount); err != nil {
+		return nil, fmt.Errorf("err receiving entry count: %w", err)
+	}
+
+	// PICK FROM THE MANIFEST
+	// the count of the entries picked follows the selection
+	if entryCount == protocol.ManifestFollows {
+		if r.selectEntries == nil {
+			return nil, fmt.Errorf("%w: manifest sent unasked", ErrProtocol)
+		}
+		if state == nil {
+			// picked files are marked accepted for this connection
+			state = newResumeState()
+		}
+		if err := r.pickEntries(con, state); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(con, binary.LittleEndian, &entryCount); err != nil {
+			return nil, fmt.Errorf("err receiving entry count: %w", err)
+		}
+	}
+
+	if streams != nil {
+		return r.receiveStreams(ctx, streams, entryCount, state)
+	}
+
+	var stats []TransferStats
+	totalBytesReceived := uint64(0)
+	for i := uint32(0); i < entryCount; i++ {
+		fileStats, err := r.receiveRetriedEntry(ctx, con, state)
+		if fileStats != nil {
+			r.completeEntry(ctx, con, fileStats, state)
+			stats = append(stats, *fileStats)
+			totalBytesReceived += fileStats.Bytes
+		}
+		if errors.Is(err, errDeclined) {
+			// declining isn't a failure, the sender has been told
+			return stats, nil
+		}
+		if err != nil {
+			return stats, fmt.Errorf("entry %d of %d: %w", i+1, entryCount, err)
+		}
+	}
+
+	r.logger.Info("received entries", "peer", con.RemoteAddr(), "entries", entryCount, "bytes", totalBytesReceived)
+
+	return stats, nil
+}
+
+// completeEntry notes the saved file of fileStats, received over con.
+func (r *Receiver) completeEntry(ctx context.Context, con net.Conn, fileStats *TransferStats, state *resumeState) {
+	fileStats.Peer = con.RemoteAddr().String()
+	state.markSaved(fileStats.Name)
+	events.Emit(&r.eventQueue, ctx, r.events.TransferCompleted, *fileStats)
+}
+
+// receiveRetriedEntry is receiveEntry, receiving the entry again for as long
+// as the sender sends it again after saving it failed.
+func (r *Receiver) receiveRetriedEntry(ctx context.Context, con net.Conn, state *resumeState) (*TransferStats, error) {
+	for {
+		fileStats, err := r.receiveEntry(ctx, con, state)
+		var acked ackedError
+		if !errors.As(err, &acked) {
+			return fileStats, err
+		}
+
+		// WAIT FOR THE SENDER'S DECISION
+		// an older sender closes the connection instead
+		var decision uint8
+		if readErr := binary.Read(con, binary.LittleEndian, &decision); readErr != nil {
+			r.logger.Debug("err receiving retry decision", "peer", con.RemoteAddr(), "err", readErr)
+			return nil, err
+		}
+		switch decision {
+		case protocol.RetryGiveUp:
+			return nil, err
+		case protocol.RetryEntry:
+			r.logger.Warn("sender sends the entry again", "peer", con.RemoteAddr(), "err", err)
+		default:
+			return nil, fmt.Errorf("%w: unknown retry decision %d", ErrProtocol, decision)
+		}
+	}
+}
+
+// receiveEntry receives a single entry frame, a file, a directory or a
+// symlink, and
+// returns the stats of the file if one was saved.
+func (r *Receiver) receiveEntry(ctx context.Context, con net.Conn, state *resumeState) (*TransferStats, error) {
+	// RECEIVE ENTRY FRAME
+	frame, err := protocol.ReadFrame(con, r.maxNameLength)
+	if errors.Is(err, protocol.ErrInvalidFrame) {
+		return nil, fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("err receiving entry frame: %w", err)
+	}
+	filePath, err := decodeName(frame.Name, r.strictNames)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+
+	switch frame.Type {
+	case protocol.EntryTypeDir:
+		if r.sink != nil {
+			r.logger.Debug("skipping directory entry, files go to the sink", "name", filePath)
+			return nil, nil
+		}
+		return nil, r.createDestDir(filePath)
+	case protocol.EntryTypeSymlink:
+		link, err := protocol.ReadLink(con, r.maxNameLength)
+		if errors.Is(err, protocol.ErrInvalidFrame) {
+			return nil, fmt.Errorf("%w: %w", ErrProtocol, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("err receiving link target: %w", err)
+		}
+		if r.sink != nil {
+			return nil, fmt.Errorf("%w: symlink sent to a sink", ErrProtocol)
+		}
+		return nil, r.createSymlink(filePath, link)
+	case protocol.EntryTypeFile:
+		return r.receiveFile(ctx, con, filePath, state)
+	default:
+		return nil, fmt.Errorf("%w: unknown entry type %d", ErrProtocol, frame.Type)
+	}
+}
+
+// receiveFile receives the remainder of a file entry after its frame and
+// returns the stats of the saved file, or nil if it was skipped. state tells
+// which files an earlier connection to the sender already took care of. The
+// outcome is recorded in the history file and posted to the webhook, and
+// saved files are announced through the notifier.
+func (r *Receiver) receiveFile(ctx context.Context, con net.Conn, filePath string, state *resumeState) (*TransferStats, error) {
+	// RECEIVE FILE HEADER
+	header, err := protocol.ReadHeader(con)
+	if err != nil {
+		return nil, fmt.Errorf("err receiving file header: %w", err)
+	}
+
+	stats, err := r.receiveFileBody(ctx, con, filePath, header, state)
+	if stats != nil || err != nil {
+		record := newHistoryRecord(con, filePath, header.Size, stats, err)
+		r.recordHistory(record)
+		r.notifyWebhook(record)
+	}
+	if stats != nil {
+		r.notifyDesktop(con, filePath, header.Size)
+	}
+	r.recordMetrics(stats, err)
+
+	return stats, err
+}
+
+// receiveFileBody receives a file entry after its header, see
+// receiveFile.
+func (r *Receiver) receiveFileBody(ctx context.Context, con net.Conn, filePath string, header protocol.Header, state *resumeState) (*TransferStats, error) {
+	contentSize, fileFlags, fileMode, modTime := header.Size, header.Flags, header.Mode, header.ModTime
+
+	// sizes are handled as int64 by the os and io packages
+	if contentSize > math.MaxInt64 {
+		return nil, fmt.Errorf("%w: file size %d out of range", ErrProtocol, contentSize)
+	}
+
+	// Refuse anything we don't understand rather than writing e.g.
+	// compressed bytes to disk as if they were the file.
+	if unknown := fileFlags &^ protocol.KnownFlags; unknown != 0 {
+		return nil, fmt.Errorf("%w: unsupported file flags %#x, the sender needs a newer receiver", ErrProtocol, unknown)
+	}
+	if fileFlags&protocol.FlagBlocks != 0 && (fileFlags&protocol.FlagCompressed != 0 || r.sink != nil) {
+		return nil, fmt.Errorf("%w: blocks sent compressed or to a sink", ErrProtocol)
+	}
+	if fileFlags&protocol.FlagUDP != 0 && (fileFlags != protocol.FlagUDP || !r.sideChannelCapable(con)) {
+		return nil, fmt.Errorf("%w: datagrams sent with other flags or without being offered", ErrProtocol)
+	}
+	if fileFlags&protocol.FlagParallel != 0 && (fileFlags != protocol.FlagParallel || !r.sideChannelCapable(con)) {
+		return nil, fmt.Errorf("%w: parallel connections used with other flags or without being offered", ErrProtocol)
+	}
+	streaming := fileFlags&protocol.FlagStreaming != 0
+	if streaming && (fileFlags&^protocol.FlagArchive != protocol.FlagStreaming || contentSize != 0) {
+		return nil, fmt.Errorf("%w: streamed content sent with other flags or a size", ErrProtocol)
+	}
+	if fileFlags&protocol.FlagArchive != 0 && !streaming {
+		return nil, fmt.Errorf("%w: archive sent without streaming it", ErrProtocol)
+	}
+
+	// an archive that isn't unpacked is saved as one
+	extract := fileFlags&protocol.FlagArchive != 0 && r.extract && r.sink == nil
+	if fileFlags&protocol.FlagArchive != 0 && !extract {
+		filePath += ".tar"
+	}
+
+	// ENFORCE SIZE LIMIT
+	// Content is never read past the advertised size, so checking it here
+	// also caps what a lying sender can make us write. Streamed content is
+	// checked as it arrives.
+	if r.maxFileSize > 0 && contentSize > r.maxFileSize {
+		if err := r.sendReply(con, protocol.Reply{Status: protocol.ReplyTooLarge}); err != nil {
+			return nil, fmt.Errorf("err sending rejection: %w", err)
+		}
+		return nil, fmt.Errorf("%w: %s is %d bytes, the limit is %d", ErrFileTooLarge, filePath, contentSize, r.maxFileSize)
+	}
+
+	// SKIP WHAT AN EARLIER CONNECTION SAVED
+	if state.wasSaved(filePath) {
+		r.logger.Info("skipping file, it was saved before the connection dropped", "file", filePath)
+		if err := r.sendReply(con, protocol.Reply{Status: protocol.ReplySkip}); err != nil {
+			return nil, fmt.Errorf("err sending skip reply: %w", err)
+		}
+		return nil, nil
+	}
+
+	// CHECK FREE SPACE
+	// before asking, so a file that doesn't fit is refused without bothering
+	// anyone or touching the disk; what a ".part" file holds needn't fit
+	// again, and streamed content has no size to check
+	if !streaming && r.sink == nil {
+		dir := r.destDir
+		if dir == "" {
+			dir = "."
+		}
+		if err := r.checkDiskSpace(dir, contentSize-r.heldBytes(filePath, contentSize)); err != nil {
+			if err := r.sendReply(con, protocol.Reply{Status: protocol.ReplyTooLarge}); err != nil {
+				return nil, fmt.Errorf("err sending rejection: %w", err)
+			}
+			return nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+	}
+
+	// ASK WHETHER TO ACCEPT THE FILE
+	// a file accepted before the connection dropped isn't asked for again
+	offeredSize := int64(contentSize)
+	if streaming {
+		offeredSize = -1
+	}
+	if !state.wasAccepted(filePath) && !r.accept(filePath, offeredSize, con.RemoteAddr().String()) {
+		if err := r.sendReply(con, protocol.Reply{Status: protocol.ReplyDeclined}); err != nil {
+			return nil, fmt.Errorf("err sending refusal: %w", err)
+		}
+		r.logger.Info("declined file, ending transfer", "peer", con.RemoteAddr(), "file", filePath, "bytes", contentSize)
+		return nil, errDeclined
+	}
+	state.markAccepted(filePath)
+	start := time.Now()
+	r.metrics.started.Inc()
+	r.metrics.active.Add(1)
+	defer r.metrics.active.Add(-1)
+	events.Emit(&r.eventQueue, ctx, r.events.TransferStarted, FileInfo{Name: filePath, Size: contentSize, Peer: con.RemoteAddr().String()})
+
+	if r.sink != nil {
+		return r.receiveToSink(con, filePath, header, start)
+	}
+	if extract {
+		return r.receiveArchive(con, filePath, start)
+	}
+
+	// PREPARE PATH TO SAVE THE FILE
+	dest, err := r.prepareDestFilePath(filePath, con.RemoteAddr().String())
+	if err != nil {
+		return nil, fmt.Errorf("err preparing dest file path: %w", err)
+	}
+	destFilePath, generated := dest.path, dest.generated
+
+	// RESERVE THE PATH
+	// A concurrent transfer of the same path owns its ".part" file, which
+	// must neither be resumed from nor overwritten. Other policies already
+	// keep off it since they create the file exclusively.
+	reservedPath := destFilePath
+	owned := r.inFlight.claim(reservedPath)
+	if owned {
+		defer r.inFlight.release(reservedPath)
+	} else if r.overwritePolicy == PolicyOverwrite {
+		return nil, fmt.Errorf("%s is being received by another transfer", destFilePath)
+	}
+
+	// CREATE FILE
+	// Content goes to "<name>.part" and is only renamed to its final name
+	// once it has been fully received and verified, so nobody watching the
+	// destination directory ever sees an incomplete file.
+	digest := sha256.New()
+	var file *os.File
+	offset := uint64(0)
+	if r.resume && owned && !generated && !streaming {
+		file, offset, err = r.openResumablePart(destFilePath, contentSize, digest)
+		if err != nil {
+			return nil, fmt.Errorf("err opening partial file: %w", err)
+		}
+	}
+	if file == nil && generated {
+		// a generated name never means to refer to an existing file, so
+		// two files arriving within the same second must not meet the
+		// overwrite policy
+		file, destFilePath, err = createUniqueFile(destFilePath)
+	} else if file == nil {
+		file, destFilePath, err = r.openDestFile(destFilePath)
+	}
+	if errors.Is(err, os.ErrExist) && r.overwritePolicy == PolicySkip {
+		r.logger.Info("skipping file, it already exists", "file", filePath, "path", destFilePath)
+		if err := r.sendReply(con, protocol.Reply{Status: protocol.ReplySkip}); err != nil {
+			return nil, fmt.Errorf("err sending skip reply: %w", err)
+		}
+		return nil, nil
+	}
+	if errors.Is(err, os.ErrExist) && r.overwritePolicy == PolicyError {
+		return nil, fmt.Errorf("%w: %s", ErrFileExists, destFilePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("err creating dest file: %w", err)
+	}
+	partFilePath := file.Name()
+
+	// CLEAN UP ON FAILURE
+	// Whatever goes wrong from here on, a panic included, leaves no file
+	// that could pass for a finished one: the ".part" file is kept to resume
+	// from, kept as ".failed" or removed, see discardPartFile.
+	settled, resumable := false, false
+	defer func() {
+		if !settled {
+			file.Close()
+			r.discardPartFile(partFilePath, resumable)
+		}
+	}()
+
+	// PREALLOCATE FILE
+	// before any bytes move, so space taken since it was checked still gets
+	// the file refused rather than failing it halfway; streamed content has
+	// no size to reserve
+	if !streaming {
+		err = r.preallocate(file, contentSize, offset)
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		err = fmt.Errorf("%w for %s (%d bytes)", ErrInsufficientSpace, destFilePath, contentSize)
+	}
+	if errors.Is(err, ErrInsufficientSpace) {
+		// the bytes held so far are still good once space was freed
+		resumable = true
+		if err := r.sendReply(con, protocol.Reply{Status: protocol.ReplyTooLarge}); err != nil {
+			return nil, fmt.Errorf("err sending rejection: %w", err)
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("err preallocating dest file: %w", err)
+	}
+
+	// NEGOTIATE RESUME OFFSET
+	offset, err = r.negotiateOffset(con, file, offset, digest)
+	if err != nil {
+		resumable = true
+		return nil, fmt.Errorf("err negotiating resume offset: %w", err)
+	}
+
+	// SAVE CONTENT TO THE FILE
+	// small chunks would otherwise mean a write syscall each
+	out := bufio.NewWriterSize(file, r.writeBufferSize)
+	trackedSize := contentSize
+	if streaming {
+		trackedSize = progress.UnknownSize
+	}
+	tracker := progress.Start(r.progress, filePath, trackedSize, offset)
+	sizer := r.chunkSizer()
+	var checksum []byte
+	if fileFlags&protocol.FlagBlocks != 0 {
+		checksum, err = r.receiveBlocks(con, file, offset, contentSize, digest, tracker)
+	} else if fileFlags&protocol.FlagUDP != 0 {
+		checksum, err = r.receiveDatagrams(con, file, offset, contentSize, digest, tracker)
+	} else if fileFlags&protocol.FlagParallel != 0 {
+		checksum, err = r.receiveParallel(con, file, offset, contentSize, digest, tracker)
+	} else if streaming {
+		// streamed content is sized once it all arrived
+		checksum, contentSize, err = r.receiveStream(con, out, digest, tracker, sizer)
+	} else {
+		checksum, err = r.receiveContent(con, fileFlags, out, contentSize-offset, digest, tracker, sizer)
+	}
+	tracker.Finish()
+	if err == nil {
+		if err = out.Flush(); err != nil {
+			err = fmt.Errorf("err flushing dest file: %w", err)
+		}
+	} else if r.resume || r.keepPartials {
+		// whatever made it this far is worth resuming from, or looking at
+		out.Flush()
+	}
+	if err != nil {
+		// nothing worth resuming from a sender that lies about sizes, nor
+		// of streamed content
+		resumable = !errors.Is(err, ErrFileTooLarge) && !streaming
+		return nil, fmt.Errorf("err receiving and saving file content: %w", err)
+	}
+
+	if r.fsync {
+		if err = file.Sync(); err != nil {
+			return nil, fmt.Errorf("err syncing dest file: %w", err)
+		}
+	}
+	if err = file.Close(); err != nil {
+		return nil, fmt.Errorf("err closing dest file: %w", err)
+	}
+
+	// VERIFY FILE CHECKSUM
+	expectedChecksum, err := r.receiveFileChecksum(con)
+	if err != nil {
+		resumable = true
+		return nil, fmt.Errorf("err receiving file checksum: %w", err)
+	}
+
+	if !bytes.Equal(checksum, expectedChecksum) {
+		err := fmt.Errorf("%w: expected %x, got %x", ErrChecksumMismatch, expectedChecksum, checksum)
+		return nil, r.sendFailedAck(con, err)
+	}
+
+	// NAME THE FILE AFTER ITS CHECKSUM
+	if dest.final != nil {
+		finalPath, err := dest.final(hex.EncodeToString(checksum))
+		if err != nil {
+			return nil, r.sendFailedAck(con, fmt.Errorf("err naming file: %w", err))
+		}
+		var release func()
+		destFilePath, release, err = r.reserveFinalPath(finalPath)
+		if errors.Is(err, os.ErrExist) && r.overwritePolicy == PolicySkip {
+			// a file of the same name most likely holds the same content
+			r.logger.Info("skipping file, it already exists", "file", filePath, "path", finalPath)
+			r.removePartFile(partFilePath)
+			settled = true
+			if err := protocol.WriteAck(con, protocol.Ack{Status: protocol.AckOK}); err != nil {
+				return nil, fmt.Errorf("err confirming skipped file: %w", err)
+			}
+			return nil, nil
+		}
+		if errors.Is(err, os.ErrExist) && r.overwritePolicy == PolicyError {
+			return nil, r.sendFailedAck(con, fmt.Errorf("%w: %s", ErrFileExists, finalPath))
+		}
+		if err != nil {
+			return nil, r.sendFailedAck(con, fmt.Errorf("err reserving %s: %w", finalPath, err))
+		}
+		defer release()
+	}
+
+	// MOVE FILE INTO PLACE
+	if err = os.Rename(partFilePath, destFilePath); err != nil {
+		err := fmt.Errorf("err renaming %s to %s: %w", partFilePath, destFilePath, err)
+		return nil, r.sendFailedAck(con, err)
+	}
+	settled = true
+	if r.fsync {
+		// the rename is only durable once the directory entry is. The file
+		// is in place by now, possibly over the one it replaced, so it is
+		// kept and only the sender is told it may not survive a crash.
+		if err := syncDir(filepath.Dir(destFilePath)); err != nil {
+			err := fmt.Errorf("err syncing %s: %w", filepath.Dir(destFilePath), err)
+			return nil, r.sendFailedAck(con, err)
+		}
+	}
+	r.filesReceived.Add(1)
+
+	// APPLY FILE MODE
+	// Windows only knows a read-only attribute, which the sender's bits would
+	// map to badly, so leave the default there.
+	if r.preservePerms && runtime.GOOS != "windows" {
+		if err := os.Chmod(destFilePath, os.FileMode(fileMode&protocol.PermMask)); err != nil {
+			r.logger.Warn("err applying file mode", "path", destFilePath, "mode", os.FileMode(fileMode&protocol.PermMask), "err", err)
+		}
+	}
+
+	// APPLY FILE MODIFICATION TIME
+	if r.preserveModTime {
+		if err := os.Chtimes(destFilePath, time.Time{}, time.Unix(0, modTime)); err != nil {
+			r.logger.Warn("err applying modification time", "path", destFilePath, "err", err)
+		}
+	}
+
+	stats := newTransferStats(filePath, destFilePath, contentSize, offset, start, checksum)
+	if fileFlags&(protocol.FlagBlocks|protocol.FlagUDP|protocol.FlagParallel) == 0 {
+		stats.ChunkSize = sizer.Size()
+	}
+	r.logger.Info("saved file", "file", stats)
+
+	// CONFIRM THE FILE
+	// the file is saved either way, so its stats are returned along with
+	// the error
+	if err := protocol.WriteAck(con, protocol.Ack{Status: protocol.AckOK}); err != nil {
+		return stats, fmt.Errorf("err confirming file: %w", err)
+	}
+
+	return stats, nil
+}
+
+// sendFailedAck tells the sender that saving its file failed with err, and
+// returns err as an ackedError once the sender got to know.
+func (r *Receiver) sendFailedAck(con net.Conn, err error) error {
+	if ackErr := protocol.WriteAck(con, protocol.Ack{Status: protocol.AckFailed, Message: err.Error()}); ackErr != nil {
+		r.logger.Debug("err sending failure ack", "peer", con.RemoteAddr(), "err", ackErr)
+		return err
+	}
+	return ackedError{err}
+}
+
+// preallocate sizes a freshly created ".part" file to contentSize so the
+// filesystem can lay it out in one piece, and reserves its blocks where the
+// platform allows. With resume enabled the size isn't touched, it is how an
+// interrupted transfer tells how far it got, nor with WithKeepPartials, whose
+// ".failed" files hold only what arrived.
+func (r *Receiver) preallocate(file *os.File, contentSize, offset uint64) error {
+	if !r.resume && !r.keepPartials && offset == 0 {
+		if err := file.Truncate(int64(contentSize)); err != nil {
+			return err
+		}
+	}
+
+	return reserveSpace(file, int64(contentSize))
+}
+
+// diskSpaceMargin is kept free on top of a file's size for the filesystem's
+// own bookkeeping and whatever else writes to it meanwhile
+const diskSpaceMargin = 64 << 20
+
+// checkDiskSpace fails with ErrInsufficientSpace if dir's filesystem has no
+// room for needed more bytes plus diskSpaceMargin. A free space that can't be
+// told is logged and doesn't fail.
+func (r *Receiver) checkDiskSpace(dir string, needed uint64) error {
+	if !r.checkSpace || needed == 0 {
+		return nil
+	}
+
+	free, err := r.freeSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		r.logger.Warn("err checking free disk space", "path", dir, "err", err)
+		return nil
+	}
+	if free < needed || free-needed < diskSpaceMargin {
+		return fmt.Errorf("%w: %d bytes needed, %d free in %s", ErrInsufficientSpace, needed, free, dir)
+	}
+
+	return nil
+}
+
+// receiveContent saves contentSize bytes of (possibly compressed) content to
+// the file and returns the digest of the uncompressed bytes.
+func (r *Receiver) receiveContent(con net.Conn, fileFlags uint8, file io.Writer, contentSize uint64, digest hash.Hash, tracker *progress.Tracker, sizer *chunk.Sizer) ([]byte, error) {
+	if fileFlags&protocol.FlagCompressed == 0 {
+		return r.receiveAndSaveFileContent(con, file, contentSize, digest, tracker, sizer)
+	}
+
+	gzipReader, err := gzip.NewReader(con)
+	if err != nil {
+		return nil, fmt.Errorf("err reading gzip header: %w", err)
+	}
+	// the checksum trailer follows the gzip stream directly, don't treat
+	// it as the start of another gzip member
+	gzipReader.Multistream(false)
+
+	checksum, err := r.receiveAndSaveFileContent(gzipReader, file, contentSize, digest, tracker, sizer)
+	if err != nil {
+		return nil, err
+	}
+
+	// consume the gzip trailer, which also verifies its CRC
+	if extra, err := io.CopyN(io.Discard, gzipReader, 1); extra > 0 {
+		return nil, fmt.Errorf("%w: compressed stream holds more than the advertised %d bytes", ErrFileTooLarge, contentSize)
+	} else if err != io.EOF {
+		return nil, fmt.Errorf("err finishing gzip stream: %w", err)
+	}
+
+	return checksum, nil
+}
+
+// receiveAndSaveFileContent writes exactly contentSize bytes from the
+// connection to the file, in chunks sized by sizer, feeding them into digest
+// as well, and returns the final digest. digest already covers any data
+// resumed from a partial file. Received bytes are reported to tracker.
+func (r *Receiver) receiveAndSaveFileContent(con io.Reader, file io.Writer, contentSize uint64, digest hash.Hash, tracker *progress.Tracker, sizer *chunk.Sizer) ([]byte, error) {
+	// Each stage wraps the connection, so the loop below sees a plain
+	// reader. It never reads past the advertised size; whatever follows on
+	// the connection is no longer part of this file.
+	var src io.Reader = io.TeeReader(con, digest)
+	src = ratelimit.NewReader(src, r.limiter)
+	src = tracker.Reader(src)
+
+	var buf []byte
+	totalBytesReceived := uint64(0)
+	for totalBytesReceived < contentSize {
+		if len(buf) < sizer.Size() {
+			buf = make([]byte, sizer.Size())
+		}
+		size := min(uint64(sizer.Size()), contentSize-totalBytesReceived)
+
+		start := time.Now()
+		n, err := io.ReadFull(src, buf[:size])
+		if _, err := file.Write(buf[:n]); err != nil {
+			return nil, fmt.Errorf("err receiving file content: %w", err)
+		}
+		totalBytesReceived += uint64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: got %d of %d bytes", ErrIncompleteTransfer, totalBytesReceived, contentSize)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("err receiving file content: %w", err)
+		}
+		sizer.Record(n, time.Since(start))
+	}
+
+	r.logger.Debug("received file content", "bytes", totalBytesReceived, "chunk_size", sizer.Size())
+
+	return digest.Sum(nil), nil
+}
+
+// chunkSizer returns the sizer of a transfer's chunks, see
+// WithChunkSizeBounds.
+func (r *Receiver) chunkSizer() *chunk.Sizer {
+	return chunk.NewSizer(chunkSize, r.chunkMin, r.chunkMax)
+}
+
+func (r *Receiver) receiveFileChecksum(con net.Conn) ([]byte, error) {
+	checksum := make([]byte, sha256.Size)
+
+	_, err := io.ReadFull(con, checksum)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: checksum missing", ErrIncompleteTransfer)
+		}
+		return nil, fmt.Errorf("err reading checksum: %w", err)
+	}
+
+	return checksum, nil
+}
+
+// destination is where prepareDestFilePath decided a file goes.
+type destination struct {
+	// path is the file's path, a provisional one if final is set
+	path string
+	// generated is set if the receiver made the name up, so it never means
+	// to refer to an existing file
+	generated bool
+	// final, if set, returns the path to rename the file to once its
+	// checksum is known
+	final func(checksum string) (string, error)
+}
+
+// prepareDestFilePath maps the name sent by the sender to a path inside the
+// destination directory, creating any parent directories it needs. Top-level
+// files are named after the name template if there is one, and otherwise
+// after the current unix timestamp unless names are preserved or nothing is
+// left of the name after sanitizing it; files inside a transferred directory
+// always keep their names.
+func (r *Receiver) prepareDestFilePath(filePath, peer string) (destination, error) {
+	relPath, err := sanitizeRelativePath(filePath)
+	if err != nil {
+		return destination{}, err
+	}
+
+	relDir, fileName := filepath.Split(filepath.FromSlash(relPath))
+	if relDir == "" && r.nameTemplate != "" {
+		return r.templateDestination(fileName, peer)
+	}
+
+	generated := relDir == "" && (!r.preserveFilename || fileName == "")
+	if generated {
+		fileName = fmt.Sprintf("%d%s", time.Now().Unix(), filepath.Ext(fileName))
+	}
+
+	destFilePath := filepath.Join(r.destDir, relDir, fileName)
+	if err := ensureInsideDir(r.destDir, destFilePath); err != nil {
+		return destination{}, err
+	}
+
+	if relDir != "" {
+		// a symlink received earlier must not be written through
+		if err := noSymlinks(r.destDir, path.Dir(relPath)); err != nil {
+			return destination{}, err
+		}
+		if err := os.MkdirAll(filepath.Dir(destFilePath), 0o755); err != nil {
+			return destination{}, fmt.Errorf("err creating parent directory: %w", err)
+		}
+	}
+
+	return destination{path: destFilePath, generated: generated}, nil
+}
+
+// createDestDir recreates a directory entry, including empty ones, inside
+// the destination directory.
+func (r *Receiver) createDestDir(dirPath string) error {
+	relPath, err := sanitizeRelativePath(dirPath)
+	if err != nil {
+		return err
+	}
+	if relPath == "" {
+		r.logger.Warn("skipping directory entry with unusable name", "name", dirPath)
+		return nil
+	}
+
+	destDirPath := filepath.Join(r.destDir, filepath.FromSlash(relPath))
+	if err := ensureInsideDir(r.destDir, destDirPath); err != nil {
+		return err
+	}
+	if err := noSymlinks(r.destDir, relPath); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(destDirPath, 0o755); err != nil {
+		return fmt.Errorf("err creating directory %s: %w", destDirPath, err)
+	}
+
+	return nil
+}
+
+// createSymlink recreates a symlink entry inside the destination directory.
+// Its target is kept as sent, with a warning if it points outside the
+// directory transferred, which the sender may have flagged; nothing is ever
+// written through it, see noSymlinks. A symlink whose path is taken, or
+// that the system refuses to create, is skipped with a warning.
+func (r *Receiver) createSymlink(linkPath string, link protocol.Link) error {
+	if strings.ContainsRune(link.Target, 0) {
+		return fmt.Errorf("%w: link target contains a NUL byte", ErrInvalidFileName)
+	}
+	target, err := decodeName(link.Target, r.strictNames)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+	relPath, err := sanitizeRelativePath(linkPath)
+	if err != nil {
+		return err
+	}
+	if relPath == "" {
+		r.logger.Warn("skipping symlink entry with unusable name", "name", linkPath)
+		return nil
+	}
+
+	destLinkPath := filepath.Join(r.destDir, filepath.FromSlash(relPath))
+	if err := ensureInsideDir(r.destDir, destLinkPath); err != nil {
+		return err
+	}
+	if err := noSymlinks(r.destDir, path.Dir(relPath)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(destLinkPath), 0o755); err != nil {
+		return fmt.Errorf("err creating parent directory: %w", err)
+	}
+
+	// the directory transferred is the first element of the name
+	_, inside, _ := strings.Cut(relPath, "/")
+	if link.Flags&protocol.LinkOutside != 0 || protocol.LinkLeaves(inside, target) {
+		r.logger.Warn("symlink points outside the transferred directory", "path", destLinkPath, "target", target)
+	}
+	if err := os.Symlink(filepath.FromSlash(target), destLinkPath); err != nil {
+		r.logger.Warn("err creating symlink", "path", destLinkPath, "target", target, "err", err)
+		return nil
+	}
+	r.logger.Info("created symlink", "path", destLinkPath, "target", target)
+
+	return nil
+}
+
+// prepareDestDir makes sure the destination directory exists and that we are
+// allowed to create files in it.
+func (r *Receiver) prepareDestDir() error {
+	if r.destDir == "" || r.sink != nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(r.destDir, 0o755); err != nil {
+		return fmt.Errorf("err creating %s: %w", r.destDir, err)
+	}
+
+	probe, err := os.CreateTemp(r.destDir, ".fileshare-probe-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", r.destDir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	return nil
+}
+
+// baseName returns the last element of a path transmitted by the sender,
+// treating both '/' and '\' as separators since the sender may run on any
+// platform. It returns an empty string if nothing usable is left.
+func baseName(filePath string) string {
+	fileName := path.Base(strings.ReplaceAll(filePath, "\\", "/"))
+	if fileName == "." || fileName == "/" || fileName == ".." {
+		return ""
+	}
+
+	return fileName
+}
+
+// bufferedConn routes reads through a bufio.Reader. Besides saving syscalls
+// for the many small header reads, it gives gzip an io.ByteReader so it never
+// reads past the end of a compressed stream into whatever follows it.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func newBufferedConn(con net.Conn) *bufferedConn {
+	return &bufferedConn{Conn: con, reader: bufio.NewReader(con)}
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *bufferedConn) ReadByte() (byte, error) {
+	return c.reader.ReadByte()
+}
@@ -0,0 +1,444 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/pjmessi/go_file_share/sender"
+)
+
+func TestReceiveConnRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		opts  []sender.Option
+		names []string
+	}{
+		{name: "single file", size: 100 << 10, names: []string{"report.pdf"}},
+		{name: "several files", size: 3000, names: []string{"a.txt", "b.txt", "c.txt"}},
+		{name: "compressed", size: 1 << 20, opts: []sender.Option{sender.WithCompression(true)}, names: []string{"big.bin"}},
+		{name: "block checksums", size: 3<<20 + 17, opts: []sender.Option{sender.WithBlockChecksums(true)}, names: []string{"blocks.bin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := t.TempDir()
+			content := testContent(tt.size)
+			var paths []string
+			for _, name := range tt.names {
+				paths = append(paths, writeTestFile(t, src, name, content))
+			}
+			r, dest := newTestReceiver(t)
+
+			res := pipeTransfer(t, r, newTestSender(t, tt.opts...), paths...)
+			if res.err != nil {
+				t.Fatalf("ReceiveConn: %v", res.err)
+			}
+			if res.senderErr != nil {
+				t.Fatalf("ServeConn: %v", res.senderErr)
+			}
+			if len(res.stats) != len(tt.names) {
+				t.Fatalf("got stats of %d files, want %d", len(res.stats), len(tt.names))
+			}
+			for i, name := range tt.names {
+				if res.stats[i].Name != name || res.stats[i].Size != uint64(tt.size) {
+					t.Errorf("stats[%d] = %s of %d bytes, want %s of %d", i, res.stats[i].Name, res.stats[i].Size, name, tt.size)
+				}
+				assertFile(t, filepath.Join(dest, name), content)
+			}
+		})
+	}
+}
+
+func TestReceiveConnDirectory(t *testing.T) {
+	src := t.TempDir()
+	content := testContent(5000)
+	writeTestFile(t, src, "album/cover.jpg", content)
+	writeTestFile(t, src, "album/disc 1/track 01.flac", content)
+	r, dest := newTestReceiver(t)
+
+	res := pipeTransfer(t, r, newTestSender(t), filepath.Join(src, "album"))
+	if res.err != nil || res.senderErr != nil {
+		t.Fatalf("transfer failed: receiver %v, sender %v", res.err, res.senderErr)
+	}
+	assertFile(t, filepath.Join(dest, "album", "cover.jpg"), content)
+	assertFile(t, filepath.Join(dest, "album", "disc 1", "track 01.flac"), content)
+}
+
+func TestReceiveFromDiscoveredPeer(t *testing.T) {
+	src := t.TempDir()
+	content := testContent(64 << 10)
+	path := writeTestFile(t, src, "notes.txt", content)
+
+	senders := newPipeSenders()
+	peer := senders.add("192.0.2.10:9000", newTestSender(t), path)
+	r, dest := newTestReceiver(t,
+		WithDiscoverers(fakeDiscoverer{peers: []Peer{peer}}),
+		WithDialer(senders.dial),
+	)
+
+	stats, err := receiveDiscovered(t, r)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if errs := senders.wait(peer.Addr); len(errs) != 1 || errs[0] != nil {
+		t.Fatalf("sender errors: %v", errs)
+	}
+	if len(stats) != 1 || stats[0].Peer != "pipe" {
+		t.Fatalf("stats = %+v, want one file from the pipe", stats)
+	}
+	assertFile(t, filepath.Join(dest, "notes.txt"), content)
+}
+
+func TestReceiveConnFromFakeSender(t *testing.T) {
+	content := testContent(10 << 10)
+	r, dest := newTestReceiver(t)
+
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if !f.handshake() || !f.write(uint32(1)) {
+			return
+		}
+		ack, ok := f.sendFile("raw.bin", content)
+		if !ok || ack.Status != protocol.AckOK {
+			t.Errorf("ack = %+v, %t, want the file confirmed", ack, ok)
+		}
+	})
+	if res.err != nil {
+		t.Fatalf("ReceiveConn: %v", res.err)
+	}
+	assertFile(t, filepath.Join(dest, "raw.bin"), content)
+}
+
+func TestReceiveConnCreatesDestDir(t *testing.T) {
+	content := testContent(2048)
+	path := writeTestFile(t, t.TempDir(), "a.txt", content)
+	dest := filepath.Join(t.TempDir(), "does", "not", "exist")
+	r, _ := newTestReceiver(t, WithDestDir(dest))
+
+	res := pipeTransfer(t, r, newTestSender(t), path)
+	if res.err != nil {
+		t.Fatalf("ReceiveConn: %v", res.err)
+	}
+	assertFile(t, filepath.Join(dest, "a.txt"), content)
+	if res.stats[0].Path != filepath.Join(dest, "a.txt") {
+		t.Errorf("Path = %s, want it inside %s", res.stats[0].Path, dest)
+	}
+}
+
+func TestReceiveConnFailsOnUnusableDestDir(t *testing.T) {
+	// a file where the directory should be
+	dest := writeTestFile(t, t.TempDir(), "taken", nil)
+	r, _ := newTestReceiver(t, WithDestDir(dest))
+
+	senderEnd, receiverEnd := net.Pipe()
+	defer senderEnd.Close()
+	if _, err := r.ReceiveConn(context.Background(), receiverEnd); err == nil {
+		t.Fatal("ReceiveConn succeeded with a file as destination directory")
+	}
+}
+
+func TestReceiveConnEmptyDirectories(t *testing.T) {
+	src := t.TempDir()
+	for _, dir := range []string{"tree/empty", "tree/a/b/c"} {
+		if err := os.MkdirAll(filepath.Join(src, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content := testContent(10)
+	writeTestFile(t, src, "tree/a/b/c/deep.txt", content)
+	r, dest := newTestReceiver(t)
+
+	res := pipeTransfer(t, r, newTestSender(t), filepath.Join(src, "tree"))
+	if res.err != nil || res.senderErr != nil {
+		t.Fatalf("transfer failed: receiver %v, sender %v", res.err, res.senderErr)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "tree", "empty")); err != nil || !info.IsDir() {
+		t.Errorf("empty directory not recreated: %v", err)
+	}
+	assertFile(t, filepath.Join(dest, "tree", "a", "b", "c", "deep.txt"), content)
+	if len(res.stats) != 1 || res.stats[0].Name != "tree/a/b/c/deep.txt" {
+		t.Errorf("stats = %+v, want the one file by its relative path", res.stats)
+	}
+}
+
+func TestReceiveConnRejectsOtherProtocols(t *testing.T) {
+	version := func(v uint16) []byte {
+		return binary.LittleEndian.AppendUint16([]byte(protocol.Magic), v)
+	}
+	tests := []struct {
+		name     string
+		preamble []byte
+		wantErr  error
+	}{
+		{name: "http request", preamble: []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"), wantErr: protocol.ErrBadMagic},
+		{name: "tls client hello", preamble: []byte{0x16, 0x03, 0x01, 0x02, 0x00, 0x01}, wantErr: protocol.ErrBadMagic},
+		{name: "newer version", preamble: version(protocol.Version + 1), wantErr: protocol.ErrIncompatibleVersion},
+		{name: "older version", preamble: version(protocol.MinVersion - 1), wantErr: protocol.ErrIncompatibleVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, dest := newTestReceiver(t)
+
+			res := rawTransfer(t, r, func(f *fakeSender) {
+				if _, err := protocol.ReadPreamble(f.con); err != nil {
+					t.Error(err)
+					return
+				}
+				f.write(tt.preamble, uint32(1))
+				f.sendFile("intruder.txt", []byte("x"))
+			})
+			if !errors.Is(res.err, ErrProtocol) || !errors.Is(res.err, tt.wantErr) {
+				t.Fatalf("ReceiveConn = %v, want ErrProtocol wrapping %v", res.err, tt.wantErr)
+			}
+			assertNoFiles(t, dest)
+		})
+	}
+}
+
+func TestPreservePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows only has a read-only attribute")
+	}
+	tests := []struct {
+		name     string
+		mode     os.FileMode
+		preserve bool
+		want     os.FileMode
+	}{
+		{name: "executable", mode: 0o755, preserve: true, want: 0o755},
+		{name: "private", mode: 0o600, preserve: true, want: 0o600},
+		{name: "not preserved", mode: 0o755, preserve: false, want: 0o644},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), "run.sh", []byte("#!/bin/sh\n"))
+			if err := os.Chmod(path, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			r, dest := newTestReceiver(t, WithPreservePermissions(tt.preserve))
+
+			res := pipeTransfer(t, r, newTestSender(t), path)
+			if res.err != nil {
+				t.Fatalf("ReceiveConn: %v", res.err)
+			}
+			info, err := os.Stat(filepath.Join(dest, "run.sh"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the umask may take bits off the default
+			if got := info.Mode().Perm(); got != tt.want && (tt.preserve || got&^tt.want != 0) {
+				t.Errorf("mode = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreserveModTime(t *testing.T) {
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 890_000_000, time.UTC)
+	for _, preserve := range []bool{true, false} {
+		t.Run(fmt.Sprint("preserve ", preserve), func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), "old.txt", []byte("old"))
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+			r, dest := newTestReceiver(t, WithPreserveModTime(preserve))
+
+			res := pipeTransfer(t, r, newTestSender(t), path)
+			if res.err != nil {
+				t.Fatalf("ReceiveConn: %v", res.err)
+			}
+			info, err := os.Stat(filepath.Join(dest, "old.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.ModTime().Equal(modTime); got != preserve {
+				t.Errorf("modification time %v, preserved %t, want %t", info.ModTime(), got, preserve)
+			}
+		})
+	}
+}
+
+func TestReceiveConnRejectsNameLengths(t *testing.T) {
+	frame := func(nameLen uint16, name string) []byte {
+		f := binary.LittleEndian.AppendUint16([]byte{protocol.EntryTypeFile}, nameLen)
+		return append(f, name...)
+	}
+	tests := []struct {
+		name  string
+		opts  []Option
+		frame []byte
+	}{
+		// nothing follows the lengths: the receiver mustn't wait for the name
+		{name: "longest length", frame: frame(0xFFFF, "")},
+		{name: "over the default", frame: frame(protocol.DefaultMaxNameLength+1, "")},
+		{name: "empty name", frame: frame(0, "")},
+		{name: "over the configured", opts: []Option{WithMaxNameLength(8)}, frame: frame(9, "ninechars")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, dest := newTestReceiver(t, tt.opts...)
+
+			start := time.Now()
+			res := rawTransfer(t, r, func(f *fakeSender) {
+				if f.handshake() {
+					f.write(uint32(1), tt.frame)
+				}
+			})
+			if !errors.Is(res.err, ErrProtocol) || !errors.Is(res.err, protocol.ErrInvalidFrame) {
+				t.Fatalf("ReceiveConn = %v, want ErrProtocol wrapping protocol.ErrInvalidFrame", res.err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("rejecting the frame took %s", elapsed)
+			}
+			assertNoFiles(t, dest)
+		})
+	}
+}
+
+func TestReceiveConnWithinMaxNameLength(t *testing.T) {
+	content := testContent(100)
+	r, dest := newTestReceiver(t, WithMaxNameLength(8))
+
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if f.handshake() && f.write(uint32(1)) {
+			f.sendFile("8chr.txt", content)
+		}
+	})
+	if res.err != nil {
+		t.Fatalf("ReceiveConn: %v", res.err)
+	}
+	assertFile(t, filepath.Join(dest, "8chr.txt"), content)
+}
+
+func TestReceiveConnRejectsHugeEntryCount(t *testing.T) {
+	r, _ := newTestReceiver(t)
+
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if f.handshake() {
+			f.write(uint32(0xFFFFFFFF))
+		}
+	})
+	if !errors.Is(res.err, ErrProtocol) {
+		t.Fatalf("ReceiveConn = %v, want ErrProtocol", res.err)
+	}
+}
+
+// countingWriter counts what is written to it and keeps none of it.
+type countingWriter struct {
+	n uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += uint64(len(p))
+	return len(p), nil
+}
+
+func (w *countingWriter) Close() error {
+	return nil
+}
+
+func TestReceiveOver4GiB(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams 4 GiB")
+	}
+	const size = 1<<32 + 4097
+	// sparse, so that it takes no room on disk
+	f, err := os.Create(filepath.Join(t.TempDir(), "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	sink := &countingWriter{}
+	r, _ := newTestReceiver(t, WithSink(func(name string, size int64) (io.WriteCloser, error) {
+		return sink, nil
+	}))
+
+	res := pipeTransfer(t, r, newTestSender(t), f.Name())
+	if res.err != nil || res.senderErr != nil {
+		t.Fatalf("transfer failed: receiver %v, sender %v", res.err, res.senderErr)
+	}
+	if len(res.stats) != 1 || res.stats[0].Size != size || res.stats[0].Bytes != size {
+		t.Errorf("stats = %+v, want %d bytes received", res.stats, uint64(size))
+	}
+	if sink.n != size {
+		t.Errorf("sink got %d bytes, want %d", sink.n, uint64(size))
+	}
+}
+
+func TestDeclinedFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		decline string
+		// wantAsked are the files the AcceptFunc is asked about, until the
+		// first one declined ends the transfer
+		wantAsked []string
+		wantFiles []string
+	}{
+		{"first", "a.txt", []string{"a.txt"}, nil},
+		{"later", "b.txt", []string{"a.txt", "b.txt"}, []string{"a.txt"}},
+		{"none", "", []string{"a.txt", "b.txt", "c.txt"}, []string{"a.txt", "b.txt", "c.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := t.TempDir()
+			var paths []string
+			for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+				paths = append(paths, writeTestFile(t, src, name, []byte("content of "+name)))
+			}
+			var asked []string
+			r, dest := newTestReceiver(t, WithAcceptFunc(func(name string, size int64, peer string) bool {
+				asked = append(asked, name)
+				if size != int64(len("content of "+name)) || peer == "" {
+					t.Errorf("asked about %s of %d bytes from %q", name, size, peer)
+				}
+				return name != tt.decline
+			}))
+			var senderLog bytes.Buffer
+			out := log.Writer()
+			log.SetOutput(&senderLog)
+			defer log.SetOutput(out)
+
+			// neither end takes a declined file for a failure
+			res := pipeTransfer(t, r, newTestSender(t), paths...)
+			if res.err != nil || res.senderErr != nil {
+				t.Fatalf("transfer: %v, sender: %v", res.err, res.senderErr)
+			}
+			if !slices.Equal(asked, tt.wantAsked) {
+				t.Errorf("asked about %q, want %q", asked, tt.wantAsked)
+			}
+			if got := savedFiles(t, dest); !slices.Equal(got, tt.wantFiles) {
+				t.Errorf("saved %q, want %q", got, tt.wantFiles)
+			}
+			if len(res.stats) != len(tt.wantFiles) {
+				t.Errorf("got stats of %d files, want %d", len(res.stats), len(tt.wantFiles))
+			}
+			if declined := strings.Contains(senderLog.String(), "declined "+tt.decline+", ending transfer"); declined != (tt.decline != "") {
+				t.Errorf("the sender logged %q", senderLog.String())
+			}
+
+			// the receiver goes on to take the next transfer
+			asked = nil
+			tt.decline = ""
+			if res := pipeTransfer(t, r, newTestSender(t), paths[2]); res.err != nil || res.senderErr != nil {
+				t.Fatalf("next transfer: %v, sender: %v", res.err, res.senderErr)
+			}
+			assertFile(t, filepath.Join(dest, "c.txt"), []byte("content of c.txt"))
+		})
+	}
+}
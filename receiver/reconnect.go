@@ -0,0 +1,103 @@
+package receiver
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// resumeState remembers what earlier connections to the same sender got
+// done, so that a reconnect continues the transfer instead of repeating it.
+// A nil resumeState remembers nothing. Entries received at once over QUIC
+// share it.
+type resumeState struct {
+	mu sync.Mutex
+	// saved holds the names of the files saved so far
+	saved map[string]bool
+	// accepted holds the names of the files accepted so far
+	accepted map[string]bool
+	// picked holds the names of the entries picked from the first
+	// manifest, nil until one was
+	picked map[string]bool
+}
+
+func newResumeState() *resumeState {
+	return &resumeState{saved: map[string]bool{}, accepted: map[string]bool{}}
+}
+
+func (s *resumeState) wasSaved(name string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.saved[name]
+}
+
+func (s *resumeState) wasAccepted(name string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.accepted[name]
+}
+
+func (s *resumeState) markSaved(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.saved[name] = true
+}
+
+func (s *resumeState) markAccepted(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accepted[name] = true
+}
+
+// pickedBefore returns the names of the entries picked from the manifest of
+// an earlier connection, if one was.
+func (s *resumeState) pickedBefore() (map[string]bool, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.picked, s.picked != nil
+}
+
+func (s *resumeState) markPicked(names map[string]bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.picked == nil {
+		s.picked = names
+	}
+}
+
+// connectionLost reports whether err means the connection broke down, e.g.
+// reset while roaming between access points, rather than the sender or the
+// receiver refusing to go on. Only such transfers are worth reconnecting for.
+func connectionLost(err error) bool {
+	var opErr *net.OpError
+	return errors.Is(err, ErrIncompleteTransfer) ||
+		errors.Is(err, ErrIdleTimeout) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &opErr)
+}
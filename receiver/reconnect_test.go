@@ -0,0 +1,94 @@
+package receiver
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/pjmessi/go_file_share/retry"
+	"github.com/pjmessi/go_file_share/sender"
+)
+
+// reconnectingReceiver returns a receiver reconnecting to peer, served by
+// senders, up to twice.
+func reconnectingReceiver(t *testing.T, senders *pipeSenders, peer Peer) (*Receiver, string) {
+	t.Helper()
+
+	return newTestReceiver(t,
+		WithResume(true),
+		WithReconnect(2),
+		WithDiscoverers(fakeDiscoverer{peers: []Peer{peer}}),
+		WithDialer(senders.dial),
+	)
+}
+
+func TestReconnectResumes(t *testing.T) {
+	const size = 4 << 20
+	src := t.TempDir()
+	first := testContent(1 << 10)
+	video := testContent(size)
+	firstPath := writeTestFile(t, src, "first.txt", first)
+	videoPath := writeTestFile(t, src, "video.mp4", video)
+
+	// the first connection drops about halfway through the video
+	senders := newPipeSenders()
+	peer := senders.addThrough("192.0.2.10:9000", newTestSender(t, sender.WithTransferRetry(retry.Policy{})), func(dial int, con net.Conn) net.Conn {
+		if dial > 1 {
+			return con
+		}
+		return &truncatingConn{Conn: con, limit: len(first) + size/2}
+	}, firstPath, videoPath)
+	r, dest := reconnectingReceiver(t, senders, peer)
+
+	stats, err := receiveDiscovered(t, r)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if n := senders.dialed(peer.Addr); n != 2 {
+		t.Errorf("sender connected to %d times, want once more after the drop", n)
+	}
+	if errs := senders.wait(peer.Addr); len(errs) != 2 || errs[1] != nil {
+		t.Errorf("sender errors = %v, want the second connection to succeed", errs)
+	}
+
+	received := map[string][]TransferStats{}
+	for _, s := range stats {
+		received[s.Name] = append(received[s.Name], s)
+	}
+	// the file saved before the drop isn't received again
+	if got := len(received["first.txt"]); got != 1 {
+		t.Errorf("first.txt received %d times, want once", got)
+	}
+	// the video continues from its .part file rather than starting over,
+	// and its checksum covers both connections' bytes
+	videoStats := received["video.mp4"]
+	if len(videoStats) != 1 {
+		t.Fatalf("video.mp4 received %d times, want once", len(videoStats))
+	}
+	if got := videoStats[0].Bytes; got == 0 || got >= size {
+		t.Errorf("second connection moved %d of %d bytes, want only the missing ones", got, size)
+	}
+	assertFile(t, filepath.Join(dest, "first.txt"), first)
+	assertFile(t, filepath.Join(dest, "video.mp4"), video)
+}
+
+func TestNoReconnectOnChecksumMismatch(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "photo.jpg", testContent(256<<10))
+
+	senders := newPipeSenders()
+	peer := senders.addThrough("192.0.2.10:9000", newTestSender(t, sender.WithTransferRetry(retry.Policy{})), func(dial int, con net.Conn) net.Conn {
+		return &corruptingConn{Conn: con, at: 10000}
+	}, path)
+	r, dest := reconnectingReceiver(t, senders, peer)
+
+	_, err := receiveDiscovered(t, r)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Receive = %v, want ErrChecksumMismatch", err)
+	}
+	if n := senders.dialed(peer.Addr); n != 1 {
+		t.Errorf("sender connected to %d times, want no reconnect for a corrupted file", n)
+	}
+	senders.wait(peer.Addr)
+	assertNoFiles(t, dest)
+}
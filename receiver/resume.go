@@ -0,0 +1,119 @@
+package receiver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+func (r *Receiver) sendReply(con net.Conn, rep protocol.Reply) error {
+	return binary.Write(con, binary.LittleEndian, rep)
+}
+
+// negotiateOffset offers the sender to resume at offset and returns the
+// offset the sender agreed to, which is either offset or 0. When the sender
+// falls back to a full transfer the partial content is discarded and digest
+// is reset.
+func (r *Receiver) negotiateOffset(con net.Conn, file *os.File, offset uint64, digest hash.Hash) (uint64, error) {
+	rep := protocol.Reply{Status: protocol.ReplyAccept, Offset: offset}
+	if offset > 0 {
+		copy(rep.PrefixHash[:], digest.Sum(nil))
+	}
+
+	if err := r.sendReply(con, rep); err != nil {
+		return 0, fmt.Errorf("err sending reply: %w", err)
+	}
+
+	var agreedOffset uint64
+	if err := binary.Read(con, binary.LittleEndian, &agreedOffset); err != nil {
+		return 0, fmt.Errorf("err receiving agreed offset: %w", err)
+	}
+
+	switch agreedOffset {
+	case offset:
+		if offset > 0 {
+			r.logger.Info("resuming file", "path", file.Name(), "offset", offset)
+		}
+		return offset, nil
+	case 0:
+		r.logger.Info("partial data does not match the sender's file, starting over", "path", file.Name())
+		if err := file.Truncate(0); err != nil {
+			return 0, fmt.Errorf("err truncating partial file: %w", err)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("err rewinding partial file: %w", err)
+		}
+		digest.Reset()
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("%w: sender agreed to offset %d, %d was offered", ErrProtocol, agreedOffset, offset)
+	}
+}
+
+// openResumablePart opens the ".part" file an interrupted transfer of
+// destFilePath left behind, hashes its content into digest and returns it
+// positioned at its end along with its size. It returns a nil file when
+// there is nothing to resume.
+func (r *Receiver) openResumablePart(destFilePath string, contentSize uint64, digest hash.Hash) (*os.File, uint64, error) {
+	// resuming would clobber an existing file the policy wants to keep
+	if _, err := os.Lstat(destFilePath); err == nil && r.overwritePolicy != PolicyOverwrite {
+		return nil, 0, nil
+	}
+
+	partInfo, err := os.Lstat(destFilePath + partSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if !partInfo.Mode().IsRegular() || uint64(partInfo.Size()) > contentSize {
+		return nil, 0, nil
+	}
+
+	file, err := os.OpenFile(destFilePath+partSuffix, os.O_RDWR, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset, err := io.Copy(digest, file)
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("err hashing partial file: %w", err)
+	}
+
+	return file, uint64(offset), nil
+}
+
+// heldBytes returns how many bytes of filePath the ".part" file of an
+// interrupted transfer holds, zero if there is none or it may not be
+// resumed from. It only looks, unlike prepareDestFilePath and
+// openResumablePart, and counts nothing for names it can't tell the
+// destination of before they are expanded.
+func (r *Receiver) heldBytes(filePath string, contentSize uint64) uint64 {
+	if !r.resume {
+		return 0
+	}
+	relPath, err := sanitizeRelativePath(filePath)
+	if err != nil {
+		return 0
+	}
+	relDir, fileName := filepath.Split(filepath.FromSlash(relPath))
+	if relDir == "" && (r.nameTemplate != "" || !r.preserveFilename || fileName == "") {
+		return 0
+	}
+
+	partInfo, err := os.Lstat(filepath.Join(r.destDir, relDir, fileName) + partSuffix)
+	if err != nil || !partInfo.Mode().IsRegular() || uint64(partInfo.Size()) > contentSize {
+		return 0
+	}
+
+	return uint64(partInfo.Size())
+}
@@ -0,0 +1,75 @@
+package receiver
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pjmessi/go_file_share/retry"
+	"github.com/pjmessi/go_file_share/sender"
+)
+
+// interruptedTransfer sends path from a sender that dies about halfway
+// through its size bytes to r, and returns the size of the ".part" file it
+// leaves in dest.
+func interruptedTransfer(t *testing.T, r *Receiver, dest, path string, size int) int64 {
+	t.Helper()
+
+	s := newTestSender(t, sender.WithTransferRetry(retry.Policy{}))
+	res := pipeTransferThrough(t, r, s, func(con net.Conn) net.Conn {
+		return &truncatingConn{Conn: con, limit: size / 2}
+	}, path)
+	if !errors.Is(res.err, ErrIncompleteTransfer) {
+		t.Fatalf("interrupted ReceiveConn = %v, want ErrIncompleteTransfer", res.err)
+	}
+	info, err := os.Stat(filepath.Join(dest, filepath.Base(path)+partSuffix))
+	if err != nil {
+		t.Fatalf("no partial file kept: %v", err)
+	}
+	if info.Size() == 0 || info.Size() >= int64(size) {
+		t.Fatalf("partial file holds %d of %d bytes, want about half", info.Size(), size)
+	}
+	return info.Size()
+}
+
+func TestResumeAfterInterruption(t *testing.T) {
+	const size = 4 << 20
+	content := testContent(size)
+	path := writeTestFile(t, t.TempDir(), "video.mp4", content)
+	r, dest := newTestReceiver(t, WithResume(true))
+
+	held := interruptedTransfer(t, r, dest, path, size)
+
+	res := pipeTransfer(t, r, newTestSender(t), path)
+	if res.err != nil || res.senderErr != nil {
+		t.Fatalf("resumed transfer failed: receiver %v, sender %v", res.err, res.senderErr)
+	}
+	if got := res.stats[0].Bytes; got != uint64(size-held) {
+		t.Errorf("resumed transfer moved %d bytes, want the %d missing", got, size-held)
+	}
+	assertFile(t, filepath.Join(dest, "video.mp4"), content)
+	assertOnlyFile(t, dest, "video.mp4")
+}
+
+func TestResumeStartsOverWhenSourceDiffers(t *testing.T) {
+	const size = 1 << 20
+	content := testContent(size)
+	path := writeTestFile(t, t.TempDir(), "doc.bin", content)
+	r, dest := newTestReceiver(t, WithResume(true))
+
+	interruptedTransfer(t, r, dest, path, size)
+
+	// the sender's copy changed since, its prefix no longer matches
+	changed := append([]byte("changed"), content[7:]...)
+	writeTestFile(t, filepath.Dir(path), "doc.bin", changed)
+	res := pipeTransfer(t, r, newTestSender(t), path)
+	if res.err != nil || res.senderErr != nil {
+		t.Fatalf("second transfer failed: receiver %v, sender %v", res.err, res.senderErr)
+	}
+	if got := res.stats[0].Bytes; got != size {
+		t.Errorf("second transfer moved %d bytes, want all %d", got, size)
+	}
+	assertFile(t, filepath.Join(dest, "doc.bin"), changed)
+}
@@ -0,0 +1,141 @@
+package receiver
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrInvalidFileName is returned when the sender transmits a name that cannot
+// be turned into a safe local file name, e.g. one containing a NUL byte.
+var ErrInvalidFileName = errors.New("invalid file name")
+
+// decodeName turns the raw bytes of a name received from the sender into the
+// UTF-8 string the rest of the receiver works with. Invalid sequences, e.g.
+// from a sender running a legacy locale, are rejected when strict is set and
+// otherwise percent-encoded byte by byte, so "caf\xe9" becomes "caf%E9". The
+// result is NFC normalized, so a name a macOS sender decomposed is the same
+// file as the one a Linux sender would have sent.
+func decodeName(name string, strict bool) (string, error) {
+	if !utf8.ValidString(name) {
+		if strict {
+			return "", fmt.Errorf("%w: %q is not valid UTF-8", ErrInvalidFileName, name)
+		}
+		name = escapeInvalidUTF8(name)
+	}
+
+	return norm.NFC.String(name), nil
+}
+
+// escapeInvalidUTF8 replaces every byte of name that isn't part of a valid
+// UTF-8 sequence with its "%XX" escape.
+func escapeInvalidUTF8(name string) string {
+	var b strings.Builder
+	for len(name) > 0 {
+		c, size := utf8.DecodeRuneInString(name)
+		if c == utf8.RuneError && size == 1 {
+			fmt.Fprintf(&b, "%%%02X", name[0])
+		} else {
+			b.WriteString(name[:size])
+		}
+		name = name[size:]
+	}
+
+	return b.String()
+}
+
+// reservedName matches the names Windows reserves for devices in every
+// directory, such as "CON" or "aux.txt".
+var reservedName = regexp.MustCompile(`(?i)^(CON|PRN|AUX|NUL|COM[0-9¹²³]|LPT[0-9¹²³]) *(\..*)?$`)
+
+// sanitizeFileName turns a name received from the sender into a single path
+// element that is safe to create inside the destination directory. Directory
+// components are stripped, reserved characters are replaced and names that
+// reduce to nothing (".", "..", "...") come back empty so the caller can fall
+// back to a generated name. The rules are those of NTFS, the strictest of the
+// file systems we may write to, and apply on every platform so that a tree
+// received anywhere can be copied to Windows as is.
+func sanitizeFileName(name string) (string, error) {
+	if strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("%w: contains a NUL byte", ErrInvalidFileName)
+	}
+
+	name = baseName(name)
+
+	name = strings.Map(func(c rune) rune {
+		switch {
+		case c < 0x20 || c == 0x7f:
+			return '_'
+		case strings.ContainsRune(`<>:"/\|?*`, c):
+			return '_'
+		}
+		return c
+	}, name)
+
+	// Windows silently drops trailing dots and spaces, so "evil.." would not
+	// be the file we think we created; it also turns "..." into "".
+	name = strings.TrimRight(name, ". ")
+
+	// device names can't be created as files on Windows, whatever their
+	// extension
+	if reservedName.MatchString(name) {
+		name = "_" + name
+	}
+
+	return name, nil
+}
+
+// sanitizeRelativePath sanitizes every element of a path received as part of
+// a directory transfer and returns the result slash-separated. Both '/' and
+// '\' separate elements, and elements that reduce to nothing, including "..",
+// are dropped, so the result can never point above the destination.
+func sanitizeRelativePath(name string) (string, error) {
+	if strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("%w: contains a NUL byte", ErrInvalidFileName)
+	}
+
+	isSeparator := func(c rune) bool { return c == '/' || c == '\\' }
+
+	var elems []string
+	for _, elem := range strings.FieldsFunc(name, isSeparator) {
+		cleanElem, err := sanitizeFileName(elem)
+		if err != nil {
+			return "", err
+		}
+		if cleanElem != "" {
+			elems = append(elems, cleanElem)
+		}
+	}
+
+	return strings.Join(elems, "/"), nil
+}
+
+// ensureInsideDir returns an error unless target, once cleaned, is located
+// inside dir.
+func ensureInsideDir(dir, target string) error {
+	if dir == "" {
+		dir = "."
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("err resolving %s: %w", dir, err)
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return fmt.Errorf("err resolving %s: %w", target, err)
+	}
+
+	rel, err := filepath.Rel(absDir, absTarget)
+	if err != nil || rel == "." || rel == ".." || filepath.IsAbs(rel) ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: %s escapes %s", ErrInvalidFileName, target, dir)
+	}
+
+	return nil
+}
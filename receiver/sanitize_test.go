@@ -0,0 +1,239 @@
+package receiver
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "report.pdf", want: "report.pdf"},
+		{name: "../../etc/passwd", want: "passwd"},
+		{name: "/etc/passwd", want: "passwd"},
+		{name: `..\..\Windows\win.ini`, want: "win.ini"},
+		{name: "dir/", want: "dir"},
+		{name: "..", want: ""},
+		{name: ".", want: ""},
+		{name: "/", want: ""},
+		{name: "a\x01b\x7fc", want: "a_b_c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeFileName(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("sanitizeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeRejectsNUL(t *testing.T) {
+	if _, err := sanitizeFileName("a\x00.txt"); !errors.Is(err, ErrInvalidFileName) {
+		t.Errorf("sanitizeFileName = %v, want ErrInvalidFileName", err)
+	}
+	if _, err := sanitizeRelativePath("dir/a\x00.txt"); !errors.Is(err, ErrInvalidFileName) {
+		t.Errorf("sanitizeRelativePath = %v, want ErrInvalidFileName", err)
+	}
+}
+
+func TestSanitizeRelativePath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "album/disc 1/track.flac", want: "album/disc 1/track.flac"},
+		{name: "album/../../../etc/passwd", want: "album/etc/passwd"},
+		{name: "/abs/path", want: "abs/path"},
+		{name: `album\sub\x.txt`, want: "album/sub/x.txt"},
+		{name: "a//b/./c", want: "a/b/c"},
+		{name: "../..", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeRelativePath(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("sanitizeRelativePath(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureInsideDir(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		target string
+		inside bool
+	}{
+		{target: filepath.Join(dir, "a.txt"), inside: true},
+		{target: filepath.Join(dir, "sub", "a.txt"), inside: true},
+		{target: dir, inside: false},
+		{target: filepath.Join(dir, "..", "a.txt"), inside: false},
+		{target: filepath.Join(dir, "..", filepath.Base(dir)+"-other", "a.txt"), inside: false},
+	}
+	for _, tt := range tests {
+		err := ensureInsideDir(dir, tt.target)
+		if tt.inside && err != nil {
+			t.Errorf("ensureInsideDir(%s) = %v, want it inside", tt.target, err)
+		}
+		if !tt.inside && !errors.Is(err, ErrInvalidFileName) {
+			t.Errorf("ensureInsideDir(%s) = %v, want ErrInvalidFileName", tt.target, err)
+		}
+	}
+}
+
+func TestTraversingNameStaysInDestDir(t *testing.T) {
+	content := testContent(100)
+	r, dest := newTestReceiver(t)
+
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if !f.handshake() || !f.write(uint32(1)) {
+			return
+		}
+		f.sendFile("../../escaped.txt", content)
+	})
+	if res.err != nil {
+		t.Fatalf("ReceiveConn: %v", res.err)
+	}
+	assertFile(t, filepath.Join(dest, "escaped.txt"), content)
+}
+
+func TestDecodeName(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		strict  bool
+		wantErr error
+	}{
+		{name: "utf-8", raw: "日本.txt", want: "日本.txt"},
+		// "日本" in Shift-JIS, its last byte being "{" in ASCII
+		{name: "shift-jis", raw: "\x93\xfa\x96\x7b.txt", want: "%93%FA%96{.txt"},
+		{name: "latin-1", raw: "caf\xe9", want: "caf%E9"},
+		{name: "truncated sequence", raw: "a\xe6\x97", want: "a%E6%97"},
+		{name: "decomposed", raw: "cafe\u0301.txt", want: "caf\u00e9.txt"},
+		{name: "strict decomposed", raw: "cafe\u0301.txt", strict: true, want: "caf\u00e9.txt"},
+		{name: "strict shift-jis", raw: "\x93\xfa\x96\x7b.txt", strict: true, wantErr: ErrInvalidFileName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeName(tt.raw, tt.strict)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("decodeName(%q) = %v, want %v", tt.raw, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("decodeName(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReceivedNamesAreDecoded(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		strict bool
+		want   string
+	}{
+		{name: "escaped", raw: "\x93\xfa\x96\x7b.txt", want: "%93%FA%96{.txt"},
+		{name: "normalized", raw: "cafe\u0301.txt", want: "caf\u00e9.txt"},
+		{name: "rejected", raw: "\x93\xfa\x96\x7b.txt", strict: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := []byte("名前")
+			r, dest := newTestReceiver(t, WithStrictNames(tt.strict))
+
+			res := rawTransfer(t, r, func(f *fakeSender) {
+				if f.handshake() && f.write(uint32(1)) {
+					f.sendFile(tt.raw, content)
+				}
+			})
+			if tt.want == "" {
+				if !errors.Is(res.err, ErrProtocol) || !errors.Is(res.err, ErrInvalidFileName) {
+					t.Fatalf("ReceiveConn = %v, want ErrProtocol wrapping ErrInvalidFileName", res.err)
+				}
+				assertNoFiles(t, dest)
+				return
+			}
+			if res.err != nil {
+				t.Fatalf("ReceiveConn: %v", res.err)
+			}
+			assertFile(t, filepath.Join(dest, tt.want), content)
+		})
+	}
+}
+
+func TestSanitizeWindowsNames(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "CON", want: "_CON"},
+		{name: "aux.txt", want: "_aux.txt"},
+		{name: "com1.tar.gz", want: "_com1.tar.gz"},
+		{name: "LPT9", want: "_LPT9"},
+		{name: "COM¹", want: "_COM¹"},
+		{name: "nul .txt", want: "_nul .txt"},
+		{name: "CONSOLE.txt", want: "CONSOLE.txt"},
+		{name: "icon.png", want: "icon.png"},
+		{name: "evil..", want: "evil"},
+		{name: "trailing. . ", want: "trailing"},
+		{name: "CON.", want: "_CON"},
+		{name: "...", want: ""},
+		{name: "12:30.txt", want: "12_30.txt"},
+		{name: `a<b>c|d?e*f"g`, want: "a_b_c_d_e_f_g"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeFileName(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("sanitizeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeRelativePathWindowsNames(t *testing.T) {
+	got, err := sanitizeRelativePath("album/CON/aux.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "album/_CON/_aux.txt"; got != want {
+		t.Errorf("sanitizeRelativePath = %q, want %q", got, want)
+	}
+	if got, _ := sanitizeRelativePath("album/.../x."); got != "album/x" {
+		t.Errorf("sanitizeRelativePath = %q, want elements reducing to nothing dropped", got)
+	}
+}
+
+func TestWindowsNamesAreReceivable(t *testing.T) {
+	content := []byte("device")
+	r, dest := newTestReceiver(t)
+
+	res := rawTransfer(t, r, func(f *fakeSender) {
+		if !f.handshake() || !f.write(uint32(2)) {
+			return
+		}
+		f.sendFile("nul.txt", content)
+		f.sendFile("tree/con/notes. ", content)
+	})
+	if res.err != nil {
+		t.Fatalf("ReceiveConn: %v", res.err)
+	}
+	assertFile(t, filepath.Join(dest, "_nul.txt"), content)
+	// slash-separated on the wire, local on disk
+	assertFile(t, filepath.Join(dest, "tree", "_con", "notes"), content)
+}
@@ -0,0 +1,115 @@
+package receiver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// Sink opens the destination the content of a received file is streamed to,
+// replacing the file in the destination directory. name is the name sent by
+// the sender, slash-separated for files inside a transferred directory and
+// not sanitized, so a sink that maps it to a path must do that itself. size is
+// the size of the content, or -1 for content streamed without a size known
+// up front, such as a directory sent as a tar archive.
+//
+// The writer is closed once the whole content arrived and its checksum was
+// verified. When the transfer fails instead, it is closed with
+// CloseWithError if it has such a method, e.g. an *io.PipeWriter, and with
+// Close otherwise, so a sink that can't tell the two apart may keep a
+// truncated or corrupt file.
+type Sink func(name string, size int64) (io.WriteCloser, error)
+
+// closeWithError is implemented by writers that can tell a failed transfer
+// apart from a finished one, such as *io.PipeWriter.
+type closeWithError interface {
+	CloseWithError(err error) error
+}
+
+// abortSink closes w after the transfer into it failed with err.
+func (r *Receiver) abortSink(w io.WriteCloser, err error) {
+	if c, ok := w.(closeWithError); ok {
+		c.CloseWithError(err)
+		return
+	}
+	w.Close()
+}
+
+// receiveToSink receives the content of an accepted file into the writer
+// r.sink opens for it and returns the stats of the file.
+func (r *Receiver) receiveToSink(con net.Conn, filePath string, header protocol.Header, start time.Time) (*TransferStats, error) {
+	streaming := header.Flags&protocol.FlagStreaming != 0
+	size := int64(header.Size)
+	if streaming {
+		size = -1
+	}
+	w, err := r.sink(filePath, size)
+	if err != nil {
+		return nil, fmt.Errorf("err opening sink for %s: %w", filePath, err)
+	}
+
+	// NEGOTIATE RESUME OFFSET
+	// a sink can't be resumed into, so nothing but offset 0 is offered and
+	// there is no partial file to touch
+	digest := sha256.New()
+	if _, err := r.negotiateOffset(con, nil, 0, digest); err != nil {
+		r.abortSink(w, err)
+		return nil, fmt.Errorf("err negotiating resume offset: %w", err)
+	}
+
+	// STREAM CONTENT TO THE SINK
+	trackedSize := header.Size
+	if streaming {
+		trackedSize = progress.UnknownSize
+	}
+	tracker := progress.Start(r.progress, filePath, trackedSize, 0)
+	sizer := r.chunkSizer()
+	var checksum []byte
+	contentSize := header.Size
+	if streaming {
+		checksum, contentSize, err = r.receiveStream(con, w, digest, tracker, sizer)
+	} else {
+		checksum, err = r.receiveContent(con, header.Flags, w, header.Size, digest, tracker, sizer)
+	}
+	tracker.Finish()
+	if err != nil {
+		r.abortSink(w, err)
+		return nil, fmt.Errorf("err receiving file content: %w", err)
+	}
+
+	// VERIFY FILE CHECKSUM
+	expectedChecksum, err := r.receiveFileChecksum(con)
+	if err != nil {
+		r.abortSink(w, err)
+		return nil, fmt.Errorf("err receiving file checksum: %w", err)
+	}
+
+	if !bytes.Equal(checksum, expectedChecksum) {
+		err := fmt.Errorf("%w: expected %x, got %x", ErrChecksumMismatch, expectedChecksum, checksum)
+		r.abortSink(w, err)
+		return nil, r.sendFailedAck(con, err)
+	}
+
+	if err := w.Close(); err != nil {
+		err := fmt.Errorf("err closing sink for %s: %w", filePath, err)
+		return nil, r.sendFailedAck(con, err)
+	}
+	r.filesReceived.Add(1)
+
+	stats := newTransferStats(filePath, "", contentSize, 0, start, checksum)
+	stats.ChunkSize = sizer.Size()
+	r.logger.Info("saved file", "file", stats)
+
+	// CONFIRM THE FILE
+	if err := protocol.WriteAck(con, protocol.Ack{Status: protocol.AckOK}); err != nil {
+		return stats, fmt.Errorf("err confirming file: %w", err)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,40 @@
+package receiver
+
+import (
+	"time"
+)
+
+// WithTCPNoDelay sets TCP_NODELAY on the connections to senders, as Go does
+// by default, so small writes such as the reply to every file header go out
+// right away instead of being held back for Nagle's algorithm to coalesce,
+// which matters most when receiving many small files. False trades that
+// latency for fewer packets.
+func WithTCPNoDelay(noDelay bool) Option {
+	return func(r *Receiver) {
+		r.socket.Delay = !noDelay
+	}
+}
+
+// WithSocketBuffers sets the receive and send buffer sizes of the connections
+// to senders, SO_RCVBUF and SO_SNDBUF, in bytes. The receive buffer caps the
+// TCP window, so large files over links with a lot of bandwidth and latency
+// want about the bandwidth-delay product, e.g. 8 MiB for a gigabit link with
+// 64ms round trips, where the kernel doesn't grow the buffer that far on its
+// own. The buffers are set before connecting, which the window scale depends
+// on, unless WithDialer replaces the dialer. Zero, the default, keeps the
+// kernel's sizes.
+func WithSocketBuffers(read, write int) Option {
+	return func(r *Receiver) {
+		r.socket.ReadBuffer, r.socket.WriteBuffer = read, write
+	}
+}
+
+// WithKeepAlive sends TCP keep-alive probes on the connections to senders
+// every interval, keeping connections that sit idle, e.g. while a file waits
+// to be accepted, from being dropped by a NAT or firewall in between. Zero
+// keeps Go's default of 15s, a negative interval turns them off.
+func WithKeepAlive(interval time.Duration) Option {
+	return func(r *Receiver) {
+		r.socket.KeepAlive = interval
+	}
+}
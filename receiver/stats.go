@@ -0,0 +1,64 @@
+package receiver
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// TransferStats describes a file that was received and saved.
+type TransferStats struct {
+	// Name is the file's name as sent by the sender.
+	Name string `json:"name"`
+	// Path is where the file was saved, empty for files streamed to a Sink.
+	Path string `json:"path"`
+	// Peer is the address of the sender.
+	Peer string `json:"peer"`
+	// Size is the size of the file.
+	Size uint64 `json:"size"`
+	// Bytes is the number of bytes received in this transfer, less than
+	// Size when it was resumed.
+	Bytes uint64 `json:"bytes"`
+	// Duration is how long the file took, from being accepted to being saved.
+	// It is encoded in nanoseconds.
+	Duration time.Duration `json:"duration"`
+	// Throughput is Bytes per second over Duration.
+	Throughput float64 `json:"throughput"`
+	// Checksum is the hex encoded SHA-256 of the file.
+	Checksum string `json:"sha256"`
+	// ChunkSize is the size the content was last read in, once it adapted
+	// to the throughput, see WithChunkSizeBounds. It is zero for content
+	// received in blocks, datagrams or over parallel connections, which are
+	// sized otherwise.
+	ChunkSize int `json:"chunk_size,omitempty"`
+}
+
+func newTransferStats(name, path string, size, offset uint64, start time.Time, checksum []byte) *TransferStats {
+	stats := &TransferStats{
+		Name:     name,
+		Path:     path,
+		Size:     size,
+		Bytes:    size - offset,
+		Duration: time.Since(start),
+		Checksum: fmt.Sprintf("%x", checksum),
+	}
+	if seconds := stats.Duration.Seconds(); seconds > 0 {
+		stats.Throughput = float64(stats.Bytes) / seconds
+	}
+
+	return stats
+}
+
+// LogValue implements slog.LogValuer.
+func (s TransferStats) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("name", s.Name),
+		slog.String("path", s.Path),
+		slog.Uint64("size", s.Size),
+		slog.Uint64("bytes", s.Bytes),
+		slog.Duration("duration", s.Duration.Round(time.Millisecond)),
+		slog.Float64("throughput", s.Throughput),
+		slog.String("sha256", s.Checksum),
+		slog.Int("chunk_size", s.ChunkSize),
+	)
+}
@@ -0,0 +1,85 @@
+package receiver
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pjmessi/go_file_share/sender"
+)
+
+func TestNoSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(t.TempDir(), filepath.Join(dir, "a", "link")); err != nil {
+		t.Skipf("can't create symlinks: %v", err)
+	}
+
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{".", false},
+		{"a", false},
+		{"a/b", false},
+		{"a/b/missing/deeper", false},
+		{"a/link", true},
+		{"a/link/file", true},
+	}
+	for _, tt := range tests {
+		err := noSymlinks(dir, tt.rel)
+		if got := errors.Is(err, ErrProtocol); got != tt.want {
+			t.Errorf("noSymlinks(%q) = %v, want a refusal: %t", tt.rel, err, tt.want)
+		}
+	}
+}
+
+func TestReceivedSymlinksAreNotWrittenThrough(t *testing.T) {
+	r, dir := newTestReceiver(t)
+	outside := t.TempDir()
+	// a symlink received before, where the next transfer has a directory
+	if err := os.MkdirAll(filepath.Join(dir, "tree"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "tree", "inner")); err != nil {
+		t.Skipf("can't create symlinks: %v", err)
+	}
+
+	tree := filepath.Join(t.TempDir(), "tree")
+	writeTestFile(t, tree, "inner/nested.txt", []byte("through the link"))
+	res := pipeTransfer(t, r, newTestSender(t), tree)
+	if !errors.Is(res.err, ErrProtocol) {
+		t.Errorf("ReceiveConn = %v, want ErrProtocol", res.err)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "nested.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("a file was written through the symlink: %v", err)
+	}
+}
+
+func TestResentSymlinksAreKept(t *testing.T) {
+	r, dir := newTestReceiver(t)
+	src := t.TempDir()
+	tree := filepath.Join(src, "tree")
+	writeTestFile(t, tree, "file.txt", []byte("file"))
+	if err := os.Symlink("file.txt", filepath.Join(tree, "link.txt")); err != nil {
+		t.Skipf("can't create symlinks: %v", err)
+	}
+
+	s := newTestSender(t, sender.WithSymlinks(sender.PreserveSymlinks))
+	if res := pipeTransfer(t, r, s, tree); res.err != nil || res.senderErr != nil {
+		t.Fatalf("ReceiveConn = %v, ServeConn = %v", res.err, res.senderErr)
+	}
+	// sending the tree again replaces neither the link nor its target
+	res := pipeTransfer(t, r, s, tree)
+	if res.err != nil || res.senderErr != nil {
+		t.Fatalf("second ReceiveConn = %v, ServeConn = %v", res.err, res.senderErr)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "tree", "link.txt")); err != nil || target != "file.txt" {
+		t.Errorf("symlink points to %q, %v, want file.txt", target, err)
+	}
+	assertFile(t, filepath.Join(dir, "tree", "file.txt"), []byte("file"))
+}
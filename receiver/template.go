@@ -0,0 +1,170 @@
+package receiver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// templatePlaceholder matches a placeholder of a name template.
+var templatePlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// templateFields are the placeholders a name template may use.
+var templateFields = map[string]bool{
+	"name":      true,
+	"stem":      true,
+	"ext":       true,
+	"timestamp": true,
+	"date":      true,
+	"peer":      true,
+	"hash8":     true,
+}
+
+// WithNameTemplate names top-level files after template rather than the
+// current unix timestamp or the sender's name, e.g. "{date}/{peer}/{name}" or
+// "{stem}-{hash8}{ext}". Its placeholders are:
+//
+//	{name}       the file's name as sent, sanitized
+//	{stem}       the name without its extension
+//	{ext}        the extension including its dot, e.g. ".pdf"
+//	{timestamp}  the current unix timestamp
+//	{date}       the current date in the RFC 3339 full-date format, 2006-01-02
+//	{peer}       the sender's IP address
+//	{hash8}      the first 8 hex digits of the file's SHA-256
+//
+// A "/" separates directories, which are created as needed. Every element of
+// the expanded path is sanitized like a name sent by the sender and must stay
+// inside the destination directory. A file named after its hash is written
+// under a provisional name and renamed once it was verified, so it is never
+// resumed. Files inside a transferred directory keep their names. New fails
+// on unknown placeholders.
+func WithNameTemplate(template string) Option {
+	return func(r *Receiver) {
+		r.nameTemplate = template
+	}
+}
+
+// validateNameTemplate reports unknown placeholders and stray braces.
+func validateNameTemplate(template string) error {
+	for _, match := range templatePlaceholder.FindAllStringSubmatch(template, -1) {
+		if !templateFields[match[1]] {
+			return fmt.Errorf("invalid name template %q: unknown placeholder %s", template, match[0])
+		}
+	}
+	if strings.ContainsAny(templatePlaceholder.ReplaceAllString(template, ""), "{}") {
+		return fmt.Errorf("invalid name template %q: unbalanced brace", template)
+	}
+
+	return nil
+}
+
+// templateValues are what the placeholders of a name template expand to.
+type templateValues struct {
+	name     string
+	peer     string
+	now      time.Time
+	checksum string
+}
+
+// expandNameTemplate returns the slash separated path template stands for,
+// relative to the destination directory. Elements that sanitize to nothing
+// are dropped.
+func expandNameTemplate(template string, values templateValues) (string, error) {
+	ext := filepath.Ext(values.name)
+	peer := values.peer
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	hash8 := values.checksum
+	if len(hash8) > 8 {
+		hash8 = hash8[:8]
+	}
+
+	expanded := templatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		switch placeholder[1 : len(placeholder)-1] {
+		case "name":
+			return values.name
+		case "stem":
+			return strings.TrimSuffix(values.name, ext)
+		case "ext":
+			return ext
+		case "timestamp":
+			return strconv.FormatInt(values.now.Unix(), 10)
+		case "date":
+			return values.now.Format(time.DateOnly)
+		case "peer":
+			return peer
+		case "hash8":
+			return hash8
+		}
+		return placeholder
+	})
+
+	var elems []string
+	for _, elem := range strings.Split(expanded, "/") {
+		elem, err := sanitizeFileName(elem)
+		if err != nil {
+			return "", err
+		}
+		if elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	if len(elems) == 0 {
+		return "", errors.New("name template expanded to an empty path")
+	}
+
+	return path.Join(elems...), nil
+}
+
+// pendingHash stands in for the checksum in the provisional path of a file
+// named after its hash.
+const pendingHash = "pending"
+
+// templateDestination expands the name template for the top-level file name
+// sent by peer. A template using {hash8} yields a provisional path and a
+// final one to rename to once the checksum is known.
+func (r *Receiver) templateDestination(name, peer string) (destination, error) {
+	values := templateValues{name: name, peer: peer, now: time.Now()}
+	if values.name == "" {
+		values.name = strconv.FormatInt(values.now.Unix(), 10)
+	}
+
+	resolve := func(values templateValues) (string, error) {
+		relPath, err := expandNameTemplate(r.nameTemplate, values)
+		if err != nil {
+			return "", err
+		}
+		destFilePath := filepath.Join(r.destDir, filepath.FromSlash(relPath))
+		if err := ensureInsideDir(r.destDir, destFilePath); err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(filepath.Dir(destFilePath), 0o755); err != nil {
+			return "", fmt.Errorf("err creating parent directory: %w", err)
+		}
+		return destFilePath, nil
+	}
+
+	if !strings.Contains(r.nameTemplate, "{hash8}") {
+		destFilePath, err := resolve(values)
+		return destination{path: destFilePath}, err
+	}
+
+	values.checksum = pendingHash
+	destFilePath, err := resolve(values)
+	return destination{
+		path:      destFilePath,
+		generated: true,
+		final: func(checksum string) (string, error) {
+			values.checksum = checksum
+			return resolve(values)
+		},
+	}, err
+}
@@ -0,0 +1,111 @@
+package receiver
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateNameTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  bool
+	}{
+		{template: "{name}"},
+		{template: "{date}/{peer}/{name}"},
+		{template: "{stem}-{hash8}{ext}"},
+		{template: "inbox/{timestamp}{ext}"},
+		{template: "plain.txt"},
+		{template: "{nmae}", wantErr: true},
+		{template: "{}", wantErr: true},
+		{template: "{name", wantErr: true},
+		{template: "name}", wantErr: true},
+		{template: "{{name}}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			// at construction, not once files arrive
+			_, err := New(WithNameTemplate(tt.template))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New(WithNameTemplate(%q)) = %v, want error %t", tt.template, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpandNameTemplate(t *testing.T) {
+	now := time.Date(2024, 2, 29, 23, 30, 0, 0, time.UTC)
+	values := templateValues{name: "report.final.pdf", peer: "192.0.2.5:9000", now: now, checksum: "0123456789abcdef"}
+	tests := []struct {
+		template string
+		values   templateValues
+		want     string
+		wantErr  bool
+	}{
+		{template: "{name}", want: "report.final.pdf"},
+		{template: "{stem}-{hash8}{ext}", want: "report.final-01234567.pdf"},
+		{template: "{date}/{peer}/{name}", want: "2024-02-29/192.0.2.5/report.final.pdf"},
+		{template: "{timestamp}{ext}", want: fmt.Sprint(now.Unix(), ".pdf")},
+		{template: "../../{name}", want: "report.final.pdf"},
+		{template: "a//b/./{name}", want: "a/b/report.final.pdf"},
+		{template: "CON/{name}", want: "_CON/report.final.pdf"},
+		{template: "{peer}/{name}", values: templateValues{name: "x", peer: "[fe80::1%eth0]:9000"}, want: "fe80__1%eth0/x"},
+		{template: "{stem}{ext}", values: templateValues{name: "Makefile"}, want: "Makefile"},
+		{template: "{name}", values: templateValues{name: ".."}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			v := values
+			if tt.values != (templateValues{}) {
+				v = tt.values
+			}
+			got, err := expandNameTemplate(tt.template, v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandNameTemplate = %q, %v, want error %t", got, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("expandNameTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNameTemplate(t *testing.T) {
+	content := testContent(3000)
+	hash8 := fmt.Sprintf("%x", sha256.Sum256(content))[:8]
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{template: "{peer}/{name}", want: "pipe/notes.txt"},
+		{template: "{stem}-{hash8}{ext}", want: "notes-" + hash8 + ".txt"},
+		{template: "{date}/{name}", want: time.Now().Format(time.DateOnly) + "/notes.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), "notes.txt", content)
+			r, dest := newTestReceiver(t, WithNameTemplate(tt.template))
+
+			res := pipeTransfer(t, r, newTestSender(t), path)
+			if res.err != nil {
+				t.Fatalf("ReceiveConn: %v", res.err)
+			}
+			want := filepath.Join(dest, filepath.FromSlash(tt.want))
+			if len(res.stats) != 1 || res.stats[0].Path != want {
+				t.Fatalf("stats = %+v, want the file at %s", res.stats, want)
+			}
+			assertFile(t, want, content)
+			// nothing provisional is left behind
+			filepath.WalkDir(dest, func(path string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() && strings.Contains(path, pendingHash) {
+					t.Errorf("%s left behind", path)
+				}
+				return nil
+			})
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package receiver
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pjmessi/go_file_share/internal/quicconn"
+)
+
+// ErrFingerprintMismatch is returned when the sender's certificate does not
+// match the fingerprint configured with WithTLSFingerprint.
+var ErrFingerprintMismatch = errors.New("certificate fingerprint mismatch")
+
+// dial connects to a sender, using TLS if it announced it or QUIC if it
+// serves QUIC, retrying the connection as configured with WithDialRetry. The
+// default dialer tries every address a host name resolves to in order.
+func (r *Receiver) dial(ctx context.Context, p Peer) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(p.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.QUIC {
+		// the handshake is part of connecting, only its failure to answer
+		// is retried
+		var con net.Conn
+		var handshakeErr error
+		err := r.dialRetry.Do(ctx, func() error {
+			var err error
+			if con, err = quicconn.Dial(ctx, r.localUDPAddr(host), p.Addr, r.tlsConfig(host)); err != nil {
+				r.logger.Debug("err connecting to peer", "peer", p.Addr, "err", err)
+			}
+			if quicconn.HandshakeFailed(err) {
+				handshakeErr = err
+				return nil
+			}
+			return err
+		})
+		if handshakeErr != nil {
+			return nil, handshakeErr
+		}
+		return con, err
+	}
+
+	dial := r.dialer
+	if dial == nil {
+		dialer := net.Dialer{Control: r.socket.Control, LocalAddr: r.localTCPAddr(host)}
+		dial = dialer.DialContext
+	}
+	var con net.Conn
+	err = r.dialRetry.Do(ctx, func() error {
+		var err error
+		if con, err = dial(ctx, "tcp", p.Addr); err != nil {
+			r.logger.Debug("err connecting to peer", "peer", p.Addr, "err", err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.socket.Apply(con); err != nil {
+		r.logger.Warn("err tuning connection", "peer", p.Addr, "err", err)
+	}
+	if !p.TLS {
+		return con, nil
+	}
+
+	tlsCon := tls.Client(con, r.tlsConfig(host))
+	if err := tlsCon.HandshakeContext(ctx); err != nil {
+		con.Close()
+		return nil, err
+	}
+
+	return tlsCon, nil
+}
+
+// tlsConfig verifies the certificate of the sender at host as configured
+// with WithTLSFingerprint and WithTLSInsecure.
+func (r *Receiver) tlsConfig(host string) *tls.Config {
+	config := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if r.tlsFingerprint != "" {
+		// the pin replaces chain verification, senders usually run with a
+		// throwaway self-signed certificate
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = r.verifyFingerprint
+	} else if r.tlsInsecure {
+		config.InsecureSkipVerify = true
+	}
+
+	return config
+}
+
+func (r *Receiver) verifyFingerprint(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("%w: no certificate presented", ErrFingerprintMismatch)
+	}
+
+	sum := sha256.Sum256(rawCerts[0])
+	if hex.EncodeToString(sum[:]) != normalizeFingerprint(r.tlsFingerprint) {
+		return fmt.Errorf("%w: got %X", ErrFingerprintMismatch, sum)
+	}
+
+	return nil
+}
+
+// normalizeFingerprint accepts the colon separated form printed by the sender
+// as well as plain hex, in any case.
+func normalizeFingerprint(fp string) string {
+	fp = strings.TrimPrefix(strings.ToLower(fp), "sha256:")
+	return strings.ReplaceAll(fp, ":", "")
+}
@@ -0,0 +1,212 @@
+package receiver
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+const (
+	// udpIdleTimeout bounds the wait for the next datagram of a file
+	udpIdleTimeout = 30 * time.Second
+	// udpLinger is how long the socket keeps acknowledging once every
+	// datagram arrived, in case the last acks were lost
+	udpLinger = 5 * time.Second
+	// udpAckEvery is how many datagrams arriving in order are acknowledged
+	// at once
+	udpAckEvery = 16
+	// udpReadBuffer is the socket receive buffer asked for, so bursts
+	// aren't dropped while a datagram is written to disk
+	udpReadBuffer = 4 << 20
+)
+
+// sideChannelCapable reports whether content can be taken next to con, over
+// UDP or parallel connections, which needs an IP address to bind to and a
+// file to write datagrams and ranges to their place in.
+func (r *Receiver) sideChannelCapable(con net.Conn) bool {
+	_, ok := con.LocalAddr().(*net.TCPAddr)
+	return ok && r.sink == nil && r.limiter == nil
+}
+
+// receiveDatagrams receives the content from offset to contentSize as sent
+// under protocol.FlagUDP, writing every datagram to its place in file. It
+// returns the digest of the whole file, of which digest already covers the
+// first offset bytes. On failure, file is cut down to the datagrams received
+// in order, so a resumed transfer can continue from it.
+func (r *Receiver) receiveDatagrams(con net.Conn, file *os.File, offset, contentSize uint64, digest hash.Hash, tracker *progress.Tracker) ([]byte, error) {
+	local, ok := con.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("%w: datagrams offered on a %s connection", ErrProtocol, con.LocalAddr().Network())
+	}
+
+	// OFFER A PORT
+	udp, err := r.listenDatagrams(net.UDPAddrFromAddrPort(netip.AddrPortFrom(local.AddrPort().Addr().Unmap(), 0)))
+	if err != nil {
+		return nil, fmt.Errorf("err listening for datagrams: %w", err)
+	}
+	lingering := false
+	defer func() {
+		if !lingering {
+			udp.Close()
+		}
+	}()
+	if buffered, ok := udp.(interface{ SetReadBuffer(int) error }); ok {
+		if err := buffered.SetReadBuffer(udpReadBuffer); err != nil {
+			r.logger.Debug("err growing udp receive buffer", "err", err)
+		}
+	}
+
+	offer := protocol.UDPOffer{Port: uint16(udp.LocalAddr().(*net.UDPAddr).Port)}
+	rand.Read(offer.Token[:])
+	if err := binary.Write(con, binary.LittleEndian, offer); err != nil {
+		return nil, fmt.Errorf("err offering udp port: %w", err)
+	}
+	var payloadSize uint16
+	if err := binary.Read(con, binary.LittleEndian, &payloadSize); err != nil {
+		return nil, fmt.Errorf("err receiving payload size: %w", err)
+	}
+	if payloadSize == 0 || int(payloadSize) > protocol.MaxMTU-protocol.DataHeaderSize {
+		return nil, fmt.Errorf("%w: payload size %d", ErrProtocol, payloadSize)
+	}
+
+	// RECEIVE DATAGRAMS
+	size := contentSize - offset
+	received := newBlockBitmap(protocol.PacketCount(size, int(payloadSize)))
+	// missing is the lowest sequence number not received, hashed how many
+	// datagrams digest covers and next the one expected after the last
+	missing, hashed, next := uint64(0), uint64(0), uint64(0)
+	var sender net.Addr
+	buf := make([]byte, protocol.MaxMTU)
+	var reply []byte
+	unacked := 0
+	for missing < received.count {
+		if err := udp.SetReadDeadline(time.Now().Add(udpIdleTimeout)); err != nil {
+			return nil, fmt.Errorf("err setting datagram deadline: %w", err)
+		}
+		n, addr, err := udp.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			r.truncatePartFile(file, min(offset+hashed*uint64(payloadSize), contentSize))
+			return nil, fmt.Errorf("%w: no datagram for %s, %d of %d received", ErrIncompleteTransfer, udpIdleTimeout, missing, received.count)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("err receiving datagram: %w", err)
+		}
+
+		// strays and datagrams of other files are dropped
+		d, err := protocol.ParseDatagram(buf[:n])
+		if err != nil || d.Type != protocol.DatagramData || d.Token != offer.Token || d.Seq >= received.count {
+			continue
+		}
+		if uint64(len(d.Payload)) != min(uint64(payloadSize), size-d.Seq*uint64(payloadSize)) {
+			continue
+		}
+		sender = addr
+
+		if !received.has(d.Seq) {
+			if _, err := file.WriteAt(d.Payload, int64(offset+d.Seq*uint64(payloadSize))); err != nil {
+				return nil, fmt.Errorf("err writing datagram %d: %w", d.Seq, err)
+			}
+			received.set(d.Seq)
+			tracker.Add(len(d.Payload))
+			if d.Seq == hashed {
+				digest.Write(d.Payload)
+				hashed++
+			}
+		}
+		for missing < received.count && received.has(missing) {
+			missing++
+		}
+
+		// ACKNOWLEDGE
+		// datagrams out of order mean loss or a retransmission, which the
+		// sender wants to hear about right away
+		if d.Seq > next {
+			var nacks []uint64
+			for seq := next; seq < d.Seq && len(nacks) < protocol.MaxNacks; seq++ {
+				if !received.has(seq) {
+					nacks = append(nacks, seq)
+				}
+			}
+			reply = protocol.AppendNack(reply[:0], offer.Token, nacks)
+			r.replyDatagram(udp, sender, reply)
+		}
+		unacked++
+		if d.Seq != next || unacked >= udpAckEvery || missing == received.count {
+			reply = protocol.AppendAck(reply[:0], offer.Token, missing, received.maskAfter(missing), d.Seq)
+			r.replyDatagram(udp, sender, reply)
+			unacked = 0
+		}
+		next = max(next, d.Seq+1)
+	}
+
+	if sender != nil {
+		lingering = true
+		go r.lingerUDP(udp, sender, offer.Token, received.count)
+	}
+
+	// HASH THE DATAGRAMS THAT CAME OUT OF ORDER
+	if err := hashFileRange(digest, file.Name(), min(offset+hashed*uint64(payloadSize), contentSize), contentSize); err != nil {
+		return nil, fmt.Errorf("err hashing received datagrams: %w", err)
+	}
+
+	r.logger.Debug("received file content", "bytes", size, "datagrams", received.count)
+
+	return digest.Sum(nil), nil
+}
+
+// listenUDP listens for datagrams on addr.
+func listenUDP(addr *net.UDPAddr) (net.PacketConn, error) {
+	return net.ListenUDP("udp", addr)
+}
+
+// replyDatagram sends an ack or nack to the sender. A lost one is made up
+// for by the next, so failures are only logged.
+func (r *Receiver) replyDatagram(udp net.PacketConn, sender net.Addr, reply []byte) {
+	if _, err := udp.WriteTo(reply, sender); err != nil {
+		r.logger.Debug("err sending ack", "err", err)
+	}
+}
+
+// lingerUDP answers the datagrams still arriving after all of count arrived,
+// which means the sender missed the last ack, until none came for udpLinger,
+// then closes udp.
+func (r *Receiver) lingerUDP(udp net.PacketConn, sender net.Addr, token [protocol.TokenSize]byte, count uint64) {
+	defer udp.Close()
+
+	buf := make([]byte, protocol.MaxMTU)
+	var ack []byte
+	for {
+		if err := udp.SetReadDeadline(time.Now().Add(udpLinger)); err != nil {
+			return
+		}
+		n, _, err := udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if d, err := protocol.ParseDatagram(buf[:n]); err == nil && d.Token == token {
+			ack = protocol.AppendAck(ack[:0], token, count, 0, d.Seq)
+			udp.WriteTo(ack, sender)
+		}
+	}
+}
+
+// maskAfter returns which of the 64 indexes after index are set, as bits
+// from the least significant one on.
+func (b *blockBitmap) maskAfter(index uint64) uint64 {
+	var mask uint64
+	for i := uint64(0); i < 64 && index+1+i < b.count; i++ {
+		if b.has(index + 1 + i) {
+			mask |= 1 << i
+		}
+	}
+	return mask
+}
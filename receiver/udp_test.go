@@ -0,0 +1,144 @@
+package receiver
+
+import (
+	"math/rand"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/pjmessi/go_file_share/sender"
+)
+
+// lossyPacketConn loses and reorders the data datagrams read from it, at
+// random but reproducibly: loss of them are dropped, and reorder of them
+// held back until the next one was read.
+type lossyPacketConn struct {
+	net.PacketConn
+	loss, reorder float64
+
+	mu      sync.Mutex
+	rng     *rand.Rand
+	held    []byte
+	heldBy  net.Addr
+	dropped atomic.Int64
+	swapped atomic.Int64
+}
+
+func newLossyPacketConn(con net.PacketConn, loss, reorder float64) *lossyPacketConn {
+	return &lossyPacketConn{PacketConn: con, loss: loss, reorder: reorder, rng: rand.New(rand.NewSource(1))}
+}
+
+func (c *lossyPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil {
+			return n, addr, err
+		}
+		if d, err := protocol.ParseDatagram(p[:n]); err != nil || d.Type != protocol.DatagramData {
+			return n, addr, nil
+		}
+		switch roll := c.rng.Float64(); {
+		case roll < c.loss:
+			c.dropped.Add(1)
+			continue
+		case roll < c.loss+c.reorder && c.held == nil:
+			c.held, c.heldBy = append([]byte(nil), p[:n]...), addr
+			continue
+		}
+		if c.held == nil {
+			return n, addr, nil
+		}
+		// hand out the datagram read now, and the one held back next time
+		read := append([]byte(nil), p[:n]...)
+		n = copy(p, c.held)
+		addr, c.held = c.heldBy, read
+		c.swapped.Add(1)
+		return n, addr, nil
+	}
+}
+
+// lossyDatagrams makes r receive datagrams through a lossyPacketConn, which
+// it returns once r listened for them.
+func lossyDatagrams(r *Receiver, loss, reorder float64) <-chan *lossyPacketConn {
+	lossy := make(chan *lossyPacketConn, 1)
+	r.listenDatagrams = func(addr *net.UDPAddr) (net.PacketConn, error) {
+		con, err := listenUDP(addr)
+		if err != nil {
+			return nil, err
+		}
+		c := newLossyPacketConn(con, loss, reorder)
+		lossy <- c
+		return c, nil
+	}
+	return lossy
+}
+
+func TestUDPTransfer(t *testing.T) {
+	tests := []struct {
+		name          string
+		loss, reorder float64
+	}{
+		{"clean", 0, 0},
+		{"lossy", 0.1, 0},
+		{"reordered", 0, 0.1},
+		{"lossy and reordered", 0.05, 0.05},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, dir := newTestReceiver(t)
+			lossy := lossyDatagrams(r, tt.loss, tt.reorder)
+			s := newTestSender(t, sender.WithTransport(sender.TransportUDP))
+			content := testContent(2 << 20)
+			path := writeTestFile(t, t.TempDir(), "lossy.bin", content)
+
+			res := tcpTransfer(t, r, s, path)
+			if res.err != nil || res.senderErr != nil {
+				t.Fatalf("ReceiveConn = %v, ServeConn = %v", res.err, res.senderErr)
+			}
+			assertFile(t, filepath.Join(dir, "lossy.bin"), content)
+
+			var c *lossyPacketConn
+			select {
+			case c = <-lossy:
+			default:
+				t.Fatal("content wasn't sent over udp")
+			}
+			if tt.loss > 0 && c.dropped.Load() == 0 {
+				t.Error("no datagram was dropped")
+			}
+			if tt.reorder > 0 && c.swapped.Load() == 0 {
+				t.Error("no datagram was reordered")
+			}
+		})
+	}
+}
+
+func TestSideChannelsOverIPv6(t *testing.T) {
+	needIPv6(t)
+	tests := []struct {
+		name string
+		opt  sender.Option
+	}{
+		{"udp", sender.WithTransport(sender.TransportUDP)},
+		{"parallel", sender.WithParallelStreams(2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, dir := newTestReceiver(t)
+			content := testContent(2 << 20)
+			path := writeTestFile(t, t.TempDir(), "v6.bin", content)
+
+			res := tcpTransferOn(t, "::1", r, newTestSender(t, tt.opt), path)
+			if res.err != nil || res.senderErr != nil {
+				t.Fatalf("ReceiveConn = %v, ServeConn = %v", res.err, res.senderErr)
+			}
+			assertFile(t, filepath.Join(dir, "v6.bin"), content)
+		})
+	}
+}
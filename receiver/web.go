@@ -0,0 +1,209 @@
+package receiver
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/lanurl"
+	"github.com/pjmessi/go_file_share/internal/netif"
+	"github.com/pjmessi/go_file_share/protocol"
+	"golang.org/x/net/websocket"
+)
+
+//go:embed web/uploader.html
+var uploaderPage string
+
+// uploaderTemplate fills the protocol constants into the uploader page, so
+// the page can't drift from the build serving it.
+var uploaderTemplate = template.Must(template.New("uploader").Parse(uploaderPage))
+
+// WithWebListener serves a page on addr, e.g. ":8080", that sends the files
+// dropped onto it from a browser, such as a phone's, to the receiver while
+// Receive runs. The page speaks the same protocol as a sender, as binary
+// WebSocket messages, so its files are checked, saved, limited and reported
+// like any other; they are received just as compressed, checksummed blocks
+// and datagrams aren't used. Uploads are received concurrently, up to
+// WithMaxConcurrent at once, and their stats are returned along with the
+// others. Only pages served by the listener itself may upload. Empty, the
+// default, serves nothing.
+func WithWebListener(addr string) Option {
+	return func(r *Receiver) {
+		r.webAddr = addr
+	}
+}
+
+// webUploads is the running web listener.
+type webUploads struct {
+	server *http.Server
+	// slots bounds the uploads received at once
+	slots chan struct{}
+	// uploads tracks the uploads in flight, abort cancels them
+	uploads sync.WaitGroup
+	ctx     context.Context
+	abort   context.CancelFunc
+
+	mu    sync.Mutex
+	stats []TransferStats
+	// stopped is set once uploads are no longer taken
+	stopped bool
+}
+
+// serveWeb starts the listener set with WithWebListener. Uploads outlive ctx
+// for the drain timeout, see stop.
+func (r *Receiver) serveWeb(ctx context.Context) (*webUploads, error) {
+	listener, err := r.listenOn(r.webAddr)
+	if err != nil {
+		return nil, fmt.Errorf("err starting web listener: %w", err)
+	}
+
+	w := r.newWebUploads(ctx)
+	w.server = &http.Server{Handler: r.webHandler(w), ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := w.server.Serve(filteredListener{listener, r}); !errors.Is(err, http.ErrServerClosed) {
+			r.logger.Error("web listener failed", "err", err)
+		}
+	}()
+	for _, addr := range netif.Addrs(listener) {
+		for _, url := range lanurl.HTTP(addr) {
+			r.logger.Info("serving uploader page", "url", url)
+		}
+	}
+
+	return w, nil
+}
+
+// newWebUploads returns the state of a web listener whose uploads outlive
+// ctx for the drain timeout. Its server is left to the caller.
+func (r *Receiver) newWebUploads(ctx context.Context) *webUploads {
+	maxConcurrent := r.maxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultMaxConcurrent
+	}
+	w := &webUploads{slots: make(chan struct{}, maxConcurrent)}
+	w.ctx, w.abort = context.WithCancel(context.WithoutCancel(ctx))
+
+	return w
+}
+
+// webHandler serves the uploader page and the WebSocket it uploads through,
+// receiving the uploads into w.
+func (r *Receiver) webHandler(w *webUploads) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", r.serveUploader)
+	mux.Handle("GET /ws", websocket.Server{
+		Handshake: checkOrigin,
+		Handler:   func(ws *websocket.Conn) { r.receiveUpload(w, ws) },
+	})
+
+	return mux
+}
+
+func (r *Receiver) serveUploader(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := uploaderTemplate.Execute(rw, map[string]any{
+		"Magic":           protocol.Magic,
+		"Version":         protocol.Version,
+		"CapAuthRequired": protocol.CapAuthRequired,
+		"NonceSize":       protocol.NonceSize,
+	})
+	if err != nil {
+		r.logger.Debug("err serving uploader page", "err", err)
+	}
+}
+
+// checkOrigin refuses WebSocket connections from pages served elsewhere,
+// which could otherwise upload to a receiver on the visitor's network.
+// Browsers always send an origin; clients that don't are let through, as
+// they could claim any origin anyway.
+func checkOrigin(config *websocket.Config, req *http.Request) error {
+	// the server only parses the origin into config without a Handshake
+	// of its own
+	origin, err := websocket.Origin(config, req)
+	if err != nil {
+		return err
+	}
+	if origin == nil {
+		return nil
+	}
+	if origin.Host != req.Host {
+		return errors.New("websocket from a foreign origin")
+	}
+	config.Origin = origin
+
+	return nil
+}
+
+// receiveUpload receives what the page at the other end of ws sends.
+func (r *Receiver) receiveUpload(w *webUploads, ws *websocket.Conn) {
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		ws.Close()
+		return
+	}
+	w.uploads.Add(1)
+	w.mu.Unlock()
+	defer w.uploads.Done()
+	ws.PayloadType = websocket.BinaryFrame
+
+	select {
+	case w.slots <- struct{}{}:
+		defer func() { <-w.slots }()
+	case <-w.ctx.Done():
+		ws.Close()
+		return
+	}
+
+	con := &webConn{Conn: ws, remote: webPeer(ws.Request().RemoteAddr)}
+	r.logger.Debug("browser connected", "peer", con.RemoteAddr())
+	stats, err := r.receiveConn(w.ctx, con, nil)
+	if err != nil {
+		r.logger.Error("err receiving upload", "peer", con.RemoteAddr(), "err", err)
+		r.reportError(w.ctx, &PeerError{Addr: con.RemoteAddr().String(), Err: err})
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stats = append(w.stats, stats...)
+}
+
+// stop closes the listener, gives the uploads in flight the drain timeout to
+// finish and returns the stats of every file uploaded.
+func (w *webUploads) stop(r *Receiver) []TransferStats {
+	w.server.Close()
+	w.mu.Lock()
+	w.stopped = true
+	w.mu.Unlock()
+	r.drain(&w.uploads, w.abort)
+	w.abort()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.stats
+}
+
+// webConn is a WebSocket as a net.Conn with the browser's address, where
+// websocket.Conn only knows the page's origin.
+type webConn struct {
+	*websocket.Conn
+	remote net.Addr
+}
+
+func (c *webConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// webPeer is the address of a browser, as host:port.
+type webPeer string
+
+func (p webPeer) Network() string { return "tcp" }
+func (p webPeer) String() string  { return string(p) }
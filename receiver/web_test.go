@@ -0,0 +1,112 @@
+package receiver
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// startWeb serves r's uploader page and WebSocket from an httptest server
+// behind the listener filtering of WithWebListener, and returns the uploads
+// along with the server.
+func startWeb(t *testing.T, r *Receiver) (*webUploads, *httptest.Server) {
+	t.Helper()
+
+	w := r.newWebUploads(context.Background())
+	ts := httptest.NewUnstartedServer(r.webHandler(w))
+	ts.Listener = filteredListener{ts.Listener, r}
+	w.server = ts.Config
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	return w, ts
+}
+
+// dialUpload opens the upload WebSocket of ts as a page from origin would.
+func dialUpload(ts *httptest.Server, origin string) (*websocket.Conn, error) {
+	config, err := websocket.NewConfig("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", origin)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+
+	return ws, nil
+}
+
+func TestWebUpload(t *testing.T) {
+	content := testContent(64 << 10)
+	path := writeTestFile(t, t.TempDir(), "holiday.jpg", content)
+	r, dest := newTestReceiver(t)
+	w, ts := startWeb(t, r)
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("GET / = %s %q, want the uploader page", res.Status, res.Header.Get("Content-Type"))
+	}
+
+	// the page speaks the sender's protocol over the WebSocket
+	ws, err := dialUpload(ts, ts.URL)
+	if err != nil {
+		t.Fatalf("dialing the upload socket: %v", err)
+	}
+	if err := newTestSender(t).ServeConn(ws, []string{path}); err != nil {
+		t.Fatalf("uploading: %v", err)
+	}
+	ws.Close()
+
+	stats := w.stop(r)
+	if len(stats) != 1 || stats[0].Name != "holiday.jpg" {
+		t.Fatalf("stats = %+v, want the uploaded file", stats)
+	}
+	assertFile(t, filepath.Join(dest, "holiday.jpg"), content)
+}
+
+func TestWebUploadFromForeignOrigin(t *testing.T) {
+	r, dest := newTestReceiver(t)
+	w, ts := startWeb(t, r)
+
+	if ws, err := dialUpload(ts, "http://evil.example"); err == nil {
+		ws.Close()
+		t.Fatal("WebSocket from a foreign origin accepted")
+	}
+	if stats := w.stop(r); len(stats) != 0 {
+		t.Errorf("stats = %+v, want nothing uploaded", stats)
+	}
+	assertNoFiles(t, dest)
+}
+
+func TestWebDropsDeniedPeers(t *testing.T) {
+	r, dest := newTestReceiver(t, WithDeniedPeers("127.0.0.0/8"))
+	_, ts := startWeb(t, r)
+
+	con, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+	con.SetDeadline(time.Now().Add(testTimeout))
+	if _, err := io.WriteString(con, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	// closed as it was accepted, without an answer
+	if n, err := con.Read(make([]byte, 1)); n != 0 || err == nil {
+		t.Errorf("read %d bytes, %v from a denied peer's connection, want it closed", n, err)
+	}
+	assertNoFiles(t, dest)
+}
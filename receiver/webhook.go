@@ -0,0 +1,126 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pjmessi/go_file_share/retry"
+)
+
+// WebhookSignatureHeader carries the signature of a webhook body when a
+// secret is set with WithWebhookSecret: "sha256=" followed by the hex encoded
+// HMAC-SHA256 of the body keyed with the secret.
+const WebhookSignatureHeader = "X-Fileshare-Signature"
+
+// webhookTimeout bounds a single delivery attempt
+const webhookTimeout = 5 * time.Second
+
+// webhookRetry is how often a delivery is attempted before it is given up
+var webhookRetry = retry.Policy{MaxAttempts: 3, InitialDelay: 500 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+// WithWebhook POSTs a HistoryRecord as JSON to url for every file that was
+// saved, failed or was declined, e.g. to start processing a file the moment
+// it lands. Deliveries run alongside the transfers and are retried a few
+// times; if they still fail this is logged, the transfer itself is
+// unaffected. Receive and ReceiveConn wait for the deliveries in flight
+// before returning.
+func WithWebhook(url string) Option {
+	return func(r *Receiver) {
+		r.webhookURL = url
+	}
+}
+
+// WithWebhookSecret signs every webhook body with secret, see
+// WebhookSignatureHeader, so the endpoint can tell it came from this
+// receiver.
+func WithWebhookSecret(secret string) Option {
+	return func(r *Receiver) {
+		r.webhookSecret = secret
+	}
+}
+
+func validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url: %s: want an http or https url", rawURL)
+	}
+
+	return nil
+}
+
+// WebhookSignature returns the value of WebhookSignatureHeader for body
+// signed with secret.
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyWebhook posts record to the webhook, if one is configured, without
+// waiting for the delivery.
+func (r *Receiver) notifyWebhook(record HistoryRecord) {
+	if r.webhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(record)
+	if err != nil {
+		r.logger.Warn("err encoding webhook body", "err", err)
+		return
+	}
+
+	r.deliveries.Add(1)
+	go func() {
+		defer r.deliveries.Done()
+
+		// the delivery outlives the transfer's context on purpose, a
+		// cancelled receiver still reports the files it got
+		err := webhookRetry.Do(context.Background(), func() error {
+			return r.postWebhook(body)
+		})
+		if err != nil {
+			r.logger.Warn("err delivering webhook", "url", r.webhookURL, "name", record.Name, "err", err)
+		}
+	}()
+}
+
+// postWebhook makes a single delivery attempt.
+func (r *Receiver) postWebhook(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.webhookSecret != "" {
+		req.Header.Set(WebhookSignatureHeader, WebhookSignature(r.webhookSecret, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package receiver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/retry"
+)
+
+// webhookRequest is a delivery a webhookServer got.
+type webhookRequest struct {
+	body      []byte
+	signature string
+}
+
+// webhookServer records the deliveries it gets, answering the first fail of
+// them with a 503.
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []webhookRequest
+	fail     int
+}
+
+func newWebhookServer(t *testing.T, fail int) *webhookServer {
+	t.Helper()
+
+	s := &webhookServer{fail: fail}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, webhookRequest{body: body, signature: req.Header.Get(WebhookSignatureHeader)})
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with %q, want a JSON POST", req.Method, req.Header.Get("Content-Type"))
+		}
+		if len(s.requests) <= s.fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) got() []webhookRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+// fastWebhookRetry retries deliveries without waiting for the length of the
+// test.
+func fastWebhookRetry(t *testing.T) {
+	saved := webhookRetry
+	webhookRetry = retry.Policy{MaxAttempts: saved.MaxAttempts, InitialDelay: time.Millisecond}
+	t.Cleanup(func() { webhookRetry = saved })
+}
+
+func TestWebhookPayload(t *testing.T) {
+	content := testContent(4321)
+	path := writeTestFile(t, t.TempDir(), "landed.csv", content)
+	server := newWebhookServer(t, 0)
+	r, dest := newTestReceiver(t, WithWebhook(server.URL), WithWebhookSecret("s3cret"))
+
+	if res := pipeTransfer(t, r, newTestSender(t), path); res.err != nil {
+		t.Fatalf("ReceiveConn: %v", res.err)
+	}
+	requests := server.got()
+	if len(requests) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(requests))
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal(requests[0].body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(payload))
+	for key := range payload {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	if want := []string{"duration", "name", "outcome", "path", "peer", "sha256", "size", "time"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("payload keys = %q, want %q", keys, want)
+	}
+	want := map[string]any{
+		"name":    "landed.csv",
+		"path":    filepath.Join(dest, "landed.csv"),
+		"peer":    "pipe",
+		"size":    4321.0,
+		"sha256":  fmt.Sprintf("%x", sha256.Sum256(content)),
+		"outcome": OutcomeOK,
+	}
+	for key, v := range want {
+		if payload[key] != v {
+			t.Errorf("%s = %v, want %v", key, payload[key], v)
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(requests[0].body)
+	if sig := "sha256=" + hex.EncodeToString(mac.Sum(nil)); requests[0].signature != sig {
+		t.Errorf("signature = %q, want %q", requests[0].signature, sig)
+	}
+}
+
+func TestWebhookReportsFailures(t *testing.T) {
+	server := newWebhookServer(t, 0)
+	r, _ := newTestReceiver(t, WithWebhook(server.URL))
+
+	rawTransfer(t, r, func(f *fakeSender) {
+		if f.handshake() && f.write(uint32(1)) {
+			f.sendFileWithChecksum("bad.bin", []byte("x"), make([]byte, 32))
+		}
+	})
+	requests := server.got()
+	if len(requests) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(requests))
+	}
+	var record HistoryRecord
+	if err := json.Unmarshal(requests[0].body, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Outcome != OutcomeFailed || !strings.Contains(record.Error, "checksum") || record.Path != "" {
+		t.Errorf("record = %+v, want a failed transfer", record)
+	}
+	if requests[0].signature != "" {
+		t.Errorf("signed with %q without a secret", requests[0].signature)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	fastWebhookRetry(t)
+	tests := []struct {
+		name     string
+		fail     int
+		attempts int
+	}{
+		{name: "recovers", fail: 2, attempts: 3},
+		{name: "gives up", fail: 100, attempts: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), "a.txt", []byte("a"))
+			server := newWebhookServer(t, tt.fail)
+			r, _ := newTestReceiver(t, WithWebhook(server.URL))
+
+			// a failing webhook leaves the transfer alone
+			if res := pipeTransfer(t, r, newTestSender(t), path); res.err != nil || len(res.stats) != 1 {
+				t.Fatalf("ReceiveConn = %v, %d files", res.err, len(res.stats))
+			}
+			if got := len(server.got()); got != tt.attempts {
+				t.Errorf("got %d attempts, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestWebhookURLValidation(t *testing.T) {
+	for _, url := range []string{"ftp://example.com/hook", "example.com/hook", "http://"} {
+		if _, err := New(WithWebhook(url)); err == nil {
+			t.Errorf("New(WithWebhook(%q)) succeeded", url)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Package retry repeats operations that may fail transiently, such as
+// connecting to a peer whose listener isn't up yet, waiting exponentially
+// longer between attempts.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy decides how often an operation is tried and how long to wait in
+// between. The zero Policy tries once.
+type Policy struct {
+	// MaxAttempts is how often the operation is tried in total. Zero counts
+	// as one.
+	MaxAttempts int
+	// InitialDelay is the wait after the first failed attempt. Every
+	// following wait doubles, up to MaxDelay.
+	InitialDelay time.Duration
+	// MaxDelay caps the wait between two attempts. Zero means no cap.
+	MaxDelay time.Duration
+}
+
+// Default tries 5 times, waiting up to 11 seconds in total.
+var Default = Policy{MaxAttempts: 5, InitialDelay: time.Second, MaxDelay: 4 * time.Second}
+
+// Validate reports a policy with negative fields.
+func (p Policy) Validate() error {
+	if p.MaxAttempts < 0 || p.InitialDelay < 0 || p.MaxDelay < 0 {
+		return errors.New("retry attempts and delays can't be negative")
+	}
+
+	return nil
+}
+
+// Delay returns how long to wait after the given number of failed attempts.
+// It is randomized between half and all of the exponential delay, so peers
+// that failed at the same moment don't retry in lockstep.
+func (p Policy) Delay(failed int) time.Duration {
+	delay := p.InitialDelay
+	for i := 1; i < failed; i++ {
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			break
+		}
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// Do calls op until it returns nil or the attempts are used up. The error of
+// the last attempt is returned, wrapped once op was tried more than once. If
+// ctx is done while waiting for the next attempt, ctx.Err() is returned.
+func (p Policy) Do(ctx context.Context, op func() error) error {
+	attempts := max(p.MaxAttempts, 1)
+
+	for failed := 0; ; {
+		err := op()
+		if err == nil {
+			return nil
+		}
+		failed++
+		if failed >= attempts {
+			if failed == 1 {
+				return err
+			}
+			return fmt.Errorf("giving up after %d attempts: %w", failed, err)
+		}
+
+		timer := time.NewTimer(p.Delay(failed))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
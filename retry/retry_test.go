@@ -0,0 +1,105 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	p := Policy{MaxAttempts: 10, InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		failed int
+		full   time.Duration
+	}{
+		{failed: 1, full: 100 * time.Millisecond},
+		{failed: 2, full: 200 * time.Millisecond},
+		{failed: 3, full: 400 * time.Millisecond},
+		{failed: 4, full: 800 * time.Millisecond},
+		{failed: 5, full: time.Second},
+		{failed: 60, full: time.Second},
+	}
+	for _, tt := range tests {
+		// jittered between half and all of it
+		for range 100 {
+			if d := p.Delay(tt.failed); d < tt.full/2 || d > tt.full {
+				t.Fatalf("Delay(%d) = %s, want between %s and %s", tt.failed, d, tt.full/2, tt.full)
+			}
+		}
+	}
+}
+
+func TestDelayUncapped(t *testing.T) {
+	p := Policy{InitialDelay: time.Millisecond}
+	if d := p.Delay(11); d < 512*time.Millisecond || d > 1024*time.Millisecond {
+		t.Errorf("Delay(11) = %s, want about 1s without a cap", d)
+	}
+	if d := (Policy{}).Delay(3); d != 0 {
+		t.Errorf("Delay of the zero Policy = %s, want none", d)
+	}
+}
+
+func TestDo(t *testing.T) {
+	errFlaky := errors.New("connection refused")
+	tests := []struct {
+		name         string
+		policy       Policy
+		failures     int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "zero policy", failures: 1, wantAttempts: 1, wantErr: true},
+		{name: "first try", policy: Policy{MaxAttempts: 3}, wantAttempts: 1},
+		{name: "recovers", policy: Policy{MaxAttempts: 3, InitialDelay: time.Millisecond}, failures: 2, wantAttempts: 3},
+		{name: "gives up", policy: Policy{MaxAttempts: 3, InitialDelay: time.Millisecond}, failures: 5, wantAttempts: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := tt.policy.Do(context.Background(), func() error {
+				attempts++
+				if attempts <= tt.failures {
+					return errFlaky
+				}
+				return nil
+			})
+			if attempts != tt.wantAttempts {
+				t.Errorf("tried %d times, want %d", attempts, tt.wantAttempts)
+			}
+			if (err != nil) != tt.wantErr || err != nil && !errors.Is(err, errFlaky) {
+				t.Fatalf("Do = %v, want the last error: %t", err, tt.wantErr)
+			}
+			if tt.wantAttempts > 1 && err != nil && !strings.Contains(err.Error(), "3 attempts") {
+				t.Errorf("error %q doesn't say how often it tried", err)
+			}
+		})
+	}
+}
+
+func TestDoStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Policy{MaxAttempts: 5, InitialDelay: time.Hour}
+
+	attempts := 0
+	err := p.Do(ctx, func() error {
+		attempts++
+		cancel()
+		return errors.New("down")
+	})
+	if !errors.Is(err, context.Canceled) || attempts != 1 {
+		t.Errorf("Do = %v after %d attempts, want context.Canceled after 1", err, attempts)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default.Validate(); err != nil {
+		t.Errorf("Default: %v", err)
+	}
+	for _, p := range []Policy{{MaxAttempts: -1}, {InitialDelay: -time.Second}, {MaxDelay: -time.Second}} {
+		if p.Validate() == nil {
+			t.Errorf("%+v validated", p)
+		}
+	}
+}
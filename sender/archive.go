@@ -0,0 +1,123 @@
+package sender
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// WithDirectoryArchives sends every offered directory as a single tar
+// archive, written while the tree is walked, to receivers that support it,
+// rather than entry by entry. Empty directories are kept, symlinks as
+// WithSymlinks says; the receiver saves the archive as NAME.tar or unpacks
+// it. Other receivers get
+// the directories entry by entry. Files offered on their own are sent as
+// usual.
+func WithDirectoryArchives(archive bool) Option {
+	return func(s *Sender) {
+		s.archive = archive
+	}
+}
+
+// sendArchive sends the directory of an archive entry, whose frame was sent,
+// as a tar archive under protocol.FlagArchive and returns the number of
+// archive bytes sent. Progress counts the content of the files in it.
+func (s *Sender) sendArchive(ctx context.Context, con net.Conn, entry entry, batch *progress.Batch) (uint64, error) {
+	// the mode is that of the archive saved as a file, the directory's own
+	// is in the archive
+	dirInfo, err := os.Stat(entry.localPath)
+	if err != nil {
+		return 0, fmt.Errorf("err reading directory info: %s", err)
+	}
+	header := protocol.Header{Flags: protocol.FlagArchive, Mode: 0o644, ModTime: dirInfo.ModTime().UnixNano()}
+
+	return s.sendStreamed(ctx, con, entry, header, uint64(entry.size), batch, func(w io.Writer, tracker *progress.Tracker) error {
+		// the tar writer makes many small writes, which are gathered into
+		// chunks of the largest size
+		buffered := bufio.NewWriterSize(w, protocol.MaxStreamChunk)
+		if err := s.writeArchive(ctx, tar.NewWriter(buffered), entry, tracker); err != nil {
+			return err
+		}
+		return buffered.Flush()
+	})
+}
+
+// writeArchive walks the directory of entry into tw, naming everything
+// below the directory's own name, and closes tw. Ownership is left out, as
+// it means nothing on the receiver's machine, and files other than regular
+// ones, directories and symlinks are skipped, as are entries the walker
+// leaves out.
+func (s *Sender) writeArchive(ctx context.Context, tw *tar.Writer, entry entry, tracker *progress.Tracker) error {
+	root := filepath.Clean(entry.localPath)
+	base := filepath.ToSlash(filepath.Base(root))
+
+	_, err := s.walker().walk(root, func(localPath, relPath string, d fs.DirEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		name := path.Join(base, relPath)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var link string
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(localPath); err != nil {
+				return err
+			}
+			if protocol.LinkLeaves(relPath, filepath.ToSlash(link)) {
+				log.Printf("warning: symlink %s points outside the offered directory: %s", localPath, link)
+			}
+		case info.IsDir(), info.Mode().IsRegular():
+		default:
+			log.Printf("skipping %s: not a regular file, directory or symlink", localPath)
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if info.IsDir() && !strings.HasSuffix(hdr.Name, "/") {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		// a file that grew since is cut to the size in its header, one that
+		// shrank fails the archive
+		_, err = io.CopyN(tw, tracker.Reader(file), hdr.Size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
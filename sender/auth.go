@@ -0,0 +1,33 @@
+package sender
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// answerChallenge reads the receiver's nonce and replies with its
+// HMAC-SHA256 under the shared key.
+func (s *Sender) answerChallenge(con net.Conn) error {
+	if s.sharedKey == "" {
+		return errors.New("receiver requires a shared key but none is configured")
+	}
+
+	nonce := make([]byte, protocol.NonceSize)
+	if _, err := io.ReadFull(con, nonce); err != nil {
+		return fmt.Errorf("err receiving nonce: %s", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.sharedKey))
+	mac.Write(nonce)
+	if _, err := con.Write(mac.Sum(nil)); err != nil {
+		return fmt.Errorf("err sending answer: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package sender
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// sendBlocks sends the file from offset to size as protocol.BlockSize
+// blocks, then sends the blocks the receiver asks for again until it asks
+// for none. It returns the final digest along with the number of content
+// bytes sent, not counting the blocks sent again.
+func (s *Sender) sendBlocks(con net.Conn, file *os.File, offset, size uint64, digest hash.Hash, tracker *progress.Tracker) ([]byte, uint64, error) {
+	remaining := size - offset
+	block := make([]byte, min(protocol.BlockSize, remaining))
+
+	// SEND EVERY BLOCK
+	for index := range protocol.BlockCount(remaining) {
+		data := block[:protocol.BlockLength(remaining, index)]
+		if _, err := io.ReadFull(file, data); err != nil {
+			return nil, 0, fmt.Errorf("err reading block %d: %s", index, err)
+		}
+		digest.Write(data)
+
+		if err := protocol.WriteBlock(con, index, data); err != nil {
+			return nil, 0, fmt.Errorf("err sending block %d: %s", index, err)
+		}
+		tracker.Add(len(data))
+	}
+	log.Printf("sent %d bytes of %s to receiver", remaining, file.Name())
+
+	// SEND WHAT ARRIVED CORRUPT AGAIN
+	for {
+		indexes, err := protocol.ReadBlockRequest(con, remaining)
+		if err != nil {
+			return nil, 0, fmt.Errorf("err receiving block request: %w", err)
+		}
+		if len(indexes) == 0 {
+			break
+		}
+
+		log.Printf("receiver asked for %d blocks of %s again", len(indexes), file.Name())
+		for _, index := range indexes {
+			data := block[:protocol.BlockLength(remaining, index)]
+			if _, err := file.ReadAt(data, int64(offset+index*protocol.BlockSize)); err != nil {
+				return nil, 0, fmt.Errorf("err reading block %d: %s", index, err)
+			}
+			if err := protocol.WriteBlock(con, index, data); err != nil {
+				return nil, 0, fmt.Errorf("err sending block %d: %s", index, err)
+			}
+		}
+	}
+
+	return digest.Sum(nil), remaining, nil
+}
@@ -0,0 +1,108 @@
+package sender
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/retry"
+)
+
+// changingConn runs change once after more than after bytes were written
+// to it, while the sender is still streaming the file.
+type changingConn struct {
+	net.Conn
+	after   int
+	written int
+	change  func()
+}
+
+func (c *changingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written += n
+	if c.change != nil && c.written > c.after {
+		c.change()
+		c.change = nil
+	}
+	return n, err
+}
+
+// changeMidTransfer returns a wrap for pipeTransferThrough that modifies the
+// file at path with modify once 64 KiB went out, which is well into its
+// content, and makes sure its modification time moves.
+func changeMidTransfer(t *testing.T, path string, modify func(*os.File) error) func(net.Conn) net.Conn {
+	return func(con net.Conn) net.Conn {
+		return &changingConn{Conn: con, after: 64 << 10, change: func() {
+			file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer file.Close()
+			if err := modify(file); err != nil {
+				t.Error(err)
+			}
+			later := time.Now().Add(time.Minute)
+			if err := os.Chtimes(path, later, later); err != nil {
+				t.Error(err)
+			}
+		}}
+	}
+}
+
+func TestSourceChangedMidTransfer(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*os.File) error
+	}{
+		{"appended", func(f *os.File) error {
+			_, err := f.Write([]byte("appended while sending"))
+			return err
+		}},
+		{"truncated", func(f *os.File) error { return f.Truncate(1 << 20) }},
+		{"touched", func(*os.File) error { return nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), "moving.bin", testContent(4<<20))
+			r, dest := newTestReceiver(t)
+			s := newTestSender(t)
+
+			res := pipeTransferThrough(t, s, r, changeMidTransfer(t, path, tt.modify), path)
+			if !errors.Is(res.serveErr, ErrSourceChanged) {
+				t.Errorf("ServeConn = %v, want ErrSourceChanged", res.serveErr)
+			}
+			if res.receiveErr == nil {
+				t.Error("ReceiveConn succeeded with a file that changed")
+			}
+			if _, err := os.Stat(filepath.Join(dest, "moving.bin")); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("the changed file was saved: %v", err)
+			}
+		})
+	}
+}
+
+func TestRestartOnSourceChange(t *testing.T) {
+	content := testContent(4 << 20)
+	path := writeTestFile(t, t.TempDir(), "moving.bin", content)
+	r, dest := newTestReceiver(t)
+	s := newTestSender(t,
+		WithRestartOnSourceChange(true),
+		WithTransferRetry(retry.Policy{MaxAttempts: 2, InitialDelay: time.Millisecond}),
+	)
+
+	tail := []byte("appended while sending")
+	res := pipeTransferThrough(t, s, r, changeMidTransfer(t, path, func(f *os.File) error {
+		_, err := f.Write(tail)
+		return err
+	}), path)
+	if res.receiveErr != nil || res.serveErr != nil {
+		t.Fatalf("ReceiveConn = %v, ServeConn = %v", res.receiveErr, res.serveErr)
+	}
+	// the file is sent again as it is after the change
+	assertFile(t, filepath.Join(dest, "moving.bin"), append(content, tail...))
+}
@@ -0,0 +1,388 @@
+package sender
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/mdns"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// Announcer is a discovery backend. The sender runs all configured backends
+// at once while it waits for receivers.
+type Announcer interface {
+	// Announce advertises announcement until ctx is done or it fails.
+	Announce(ctx context.Context, announcement protocol.Announcement) error
+}
+
+// BroadcastAnnouncer broadcasts the JSON announcement to Port every
+// Interval, or every second if it is zero. Over IPv6, which has no broadcast,
+// it is sent to protocol.IPv6AllNodes on every interface that is up. Network
+// is "udp4" or "udp6" to announce over one IP version only; empty or "udp"
+// announces over both, as long as either works. Interface names the network
+// interface to broadcast through; empty lets the system choose for IPv4 and
+// takes every interface for IPv6.
+type BroadcastAnnouncer struct {
+	Port      uint
+	Network   string
+	Interface string
+	Interval  time.Duration
+}
+
+// MulticastAnnouncer sends the JSON announcement to Group, an IPv4 or IPv6
+// multicast group, on Port every Interval, or every second if it is zero.
+// Interface names the network interface to send from; empty lets the system
+// choose.
+type MulticastAnnouncer struct {
+	Group     string
+	Port      uint
+	Interface string
+	Interval  time.Duration
+}
+
+// MDNSAnnouncer advertises the sender as a protocol.MDNSService instance over
+// multicast DNS, with the announcement in its TXT record, repeated every
+// Interval, or every second if it is zero.
+type MDNSAnnouncer struct {
+	Interval time.Duration
+}
+
+// defaultAnnounceInterval is how often announcements are repeated unless
+// the announcer says otherwise
+const defaultAnnounceInterval = time.Second
+
+// announceInterval returns interval, or the default if it is zero.
+func announceInterval(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return defaultAnnounceInterval
+	}
+	return interval
+}
+
+// WithSenderName announces the sender under name, e.g. "alice", so that
+// receivers can tell whose files they are offered, rather than under the
+// machine's hostname only. It is at most protocol.MaxSenderName bytes.
+func WithSenderName(name string) Option {
+	return func(s *Sender) {
+		s.name = name
+	}
+}
+
+// defaultAnnounceDuration is how long the sender announces itself unless
+// WithAnnounceDuration or WithWaitForever say otherwise
+const defaultAnnounceDuration = 10 * time.Second
+
+// WithAnnounceDuration announces the sender for d, rather than 10 seconds,
+// and gives up with ErrNoReceivers if no receiver connected by then, for
+// scripts that mustn't wait for nobody. Once a receiver connected, Send
+// keeps serving the ones connecting later too.
+func WithAnnounceDuration(d time.Duration) Option {
+	return func(s *Sender) {
+		s.announceFor = d
+	}
+}
+
+// WithReceiverTimeout gives up with ErrNoReceivers if no receiver connected
+// within d, for scripts that must fail fast, without changing how long the
+// sender is announced for beyond announcing it for at least d. Transfers in
+// progress, and receivers connecting to a sender that already served one,
+// aren't bound by d. Zero, the default, waits forever.
+func WithReceiverTimeout(d time.Duration) Option {
+	return func(s *Sender) {
+		s.receiverTimeout = d
+	}
+}
+
+// WithWaitForever keeps announcing the sender for as long as Send runs,
+// rather than for 10 seconds, for receivers started much later.
+func WithWaitForever(wait bool) Option {
+	return func(s *Sender) {
+		s.waitForever = wait
+	}
+}
+
+// announceContext returns the context announcements run under, done once
+// the sender stops announcing itself.
+func (s *Sender) announceContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	switch {
+	case s.waitForever, s.serveForever:
+		return context.WithCancel(ctx)
+	case s.announceFor > 0:
+		return context.WithTimeout(ctx, s.announceFor)
+	default:
+		return context.WithTimeout(ctx, max(defaultAnnounceDuration, s.receiverTimeout))
+	}
+}
+
+// receiverWait returns how long Send waits for the first receiver before it
+// gives up, zero if it waits forever.
+func (s *Sender) receiverWait() time.Duration {
+	if s.announceFor > 0 && (s.receiverTimeout == 0 || s.announceFor < s.receiverTimeout) {
+		return s.announceFor
+	}
+	return s.receiverTimeout
+}
+
+// announcement describes us to receivers, along with the offered entries, if
+// they are known up front: their number of files and total size, and the
+// name of a single offered file. It is signed with the shared key, if one
+// is configured.
+func (s *Sender) announcement(port uint16, entries []entry) protocol.Announcement {
+	announcement := protocol.NewAnnouncement(port)
+	if s.transport == TransportQUIC {
+		announcement.Transport = protocol.TransportQUIC
+	} else {
+		announcement.TLS = s.tls
+	}
+	announcement.Hostname, _ = os.Hostname()
+	announcement.Name = s.name
+	announcement.Session = s.session
+
+	for _, entry := range entries {
+		if entry.isFile() {
+			announcement.FileCount++
+			announcement.TotalSize += entry.size
+			if len(announcement.FileNames) < protocol.MaxAnnouncedFiles {
+				announcement.FileNames = append(announcement.FileNames, entry.name)
+			}
+		}
+	}
+	if len(entries) == 1 && entries[0].isFile() {
+		announcement.FileName = entries[0].name
+		announcement.FileSize = entries[0].size
+	}
+
+	return announcement.SignedWith(s.sharedKey)
+}
+
+// Announce implements Announcer.
+func (a BroadcastAnnouncer) Announce(ctx context.Context, announcement protocol.Announcement) error {
+	if _, err := announcement.Marshal(); err != nil {
+		return fmt.Errorf("err building discovery msg: %s", err)
+	}
+
+	var targets []netip.AddrPort
+	// binding to an address of the interface makes the system send the
+	// broadcast out through it
+	var laddr *net.UDPAddr
+	if a.Network != "udp6" {
+		targets = append(targets, netip.AddrPortFrom(netip.AddrFrom4([4]byte{255, 255, 255, 255}), uint16(a.Port)))
+		if a.Interface != "" {
+			ip, err := interfaceAddr(a.Interface, false)
+			switch {
+			case err == nil:
+				laddr = net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, 0))
+			case a.Network == "udp4":
+				return err
+			default:
+				// IPv6 only then
+				targets = targets[:0]
+			}
+		}
+	}
+	if a.Network != "udp4" {
+		allNodes, err := allNodesTargets(uint16(a.Port), a.Interface)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, allNodes...)
+	}
+	if len(targets) == 0 {
+		return errors.New("no interface to broadcast on")
+	}
+
+	// a machine without IPv4, or without IPv6, only fails the targets of
+	// that version
+	var wg sync.WaitGroup
+	errs := make([]error, len(targets))
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var from *net.UDPAddr
+			if target.Addr().Is4() {
+				from = laddr
+			}
+			con, err := net.DialUDP("udp", from, net.UDPAddrFromAddrPort(target))
+			if err != nil {
+				errs[i] = fmt.Errorf("err dialing udp: %s", err)
+			} else {
+				defer con.Close()
+				errs[i] = sendAnnouncements(ctx, con, announcement, announceInterval(a.Interval))
+			}
+			if errs[i] != nil && len(targets) > 1 {
+				log.Printf("warning: stopped announcing to %s: %s", target, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !slices.Contains(errs, nil) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// allNodesTargets returns protocol.IPv6AllNodes on port on every interface
+// that is up and can multicast, the group being link-local, or on the one
+// named name only, unless it is empty.
+func allNodesTargets(port uint16, name string) ([]netip.AddrPort, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("err listing interfaces: %s", err)
+	}
+
+	allNodes := netip.MustParseAddr(protocol.IPv6AllNodes)
+	var targets []netip.AddrPort
+	for _, ifi := range ifaces {
+		if name != "" && ifi.Name != name {
+			continue
+		}
+		if ifi.Flags&net.FlagUp != 0 && ifi.Flags&net.FlagMulticast != 0 && ifi.Flags&net.FlagLoopback == 0 {
+			targets = append(targets, netip.AddrPortFrom(allNodes.WithZone(ifi.Name), port))
+		}
+	}
+
+	return targets, nil
+}
+
+// Announce implements Announcer.
+func (a MulticastAnnouncer) Announce(ctx context.Context, announcement protocol.Announcement) error {
+	if _, err := announcement.Marshal(); err != nil {
+		return fmt.Errorf("err building discovery msg: %s", err)
+	}
+
+	group, err := netip.ParseAddr(a.Group)
+	if err != nil || !group.IsMulticast() {
+		return fmt.Errorf("invalid multicast group: %q", a.Group)
+	}
+	network := "udp4"
+	if group.Is6() {
+		network = "udp6"
+	}
+
+	// binding to an address of the interface makes the system send the
+	// multicast out through it, as does the zone of a link-local group
+	var laddr *net.UDPAddr
+	if a.Interface != "" {
+		ip, err := interfaceAddr(a.Interface, group.Is6())
+		if err != nil {
+			return err
+		}
+		laddr = net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, 0))
+		if group.IsLinkLocalMulticast() {
+			group = group.WithZone(a.Interface)
+		}
+	}
+
+	con, err := net.DialUDP(network, laddr, net.UDPAddrFromAddrPort(netip.AddrPortFrom(group, uint16(a.Port))))
+	if err != nil {
+		return fmt.Errorf("err dialing udp: %s", err)
+	}
+	defer con.Close()
+
+	return sendAnnouncements(ctx, con, announcement, announceInterval(a.Interval))
+}
+
+// sendAnnouncements writes announcement to con right away and then every
+// interval until ctx is done, encoded anew every time so a signed one
+// carries the time it was sent.
+func sendAnnouncements(ctx context.Context, con *net.UDPConn, announcement protocol.Announcement, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		discoveryMsg, err := announcement.Marshal()
+		if err != nil {
+			return fmt.Errorf("err building discovery msg: %s", err)
+		}
+		if _, err := con.Write(discoveryMsg); err != nil {
+			return fmt.Errorf("err sending discovery msg: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("stopped announcing to %s", con.RemoteAddr())
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// interfaceAddr returns the first IPv4 address of the named interface, or
+// the first IPv6 one if ipv6 is set, zoned to the interface if link-local.
+func interfaceAddr(name string, ipv6 bool) (netip.Addr, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("err looking up interface: %s", err)
+	}
+
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("err listing addresses of %s: %s", name, err)
+	}
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err != nil {
+			continue
+		}
+		ip := prefix.Addr().Unmap()
+		if ip.Is6() != ipv6 {
+			continue
+		}
+		if ip.IsLinkLocalUnicast() {
+			ip = ip.WithZone(name)
+		}
+		return ip, nil
+	}
+
+	if ipv6 {
+		return netip.Addr{}, fmt.Errorf("interface %s has no ipv6 address", name)
+	}
+	return netip.Addr{}, fmt.Errorf("interface %s has no ipv4 address", name)
+}
+
+// Announce implements Announcer.
+func (a MDNSAnnouncer) Announce(ctx context.Context, announcement protocol.Announcement) error {
+	hostname := announcement.Hostname
+	if hostname == "" {
+		hostname = "fileshare"
+	}
+
+	svc := mdns.Service{
+		// the port keeps several senders on one host apart
+		Instance: fmt.Sprintf("%s-%d", hostname, announcement.Port),
+		Type:     protocol.MDNSService,
+		Port:     announcement.Port,
+		TXT:      announcement.TXT(),
+		// signed announcements carry the time they were sent
+		RefreshTXT: announcement.TXT,
+	}
+
+	if err := mdns.Announce(ctx, svc, announceInterval(a.Interval)); err != nil {
+		return err
+	}
+
+	log.Println("stopped mdns announcements")
+	return nil
+}
+
+// newSessionID returns the random ID announcements of this sender carry.
+func newSessionID() string {
+	id := make([]byte, 8)
+	// crypto/rand only fails if the system's entropy source is broken, and
+	// a zero ID still works, it just can't be told apart from others
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
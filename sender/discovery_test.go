@@ -0,0 +1,161 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+func TestReceiverWait(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want time.Duration
+	}{
+		{"default", nil, 0},
+		{"receiver timeout", []Option{WithReceiverTimeout(time.Minute)}, time.Minute},
+		{"announce duration", []Option{WithAnnounceDuration(time.Minute)}, time.Minute},
+		{"shorter announce duration", []Option{WithAnnounceDuration(time.Second), WithReceiverTimeout(time.Minute)}, time.Second},
+		{"shorter receiver timeout", []Option{WithAnnounceDuration(time.Minute), WithReceiverTimeout(time.Second)}, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newTestSender(t, tt.opts...).receiverWait(); got != tt.want {
+				t.Errorf("receiverWait = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoReceivers(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{"receiver timeout", WithReceiverTimeout(100 * time.Millisecond)},
+		{"announce duration", WithAnnounceDuration(100 * time.Millisecond)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), "unwanted.txt", []byte("nobody came"))
+			_, sent := startSend(t, newTestSender(t, tt.opt), path)
+
+			select {
+			case err := <-sent:
+				if !errors.Is(err, ErrNoReceivers) {
+					t.Errorf("Send = %v, want ErrNoReceivers", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Send still waits for receivers")
+			}
+		})
+	}
+}
+
+func TestReceiverTimeoutSparesTransfers(t *testing.T) {
+	content := testContent(1 << 20)
+	path := writeTestFile(t, t.TempDir(), "slow.bin", content)
+	s := newTestSender(t, WithReceiverTimeout(100*time.Millisecond), WithMaxTransfers(1))
+	port, sent := startSend(t, s, path)
+
+	// the transfer outlasts the timeout
+	slow := newStallingConn(nil, 64<<10, false)
+	p := startPull(t, port, func(con net.Conn) net.Conn {
+		slow.Conn = con
+		return slow
+	})
+	time.Sleep(300 * time.Millisecond)
+	close(slow.released)
+	if err := p.wait(t, testTimeout); err != nil {
+		t.Fatalf("receiver: %v", err)
+	}
+	assertFile(t, filepath.Join(p.dir, "slow.bin"), content)
+	if err := <-sent; err != nil {
+		t.Errorf("Send = %v, want the transfer to outlast the timeout", err)
+	}
+}
+
+func TestAnnouncementIsSigned(t *testing.T) {
+	for _, key := range []string{"", "shared"} {
+		payload, err := newTestSender(t, WithSharedKey(key)).announcement(9000, nil).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := protocol.ParseAnnouncement(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Verify("shared", time.Now(), time.Minute); (err == nil) != (key != "") {
+			t.Errorf("Verify of an announcement signed with %q = %v", key, err)
+		}
+	}
+}
+
+func TestAnnouncementSession(t *testing.T) {
+	s := newTestSender(t)
+	first, again := s.announcement(9000, nil), s.announcement(9001, nil)
+	other := newTestSender(t).announcement(9000, nil)
+	if first.Session == "" || first.Session != again.Session {
+		t.Errorf("sessions %q and %q, want the sender to keep one", first.Session, again.Session)
+	}
+	if other.Session == first.Session {
+		t.Errorf("two senders announced session %q", first.Session)
+	}
+}
+
+func TestAllNodesTargets(t *testing.T) {
+	targets, err := allNodesTargets(9999, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range targets {
+		if target.Addr().WithZone("") != netip.MustParseAddr(protocol.IPv6AllNodes) || target.Port() != 9999 {
+			t.Errorf("target %s, want the all-nodes group on port 9999", target)
+			continue
+		}
+		// zoned to an interface multicast goes out through
+		ifi, err := net.InterfaceByName(target.Addr().Zone())
+		if err != nil {
+			t.Errorf("target %s: %v", target, err)
+			continue
+		}
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			t.Errorf("target %s is on interface %s, flags %s", target, ifi.Name, ifi.Flags)
+		}
+	}
+
+	if _, err := allNodesTargets(9999, "no-such-interface"); err != nil {
+		t.Errorf("allNodesTargets of an unknown interface = %v, want no targets", err)
+	}
+}
+
+func TestSendOverIPv6(t *testing.T) {
+	if l, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skipf("no ipv6 loopback: %v", err)
+	} else {
+		l.Close()
+	}
+	path := writeTestFile(t, t.TempDir(), "v6.txt", []byte("over ipv6"))
+	port, _ := startSend(t, newTestSender(t), path)
+
+	// the listener on all interfaces takes IPv6 connections too
+	con, err := net.Dial("tcp6", net.JoinHostPort("::1", strconv.Itoa(int(port))))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer con.Close()
+	r, dest := newTestReceiver(t)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if _, err := r.ReceiveConn(ctx, con); err != nil {
+		t.Fatalf("ReceiveConn: %v", err)
+	}
+	assertFile(t, filepath.Join(dest, "v6.txt"), []byte("over ipv6"))
+}
@@ -0,0 +1,239 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// entry is a single item of a transfer. name is what the receiver sees: the
+// slash-separated path relative to the parent of the path given by the user,
+// so sending "photos" yields "photos", "photos/a.jpg", "photos/2024/b.jpg".
+// file numbers the files among the entries, counting from 1, and size is
+// what the file held when collected; both are zero for directories. modTime
+// is in nanoseconds since the Unix epoch, as collected. An
+// archive entry is a directory sent as a single file, see
+// WithDirectoryArchives, whose size is that of the files in it. A stdin
+// entry is the content read from stdin, see StdinPath, whose size is zero. A
+// symlink entry is a symlink sent as such, see PreserveSymlinks, holding
+// target with linkFlags.
+type entry struct {
+	localPath string
+	name      string
+	isDir     bool
+	archive   bool
+	stdin     bool
+	symlink   bool
+	target    string
+	linkFlags uint8
+	file      int
+	size      int64
+	modTime   int64
+}
+
+// frameType returns the type the entry's frame is sent with.
+func (e entry) frameType() uint8 {
+	switch {
+	case e.isDir:
+		return protocol.EntryTypeDir
+	case e.symlink:
+		return protocol.EntryTypeSymlink
+	default:
+		return protocol.EntryTypeFile
+	}
+}
+
+// isFile reports whether the entry has content, rather than being a
+// directory or a symlink.
+func (e entry) isFile() bool {
+	return !e.isDir && !e.symlink
+}
+
+// collectEntries expands filePaths into the list of entries to send,
+// walking directories recursively as walker says, and returns the number of
+// entries its patterns left out too. Entries found in directories that are
+// neither regular files, directories nor symlinks walker passes are
+// skipped; given as a path, they are an error. StdinPath yields a stdin
+// entry, named by the caller.
+func collectEntries(filePaths []string, walker walker) ([]entry, int, error) {
+	var entries []entry
+	skipped := 0
+
+	for _, filePath := range filePaths {
+		if filePath == StdinPath {
+			entries = append(entries, entry{localPath: filePath, stdin: true})
+			continue
+		}
+
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, 0, fmt.Errorf("err reading %s: %s", filePath, err)
+		}
+
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil, 0, fmt.Errorf("%s is neither a regular file nor a directory", filePath)
+		}
+		if !info.IsDir() {
+			entries = append(entries, entry{localPath: filePath, name: filepath.Base(filePath), size: info.Size(), modTime: info.ModTime().UnixNano()})
+			continue
+		}
+
+		base := filepath.Base(filepath.Clean(filePath))
+		filtered, err := walker.walk(filePath, func(localPath, relPath string, d fs.DirEntry) error {
+			name := path.Join(filepath.ToSlash(base), relPath)
+			if d.Type()&fs.ModeSymlink != 0 {
+				link, err := linkEntry(localPath, relPath, name)
+				if err != nil {
+					return err
+				}
+				entries = append(entries, link)
+				return nil
+			}
+			if !d.IsDir() && !d.Type().IsRegular() {
+				log.Printf("skipping %s: not a regular file", localPath)
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			var size int64
+			if !d.IsDir() {
+				size = info.Size()
+			}
+
+			entries = append(entries, entry{
+				localPath: localPath,
+				name:      name,
+				isDir:     d.IsDir(),
+				size:      size,
+				modTime:   info.ModTime().UnixNano(),
+			})
+
+			return nil
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("err walking %s: %s", filePath, err)
+		}
+		skipped += filtered
+	}
+
+	numberFiles(entries)
+
+	return entries, skipped, nil
+}
+
+// archiveEntries is collectEntries with every directory among filePaths
+// collected as a single archive entry, whose size counts the files walker
+// passes.
+func archiveEntries(filePaths []string, walker walker) ([]entry, int, error) {
+	var entries []entry
+	skipped := 0
+
+	for _, filePath := range filePaths {
+		info, err := os.Stat(filePath)
+		if filePath == StdinPath || err == nil && !info.IsDir() {
+			fileEntries, _, err := collectEntries([]string{filePath}, walker)
+			if err != nil {
+				return nil, 0, err
+			}
+			entries = append(entries, fileEntries...)
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("err reading %s: %s", filePath, err)
+		}
+
+		archive := entry{localPath: filePath, name: filepath.Base(filepath.Clean(filePath)), archive: true, modTime: info.ModTime().UnixNano()}
+		filtered, err := walker.walk(filePath, func(localPath, relPath string, d fs.DirEntry) error {
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			archive.size += info.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("err walking %s: %s", filePath, err)
+		}
+		skipped += filtered
+		entries = append(entries, archive)
+	}
+	numberFiles(entries)
+
+	return entries, skipped, nil
+}
+
+// collect collects the entries of filePaths as the include and exclude
+// patterns keep them, with every directory as an archive if archive is set,
+// and names the stdin entry. It returns the number of entries the patterns
+// left out too.
+func (s *Sender) collect(filePaths []string, archive bool) ([]entry, int, error) {
+	stdin := 0
+	for _, filePath := range filePaths {
+		if filePath == StdinPath {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		return nil, 0, errors.New("stdin can only be sent once")
+	}
+
+	collect := collectEntries
+	if archive {
+		collect = archiveEntries
+	}
+	entries, skipped, err := collect(filePaths, s.walker())
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range entries {
+		if entries[i].stdin {
+			entries[i].name = s.stdinName
+		}
+	}
+
+	return entries, skipped, nil
+}
+
+// numberFiles numbers the entries that are files.
+func numberFiles(entries []entry) {
+	files := 0
+	for i := range entries {
+		if entries[i].isFile() {
+			files++
+			entries[i].file = files
+		}
+	}
+}
+
+// batchOf returns the Batch of group adding up the progress of the files
+// among entries sent to peer. Its total is zero if it includes stdin, whose
+// size is unknown.
+func batchOf(entries []entry, group *progress.Group, peer string) *progress.Batch {
+	files, total := 0, uint64(0)
+	unknown := false
+	for _, entry := range entries {
+		if entry.isFile() {
+			files++
+			total += uint64(entry.size)
+			unknown = unknown || entry.stdin
+		}
+	}
+	if unknown {
+		total = 0
+	}
+
+	return group.NewBatch(peer, files, total)
+}
@@ -0,0 +1,57 @@
+package sender
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCollectEntriesWalksDirectories(t *testing.T) {
+	src := t.TempDir()
+	writeTestFile(t, src, "photos/a.jpg", []byte("a"))
+	writeTestFile(t, src, "photos/2024/b.jpg", []byte("bb"))
+	if err := os.Mkdir(filepath.Join(src, "photos", "empty"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	single := writeTestFile(t, t.TempDir(), "notes.txt", []byte("ccc"))
+
+	entries, _, err := newTestSender(t).collect([]string{filepath.Join(src, "photos"), single}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"photos/", "photos/2024/", "photos/2024/b.jpg", "photos/a.jpg", "photos/empty/", "notes.txt"}
+	if got := entryNames(entries); !slices.Equal(got, want) {
+		t.Fatalf("entries = %q, want %q", got, want)
+	}
+	for _, e := range entries {
+		if e.isDir && (e.size != 0 || e.file != 0) {
+			t.Errorf("directory %s has size %d, file number %d", e.name, e.size, e.file)
+		}
+		if !e.isDir && e.file == 0 {
+			t.Errorf("file %s isn't numbered", e.name)
+		}
+	}
+	if last := entries[len(entries)-1]; last.size != 3 || last.file != 3 {
+		t.Errorf("notes.txt has size %d, file number %d, want 3 and 3", last.size, last.file)
+	}
+}
+
+func TestCollectEntriesTrailingSlash(t *testing.T) {
+	src := t.TempDir()
+	writeTestFile(t, src, "docs/readme.md", nil)
+
+	entries, _, err := newTestSender(t).collect([]string{filepath.Join(src, "docs") + string(filepath.Separator)}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entryNames(entries), []string{"docs/", "docs/readme.md"}; !slices.Equal(got, want) {
+		t.Fatalf("entries = %q, want %q", got, want)
+	}
+}
+
+func TestCollectEntriesMissingPath(t *testing.T) {
+	if _, _, err := newTestSender(t).collect([]string{filepath.Join(t.TempDir(), "missing")}, false); err == nil {
+		t.Fatal("collect succeeded for a missing path")
+	}
+}
@@ -0,0 +1,66 @@
+package sender
+
+import (
+	"context"
+	"time"
+)
+
+// Events are callbacks for the milestones of a sender, set with WithEvents.
+// Every field is optional. ctx is the context passed to Send, or
+// context.Background() for ServeConn, so work started from a callback can be
+// cancelled along with the sender.
+//
+// The callbacks run one at a time, in the order of their events, on a
+// goroutine of their own. A slow callback never holds up a transfer, it only
+// delays the events after it. Progress is reported through WithProgress.
+type Events struct {
+	// ReceiverConnected is called with the address of every receiver that
+	// connected and completed the handshake.
+	ReceiverConnected func(ctx context.Context, addr string)
+	// TransferStarted is called once the receiver accepted a file, before
+	// its content is sent.
+	TransferStarted func(ctx context.Context, file FileInfo)
+	// TransferCompleted is called for every file the receiver confirmed.
+	TransferCompleted func(ctx context.Context, stats TransferStats)
+	// Error is called with the error of every transfer to a receiver that
+	// failed. Stray connections that don't speak the protocol and errors
+	// caused by cancelling the sender are not reported.
+	Error func(ctx context.Context, err error)
+	// QueueItemChanged is called with a path of the queue, see Queue,
+	// whenever it is queued or its state changes.
+	QueueItemChanged func(ctx context.Context, item QueueItem)
+}
+
+// FileInfo describes a file offered to a receiver.
+type FileInfo struct {
+	// Name is the file's name as sent to the receiver.
+	Name string
+	// Size is the size of the file.
+	Size uint64
+	// Peer is the address of the receiver.
+	Peer string
+}
+
+// TransferStats describes a file the receiver confirmed.
+type TransferStats struct {
+	FileInfo
+	// Bytes is the number of content bytes sent, less than Size when the
+	// transfer was resumed.
+	Bytes uint64
+	// Duration is how long the file took, from being accepted to being
+	// confirmed.
+	Duration time.Duration
+	// ChunkSize is the size the content was last sent in, once it adapted
+	// to the throughput, see WithChunkSizeBounds. It is zero for content
+	// sent in blocks, datagrams or over parallel connections, which are
+	// sized otherwise.
+	ChunkSize int
+}
+
+// WithEvents calls the callbacks of events at the milestones of every
+// transfer, see Events.
+func WithEvents(events Events) Option {
+	return func(s *Sender) {
+		s.events = events
+	}
+}
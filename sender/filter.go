@@ -0,0 +1,58 @@
+package sender
+
+import (
+	"fmt"
+
+	"github.com/pjmessi/go_file_share/internal/pathmatch"
+)
+
+// WithExclude leaves out the entries of offered directories matching any of
+// patterns, gitignore-style, see pathmatch.Pattern: "*.o", "node_modules",
+// "build/" or "docs/**/*.tmp". Patterns are matched against the path
+// relative to the offered directory; a directory left out is left out with
+// everything in it. Excludes take precedence over WithInclude. Files offered
+// on their own are always sent.
+func WithExclude(patterns ...string) Option {
+	return func(s *Sender) {
+		s.exclude = append(s.exclude, patterns...)
+	}
+}
+
+// WithInclude only sends the files of offered directories matching any of
+// patterns, or in a directory matching one, as WithExclude matches them.
+// Directories are sent as far as they lead to a file that is sent, or match
+// themselves. The default, no patterns, sends every file.
+func WithInclude(patterns ...string) Option {
+	return func(s *Sender) {
+		s.include = append(s.include, patterns...)
+	}
+}
+
+// filter is the compiled WithInclude and WithExclude patterns, applied as
+// walker walks the offered directories.
+type filter struct {
+	include pathmatch.Set
+	exclude pathmatch.Set
+}
+
+func newFilter(include, exclude []string) (filter, error) {
+	var f filter
+	var err error
+	if f.include, err = pathmatch.CompileSet(include); err != nil {
+		return filter{}, fmt.Errorf("invalid include pattern: %s", err)
+	}
+	if f.exclude, err = pathmatch.CompileSet(exclude); err != nil {
+		return filter{}, fmt.Errorf("invalid exclude pattern: %s", err)
+	}
+
+	return f, nil
+}
+
+// keeps reports whether the patterns keep a file named name directly in
+// the offered directory.
+func (f filter) keeps(name string) bool {
+	if f.exclude.Match(name, false) {
+		return false
+	}
+	return len(f.include) == 0 || f.include.Match(name, false)
+}
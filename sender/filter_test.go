@@ -0,0 +1,102 @@
+package sender
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCollectFiltersDirectories(t *testing.T) {
+	src := t.TempDir()
+	project := filepath.Join(src, "project")
+	for _, name := range []string{
+		"main.go", "main.o", "README.md",
+		".git/HEAD", "web/node_modules/pkg/index.js", "web/app.js",
+		"docs/guide.md", "docs/drafts/old.tmp",
+	} {
+		writeTestFile(t, project, name, []byte(name))
+	}
+	single := writeTestFile(t, src, "alone.o", []byte("sent anyway"))
+
+	tests := []struct {
+		name             string
+		include, exclude []string
+		want             []string
+		filtered         int
+	}{
+		{
+			name:     "everything",
+			want:     []string{"project/", "project/.git/", "project/.git/HEAD", "project/README.md", "project/docs/", "project/docs/drafts/", "project/docs/drafts/old.tmp", "project/docs/guide.md", "project/main.go", "project/main.o", "project/web/", "project/web/app.js", "project/web/node_modules/", "project/web/node_modules/pkg/", "project/web/node_modules/pkg/index.js", "alone.o"},
+			filtered: 0,
+		},
+		{
+			// an excluded directory counts once, with what is in it
+			name:     "exclude",
+			exclude:  []string{".git", "node_modules", "*.o", "docs/**/*.tmp"},
+			want:     []string{"project/", "project/README.md", "project/docs/", "project/docs/drafts/", "project/docs/guide.md", "project/main.go", "project/web/", "project/web/app.js", "alone.o"},
+			filtered: 4,
+		},
+		{
+			// directories are kept as far as they lead to a file kept, and
+			// count as left out along with each file in them otherwise
+			name:     "include",
+			include:  []string{"*.md"},
+			want:     []string{"project/", "project/README.md", "project/docs/", "project/docs/guide.md", "alone.o"},
+			filtered: 11,
+		},
+		{
+			name:     "exclude over include",
+			include:  []string{"*.md", "*.go"},
+			exclude:  []string{"docs/"},
+			want:     []string{"project/", "project/README.md", "project/main.go", "alone.o"},
+			filtered: 9,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSender(t, WithInclude(tt.include...), WithExclude(tt.exclude...))
+			entries, filtered, err := s.collect([]string{project, single}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := entryNames(entries); !slices.Equal(got, tt.want) {
+				t.Errorf("entries = %q, want %q", got, tt.want)
+			}
+			if filtered != tt.filtered {
+				t.Errorf("%d entries left out, want %d", filtered, tt.filtered)
+			}
+		})
+	}
+}
+
+func TestInvalidPatterns(t *testing.T) {
+	for _, opt := range []Option{WithInclude("[a"), WithExclude("!keep")} {
+		if _, err := New(opt); err == nil {
+			t.Error("New accepted an invalid pattern")
+		}
+	}
+}
+
+func TestFilteredDirectoryTransfer(t *testing.T) {
+	project := filepath.Join(t.TempDir(), "project")
+	writeTestFile(t, project, "main.go", []byte("package main"))
+	writeTestFile(t, project, "main.o", []byte("object"))
+	writeTestFile(t, project, "node_modules/pkg/index.js", []byte("js"))
+
+	s := newTestSender(t, WithExclude("*.o", "node_modules"))
+	r, dest := newTestReceiver(t)
+	res := pipeTransfer(t, s, r, project)
+	if res.receiveErr != nil || res.serveErr != nil {
+		t.Fatalf("ReceiveConn = %v, ServeConn = %v", res.receiveErr, res.serveErr)
+	}
+
+	assertFile(t, filepath.Join(dest, "project", "main.go"), []byte("package main"))
+	for _, name := range []string{"main.o", "node_modules"} {
+		if _, err := os.Lstat(filepath.Join(dest, "project", name)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("excluded %s was received: %v", name, err)
+		}
+	}
+}
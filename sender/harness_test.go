@@ -0,0 +1,215 @@
+package sender
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/pjmessi/go_file_share/receiver"
+)
+
+// testTimeout bounds every transfer of a test.
+const testTimeout = 30 * time.Second
+
+// newTestSender returns a sender configured by opts.
+func newTestSender(t testing.TB, opts ...Option) *Sender {
+	t.Helper()
+
+	s, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	return s
+}
+
+// writeTestFile creates the file name under dir, along with its parent
+// directories, holding content, and returns its path.
+func writeTestFile(t testing.TB, dir, name string, content []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// entryNames returns the names of entries, directories with a trailing
+// slash.
+func entryNames(entries []entry) []string {
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.isDir {
+			names = append(names, e.name+"/")
+		} else {
+			names = append(names, e.name)
+		}
+	}
+	return names
+}
+
+// newTestReceiver returns a receiver saving into a new temporary directory,
+// under the names files are sent with, configured by opts, along with the
+// directory.
+func newTestReceiver(t *testing.T, opts ...receiver.Option) (*receiver.Receiver, string) {
+	t.Helper()
+
+	dest := t.TempDir()
+	opts = append([]receiver.Option{
+		receiver.WithDestDir(dest),
+		receiver.WithPreserveFilename(true),
+		receiver.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	}, opts...)
+	r, err := receiver.New(opts...)
+	if err != nil {
+		t.Fatalf("receiver.New: %v", err)
+	}
+
+	return r, dest
+}
+
+// pipeResult is the outcome of both ends of a pipeTransfer.
+type pipeResult struct {
+	stats      []receiver.TransferStats
+	receiveErr error
+	serveErr   error
+}
+
+// pipeTransfer serves paths from s to r over a net.Pipe.
+func pipeTransfer(t *testing.T, s *Sender, r *receiver.Receiver, paths ...string) pipeResult {
+	t.Helper()
+
+	return pipeTransferThrough(t, s, r, nil, paths...)
+}
+
+// pipeTransferThrough is pipeTransfer with the sender's end of the pipe
+// wrapped by wrap, if it is set, to act on what the sender sends.
+func pipeTransferThrough(t *testing.T, s *Sender, r *receiver.Receiver, wrap func(net.Conn) net.Conn, paths ...string) pipeResult {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	senderEnd, receiverEnd := net.Pipe()
+	serveErr := make(chan error, 1)
+	go func() {
+		defer senderEnd.Close()
+		con := senderEnd
+		if wrap != nil {
+			con = wrap(senderEnd)
+		}
+		serveErr <- s.ServeConn(con, paths)
+	}()
+
+	var res pipeResult
+	res.stats, res.receiveErr = r.ReceiveConn(ctx, receiverEnd)
+	receiverEnd.Close()
+	select {
+	case res.serveErr = <-serveErr:
+	case <-ctx.Done():
+		t.Fatal("sender didn't finish within the test timeout")
+	}
+
+	return res
+}
+
+// portAnnouncer hands the port of the first announcement to port.
+type portAnnouncer struct {
+	port chan uint16
+}
+
+func (a portAnnouncer) Announce(ctx context.Context, announcement protocol.Announcement) error {
+	select {
+	case a.port <- announcement.Port:
+	default:
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// startSend runs s.Send on a port the system picks, announcing it to the
+// test only, and returns the port once it listens along with Send's result
+// to come. The test cancels Send when it ends.
+func startSend(t *testing.T, s *Sender, paths ...string) (uint16, <-chan error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	announcer := portAnnouncer{port: make(chan uint16, 1)}
+	s.announcers = []Announcer{announcer}
+	sent := make(chan error, 1)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sent <- s.Send(ctx, 0, paths)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	select {
+	case port := <-announcer.port:
+		return port, sent
+	case err := <-sent:
+		t.Fatalf("Send = %v before announcing", err)
+	case <-ctx.Done():
+		t.Fatal("Send didn't announce within the test timeout")
+	}
+	return 0, nil
+}
+
+// receiveFrom connects r to the sender listening on port of localhost, its
+// connection wrapped by wrap, if it is set, and receives what it sends.
+func receiveFrom(t *testing.T, r *receiver.Receiver, port uint16, wrap func(net.Conn) net.Conn) ([]receiver.TransferStats, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	con, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		return nil, err
+	}
+	defer con.Close()
+	if wrap != nil {
+		con = wrap(con)
+	}
+
+	return r.ReceiveConn(ctx, con)
+}
+
+// testContent returns size bytes of content that differs from one offset
+// to the next, so misplaced bytes show in the checksum.
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i*7 + i/251)
+	}
+	return content
+}
+
+// assertFile fails the test unless the file at path holds content.
+func assertFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading received file: %v", err)
+	}
+	if sha256.Sum256(got) != sha256.Sum256(content) {
+		t.Fatalf("%s holds %d bytes that differ from the %d sent", path, len(got), len(content))
+	}
+}
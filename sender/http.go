@@ -0,0 +1,267 @@
+package sender
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/lanurl"
+	"github.com/pjmessi/go_file_share/internal/netif"
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// WithHTTPDownloads also serves the offered files over plain HTTP on addr,
+// e.g. ":4101", while Send runs, for machines that can't run fileshare: a
+// browser, curl or wget downloads a single offered file from
+// protocol.DownloadPath, and every offered file from its name below it, as
+// listed at "/". Range requests let interrupted downloads resume. The port is
+// announced along with the sender. Downloads are neither authenticated nor
+// encrypted, whatever WithSharedKey and WithTLS say. Empty, the default,
+// serves nothing.
+func WithHTTPDownloads(addr string) Option {
+	return func(s *Sender) {
+		s.httpAddr = addr
+	}
+}
+
+// indexTemplate lists the offered files with links to download them.
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>fileshare</title></head>
+<body>
+<h1>Offered files</h1>
+<ul>
+{{range .}}<li><a href="{{.URL}}">{{.Name}}</a> {{.Size}}</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// serveHTTP starts the listener set with WithHTTPDownloads, serving
+// filePaths until ctx is done, and returns the port it listens on.
+func (s *Sender) serveHTTP(ctx context.Context, filePaths []string) (uint16, error) {
+	listener, err := s.listenOn(s.httpAddr, func(addr string) (net.Listener, error) {
+		return net.Listen("tcp", addr)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("err starting http listener: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, req *http.Request) {
+		s.serveIndex(w, filePaths)
+	})
+	mux.HandleFunc("GET "+protocol.DownloadPath, func(w http.ResponseWriter, req *http.Request) {
+		files, err := downloadable(filePaths, s.walker())
+		switch {
+		case err != nil:
+			log.Printf("err listing files for http: %s", err)
+			http.Error(w, "files unavailable", http.StatusInternalServerError)
+		case len(files) == 1:
+			s.serveDownload(w, req, files[0])
+		default:
+			// several files, or none left, are for the listing to show
+			http.Redirect(w, req, "/", http.StatusFound)
+		}
+	})
+	mux.HandleFunc("GET "+protocol.DownloadPath+"/{name...}", func(w http.ResponseWriter, req *http.Request) {
+		files, err := downloadable(filePaths, s.walker())
+		if err != nil {
+			log.Printf("err listing files for http: %s", err)
+			http.Error(w, "files unavailable", http.StatusInternalServerError)
+			return
+		}
+		for _, file := range files {
+			if file.name == req.PathValue("name") {
+				s.serveDownload(w, req, file)
+				return
+			}
+		}
+		http.NotFound(w, req)
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("err serving http downloads: %s", err)
+		}
+	}()
+	context.AfterFunc(ctx, func() { server.Close() })
+
+	for _, addr := range netif.Addrs(listener) {
+		for _, url := range lanurl.HTTP(addr) {
+			log.Printf("serving http downloads at: %s%s", url, protocol.DownloadPath)
+		}
+	}
+
+	return uint16(listener.Addr().(*net.TCPAddr).Port), nil
+}
+
+// downloadable returns the files, not the directories, symlinks nor stdin,
+// among the entries of filePaths walker collects. They are collected for
+// every request, so files added to an offered directory show up as they do
+// for receivers connecting later.
+func downloadable(filePaths []string, walker walker) ([]entry, error) {
+	entries, _, err := collectEntries(filePaths, walker)
+	if err != nil {
+		return nil, err
+	}
+
+	files := entries[:0]
+	for _, entry := range entries {
+		if entry.isFile() && !entry.stdin {
+			files = append(files, entry)
+		}
+	}
+
+	return files, nil
+}
+
+// serveIndex lists the offered files.
+func (s *Sender) serveIndex(w http.ResponseWriter, filePaths []string) {
+	files, err := downloadable(filePaths, s.walker())
+	if err != nil {
+		log.Printf("err listing files for http: %s", err)
+		http.Error(w, "files unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	type listed struct{ Name, URL, Size string }
+	var list []listed
+	for _, file := range files {
+		size := "?"
+		if info, err := os.Stat(file.localPath); err == nil {
+			size = progress.FormatSize(float64(info.Size()))
+		}
+		link := url.URL{Path: protocol.DownloadPath + "/" + file.name}
+		list = append(list, listed{Name: file.name, URL: link.String(), Size: size})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, list); err != nil {
+		log.Printf("err serving file list: %s", err)
+	}
+}
+
+// serveDownload sends file, or the ranges of it the request asks for, as an
+// attachment. http.ServeContent answers HEAD, Range and If-Range requests;
+// a resumed download only gets a range if the file is still the one it
+// started with, as far as its modification time tells.
+func (s *Sender) serveDownload(w http.ResponseWriter, req *http.Request, file entry) {
+	// LOAD THE FILE
+	f, err := os.Open(file.localPath)
+	if err != nil {
+		log.Printf("err opening %s for http: %s", file.localPath, err)
+		http.Error(w, "file unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		log.Printf("err reading file info of %s for http: %s", file.localPath, err)
+		http.Error(w, "file unavailable", http.StatusInternalServerError)
+		return
+	}
+	size := info.Size()
+
+	// DESCRIBE THE FILE
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(file.name)})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", disposition)
+
+	// SEND FILE CONTENT
+	batch := s.transfers.NewBatch(req.RemoteAddr, 1, uint64(size))
+	content := &downloadContent{file: f, r: s.newLimits().reader(f), digest: sha256.New()}
+	content.start = func(offset int64) *progress.Tracker {
+		log.Printf("sending %s to %s over http", file.name, req.RemoteAddr)
+		return batch.Start(s.progress, 1, file.name, uint64(size), uint64(offset))
+	}
+	http.ServeContent(w, req, file.name, info.ModTime(), content)
+	content.tracker.Finish()
+	batch.End()
+
+	switch {
+	case content.err != nil:
+		log.Printf("err sending %s to %s over http: %s", file.name, req.RemoteAddr, content.err)
+	case !content.started:
+		// a HEAD request, or one answered without content
+	case content.digest != nil && content.sent == size:
+		log.Printf("sent %d bytes of %s to %s over http, sha256: %s", content.sent, file.name, req.RemoteAddr, hex.EncodeToString(content.digest.Sum(nil)))
+	default:
+		log.Printf("sent %d bytes of %s to %s over http in ranges", content.sent, file.name, req.RemoteAddr)
+	}
+}
+
+// downloadContent is the file of a download as http.ServeContent reads it,
+// with its reads limited, hashed and tracked. Only content read from the
+// start of the file without seeking in between adds up to its digest, which
+// is dropped otherwise.
+type downloadContent struct {
+	file *os.File
+	// r reads file under the limits of the download
+	r      io.Reader
+	digest hash.Hash
+	// start starts tracking the download at the first read, from offset
+	start   func(offset int64) *progress.Tracker
+	tracker *progress.Tracker
+	started bool
+	// pos is the offset of the next read and sent the bytes read so far
+	pos, sent int64
+	// err is the first error reading the file
+	err error
+}
+
+func (c *downloadContent) Read(p []byte) (int, error) {
+	if !c.started {
+		c.started = true
+		c.tracker = c.start(c.pos)
+		if c.pos != 0 {
+			c.digest = nil
+		}
+	}
+
+	n, err := c.r.Read(p)
+	if c.digest != nil {
+		c.digest.Write(p[:n])
+	}
+	c.pos += int64(n)
+	c.sent += int64(n)
+	c.tracker.Add(n)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+
+	return n, err
+}
+
+func (c *downloadContent) Seek(offset int64, whence int) (int64, error) {
+	pos, err := c.file.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	// several ranges are read one after the other
+	if c.started && pos != c.pos {
+		c.digest = nil
+	}
+	c.pos = pos
+
+	return pos, nil
+}
@@ -0,0 +1,171 @@
+package sender
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+)
+
+func TestServeDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := entry{localPath: path, name: "dir/data.bin"}
+
+	tests := []struct {
+		name       string
+		method     string
+		header     map[string]string
+		wantStatus int
+		wantBody   []byte
+		wantRange  string
+	}{
+		{name: "whole file", wantStatus: http.StatusOK, wantBody: content},
+		{name: "head", method: http.MethodHead, wantStatus: http.StatusOK, wantBody: []byte{}},
+		{
+			name:       "range",
+			header:     map[string]string{"Range": "bytes=100-199"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   content[100:200],
+			wantRange:  "bytes 100-199/10000",
+		},
+		{
+			name:       "open range",
+			header:     map[string]string{"Range": "bytes=9990-"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   content[9990:],
+			wantRange:  "bytes 9990-9999/10000",
+		},
+		{
+			name:       "suffix range",
+			header:     map[string]string{"Range": "bytes=-5"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   content[9995:],
+			wantRange:  "bytes 9995-9999/10000",
+		},
+		{
+			name:       "unchanged if-range",
+			header:     map[string]string{"Range": "bytes=10-19", "If-Range": modTime.Format(http.TimeFormat)},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   content[10:20],
+			wantRange:  "bytes 10-19/10000",
+		},
+		{
+			name:       "changed if-range",
+			header:     map[string]string{"Range": "bytes=10-19", "If-Range": modTime.Add(-time.Hour).Format(http.TimeFormat)},
+			wantStatus: http.StatusOK,
+			wantBody:   content,
+		},
+		{
+			name:       "beyond the end",
+			header:     map[string]string{"Range": "bytes=10000-"},
+			wantStatus: http.StatusRequestedRangeNotSatisfiable,
+			wantRange:  "bytes */10000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/download/dir/data.bin", nil)
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+
+			s.serveDownload(rec, req, file)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Range"); got != tt.wantRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantRange)
+			}
+			if tt.wantBody != nil && !bytes.Equal(rec.Body.Bytes(), tt.wantBody) {
+				t.Errorf("body is %d bytes, want %d", rec.Body.Len(), len(tt.wantBody))
+			}
+			if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename=data.bin` {
+				t.Errorf("Content-Disposition = %q", got)
+			}
+		})
+	}
+}
+
+func TestDownloadContentDigest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, []byte("hello world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	open := func(t *testing.T) *downloadContent {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return &downloadContent{file: f, r: f, digest: sha256.New(), start: func(int64) *progress.Tracker { return nil }}
+	}
+
+	t.Run("whole file", func(t *testing.T) {
+		content := open(t)
+		if _, err := content.Seek(0, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, content); err != nil {
+			t.Fatal(err)
+		}
+		want := sha256.Sum256([]byte("hello world"))
+		if content.digest == nil || !bytes.Equal(content.digest.Sum(nil), want[:]) {
+			t.Errorf("digest of the whole file is wrong or missing")
+		}
+	})
+	t.Run("from an offset", func(t *testing.T) {
+		content := open(t)
+		if _, err := content.Seek(6, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, content); err != nil {
+			t.Fatal(err)
+		}
+		if content.digest != nil || content.sent != 5 {
+			t.Errorf("digest kept, or %d bytes sent instead of 5", content.sent)
+		}
+	})
+	t.Run("several ranges", func(t *testing.T) {
+		content := open(t)
+		if _, err := io.CopyN(io.Discard, content, 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := content.Seek(6, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.CopyN(io.Discard, content, 2); err != nil {
+			t.Fatal(err)
+		}
+		if content.digest != nil || content.sent != 4 {
+			t.Errorf("digest kept, or %d bytes sent instead of 4", content.sent)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package sender
+
+import (
+	"log"
+	"net"
+	"net/netip"
+
+	"github.com/pjmessi/go_file_share/internal/netif"
+)
+
+// WithInterface announces the sender and serves receivers through the
+// network interface named name only, e.g. "eth1", rather than through
+// whichever one the system picks, for machines with Docker bridges, VPN
+// tunnels or several NICs. Announcements go out through it unless
+// BroadcastAnnouncer.Interface or MulticastAnnouncer.Interface say
+// otherwise. Receivers and WithHTTPDownloads downloads are listened for on
+// its addresses, connections through other interfaces being turned away,
+// and parallel connections and datagrams leave from its address. The
+// addresses of the interface are looked up once, when the sender
+// is created, which fails for an unknown name.
+func WithInterface(name string) Option {
+	return func(s *Sender) {
+		s.ifaceName = name
+	}
+}
+
+// onInterface returns a announcing through the WithInterface interface, if
+// one is configured and a names none itself.
+func (s *Sender) onInterface(a Announcer) Announcer {
+	if s.ifaceName == "" {
+		return a
+	}
+	switch a := a.(type) {
+	case BroadcastAnnouncer:
+		if a.Interface == "" {
+			a.Interface = s.ifaceName
+		}
+		return a
+	case MulticastAnnouncer:
+		if a.Interface == "" {
+			a.Interface = s.ifaceName
+		}
+		return a
+	}
+	return a
+}
+
+// listenOn listens on addr, a host:port, through listen. With the
+// WithInterface interface configured, an empty or unspecified host stands
+// for every address of the interface rather than of the machine, and the
+// connections that don't run through it are turned away.
+func (s *Sender) listenOn(addr string, listen func(addr string) (net.Listener, error)) (net.Listener, error) {
+	if s.ifaceName == "" {
+		return listen(addr)
+	}
+	listener, err := s.iface.ListenOn(addr, listen)
+	if err != nil {
+		return nil, err
+	}
+	return ifaceListener{Listener: listener, iface: s.iface}, nil
+}
+
+// localAddr returns the address of the WithInterface interface to connect
+// to remote from, if one is configured.
+func (s *Sender) localAddr(remote netip.Addr) (netip.Addr, bool) {
+	if s.ifaceName == "" {
+		return netip.Addr{}, false
+	}
+	return s.iface.Addr(remote)
+}
+
+// localTCPAddr returns the local address of net.Dialer connecting to
+// remote, nil to let the system pick one.
+func (s *Sender) localTCPAddr(remote netip.Addr) net.Addr {
+	local, ok := s.localAddr(remote)
+	if !ok {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(local, 0))
+}
+
+// localUDPAddr is localTCPAddr for datagrams.
+func (s *Sender) localUDPAddr(remote netip.Addr) *net.UDPAddr {
+	local, ok := s.localAddr(remote)
+	if !ok {
+		return nil
+	}
+	return net.UDPAddrFromAddrPort(netip.AddrPortFrom(local, 0))
+}
+
+// ifaceListener drops the connections that don't run through iface as they
+// are accepted.
+type ifaceListener struct {
+	net.Listener
+	iface netif.Interface
+}
+
+// Addrs returns the addresses the listener listens on, see netif.Addrs.
+func (l ifaceListener) Addrs() []net.Addr {
+	return netif.Addrs(l.Listener)
+}
+
+func (l ifaceListener) Accept() (net.Conn, error) {
+	for {
+		con, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		local, localOK := ipOf(con.LocalAddr())
+		remote, remoteOK := ipOf(con.RemoteAddr())
+		if !localOK || !remoteOK || !l.iface.Carries(local, remote) {
+			log.Printf("dropping connection from %s: not through interface %s", con.RemoteAddr(), l.iface.Name)
+			con.Close()
+			continue
+		}
+		return con, nil
+	}
+}
+
+// ipOf returns the IP of a TCP or UDP address.
+func ipOf(addr net.Addr) (netip.Addr, bool) {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.AddrPort().Addr(), true
+	case *net.UDPAddr:
+		return addr.AddrPort().Addr(), true
+	}
+	return netip.Addr{}, false
+}
+
+// lookupInterface looks up the WithInterface interface, if one is
+// configured.
+func (s *Sender) lookupInterface() error {
+	if s.ifaceName == "" {
+		return nil
+	}
+	var err error
+	s.iface, err = netif.Lookup(s.ifaceName)
+	return err
+}
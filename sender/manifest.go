@@ -0,0 +1,48 @@
+package sender
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// offerEntries lists entries to a receiver that announced CapManifest and
+// returns those it selected, numbered anew. Selecting none leaves a
+// transfer of no entries.
+func (s *Sender) offerEntries(con net.Conn, entries []entry) ([]entry, error) {
+	// SEND THE MANIFEST
+	manifest := make([]protocol.ManifestEntry, len(entries))
+	for i, entry := range entries {
+		manifest[i] = protocol.ManifestEntry{
+			Frame:   protocol.Frame{Type: entry.frameType(), Name: entry.name},
+			Size:    uint64(entry.size),
+			ModTime: entry.modTime,
+		}
+		if entry.stdin {
+			manifest[i].Size = protocol.UnknownSize
+		}
+	}
+	if err := binary.Write(con, binary.LittleEndian, protocol.ManifestFollows); err != nil {
+		return nil, fmt.Errorf("err sending manifest: %s", err)
+	}
+	if err := protocol.WriteManifest(con, manifest); err != nil {
+		return nil, fmt.Errorf("err sending manifest: %s", err)
+	}
+
+	// READ THE SELECTION
+	indexes, err := protocol.ReadSelection(con, len(entries))
+	if err != nil {
+		return nil, fmt.Errorf("err reading selection: %s", err)
+	}
+	selected := make([]entry, len(indexes))
+	for j, i := range indexes {
+		selected[j] = entries[i]
+	}
+	numberFiles(selected)
+	log.Printf("%s picked %d of %d entries", con.RemoteAddr(), len(selected), len(entries))
+
+	return selected, nil
+}
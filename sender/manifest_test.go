@@ -0,0 +1,109 @@
+package sender
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/receiver"
+)
+
+// listOffers lists what the sender listening on port of localhost offers.
+func listOffers(t *testing.T, port uint16) []receiver.FileInfo {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	r, dest := newTestReceiver(t, receiver.WithPeer(net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))), false))
+	offers, err := r.ListOffers(ctx)
+	if err != nil {
+		t.Fatalf("ListOffers: %v", err)
+	}
+	saved, err := os.ReadDir(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range saved {
+		t.Errorf("listing saved %s", entry.Name())
+	}
+	return offers
+}
+
+func TestListingIsNoTransfer(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "file.txt", []byte("only listed"))
+	errs := make(chan error, 1)
+	s := newTestSender(t, WithEvents(Events{Error: func(_ context.Context, err error) { errs <- err }}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	served := make(chan error, 1)
+	go func() {
+		con, err := listener.Accept()
+		if err != nil {
+			served <- err
+			return
+		}
+		served <- s.ServeConn(con, []string{path})
+	}()
+
+	offers := listOffers(t, uint16(listener.Addr().(*net.TCPAddr).Port))
+	if len(offers) != 1 || offers[0].Name != "file.txt" {
+		t.Errorf("ListOffers = %+v, want file.txt", offers)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("ServeConn = %v after the receiver only listed the file", err)
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("listing was reported as an error: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestListedQueueItemIsWithdrawn(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "file.txt", []byte("only listed"))
+	changes := make(chan QueueItem, 16)
+	s := newTestSender(t, WithEvents(Events{QueueItemChanged: func(_ context.Context, item QueueItem) { changes <- item }}))
+	if err := s.Enqueue(path); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	announcer := portAnnouncer{port: make(chan uint16, 1)}
+	s.announcers = []Announcer{announcer}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.ProcessQueue(ctx, 0)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	listOffers(t, nextSession(t, announcer.port))
+
+	// the path is offered again, as if the listing never happened
+	nextSession(t, announcer.port)
+	want := []struct {
+		state    ItemState
+		attempts int
+	}{{ItemPending, 0}, {ItemSending, 1}, {ItemPending, 0}, {ItemSending, 1}}
+	for i, w := range want {
+		select {
+		case item := <-changes:
+			if item.State != w.state || item.Attempts != w.attempts {
+				t.Errorf("change %d: %s after %d attempts, want %s after %d", i+1, item.State, item.Attempts, w.state, w.attempts)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("change %d wasn't reported", i+1)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package sender
+
+import "github.com/pjmessi/go_file_share/internal/metrics"
+
+// senderMetrics are the metrics served with WithMetricsAddr.
+type senderMetrics struct {
+	registry metrics.Registry
+
+	started      *metrics.Counter
+	completed    *metrics.Counter
+	failed       *metrics.Counter
+	bytes        *metrics.Counter
+	connections  *metrics.Counter
+	active       *metrics.Gauge
+	lastDuration *metrics.Gauge
+}
+
+func newSenderMetrics() *senderMetrics {
+	m := &senderMetrics{}
+	m.started = m.registry.Counter("fileshare_sender_transfers_started_total", "Files accepted by receivers.")
+	m.completed = m.registry.Counter("fileshare_sender_transfers_completed_total", "Files confirmed by receivers.")
+	m.failed = m.registry.Counter("fileshare_sender_transfers_failed_total", "Files that failed to be sent.")
+	m.bytes = m.registry.Counter("fileshare_sender_bytes_sent_total", "Content bytes of the files confirmed.")
+	m.connections = m.registry.Counter("fileshare_sender_connections_total", "Receivers that completed the handshake.")
+	m.active = m.registry.Gauge("fileshare_sender_active_transfers", "Files being sent right now.")
+	m.lastDuration = m.registry.Gauge("fileshare_sender_last_transfer_duration_seconds", "How long the last confirmed file took.")
+	return m
+}
+
+// WithMetricsAddr serves metrics in the Prometheus text format at /metrics
+// on addr, e.g. ":9091", while Send runs. The metric names are stable:
+//
+//	fileshare_sender_transfers_started_total
+//	fileshare_sender_transfers_completed_total
+//	fileshare_sender_transfers_failed_total
+//	fileshare_sender_bytes_sent_total
+//	fileshare_sender_connections_total
+//	fileshare_sender_active_transfers
+//	fileshare_sender_last_transfer_duration_seconds
+//
+// Declined files count as neither completed nor failed.
+func WithMetricsAddr(addr string) Option {
+	return func(s *Sender) {
+		s.metricsAddr = addr
+	}
+}
@@ -0,0 +1,83 @@
+package sender
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pjmessi/go_file_share/receiver"
+)
+
+// scrape returns the metrics s serves, by name, in the order served.
+func scrape(t *testing.T, s *Sender) ([]string, map[string]float64) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	s.metrics.registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	var names []string
+	values := make(map[string]float64)
+	for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, _ := strings.Cut(line, " ")
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("metric line %q: %v", line, err)
+		}
+		names = append(names, name)
+		values[name] = v
+	}
+	return names, values
+}
+
+func TestMetricNames(t *testing.T) {
+	names, _ := scrape(t, newTestSender(t))
+	want := []string{
+		"fileshare_sender_transfers_started_total",
+		"fileshare_sender_transfers_completed_total",
+		"fileshare_sender_transfers_failed_total",
+		"fileshare_sender_bytes_sent_total",
+		"fileshare_sender_connections_total",
+		"fileshare_sender_active_transfers",
+		"fileshare_sender_last_transfer_duration_seconds",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("metrics = %q, want %q", names, want)
+	}
+}
+
+func TestMetricsCountTransfers(t *testing.T) {
+	src := t.TempDir()
+	paths := []string{
+		writeTestFile(t, src, "a.txt", make([]byte, 1000)),
+		writeTestFile(t, src, "b.txt", make([]byte, 234)),
+	}
+	s := newTestSender(t)
+
+	r, _ := newTestReceiver(t)
+	if res := pipeTransfer(t, s, r, paths...); res.receiveErr != nil || res.serveErr != nil {
+		t.Fatalf("transfer failed: receiver %v, sender %v", res.receiveErr, res.serveErr)
+	}
+	small, _ := newTestReceiver(t, receiver.WithMaxFileSize(500))
+	pipeTransfer(t, s, small, paths[0])
+
+	_, values := scrape(t, s)
+	want := map[string]float64{
+		"fileshare_sender_transfers_completed_total": 2,
+		"fileshare_sender_transfers_failed_total":    1,
+		"fileshare_sender_bytes_sent_total":          1234,
+		"fileshare_sender_connections_total":         2,
+		"fileshare_sender_active_transfers":          0,
+	}
+	for name, v := range want {
+		if values[name] != v {
+			t.Errorf("%s = %g, want %g", name, values[name], v)
+		}
+	}
+	if values["fileshare_sender_last_transfer_duration_seconds"] <= 0 {
+		t.Error("last transfer duration not recorded")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package sender
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile is unsupported where the standard library has no mmap; content is
+// read buffered instead.
+func mapFile(file *os.File, size uint64) ([]byte, func() error, error) {
+	return nil, nil, errors.ErrUnsupported
+}
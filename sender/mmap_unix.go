@@ -0,0 +1,25 @@
+//go:build unix
+
+package sender
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first size bytes of file into memory read-only and
+// returns them along with the function unmapping them.
+func mapFile(file *os.File, size uint64) ([]byte, func() error, error) {
+	if size > math.MaxInt {
+		return nil, nil, fmt.Errorf("%d bytes don't fit the address space", size)
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
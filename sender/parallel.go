@@ -0,0 +1,172 @@
+package sender
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// parallelMinRange is the least content a parallel connection is opened for
+const parallelMinRange = 1 << 20
+
+// WithParallelStreams sends the content of every file over n TCP connections
+// at once, up to protocol.MaxParallel, to receivers that support it; on links
+// with a lot of bandwidth and latency, such as a VPN between sites, a single
+// connection tops out well below their rate. The content is split into n
+// contiguous ranges of at least 1 MiB, so smaller files use fewer
+// connections, and a connection that fails is replaced, continuing its range
+// where the receiver got to. The connections are unencrypted, so receivers
+// connected over TLS get the content over the transfer's connection, as do
+// receivers that don't support it. It takes precedence over
+// WithBlockChecksums and WithCompression and needs TransportTCP. The default,
+// 1, sends the content over the transfer's connection.
+func WithParallelStreams(n int) Option {
+	return func(s *Sender) {
+		s.parallel = n
+	}
+}
+
+// sendParallel sends the rest of the file from offset on in ranges over
+// parallel connections to the port the receiver offers. It returns the final
+// digest, taken from the file once every range was confirmed as they go out
+// of order, along with the number of content bytes sent.
+func (s *Sender) sendParallel(ctx context.Context, con net.Conn, file *os.File, offset, size uint64, digest hash.Hash, tracker *progress.Tracker) ([]byte, uint64, error) {
+	remote, ok := con.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, 0, fmt.Errorf("parallel connections can't be opened next to a %s connection", con.RemoteAddr().Network())
+	}
+
+	// PICK THE NUMBER OF CONNECTIONS
+	var offer protocol.ParallelOffer
+	if err := binary.Read(con, binary.LittleEndian, &offer); err != nil {
+		return nil, 0, fmt.Errorf("err receiving parallel offer: %s", err)
+	}
+	remaining := size - offset
+	streams := min(s.parallel, max(1, int(remaining/parallelMinRange)))
+	if err := binary.Write(con, binary.LittleEndian, uint8(streams)); err != nil {
+		return nil, 0, fmt.Errorf("err sending stream count: %s", err)
+	}
+	addr := netip.AddrPortFrom(remote.AddrPort().Addr().Unmap(), offer.Port).String()
+	// the ranges share the limits of the transfer
+	limits := limitsOf(con)
+
+	// SEND THE RANGES
+	// A range is connected for again as WithTransferRetry allows; one whose
+	// connections keep failing dooms the file, which stops the other ranges
+	// too.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var ranges sync.WaitGroup
+	var once sync.Once
+	var failure error
+	for i := range streams {
+		start, end := protocol.ParallelRange(remaining, streams, i)
+		if start == end {
+			continue
+		}
+
+		ranges.Add(1)
+		go func() {
+			defer ranges.Done()
+			err := s.transferRetry.Do(ctx, func() error {
+				err := s.sendRange(ctx, addr, offer.Token, i, file, offset+start, offset+end, tracker, limits)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("warning: %s", err)
+				}
+				return err
+			})
+			if err != nil {
+				once.Do(func() {
+					failure = err
+					cancel()
+				})
+			}
+		}()
+	}
+	ranges.Wait()
+	if failure != nil {
+		return nil, 0, failure
+	}
+
+	// HASH THE CONTENT
+	if _, err := io.Copy(digest, io.NewSectionReader(file, int64(offset), int64(remaining))); err != nil {
+		return nil, 0, fmt.Errorf("err hashing file: %s", err)
+	}
+
+	log.Printf("sent %d bytes of %s to receiver over %d connections", remaining, file.Name(), streams)
+
+	return digest.Sum(nil), remaining, nil
+}
+
+// sendRange connects to addr for range i, from start to end of file, sends
+// what the receiver doesn't hold of it yet under limits and waits for the
+// receiver to confirm it.
+func (s *Sender) sendRange(ctx context.Context, addr string, token [protocol.TokenSize]byte, i int, file *os.File, start, end uint64, tracker *progress.Tracker, limits limits) error {
+	remote, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return fmt.Errorf("err connecting for range %d: %s", i, err)
+	}
+	dialer := net.Dialer{Timeout: 10 * time.Second, Control: s.socket.Control, LocalAddr: s.localTCPAddr(remote.Addr())}
+	c, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("err connecting for range %d: %s", i, err)
+	}
+	defer c.Close()
+	if err := s.socket.Apply(c); err != nil {
+		return fmt.Errorf("err tuning connection for range %d: %s", i, err)
+	}
+	stopClosing := context.AfterFunc(ctx, func() { c.Close() })
+	defer stopClosing()
+	c = limits.throttle(c)
+
+	// NAME THE RANGE
+	open := struct {
+		Token [protocol.TokenSize]byte
+		Index uint8
+	}{token, uint8(i)}
+	if err := binary.Write(c, binary.LittleEndian, open); err != nil {
+		return fmt.Errorf("err naming range %d: %s", i, err)
+	}
+	var have uint64
+	if err := binary.Read(c, binary.LittleEndian, &have); err != nil {
+		return fmt.Errorf("err receiving offset of range %d: %s", i, err)
+	}
+	if have > end-start {
+		return fmt.Errorf("receiver holds %d bytes of range %d, which has %d", have, i, end-start)
+	}
+
+	// SEND THE REST OF THE RANGE
+	// sendfile sends from the file's position, which every range needs one
+	// of its own of
+	if s.readMode == ReadSendfile {
+		if file, err = os.Open(file.Name()); err != nil {
+			return fmt.Errorf("err opening file for range %d: %s", i, err)
+		}
+		defer file.Close()
+	}
+	if _, err := s.sendFileRange(c, file, start+have, end, nil, tracker, s.chunkSizer()); err != nil {
+		return fmt.Errorf("err sending range %d: %s", i, err)
+	}
+
+	// WAIT FOR THE RECEIVER TO CONFIRM
+	if s.ackTimeout > 0 {
+		c.SetReadDeadline(time.Now().Add(s.ackTimeout))
+	}
+	var confirmation [1]byte
+	if _, err := io.ReadFull(c, confirmation[:]); err != nil {
+		return fmt.Errorf("err receiving confirmation of range %d: %s", i, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,364 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/events"
+	"github.com/pjmessi/go_file_share/retry"
+)
+
+// ItemState is where a queued path is at, see QueueItem.
+type ItemState uint8
+
+const (
+	// ItemPending waits to be sent, for the first time or again.
+	ItemPending ItemState = iota
+	// ItemSending is being offered or sent to a receiver.
+	ItemSending
+	// ItemDone was confirmed by a receiver.
+	ItemDone
+	// ItemFailed failed as often as WithTransferRetry allows.
+	ItemFailed
+)
+
+func (s ItemState) String() string {
+	switch s {
+	case ItemPending:
+		return "pending"
+	case ItemSending:
+		return "sending"
+	case ItemDone:
+		return "done"
+	case ItemFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("ItemState(%d)", uint8(s))
+	}
+}
+
+// QueueItem is a path in the sender's queue, see Enqueue and Queue.
+type QueueItem struct {
+	// Path is the path as given to Enqueue or Send.
+	Path  string
+	State ItemState
+	// Attempts counts the transfers the path went out in since it was
+	// queued.
+	Attempts int
+	// Err is why the last attempt failed, nil if it didn't.
+	Err error
+
+	// retryAt is when a pending path that failed is taken again
+	retryAt time.Time
+}
+
+// WithQueueConcurrency sends up to n queued paths at once, see
+// ProcessQueue, each announced on a port of its own, which needs port 0. The
+// default, 1, sends them one after the other.
+func WithQueueConcurrency(n int) Option {
+	return func(s *Sender) {
+		s.queueConcurrency = n
+	}
+}
+
+// queue holds the paths sent by Send and those queued for ProcessQueue and
+// Watch, in the order they were added.
+type queue struct {
+	mu    sync.Mutex
+	items []QueueItem
+	// wake is closed, and replaced, once there are new pending paths, which
+	// wakes every worker of ProcessQueue waiting for them
+	wake chan struct{}
+}
+
+func newQueue() *queue {
+	return &queue{wake: make(chan struct{})}
+}
+
+// woken returns a channel that is closed once paths are added after the
+// call.
+func (q *queue) woken() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.wake
+}
+
+// item returns the item of path, adding a pending one if there is none.
+// q.mu must be held.
+func (q *queue) item(path string) *QueueItem {
+	for i := range q.items {
+		if q.items[i].Path == path {
+			return &q.items[i]
+		}
+	}
+	q.items = append(q.items, QueueItem{Path: path})
+	return &q.items[len(q.items)-1]
+}
+
+// add queues path to be sent: a new path, or one sent or failed before, is
+// pending afterwards. It returns the item and whether it changed.
+func (q *queue) add(path string) (QueueItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := len(q.items)
+	item := q.item(path)
+	if len(q.items) > n || item.State != ItemPending {
+		*item = QueueItem{Path: path}
+		close(q.wake)
+		q.wake = make(chan struct{})
+		return *item, true
+	}
+	return *item, false
+}
+
+// take marks the first pending path that is due as sending and returns it.
+func (q *queue) take(now time.Time) (QueueItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.items {
+		item := &q.items[i]
+		if item.State == ItemPending && !now.Before(item.retryAt) {
+			item.State = ItemSending
+			item.Attempts++
+			return *item, true
+		}
+	}
+	return QueueItem{}, false
+}
+
+// end settles path after a transfer that ended with err, unless it was
+// queued again meanwhile: it is done if the transfer succeeded; otherwise
+// it is pending again, after the delay of policy, until the attempts of
+// policy are used up, and failed then. It returns the item and whether it
+// changed.
+func (q *queue) end(path string, err error, policy retry.Policy, now time.Time) (QueueItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item := q.item(path)
+	if item.State != ItemSending {
+		return *item, false
+	}
+	item.Err = err
+	switch {
+	case err == nil:
+		item.State = ItemDone
+	case item.Attempts < max(policy.MaxAttempts, 1):
+		item.State = ItemPending
+		item.retryAt = now.Add(policy.Delay(item.Attempts))
+	default:
+		item.State = ItemFailed
+	}
+	return *item, true
+}
+
+// offer marks paths as sending while Send offers them, for the first
+// attempt. It returns the items.
+func (q *queue) offer(paths []string) []QueueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var offered []QueueItem
+	for _, path := range paths {
+		item := q.item(path)
+		*item = QueueItem{Path: path, State: ItemSending, Attempts: 1}
+		offered = append(offered, *item)
+	}
+	return offered
+}
+
+// served notes a transfer of Send's paths that ended with err: they are
+// done once a receiver confirmed them, failed if the transfer failed and no
+// receiver confirmed them before. It returns the items that changed.
+func (q *queue) served(paths []string, err error) []QueueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var changed []QueueItem
+	for _, path := range paths {
+		item := q.item(path)
+		if item.State == ItemDone && err != nil {
+			continue
+		}
+		if item.State != ItemSending {
+			item.Attempts++
+		}
+		item.State, item.Err = ItemDone, err
+		if err != nil {
+			item.State = ItemFailed
+		}
+		changed = append(changed, *item)
+	}
+	return changed
+}
+
+// withdraw marks paths that no transfer went through, once they stopped
+// being offered, as pending. It returns the items that changed.
+func (q *queue) withdraw(paths []string) []QueueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var changed []QueueItem
+	for _, path := range paths {
+		item := q.item(path)
+		if item.State == ItemSending {
+			item.State, item.Attempts = ItemPending, item.Attempts-1
+			changed = append(changed, *item)
+		}
+	}
+	return changed
+}
+
+// snapshot returns a copy of the items.
+func (q *queue) snapshot() []QueueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]QueueItem, len(q.items))
+	copy(items, q.items)
+	return items
+}
+
+// log logs how many items are in every state, and every failed one.
+func (q *queue) log() {
+	items := q.snapshot()
+	if len(items) == 0 {
+		return
+	}
+
+	counts := map[ItemState]int{}
+	for _, item := range items {
+		counts[item.State]++
+	}
+	log.Printf("queue: %d done, %d failed, %d pending, %d sending",
+		counts[ItemDone], counts[ItemFailed], counts[ItemPending], counts[ItemSending])
+	for _, item := range items {
+		if item.State == ItemFailed {
+			log.Printf("  %s: failed after %d attempts: %s", item.Path, item.Attempts, item.Err)
+		}
+	}
+}
+
+// Enqueue queues path, a file or directory, to be sent by ProcessQueue or
+// Watch, again if it was sent before. A path already pending stays queued
+// once.
+func (s *Sender) Enqueue(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("err queueing %s: %s", path, err)
+	}
+	s.enqueue(context.Background(), path)
+
+	return nil
+}
+
+// enqueue queues path and reports its item if that changed.
+func (s *Sender) enqueue(ctx context.Context, path string) {
+	if item, changed := s.queue.add(path); changed {
+		events.Emit(&s.eventQueue, ctx, s.events.QueueItemChanged, item)
+	}
+}
+
+// Queue returns the paths Send, Enqueue and Watch queued, in the order they
+// were first queued, with their state at the time of the call.
+func (s *Sender) Queue() []QueueItem {
+	return s.queue.snapshot()
+}
+
+// reportItems passes items to the QueueItemChanged callback.
+func (s *Sender) reportItems(ctx context.Context, items []QueueItem) {
+	for _, item := range items {
+		events.Emit(&s.eventQueue, ctx, s.events.QueueItemChanged, item)
+	}
+}
+
+// ProcessQueue sends the paths queued with Enqueue until ctx is done, one
+// after the other, or up to WithQueueConcurrency at once. Every path is
+// announced under a session of its own, so receivers in daemon mode connect
+// for every one, and goes to the first receiver that connects, however long
+// that takes. A path whose transfer failed is sent again as
+// WithTransferRetry allows before it counts as failed. The state of the
+// queue is logged once ctx is done, and ctx.Err() is returned.
+func (s *Sender) ProcessQueue(ctx context.Context, port uint16) error {
+	// senders from the deprecated constructor haven't been validated yet
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	return s.processQueue(ctx, port, nil)
+}
+
+// processQueue runs ProcessQueue, passing every item that is done to done,
+// if set.
+func (s *Sender) processQueue(ctx context.Context, port uint16, done func(QueueItem)) error {
+	if s.queueConcurrency > 1 && port != 0 {
+		return errors.New("paths sent at once are announced on ports of their own, which needs port 0")
+	}
+	defer s.queue.log()
+
+	var workers sync.WaitGroup
+	for range s.queueConcurrency {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			s.sendQueued(ctx, port, done)
+		}()
+	}
+	workers.Wait()
+
+	return ctx.Err()
+}
+
+// sendQueued sends the pending paths one after the other until ctx is
+// done.
+func (s *Sender) sendQueued(ctx context.Context, port uint16, done func(QueueItem)) {
+	for {
+		// WAIT FOR A PENDING PATH
+		// paths waiting to be retried are looked at again every poll
+		wake := s.queue.woken()
+		item, ok := s.queue.take(time.Now())
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-wake:
+			case <-time.After(watchPoll):
+			}
+			continue
+		}
+		s.reportItems(ctx, []QueueItem{item})
+
+		// SEND THE PATH
+		log.Printf("sending %s, attempt %d", item.Path, item.Attempts)
+		err := s.send(ctx, port, []string{item.Path}, true)
+		if ctx.Err() != nil {
+			// an interrupted path is sent by the next run
+			s.reportItems(ctx, s.queue.withdraw([]string{item.Path}))
+			return
+		}
+		if errors.Is(err, errNothingPicked) {
+			// the receiver only listed it, the path is offered again
+			s.reportItems(ctx, s.queue.withdraw([]string{item.Path}))
+			continue
+		}
+		if err != nil {
+			log.Printf("warning: err sending %s: %s", item.Path, err)
+		}
+
+		item, changed := s.queue.end(item.Path, err, s.transferRetry, time.Now())
+		if !changed {
+			continue
+		}
+		s.reportItems(ctx, []QueueItem{item})
+		if item.State == ItemDone && done != nil {
+			done(item)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package sender
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/retry"
+)
+
+// assertItem fails the test unless item is in state after attempts.
+func assertItem(t *testing.T, item QueueItem, state ItemState, attempts int) {
+	t.Helper()
+
+	if item.State != state || item.Attempts != attempts {
+		t.Errorf("%s is %s after %d attempts, want %s after %d", item.Path, item.State, item.Attempts, state, attempts)
+	}
+}
+
+func TestQueueAdd(t *testing.T) {
+	q := newQueue()
+	item, changed := q.add("a.txt")
+	if !changed {
+		t.Error("adding a new path changed nothing")
+	}
+	assertItem(t, item, ItemPending, 0)
+	if _, changed := q.add("a.txt"); changed {
+		t.Error("adding a pending path again changed it")
+	}
+	if n := len(q.snapshot()); n != 1 {
+		t.Errorf("the queue holds %d items, want 1", n)
+	}
+
+	// a path queued again while it is sent is sent again, the transfer
+	// going on doesn't settle it
+	item, _ = q.take(time.Now())
+	assertItem(t, item, ItemSending, 1)
+	if item, changed = q.add("a.txt"); !changed {
+		t.Error("adding a path being sent changed nothing")
+	}
+	assertItem(t, item, ItemPending, 0)
+	if item, changed = q.end("a.txt", nil, retry.Policy{}, time.Now()); changed {
+		t.Error("the transfer of a path queued again settled it")
+	}
+	assertItem(t, item, ItemPending, 0)
+
+	// as are those sent or failed before
+	item, _ = q.take(time.Now())
+	q.end(item.Path, nil, retry.Policy{}, time.Now())
+	if item, changed = q.add("a.txt"); !changed {
+		t.Error("adding a path sent before changed nothing")
+	}
+	assertItem(t, item, ItemPending, 0)
+}
+
+func TestQueueTakesInOrder(t *testing.T) {
+	q := newQueue()
+	for _, path := range []string{"a.txt", "b.txt"} {
+		q.add(path)
+	}
+	for _, want := range []string{"a.txt", "b.txt"} {
+		item, ok := q.take(time.Now())
+		if !ok || item.Path != want {
+			t.Fatalf("take = %q, %t, want %q", item.Path, ok, want)
+		}
+	}
+	if item, ok := q.take(time.Now()); ok {
+		t.Errorf("take = %q with every path being sent", item.Path)
+	}
+}
+
+func TestQueueEnd(t *testing.T) {
+	failure := errors.New("receiver went away")
+	tests := []struct {
+		name   string
+		policy retry.Policy
+		errs   []error
+		// want is the state after every transfer in errs
+		want []ItemState
+	}{
+		{"sent", retry.Policy{MaxAttempts: 3}, []error{nil}, []ItemState{ItemDone}},
+		{"sent on retry", retry.Policy{MaxAttempts: 3}, []error{failure, nil}, []ItemState{ItemPending, ItemDone}},
+		{"attempts used up", retry.Policy{MaxAttempts: 3}, []error{failure, failure, failure}, []ItemState{ItemPending, ItemPending, ItemFailed}},
+		{"no retries", retry.Policy{}, []error{failure}, []ItemState{ItemFailed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQueue()
+			q.add("a.txt")
+			now := time.Now()
+			for i, err := range tt.errs {
+				if _, ok := q.take(now); !ok {
+					t.Fatalf("attempt %d: nothing to take", i+1)
+				}
+				item, changed := q.end("a.txt", err, tt.policy, now)
+				if !changed {
+					t.Errorf("attempt %d: ending the transfer changed nothing", i+1)
+				}
+				assertItem(t, item, tt.want[i], i+1)
+				if item.Err != err {
+					t.Errorf("attempt %d: Err = %v, want %v", i+1, item.Err, err)
+				}
+			}
+		})
+	}
+}
+
+func TestQueueRetryDelay(t *testing.T) {
+	q := newQueue()
+	q.add("a.txt")
+	policy := retry.Policy{MaxAttempts: 2, InitialDelay: time.Minute, MaxDelay: time.Minute}
+	now := time.Now()
+	q.take(now)
+	q.end("a.txt", errors.New("receiver went away"), policy, now)
+
+	// the delay is between half and all of a minute
+	if item, ok := q.take(now.Add(29 * time.Second)); ok {
+		t.Errorf("%s was taken again before its retry delay", item.Path)
+	}
+	item, ok := q.take(now.Add(61 * time.Second))
+	if !ok {
+		t.Fatal("the path wasn't taken again after its retry delay")
+	}
+	assertItem(t, item, ItemSending, 2)
+}
+
+func TestQueueServed(t *testing.T) {
+	failure := errors.New("receiver went away")
+	tests := []struct {
+		name string
+		// before is the outcome of an earlier transfer of the path, if any
+		before       []error
+		err          error
+		want         ItemState
+		wantAttempts int
+		wantChanged  bool
+	}{
+		{"confirmed", nil, nil, ItemDone, 1, true},
+		{"failed", nil, failure, ItemFailed, 1, true},
+		{"failed after it was confirmed", []error{nil}, failure, ItemDone, 1, false},
+		{"confirmed after it failed", []error{failure}, nil, ItemDone, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQueue()
+			for _, item := range q.offer([]string{"a.txt"}) {
+				assertItem(t, item, ItemSending, 1)
+			}
+			for _, err := range tt.before {
+				q.served([]string{"a.txt"}, err)
+			}
+
+			changed := q.served([]string{"a.txt"}, tt.err)
+			if got := len(changed) == 1; got != tt.wantChanged {
+				t.Errorf("served changed %+v, want a change: %t", changed, tt.wantChanged)
+			}
+			assertItem(t, q.snapshot()[0], tt.want, tt.wantAttempts)
+		})
+	}
+}
+
+func TestQueueWithdraw(t *testing.T) {
+	q := newQueue()
+	q.add("listed.txt")
+	q.add("sent.txt")
+
+	// a session in which the receiver only listed the path doesn't count as
+	// an attempt
+	q.take(time.Now())
+	changed := q.withdraw([]string{"listed.txt"})
+	if len(changed) != 1 {
+		t.Fatalf("withdraw changed %d items, want 1", len(changed))
+	}
+	assertItem(t, changed[0], ItemPending, 0)
+	if item, ok := q.take(time.Now()); !ok || item.Path != "listed.txt" {
+		t.Errorf("take = %q, %t, want the withdrawn path again", item.Path, ok)
+	}
+
+	// paths no longer being sent are left alone
+	q.take(time.Now())
+	q.end("sent.txt", nil, retry.Policy{}, time.Now())
+	if changed := q.withdraw([]string{"sent.txt"}); len(changed) != 0 {
+		t.Errorf("withdraw changed %+v", changed)
+	}
+	for _, item := range q.snapshot() {
+		if item.Path == "sent.txt" {
+			assertItem(t, item, ItemDone, 1)
+		}
+	}
+}
+
+func TestQueueWakesEveryWorker(t *testing.T) {
+	q := newQueue()
+	q.add("a.txt")
+	workers := []<-chan struct{}{q.woken(), q.woken()}
+
+	// queuing a path already pending wakes nobody
+	q.add("a.txt")
+	for i, wake := range workers {
+		select {
+		case <-wake:
+			t.Errorf("worker %d was woken", i)
+		default:
+		}
+	}
+
+	q.add("b.txt")
+	for i, wake := range workers {
+		select {
+		case <-wake:
+		default:
+			t.Errorf("worker %d wasn't woken", i)
+		}
+	}
+}
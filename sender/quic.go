@@ -0,0 +1,63 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/internal/quicconn"
+	"github.com/pjmessi/go_file_share/retry"
+)
+
+// quicStreams is how many entries are in flight at once over QUIC
+const quicStreams = 4
+
+// sendStreams sends every entry on a stream of its own, opened in entry
+// order, with up to quicStreams at once, and returns the number of content
+// bytes sent, reporting progress as part of batch and sharing limits among
+// the streams. Once a file fails or is declined no further streams are
+// opened; the ones in flight are finished and the first error is returned.
+func (s *Sender) sendStreams(ctx context.Context, con *quicconn.Conn, entries []entry, fileFlags uint8, batch *progress.Batch, limits limits, retries *retry.Policy) (uint64, error) {
+	var mu sync.Mutex
+	var totalBytesSent uint64
+	var failure error
+
+	var inFlight sync.WaitGroup
+	slots := make(chan struct{}, quicStreams)
+	for _, entry := range entries {
+		slots <- struct{}{}
+		mu.Lock()
+		failed := failure != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+
+		stream, err := con.OpenStream(ctx)
+		if err != nil {
+			mu.Lock()
+			failure = fmt.Errorf("err opening stream for %s: %s", entry.name, err)
+			mu.Unlock()
+			break
+		}
+
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			defer func() { <-slots }()
+			defer stream.Close()
+
+			bytesSent, err := s.sendListedEntry(ctx, limits.throttle(stream), entry, fileFlags, batch, retries)
+			mu.Lock()
+			defer mu.Unlock()
+			totalBytesSent += bytesSent
+			if err != nil && failure == nil {
+				failure = err
+			}
+		}()
+	}
+	inFlight.Wait()
+
+	return totalBytesSent, failure
+}
@@ -0,0 +1,93 @@
+package sender
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/ratelimit"
+)
+
+// WithRateLimit caps how fast content is sent to every receiver, in bytes
+// per second, e.g. to leave room on a WAN link shared with others. The cap
+// counts the bytes on the wire, parallel connections and datagrams included,
+// which compression makes fewer than the file has. Under a cap, ReadSendfile
+// reads content buffered. Zero, the default, means unlimited.
+func WithRateLimit(bytesPerSec int64) Option {
+	return func(s *Sender) {
+		s.rateLimit = bytesPerSec
+	}
+}
+
+// WithTotalRateLimit caps how fast content is sent to all receivers served
+// at once together, in bytes per second, on top of the cap set with
+// WithRateLimit for each of them. HTTP downloads count towards both. Zero,
+// the default, means unlimited.
+func WithTotalRateLimit(bytesPerSec int64) Option {
+	return func(s *Sender) {
+		s.totalLimiter = ratelimit.New(bytesPerSec)
+	}
+}
+
+// limits are the rate limits a transfer is subject to: its own and the one
+// shared with every other transfer. The zero limits limit nothing.
+type limits struct {
+	own, shared *ratelimit.Limiter
+}
+
+// newLimits returns the limits of a new transfer.
+func (s *Sender) newLimits() limits {
+	return limits{own: ratelimit.New(s.rateLimit), shared: s.totalLimiter}
+}
+
+// wait blocks until n more bytes fit both limits.
+func (l limits) wait(n int) {
+	l.own.Wait(n)
+	l.shared.Wait(n)
+}
+
+// delay returns how long wait would block for n more bytes right now.
+func (l limits) delay(n int) time.Duration {
+	return max(l.own.Delay(n), l.shared.Delay(n))
+}
+
+// writer returns a writer whose writes to w are limited, or w itself if
+// nothing is.
+func (l limits) writer(w io.Writer) io.Writer {
+	return ratelimit.NewWriter(ratelimit.NewWriter(w, l.own), l.shared)
+}
+
+// reader returns a reader whose reads from r are limited, or r itself if
+// nothing is.
+func (l limits) reader(r io.Reader) io.Reader {
+	return ratelimit.NewReader(ratelimit.NewReader(r, l.own), l.shared)
+}
+
+// throttle returns con with its writes limited, or con itself if nothing
+// is. The ReadFrom of con is hidden, so content isn't sent with sendfile.
+func (l limits) throttle(con net.Conn) net.Conn {
+	if l.own == nil && l.shared == nil {
+		return con
+	}
+	return &throttledConn{Conn: con, w: l.writer(con), limits: l}
+}
+
+// throttledConn is a connection whose writes are limited.
+type throttledConn struct {
+	net.Conn
+	w      io.Writer
+	limits limits
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+// limitsOf returns the limits con was throttled with, for the connections
+// and datagrams content is sent over alongside it.
+func limitsOf(con net.Conn) limits {
+	if c, ok := con.(*throttledConn); ok {
+		return c.limits
+	}
+	return limits{}
+}
@@ -0,0 +1,173 @@
+package sender
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/chunk"
+	"github.com/pjmessi/go_file_share/internal/progress"
+)
+
+// ReadMode selects how file content gets from the disk to the connection.
+type ReadMode int
+
+const (
+	// ReadBuffered reads the content into a buffer the size of a chunk and
+	// writes it from there, costing a copy into the buffer and one out of it
+	// for every byte. This is the default.
+	ReadBuffered ReadMode = iota
+	// ReadSendfile hands the content to the kernel, which on Linux sends it
+	// from the page cache with sendfile(2) without it passing through the
+	// sender; it is read once more for its checksum. It only applies to
+	// plain TCP connections, content that is compressed or encrypted is
+	// read buffered.
+	ReadSendfile
+	// ReadMmap maps the file into memory and writes the content from there,
+	// saving the copy into the buffer for any connection. Where files can't
+	// be mapped, e.g. on Windows, content is read buffered. A file truncated
+	// while it is mapped fails its transfer.
+	ReadMmap
+)
+
+var readModeNames = map[ReadMode]string{
+	ReadBuffered: "buffered",
+	ReadSendfile: "sendfile",
+	ReadMmap:     "mmap",
+}
+
+func (m ReadMode) String() string {
+	if name, ok := readModeNames[m]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("ReadMode(%d)", int(m))
+}
+
+// ParseReadMode maps "buffered", "sendfile" or "mmap" to the corresponding
+// read mode.
+func ParseReadMode(name string) (ReadMode, error) {
+	for mode, modeName := range readModeNames {
+		if modeName == name {
+			return mode, nil
+		}
+	}
+
+	return ReadBuffered, fmt.Errorf("unknown read mode: %s", name)
+}
+
+// WithReadMode reads file content as mode says, to spend less CPU per byte
+// on large files. Blocks and datagrams are always read buffered. The default
+// is ReadBuffered.
+func WithReadMode(mode ReadMode) Option {
+	return func(s *Sender) {
+		s.readMode = mode
+	}
+}
+
+// sendFileRange sends file from start to end to w the way WithReadMode says,
+// in chunks sized by sizer, feeding them into digest, unless it is nil, and
+// reporting them to tracker, and returns the number of bytes sent. Under
+// ReadSendfile it moves the file's position, so a file must not be shared by
+// concurrent calls then.
+func (s *Sender) sendFileRange(w io.Writer, file *os.File, start, end uint64, digest hash.Hash, tracker *progress.Tracker, sizer *chunk.Sizer) (uint64, error) {
+	switch rf, ok := w.(io.ReaderFrom); {
+	case s.readMode == ReadSendfile && ok:
+		return s.sendfile(rf, file, start, end, digest, tracker, sizer)
+	case s.readMode == ReadMmap:
+		return s.sendMapped(w, file, start, end, digest, tracker, sizer)
+	}
+
+	return s.streamContent(w, io.NewSectionReader(file, int64(start), int64(end-start)), digest, tracker, sizer)
+}
+
+// sendfile sends the range through the ReadFrom of w, which TCP connections
+// implement with sendfile(2) on the platforms that have it and with a copy on
+// the others. The chunks sent are hashed from the file afterwards.
+func (s *Sender) sendfile(w io.ReaderFrom, file *os.File, start, end uint64, digest hash.Hash, tracker *progress.Tracker, sizer *chunk.Sizer) (uint64, error) {
+	if _, err := file.Seek(int64(start), io.SeekStart); err != nil {
+		return 0, fmt.Errorf("err seeking file: %s", err)
+	}
+
+	var buf []byte
+	pos := start
+	for pos < end {
+		// SEND A CHUNK
+		// Only a reader limited to the file itself keeps it eligible for
+		// sendfile.
+		size := min(uint64(sizer.Size()), end-pos)
+		chunkStart := time.Now()
+		bytesSent, err := w.ReadFrom(&io.LimitedReader{R: file, N: int64(size)})
+		if err != nil {
+			return 0, fmt.Errorf("err sending file chunk: %s", err)
+		}
+		if bytesSent == 0 {
+			return 0, fmt.Errorf("err sending file chunk: %s ended at %d of %d bytes", file.Name(), pos, end)
+		}
+		sizer.Record(int(bytesSent), time.Since(chunkStart))
+
+		// HASH THE CHUNK
+		if digest != nil {
+			if len(buf) < sizer.Max() {
+				buf = make([]byte, sizer.Max())
+			}
+			if _, err := io.CopyBuffer(digest, io.NewSectionReader(file, int64(pos), bytesSent), buf); err != nil {
+				return 0, fmt.Errorf("err hashing file chunk: %s", err)
+			}
+		}
+		pos += uint64(bytesSent)
+		tracker.Add(int(bytesSent))
+	}
+
+	return pos - start, nil
+}
+
+// sendMapped writes the range straight from the file mapped into memory,
+// falling back to reading it buffered where the file can't be mapped.
+func (s *Sender) sendMapped(w io.Writer, file *os.File, start, end uint64, digest hash.Hash, tracker *progress.Tracker, sizer *chunk.Sizer) (bytesSent uint64, err error) {
+	if start == end {
+		return 0, nil
+	}
+	data, unmap, err := mapFile(file, end)
+	if err != nil {
+		log.Printf("warning: err mapping %s, reading it buffered: %s", file.Name(), err)
+		return s.streamContent(w, io.NewSectionReader(file, int64(start), int64(end-start)), digest, tracker, sizer)
+	}
+	defer unmap()
+
+	// a file truncated underneath the mapping faults on access, which fails
+	// the transfer instead of the process
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		fault := recover()
+		if fault == nil {
+			return
+		}
+		if _, ok := fault.(interface{ Addr() uintptr }); !ok {
+			panic(fault)
+		}
+		err = fmt.Errorf("err reading mapped %s, it shrank while being sent: %v", file.Name(), fault)
+	}()
+
+	pos := start
+	for pos < end {
+		chunk := data[pos:min(pos+uint64(sizer.Size()), end)]
+		chunkStart := time.Now()
+		if _, err := w.Write(chunk); err != nil {
+			return 0, fmt.Errorf("err sending file chunk: %s", err)
+		}
+		sizer.Record(len(chunk), time.Since(chunkStart))
+
+		if digest != nil {
+			digest.Write(chunk)
+		}
+		pos += uint64(len(chunk))
+		tracker.Add(len(chunk))
+	}
+
+	return pos - start, nil
+}
@@ -0,0 +1,93 @@
+package sender
+
+import (
+	"crypto/sha256"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// cpuTime returns the user and system CPU time the process has used.
+func cpuTime(b *testing.B) time.Duration {
+	b.Helper()
+
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		b.Fatal(err)
+	}
+
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// BenchmarkReadModes sends a 64 MiB file, from the page cache, over a
+// localhost TCP connection in every read mode, without and with a SHA-256
+// digest, and reports the CPU time the process spent per GiB sent. That
+// includes the receiving end, which discards the content the same way in
+// every mode.
+func BenchmarkReadModes(b *testing.B) {
+	const size = 64 << 20
+	path := writeTestFile(b, b.TempDir(), "content", testContent(size))
+
+	for _, mode := range []ReadMode{ReadBuffered, ReadSendfile, ReadMmap} {
+		for _, hashed := range []bool{false, true} {
+			name := mode.String()
+			if hashed {
+				name += "/sha256"
+			}
+			b.Run(name, func(b *testing.B) {
+				s := newTestSender(b, WithReadMode(mode))
+				file, err := os.Open(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer file.Close()
+
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer listener.Close()
+				received := make(chan error, 1)
+				go func() {
+					con, err := listener.Accept()
+					if err != nil {
+						received <- err
+						return
+					}
+					defer con.Close()
+					_, err = io.Copy(io.Discard, con)
+					received <- err
+				}()
+				con, err := net.Dial("tcp", listener.Addr().String())
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				b.SetBytes(size)
+				b.ResetTimer()
+				start := cpuTime(b)
+				for i := 0; i < b.N; i++ {
+					var digest hash.Hash
+					if hashed {
+						digest = sha256.New()
+					}
+					if _, err := s.sendFileRange(con, file, 0, size, digest, nil, s.chunkSizer()); err != nil {
+						b.Fatal(err)
+					}
+				}
+				cpu := float64(cpuTime(b)-start) / float64(time.Millisecond)
+				b.ReportMetric(cpu/float64(b.N)*float64(1<<30)/size, "cpu-ms/GiB")
+				b.StopTimer()
+
+				con.Close()
+				if err := <-received; err != nil {
+					b.Fatal(err)
+				}
+			})
+		}
+	}
+}
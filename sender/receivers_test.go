@@ -0,0 +1,164 @@
+package sender
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stallingConn stops reading after limit bytes until released, like a
+// receiver on a slow link, or, if fail is set, fails instead, like one that
+// went away.
+type stallingConn struct {
+	net.Conn
+	limit    int
+	fail     bool
+	released chan struct{}
+	read     int
+}
+
+func newStallingConn(con net.Conn, limit int, fail bool) *stallingConn {
+	return &stallingConn{Conn: con, limit: limit, fail: fail, released: make(chan struct{})}
+}
+
+func (c *stallingConn) Read(p []byte) (int, error) {
+	if c.read >= c.limit {
+		if c.fail {
+			c.Conn.Close()
+			return 0, errors.New("receiver went away")
+		}
+		<-c.released
+	}
+	if c.read < c.limit {
+		p = p[:min(len(p), c.limit-c.read)]
+	}
+	n, err := c.Conn.Read(p)
+	c.read += n
+	return n, err
+}
+
+// pull is a receiver's transfer from a sender, running in the background.
+type pull struct {
+	dir  string
+	done chan error
+}
+
+// startPull connects a new receiver to the sender on port, through wrap if
+// it is set, and receives in the background.
+func startPull(t *testing.T, port uint16, wrap func(net.Conn) net.Conn) pull {
+	t.Helper()
+
+	r, dir := newTestReceiver(t)
+	p := pull{dir: dir, done: make(chan error, 1)}
+	go func() {
+		_, err := receiveFrom(t, r, port, wrap)
+		p.done <- err
+	}()
+	return p
+}
+
+// wait returns the error the pull ended with, failing the test if it takes
+// longer than within.
+func (p pull) wait(t *testing.T, within time.Duration) error {
+	t.Helper()
+
+	select {
+	case err := <-p.done:
+		return err
+	case <-time.After(within):
+		t.Fatalf("receiver didn't finish within %s", within)
+		return nil
+	}
+}
+
+func TestReceiversAreServedConcurrently(t *testing.T) {
+	content := testContent(4 << 20)
+	path := writeTestFile(t, t.TempDir(), "shared.bin", content)
+	s := newTestSender(t, WithMaxTransfers(2))
+	port, sent := startSend(t, s, path)
+
+	// the first receiver stalls mid-transfer, the second is served all
+	// the same
+	slow := newStallingConn(nil, 64<<10, false)
+	first := startPull(t, port, func(con net.Conn) net.Conn {
+		slow.Conn = con
+		return slow
+	})
+	second := startPull(t, port, nil)
+	if err := second.wait(t, testTimeout); err != nil {
+		t.Fatalf("second receiver: %v", err)
+	}
+	assertFile(t, filepath.Join(second.dir, "shared.bin"), content)
+
+	close(slow.released)
+	if err := first.wait(t, testTimeout); err != nil {
+		t.Fatalf("first receiver: %v", err)
+	}
+	assertFile(t, filepath.Join(first.dir, "shared.bin"), content)
+	if err := <-sent; err != nil {
+		t.Errorf("Send = %v after serving both receivers", err)
+	}
+}
+
+func TestFailingReceiverLeavesOthersAlone(t *testing.T) {
+	content := testContent(4 << 20)
+	path := writeTestFile(t, t.TempDir(), "shared.bin", content)
+	s := newTestSender(t, WithMaxTransfers(1))
+	port, sent := startSend(t, s, path)
+
+	failing := startPull(t, port, func(con net.Conn) net.Conn {
+		return newStallingConn(con, 64<<10, true)
+	})
+	if err := failing.wait(t, testTimeout); err == nil {
+		t.Fatal("the failing receiver succeeded")
+	}
+	working := startPull(t, port, nil)
+	if err := working.wait(t, testTimeout); err != nil {
+		t.Fatalf("working receiver: %v", err)
+	}
+	assertFile(t, filepath.Join(working.dir, "shared.bin"), content)
+	// only the successful transfer counts towards WithMaxTransfers
+	if err := <-sent; err != nil {
+		t.Errorf("Send = %v after serving the working receiver", err)
+	}
+}
+
+func TestMaxReceiversQueuesTheRest(t *testing.T) {
+	content := testContent(1 << 20)
+	path := writeTestFile(t, t.TempDir(), "shared.bin", content)
+	s := newTestSender(t, WithMaxReceivers(1), WithMaxTransfers(2))
+	port, sent := startSend(t, s, path)
+
+	slow := newStallingConn(nil, 64<<10, false)
+	var connected sync.WaitGroup
+	connected.Add(1)
+	first := startPull(t, port, func(con net.Conn) net.Conn {
+		defer connected.Done()
+		slow.Conn = con
+		return slow
+	})
+	connected.Wait()
+	// accepted in any order, the first receiver to start its transfer
+	// takes the only slot, which the stalled one must hold
+	time.Sleep(100 * time.Millisecond)
+	second := startPull(t, port, nil)
+	select {
+	case err := <-second.done:
+		t.Fatalf("second receiver finished with %v while the only slot was taken", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	close(slow.released)
+	for _, p := range []pull{first, second} {
+		if err := p.wait(t, testTimeout); err != nil {
+			t.Fatalf("receiver: %v", err)
+		}
+		assertFile(t, filepath.Join(p.dir, "shared.bin"), content)
+	}
+	if err := <-sent; err != nil {
+		t.Errorf("Send = %v after serving both receivers", err)
+	}
+}
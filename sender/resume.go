@@ -0,0 +1,39 @@
+package sender
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// resumeOffset checks whether the first rep.Offset bytes the receiver already
+// has match the file and returns the offset to continue from, leaving the
+// file positioned there and the prefix hashed into digest. It returns 0 if
+// the receiver has nothing or its data differs from ours.
+func (s *Sender) resumeOffset(file *os.File, rep protocol.Reply, digest hash.Hash) (uint64, error) {
+	if rep.Offset == 0 {
+		return 0, nil
+	}
+
+	_, err := io.CopyN(digest, file, int64(rep.Offset))
+	if err == nil && bytes.Equal(digest.Sum(nil), rep.PrefixHash[:]) {
+		log.Printf("resuming %s at byte %d", file.Name(), rep.Offset)
+		return rep.Offset, nil
+	}
+	if err != nil && err != io.EOF {
+		return 0, fmt.Errorf("err hashing file prefix: %s", err)
+	}
+
+	log.Printf("receiver's partial copy of %s does not match, sending it again", file.Name())
+	digest.Reset()
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("err rewinding file: %s", err)
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,1265 @@
+// Package sender offers files to fileshare receivers on the local network.
+//
+// A Sender is built with New and functional options, and Send announces it
+// and serves every receiver that connects until its context is cancelled:
+//
+//	s, err := sender.New(sender.WithCompression(true))
+//	if err != nil {
+//		return err
+//	}
+//	err = s.Send(ctx, 4100, []string{"report.pdf"})
+//
+// WithProgress reports the progress of every file sent, and a file the
+// receiver couldn't verify or save fails with ErrNotConfirmed. The wire format
+// is described by package protocol.
+package sender
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/chunk"
+	"github.com/pjmessi/go_file_share/internal/events"
+	"github.com/pjmessi/go_file_share/internal/metrics"
+	"github.com/pjmessi/go_file_share/internal/netif"
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/internal/quicconn"
+	"github.com/pjmessi/go_file_share/internal/ratelimit"
+	"github.com/pjmessi/go_file_share/internal/sockopt"
+	"github.com/pjmessi/go_file_share/protocol"
+	"github.com/pjmessi/go_file_share/retry"
+)
+
+// ErrNotConfirmed is returned when the receiver doesn't confirm that it
+// verified and saved a file, because it reported a failure, closed the
+// connection or didn't answer within the timeout set with WithAckTimeout.
+var ErrNotConfirmed = errors.New("file not confirmed by the receiver")
+
+// ErrSourceChanged is returned when a file changed size or modification time
+// while its content was sent, so what the receiver got may be neither the
+// old file nor the new one. The receiver is made to discard it, see
+// WithRestartOnSourceChange.
+var ErrSourceChanged = errors.New("file changed while it was sent")
+
+// ErrNoReceivers is returned by Send when no receiver connected within the
+// time set with WithAnnounceDuration or WithReceiverTimeout.
+var ErrNoReceivers = errors.New("no receivers found")
+
+// defaultAckTimeout is how long the sender waits for a file to be confirmed
+// unless configured otherwise
+const defaultAckTimeout = 30 * time.Second
+
+// errDeclined is returned by sendEntry when the receiver's user declined the
+// file, which ends the transfer without it being an error.
+var errDeclined = errors.New("file declined")
+
+// errNothingPicked is returned by sendFiles when the receiver picked none of
+// the entries it was offered, e.g. only to list them, which neither counts
+// as a transfer nor fails one.
+var errNothingPicked = errors.New("no entries picked")
+
+// errSaveFailed is returned by sendEntry when the receiver reported that
+// saving the file failed, which may be worth sending it again for.
+var errSaveFailed = errors.New("receiver failed to save")
+
+// Sender offers files to fileshare receivers. Build one with New.
+type Sender struct {
+	chunkSize        uint
+	chunkMin         uint
+	chunkMax         uint
+	udpDiscoveryPort uint
+	compress         bool
+	blocks           bool
+	transport        Transport
+	mtu              int
+	tls              bool
+	tlsCertFile      string
+	tlsKeyFile       string
+	sharedKey        string
+	announcers       []Announcer
+	progress         func(ProgressInfo)
+	session          string
+	ackTimeout       time.Duration
+	transferRetry    retry.Policy
+	restartChanged   bool
+	watchGrace       time.Duration
+	queue            *queue
+	queueConcurrency int
+	sentDir          string
+	include          []string
+	exclude          []string
+	filter           filter
+	symlinks         SymlinkPolicy
+	events           Events
+	metricsAddr      string
+	httpAddr         string
+	parallel         int
+	socket           sockopt.Options
+	readMode         ReadMode
+	archive          bool
+	stdinName        string
+	name             string
+	announceFor      time.Duration
+	receiverTimeout  time.Duration
+	waitForever      bool
+	maxTransfers     int
+	serveForever     bool
+	maxReceivers     int
+	rateLimit        int64
+	totalLimiter     *ratelimit.Limiter
+	ifaceName        string
+	iface            netif.Interface
+
+	// stdinOwner is the connection stdin is sent over, once a receiver
+	// connected for it
+	stdinOwner atomic.Pointer[net.Conn]
+
+	// connected is set once a receiver completed the handshake
+	connected atomic.Bool
+
+	// transfers adds up the progress of the receivers served at once
+	transfers progress.Group
+
+	// metrics are always kept, and served if metricsAddr is set
+	metrics *senderMetrics
+
+	// eventQueue delivers the callbacks of events
+	eventQueue events.Queue
+}
+
+// defaultChunkSize is the default size of the reads file content is sent in
+const defaultChunkSize = 1024
+
+// defaultMaxChunkSize is the default bound the chunk size grows to
+const defaultMaxChunkSize = 1 << 20
+
+// Option configures optional Sender behaviour.
+type Option func(*Sender)
+
+// WithChunkSize reads and sends file content in chunks of size bytes. The
+// default is 1 KiB.
+func WithChunkSize(size uint) Option {
+	return func(s *Sender) {
+		s.chunkSize = size
+	}
+}
+
+// WithChunkSizeBounds lets the size file content is read and sent in adapt
+// to the throughput it achieves, from the size set with WithChunkSize to
+// anywhere between min and max bytes. The default bounds are that size and
+// 1 MiB, or that size if it is larger; min equal to max keeps the size fixed.
+func WithChunkSizeBounds(min, max uint) Option {
+	return func(s *Sender) {
+		s.chunkMin, s.chunkMax = min, max
+	}
+}
+
+// WithDiscoveryPort announces the sender on port. The default is
+// protocol.DefaultDiscoveryPort. It only changes the default discovery
+// backend, not those given with WithAnnouncers.
+func WithDiscoveryPort(port uint) Option {
+	return func(s *Sender) {
+		s.udpDiscoveryPort = port
+	}
+}
+
+// WithBlockChecksums sends file content in blocks that each carry a CRC32C,
+// for receivers that support it. The receiver checks every block as it
+// arrives and asks for the corrupt ones again, so corruption on a lossy link
+// costs a block rather than the whole file; the file's SHA-256 still has the
+// final word. It takes precedence over WithCompression.
+func WithBlockChecksums(blocks bool) Option {
+	return func(s *Sender) {
+		s.blocks = blocks
+	}
+}
+
+// WithCompression gzips file content on the wire for receivers that support
+// it. Receivers that don't get the raw bytes.
+func WithCompression(compress bool) Option {
+	return func(s *Sender) {
+		s.compress = compress
+	}
+}
+
+// WithTLS serves transfers over TLS. Without WithCertificate a self-signed
+// certificate is generated on startup and its fingerprint is logged so
+// receivers can pin it.
+func WithTLS(enable bool) Option {
+	return func(s *Sender) {
+		s.tls = enable
+	}
+}
+
+// WithCertificate uses the given PEM certificate and key for TLS instead of a
+// generated one.
+func WithCertificate(certFile, keyFile string) Option {
+	return func(s *Sender) {
+		s.tlsCertFile = certFile
+		s.tlsKeyFile = keyFile
+	}
+}
+
+// WithSharedKey answers the authentication challenge of receivers that
+// require a shared key.
+func WithSharedKey(key string) Option {
+	return func(s *Sender) {
+		s.sharedKey = key
+	}
+}
+
+// WithAnnouncers replaces the discovery backends. By default only a
+// BroadcastAnnouncer on the discovery port is used.
+func WithAnnouncers(announcers ...Announcer) Option {
+	return func(s *Sender) {
+		s.announcers = announcers
+	}
+}
+
+// WithAckTimeout bounds how long the sender waits for the receiver to confirm
+// a file after its content was sent. The default is 30s, zero waits forever.
+func WithAckTimeout(timeout time.Duration) Option {
+	return func(s *Sender) {
+		s.ackTimeout = timeout
+	}
+}
+
+// WithTransferRetry sends an entry again according to policy when the
+// receiver reports that saving it failed, e.g. because its disk was full for
+// a moment, before giving up on the receiver. The file continues from what
+// the receiver kept of it, if it resumes, and starts over otherwise. Stdin
+// can't be sent again, and receivers that don't support it end the transfer
+// at the first failure. The connections of WithParallelStreams ranges are
+// retried by the same policy. The default is retry.Default; the zero Policy
+// tries once.
+func WithTransferRetry(policy retry.Policy) Option {
+	return func(s *Sender) {
+		s.transferRetry = policy
+	}
+}
+
+// WithRestartOnSourceChange sends a file that changed while it was sent
+// again from the start, as WithTransferRetry allows, instead of failing the
+// transfer with ErrSourceChanged, which is the default. Receivers that can't
+// take a file again fail the transfer either way.
+func WithRestartOnSourceChange(restart bool) Option {
+	return func(s *Sender) {
+		s.restartChanged = restart
+	}
+}
+
+// WithMaxTransfers ends Send once n receivers were served: the sender stops
+// announcing itself and accepting receivers, lets the transfers in flight
+// finish and returns nil. Transfers that failed don't count. The default, 0,
+// serves receivers until Send's context is done.
+func WithMaxTransfers(n int) Option {
+	return func(s *Sender) {
+		s.maxTransfers = n
+	}
+}
+
+// WithMaxReceivers serves at most n receivers at once; further receivers
+// are accepted but wait for one of them to finish before their transfer
+// starts. The default, 0, serves every receiver that connects right away.
+func WithMaxReceivers(n int) Option {
+	return func(s *Sender) {
+		s.maxReceivers = n
+	}
+}
+
+// WithServeForever serves receivers, and announces the sender to them, until
+// Send's context is done, like a file server left up for an afternoon. It is
+// WithWaitForever for senders that mustn't be limited by WithMaxTransfers.
+func WithServeForever(serve bool) Option {
+	return func(s *Sender) {
+		s.serveForever = serve
+	}
+}
+
+// ProgressInfo describes the transfer of a single file.
+type ProgressInfo = progress.Info
+
+// WithProgress calls fn with the progress of every file being sent, at most
+// every 100ms and once more with Done set when its transfer ends. fn runs on
+// a separate goroutine; reports arriving while it is busy are dropped. With
+// several receivers connected, fn is called for each of their transfers.
+func WithProgress(fn func(ProgressInfo)) Option {
+	return func(s *Sender) {
+		s.progress = fn
+	}
+}
+
+// New returns a Sender configured by opts. It fails if the options
+// contradict each other or are out of range.
+func New(opts ...Option) (*Sender, error) {
+	s := newSender(opts)
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// NewSender returns a Sender that sends content in chunks of chunkSize bytes
+// and announces itself on udpDiscoveryPort.
+//
+// Deprecated: use New with WithChunkSize and WithDiscoveryPort, which also
+// validates the options.
+func NewSender(chunkSize, udpDiscoveryPort uint, opts ...Option) *Sender {
+	return newSender(append([]Option{WithChunkSize(chunkSize), WithDiscoveryPort(udpDiscoveryPort)}, opts...))
+}
+
+func newSender(opts []Option) *Sender {
+	s := &Sender{
+		chunkSize:        defaultChunkSize,
+		udpDiscoveryPort: protocol.DefaultDiscoveryPort,
+		session:          newSessionID(),
+		ackTimeout:       defaultAckTimeout,
+		transferRetry:    retry.Default,
+		watchGrace:       defaultWatchGrace,
+		queue:            newQueue(),
+		queueConcurrency: 1,
+		mtu:              protocol.DefaultMTU,
+		parallel:         1,
+		stdinName:        defaultStdinName,
+		metrics:          newSenderMetrics(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// the default backend depends on the configured port
+	if s.announcers == nil {
+		s.announcers = []Announcer{BroadcastAnnouncer{Port: s.udpDiscoveryPort}}
+	}
+
+	return s
+}
+
+// validate reports the first contradictory or out of range option.
+func (s *Sender) validate() error {
+	switch {
+	case s.chunkSize == 0:
+		return errors.New("chunk size must be positive")
+	case s.chunkMax != 0 && s.chunkMin == 0:
+		return errors.New("chunk size bounds must be positive")
+	case s.chunkMin > s.chunkMax:
+		return fmt.Errorf("chunk size bounds are reversed: %d > %d", s.chunkMin, s.chunkMax)
+	case s.udpDiscoveryPort == 0 || s.udpDiscoveryPort > 65535:
+		return fmt.Errorf("invalid discovery port: %d", s.udpDiscoveryPort)
+	case s.ackTimeout < 0:
+		return errors.New("ack timeout can't be negative")
+	case len(s.announcers) == 0:
+		return errors.New("no discovery backend configured")
+	case (s.tlsCertFile == "") != (s.tlsKeyFile == ""):
+		return errors.New("tls certificate and key must be given together")
+	case s.transport != TransportTCP && s.transport != TransportUDP && s.transport != TransportQUIC:
+		return fmt.Errorf("unknown transport: %s", s.transport)
+	case s.mtu < protocol.MinMTU || s.mtu > protocol.MaxMTU:
+		return fmt.Errorf("mtu must be between %d and %d: %d", protocol.MinMTU, protocol.MaxMTU, s.mtu)
+	case s.parallel < 1 || s.parallel > protocol.MaxParallel:
+		return fmt.Errorf("parallel streams must be between 1 and %d: %d", protocol.MaxParallel, s.parallel)
+	case s.parallel > 1 && s.transport != TransportTCP:
+		return fmt.Errorf("parallel streams can't be combined with the %s transport", s.transport)
+	case s.readMode < ReadBuffered || s.readMode > ReadMmap:
+		return fmt.Errorf("unknown read mode: %s", s.readMode)
+	case s.symlinks < SkipSymlinks || s.symlinks > PreserveSymlinks:
+		return fmt.Errorf("unknown symlink policy: %s", s.symlinks)
+	case s.announceFor < 0:
+		return errors.New("announce duration can't be negative")
+	case s.receiverTimeout < 0:
+		return errors.New("receiver timeout can't be negative")
+	case s.announceFor > 0 && s.waitForever:
+		return errors.New("an announce duration can't be combined with waiting forever")
+	case s.maxTransfers < 0:
+		return errors.New("max transfers can't be negative")
+	case s.maxReceivers < 0:
+		return errors.New("max receivers can't be negative")
+	case s.rateLimit < 0:
+		return errors.New("rate limit can't be negative")
+	case s.serveForever && s.maxTransfers > 0:
+		return errors.New("serving forever can't be combined with max transfers")
+	case s.serveForever && s.announceFor > 0:
+		return errors.New("serving forever can't be combined with an announce duration")
+	case len(s.name) > protocol.MaxSenderName:
+		return fmt.Errorf("sender name is longer than %d bytes", protocol.MaxSenderName)
+	case s.queueConcurrency < 1:
+		return errors.New("queue concurrency must be positive")
+	case s.watchGrace < 0:
+		return errors.New("watch grace period can't be negative")
+	case s.stdinName == "":
+		return errors.New("stdin name can't be empty")
+	case s.socket.ReadBuffer < 0 || s.socket.WriteBuffer < 0:
+		return fmt.Errorf("socket buffer sizes can't be negative: %d, %d", s.socket.ReadBuffer, s.socket.WriteBuffer)
+	}
+
+	filter, err := newFilter(s.include, s.exclude)
+	if err != nil {
+		return err
+	}
+	s.filter = filter
+	if err := s.lookupInterface(); err != nil {
+		return err
+	}
+
+	return s.transferRetry.Validate()
+}
+
+// Send announces the sender on the LAN, listening on port, or on a free port
+// the system picks if port is 0, and sends filePaths, recursing into
+// directories, to every receiver that connects, all in one transfer. The
+// paths are checked before anything is announced, and collected afresh for
+// every receiver. If no paths are given, the user is prompted for one each
+// time a receiver connects. It serves receivers until
+// ctx is done, then closes the connections still open and returns ctx.Err()
+// once their transfers stopped. Sending stdin, see StdinPath, ends with the
+// transfer it went to instead, returning its error, and no receiver
+// connecting within WithAnnounceDuration or WithReceiverTimeout returns
+// ErrNoReceivers. Once as many receivers as WithMaxTransfers says were
+// served, it returns nil.
+func (s *Sender) Send(ctx context.Context, port uint16, filePaths []string) error {
+	// senders from the deprecated constructor haven't been validated yet
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	return s.send(ctx, port, filePaths, false)
+}
+
+// send runs Send. A path from the queue is announced under a session of
+// its own until a receiver connects, which is the only one it goes to.
+func (s *Sender) send(ctx context.Context, port uint16, filePaths []string, fromQueue bool) error {
+	if s.httpAddr != "" && len(filePaths) == 0 {
+		return errors.New("http downloads need the files to send to be given up front")
+	}
+	var entries []entry
+	var outcomes summary
+	if len(filePaths) > 0 {
+		// receivers that can't take archives get the directories entry by
+		// entry, which the totals announced don't tell apart
+		var err error
+		if entries, outcomes.filtered, err = s.collect(filePaths, s.archive); err != nil {
+			return fmt.Errorf("err collecting files: %s", err)
+		}
+		if outcomes.filtered > 0 {
+			log.Printf("left out %d entries matching the exclude patterns or not the include ones", outcomes.filtered)
+		}
+	}
+	// sending stdin or a path from the queue, or giving up on receivers ends
+	// Send early
+	parent := ctx
+	ctx, finish := context.WithCancelCause(ctx)
+	defer finish(nil)
+
+	// the queue tracks the state of its own paths
+	offered := !fromQueue && len(filePaths) > 0
+	if offered {
+		s.reportItems(parent, s.queue.offer(filePaths))
+		defer func() { s.reportItems(parent, s.queue.withdraw(filePaths)) }()
+	}
+
+	if s.metricsAddr != "" {
+		err := metrics.Serve(ctx, s.metricsAddr, &s.metrics.registry, func(err error) {
+			log.Printf("err serving metrics: %s", err)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// CREATE A LISTENER
+	// port 0 leaves the pick to the system, the port picked is announced
+	listener, err := s.listen(port)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	stopListening := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stopListening()
+	port = listenerPort(listener, port)
+	log.Printf("listening on port: %d", port)
+
+	announceCtx, stopAnnouncing := s.announceContext(ctx)
+	if fromQueue {
+		announceCtx, stopAnnouncing = context.WithCancel(ctx)
+	}
+	defer stopAnnouncing()
+
+	// SERVE HTTP DOWNLOADS
+	announcement := s.announcement(port, entries)
+	if fromQueue {
+		// receivers in daemon mode connect once per session
+		announcement.Session = newSessionID()
+	}
+	if s.httpAddr != "" {
+		httpPort, err := s.serveHTTP(ctx, filePaths)
+		if err != nil {
+			return err
+		}
+		announcement.HTTPPort = httpPort
+	}
+
+	// ANNOUNCE OURSELVES
+	for _, announcer := range s.announcers {
+		go func() {
+			if err := s.onInterface(announcer).Announce(announceCtx, announcement); err != nil {
+				log.Printf("err announcing sender: %s", err)
+			}
+		}()
+	}
+
+	// GIVE UP WITHOUT RECEIVERS
+	if wait := s.receiverWait(); wait > 0 && !fromQueue {
+		giveUp := time.AfterFunc(wait, func() {
+			if !s.connected.Load() {
+				finish(fmt.Errorf("%w within %s", ErrNoReceivers, wait))
+			}
+		})
+		defer giveUp.Stop()
+	}
+
+	// LISTEN FOR CLIENTS IN A LOOP
+	// the summary is logged once the last transfer ended, the queue logs
+	// its own paths
+	if offered {
+		defer s.queue.log()
+	}
+	if !fromQueue {
+		defer outcomes.log()
+	}
+	var transfers sync.WaitGroup
+	defer transfers.Wait()
+	var served atomic.Int64
+	var slots chan struct{}
+	if s.maxReceivers > 0 {
+		slots = make(chan struct{}, s.maxReceivers)
+	}
+	servedAll := func() bool {
+		return s.maxTransfers > 0 && served.Load() >= int64(s.maxTransfers)
+	}
+	taken := false
+	for {
+		con, err := listener.Accept()
+		if err != nil {
+			if taken {
+				// a path from the queue ends with its transfer
+				transfers.Wait()
+				return sendResult(ctx)
+			}
+			if servedAll() {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return sendResult(ctx)
+			}
+			return fmt.Errorf("err accepting connection: %s", err)
+		}
+		if err := s.socket.Apply(con); err != nil {
+			log.Printf("warning: err tuning connection from %s: %s", con.RemoteAddr(), err)
+		}
+		if fromQueue {
+			taken = true
+			stopAnnouncing()
+			listener.Close()
+		}
+
+		transfers.Add(1)
+		go func() {
+			defer transfers.Done()
+			stopClosing := context.AfterFunc(ctx, func() { con.Close() })
+			defer stopClosing()
+
+			// WAIT FOR A FREE SLOT
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+				default:
+					log.Printf("%s waits its turn, %d receivers are served at once", con.RemoteAddr(), s.maxReceivers)
+					select {
+					case slots <- struct{}{}:
+					case <-ctx.Done():
+						return
+					}
+				}
+				defer func() { <-slots }()
+			}
+
+			bytesSent, err := s.serveConn(ctx, con, filePaths)
+			if fromQueue || s.sentStdin(con) {
+				finish(&lastTransfer{err: err})
+			}
+			switch {
+			case errors.Is(err, protocol.ErrBadMagic):
+				log.Printf("warning: dropped stray connection from %s: %s", con.RemoteAddr(), err)
+			case errors.Is(err, errNothingPicked):
+				// listing the entries is no transfer
+				return
+			default:
+				outcomes.add(con.RemoteAddr().String(), bytesSent, err)
+				if offered {
+					s.reportItems(parent, s.queue.served(filePaths, err))
+				}
+			}
+			if err != nil && ctx.Err() == nil && !errors.Is(err, protocol.ErrBadMagic) {
+				log.Printf("err sending files to %s: %s", con.RemoteAddr(), err)
+			}
+
+			// the receivers still connected get their transfers finished
+			if err == nil && served.Add(1) == int64(s.maxTransfers) {
+				log.Printf("served %d receivers, no longer accepting new ones", s.maxTransfers)
+				stopAnnouncing()
+				listener.Close()
+			}
+		}()
+	}
+}
+
+// listenerPort returns the port listener listens on, or port if its address
+// doesn't tell.
+func listenerPort(listener net.Listener, port uint16) uint16 {
+	switch addr := listener.Addr().(type) {
+	case *net.TCPAddr:
+		return uint16(addr.Port)
+	case *net.UDPAddr:
+		return uint16(addr.Port)
+	}
+	return port
+}
+
+// listen listens on port for receivers, over TLS or QUIC if configured.
+func (s *Sender) listen(port uint16) (net.Listener, error) {
+	addr := ":" + strconv.Itoa(int(port))
+	if !s.tls && s.transport != TransportQUIC {
+		listener, err := s.listenOn(addr, s.listenTCP)
+		if err != nil {
+			return nil, fmt.Errorf("err starting listener: %s", err)
+		}
+		return listener, nil
+	}
+
+	tlsConfig, fingerprint, err := s.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("err setting up tls: %s", err)
+	}
+	log.Printf("tls certificate fingerprint: %s", fingerprint)
+
+	if s.transport == TransportQUIC {
+		listener, err := s.listenOn(addr, func(addr string) (net.Listener, error) {
+			return quicconn.Listen(addr, tlsConfig)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("err starting quic listener: %s", err)
+		}
+		return listener, nil
+	}
+
+	listener, err := s.listenOn(addr, s.listenTCP)
+	if err != nil {
+		return nil, fmt.Errorf("err starting listener: %s", err)
+	}
+
+	return tls.NewListener(listener, tlsConfig), nil
+}
+
+// Handle runs Send on portStr until the process exits.
+//
+// Deprecated: use Send, which takes a context to stop it with.
+func (s *Sender) Handle(portStr string, filePaths []string) error {
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", portStr)
+	}
+
+	return s.Send(context.Background(), uint16(port), filePaths)
+}
+
+// handshake checks the receiver's preamble and answers with ours. The answer
+// is sent even to an incompatible receiver so it can report the versions
+// involved rather than a dropped connection.
+func (s *Sender) handshake(con net.Conn) error {
+	if err := con.SetReadDeadline(time.Now().Add(protocol.PreambleTimeout)); err != nil {
+		return fmt.Errorf("err setting preamble deadline: %s", err)
+	}
+
+	receiverVersion, err := protocol.ReadPreamble(con)
+	if err != nil {
+		return fmt.Errorf("err reading preamble: %w", err)
+	}
+
+	if err := con.SetReadDeadline(time.Time{}); err != nil {
+		return fmt.Errorf("err clearing preamble deadline: %s", err)
+	}
+
+	if err := protocol.WritePreamble(con); err != nil {
+		return fmt.Errorf("err sending preamble: %s", err)
+	}
+
+	if !protocol.Supports(receiverVersion) {
+		return fmt.Errorf("%w: receiver speaks protocol v%d, this sender supports %s",
+			protocol.ErrIncompatibleVersion, receiverVersion, protocol.SupportedVersions())
+	}
+
+	return nil
+}
+
+// ServeConn sends filePaths to the receiver at the other end of con, the way
+// Send does for every receiver that connects, and closes con. It lets the
+// transfer run over any connection, e.g. one end of a net.Pipe whose other
+// end is passed to the receiver's ReceiveConn.
+func (s *Sender) ServeConn(con net.Conn, filePaths []string) error {
+	_, err := s.serveConn(context.Background(), con, filePaths)
+	if errors.Is(err, errNothingPicked) {
+		return nil
+	}
+	return err
+}
+
+// serveConn runs sendFiles and reports its error to the Error callback.
+func (s *Sender) serveConn(ctx context.Context, con net.Conn, filePaths []string) (uint64, error) {
+	bytesSent, err := s.sendFiles(ctx, con, filePaths)
+	if err != nil && ctx.Err() == nil && !errors.Is(err, protocol.ErrBadMagic) && !errors.Is(err, errNothingPicked) {
+		events.Emit(&s.eventQueue, ctx, s.events.Error, err)
+	}
+
+	return bytesSent, err
+}
+
+// sendFiles sends filePaths to the receiver at the other end of con, closes
+// con and returns the number of content bytes sent.
+func (s *Sender) sendFiles(ctx context.Context, con net.Conn, filePaths []string) (uint64, error) {
+	defer con.Close()
+
+	// EXCHANGE PROTOCOL VERSIONS
+	// Nothing is prompted for or written before the connection has proven to
+	// be a fileshare receiver; the port is announced to the whole LAN.
+	if err := s.handshake(con); err != nil {
+		return 0, fmt.Errorf("err during handshake: %w", err)
+	}
+	log.Printf("connected to receiver: %s", con.RemoteAddr())
+	s.connected.Store(true)
+	s.metrics.connections.Inc()
+	events.Emit(&s.eventQueue, ctx, s.events.ReceiverConnected, con.RemoteAddr().String())
+
+	// REQUEST FILE PATH
+	if len(filePaths) == 0 {
+		filePaths = []string{s.requestFilePath()}
+	}
+
+	// RECEIVE CAPABILITIES
+	var receiverCaps uint32
+	if err := binary.Read(con, binary.LittleEndian, &receiverCaps); err != nil {
+		return 0, fmt.Errorf("err receiving capabilities: %s", err)
+	}
+
+	// AUTHENTICATE
+	if receiverCaps&protocol.CapAuthRequired != 0 {
+		if err := s.answerChallenge(con); err != nil {
+			return 0, fmt.Errorf("err authenticating: %s", err)
+		}
+	}
+
+	var fileFlags uint8
+	_, encrypted := con.(*tls.Conn)
+	switch {
+	case s.transport != TransportUDP:
+	case encrypted:
+		log.Printf("datagrams would go unencrypted, sending content to %s over tls", con.RemoteAddr())
+	case receiverCaps&protocol.CapUDP == 0:
+		log.Printf("%s does not support datagrams, sending content over tcp", con.RemoteAddr())
+	default:
+		fileFlags |= protocol.FlagUDP
+	}
+	switch {
+	case s.parallel == 1:
+	case encrypted:
+		log.Printf("parallel connections would go unencrypted, sending content to %s over tls", con.RemoteAddr())
+	case receiverCaps&protocol.CapParallel == 0:
+		log.Printf("%s does not support parallel connections, sending content over one", con.RemoteAddr())
+	default:
+		fileFlags |= protocol.FlagParallel
+	}
+	if s.blocks && fileFlags&(protocol.FlagUDP|protocol.FlagParallel) != 0 {
+		log.Printf("datagrams and parallel connections can't be combined with block checksums, sending them without")
+	} else if s.blocks && receiverCaps&protocol.CapBlocks != 0 {
+		fileFlags |= protocol.FlagBlocks
+	} else if s.blocks {
+		log.Printf("%s does not support block checksums, sending a plain stream", con.RemoteAddr())
+	}
+	if s.compress && fileFlags&(protocol.FlagBlocks|protocol.FlagUDP|protocol.FlagParallel) != 0 {
+		log.Printf("block checksums, datagrams and parallel connections can't be combined with compression, sending uncompressed")
+	} else if s.compress && receiverCaps&protocol.CapCompression != 0 {
+		fileFlags |= protocol.FlagCompressed
+	} else if s.compress {
+		log.Printf("%s does not support compression, sending uncompressed", con.RemoteAddr())
+	}
+
+	// COLLECT THE ENTRIES
+	archive := s.archive && receiverCaps&protocol.CapStreaming != 0
+	if s.archive && !archive {
+		log.Printf("%s does not support archives, sending directories entry by entry", con.RemoteAddr())
+	}
+	entries, _, err := s.collect(filePaths, archive)
+	if err != nil {
+		return 0, fmt.Errorf("err collecting files: %s", err)
+	}
+	if receiverCaps&protocol.CapSymlinks == 0 && slices.ContainsFunc(entries, func(e entry) bool { return e.symlink }) {
+		log.Printf("%s does not support symlinks, sending directories without them", con.RemoteAddr())
+		entries = slices.DeleteFunc(entries, func(e entry) bool { return e.symlink })
+	}
+
+	// OFFER THE ENTRIES
+	// a receiver that picks from them only gets those it selected
+	if receiverCaps&protocol.CapManifest != 0 {
+		if entries, err = s.offerEntries(con, entries); err != nil {
+			return 0, err
+		}
+		if len(entries) == 0 {
+			if err := binary.Write(con, binary.LittleEndian, uint32(0)); err != nil {
+				return 0, fmt.Errorf("err sending entry count: %s", err)
+			}
+			return 0, errNothingPicked
+		}
+	}
+	if slices.ContainsFunc(entries, func(e entry) bool { return e.stdin }) {
+		if receiverCaps&protocol.CapStreaming == 0 {
+			return 0, fmt.Errorf("%s does not support content of unknown size, such as stdin", con.RemoteAddr())
+		}
+		if err := s.claimStdin(con); err != nil {
+			return 0, err
+		}
+	}
+	batch := batchOf(entries, &s.transfers, con.RemoteAddr().String())
+	defer batch.End()
+
+	// SEND ENTRY COUNT
+	if err := binary.Write(con, binary.LittleEndian, uint32(len(entries))); err != nil {
+		return 0, fmt.Errorf("err sending entry count: %s", err)
+	}
+
+	// a receiver that can take an entry again waits to hear whether it
+	// does after every failure
+	var retries *retry.Policy
+	if receiverCaps&protocol.CapRetry != 0 {
+		retries = &s.transferRetry
+	}
+
+	var totalBytesSent uint64
+	limits := s.newLimits()
+	if streams, ok := con.(*quicconn.Conn); ok {
+		totalBytesSent, err = s.sendStreams(ctx, streams, entries, fileFlags, batch, limits, retries)
+	} else {
+		totalBytesSent, err = s.sendEntries(ctx, limits.throttle(con), entries, fileFlags, batch, retries)
+	}
+	if errors.Is(err, errDeclined) {
+		return totalBytesSent, nil
+	}
+	if err != nil {
+		return totalBytesSent, err
+	}
+
+	log.Printf("sent %d entries, %d bytes in total to %s", len(entries), totalBytesSent, con.RemoteAddr())
+
+	return totalBytesSent, nil
+}
+
+// sendEntries sends entries one after the other over con and returns the
+// number of content bytes sent, reporting progress as part of batch. A
+// declined file ends the transfer with errDeclined.
+func (s *Sender) sendEntries(ctx context.Context, con net.Conn, entries []entry, fileFlags uint8, batch *progress.Batch, retries *retry.Policy) (uint64, error) {
+	totalBytesSent := uint64(0)
+	for _, entry := range entries {
+		bytesSent, err := s.sendListedEntry(ctx, con, entry, fileFlags, batch, retries)
+		if err != nil {
+			return totalBytesSent, err
+		}
+
+		totalBytesSent += bytesSent
+	}
+
+	return totalBytesSent, nil
+}
+
+// sendListedEntry is sendEntry for one of the entries of a transfer, logging
+// a declined file and counting and describing a failed one. An entry the
+// receiver failed to save is sent again as retries allows; nil retries means
+// the receiver can't take it again.
+func (s *Sender) sendListedEntry(ctx context.Context, con net.Conn, entry entry, fileFlags uint8, batch *progress.Batch, retries *retry.Policy) (uint64, error) {
+	for failed := 0; ; {
+		bytesSent, err := s.sendEntry(ctx, con, entry, fileFlags, batch)
+		if errors.Is(err, errDeclined) {
+			log.Printf("%s declined %s, ending transfer", con.RemoteAddr(), entry.name)
+			return 0, err
+		}
+		if err == nil {
+			return bytesSent, nil
+		}
+		s.metrics.failed.Inc()
+		err = fmt.Errorf("err sending %s: %w", entry.localPath, err)
+		if retries == nil || !errors.Is(err, errSaveFailed) {
+			return 0, err
+		}
+
+		// TELL THE RECEIVER WHETHER THE ENTRY COMES AGAIN
+		failed++
+		giveUp := entry.stdin || errors.Is(err, ErrSourceChanged) && !s.restartChanged
+		if failed >= max(retries.MaxAttempts, 1) || giveUp {
+			if decisionErr := binary.Write(con, binary.LittleEndian, protocol.RetryGiveUp); decisionErr != nil {
+				log.Printf("warning: err ending retries of %s: %s", entry.name, decisionErr)
+			}
+			if failed > 1 {
+				return 0, fmt.Errorf("giving up after %d attempts: %w", failed, err)
+			}
+			return 0, err
+		}
+		log.Printf("warning: %s, sending it to %s again", err, con.RemoteAddr())
+		timer := time.NewTimer(retries.Delay(failed))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, err
+		case <-timer.C:
+		}
+		if err := binary.Write(con, binary.LittleEndian, protocol.RetryEntry); err != nil {
+			return 0, fmt.Errorf("err retrying %s: %s", entry.name, err)
+		}
+	}
+}
+
+// sendEntry sends a single entry frame and returns the number of content bytes
+// that were sent, reporting progress as part of batch. Directories only
+// consist of their type and name, symlinks of their target besides.
+func (s *Sender) sendEntry(ctx context.Context, con net.Conn, entry entry, fileFlags uint8, batch *progress.Batch) (uint64, error) {
+	// SEND ENTRY FRAME
+	if err := protocol.WriteFrame(con, protocol.Frame{Type: entry.frameType(), Name: entry.name}); err != nil {
+		return 0, fmt.Errorf("err sending entry frame: %s", err)
+	}
+
+	if entry.isDir {
+		return 0, nil
+	}
+	if entry.symlink {
+		if err := protocol.WriteLink(con, protocol.Link{Flags: entry.linkFlags, Target: entry.target}); err != nil {
+			return 0, fmt.Errorf("err sending link target: %s", err)
+		}
+		return 0, nil
+	}
+	if entry.archive {
+		return s.sendArchive(ctx, con, entry, batch)
+	}
+	if entry.stdin {
+		return s.sendStdin(ctx, con, entry, batch)
+	}
+
+	// LOAD THE FILE
+	file, err := os.Open(entry.localPath)
+	if err != nil {
+		return 0, fmt.Errorf("err opening file: %s", err)
+	}
+	defer file.Close()
+
+	// SEND FILE HEADER
+	header, err := fileHeader(file, fileFlags)
+	if err != nil {
+		return 0, err
+	}
+	if err := protocol.WriteHeader(con, header); err != nil {
+		return 0, fmt.Errorf("err sending file header: %s", err)
+	}
+	contentSize := header.Size
+
+	// WAIT FOR THE RECEIVER'S REPLY
+	rep, err := s.awaitReply(con, entry.name)
+	if err != nil {
+		return 0, err
+	}
+	if rep.Status == protocol.ReplySkip {
+		batch.Skip(contentSize)
+		return 0, nil
+	}
+	start := time.Now()
+	info := FileInfo{Name: entry.name, Size: contentSize, Peer: con.RemoteAddr().String()}
+	s.metrics.started.Inc()
+	s.metrics.active.Add(1)
+	defer s.metrics.active.Add(-1)
+	events.Emit(&s.eventQueue, ctx, s.events.TransferStarted, info)
+
+	// AGREE ON RESUME OFFSET
+	digest := sha256.New()
+	offset, err := s.resumeOffset(file, rep, digest)
+	if err != nil {
+		return 0, fmt.Errorf("err checking resume offset: %s", err)
+	}
+	if err := binary.Write(con, binary.LittleEndian, offset); err != nil {
+		return 0, fmt.Errorf("err sending resume offset: %s", err)
+	}
+
+	// SEND FILE CONTENT
+	tracker := batch.Start(s.progress, entry.file, entry.name, contentSize, offset)
+	sizer := s.chunkSizer()
+	checksum, bytesSent, err := s.sendContent(ctx, con, fileFlags, file, offset, contentSize, digest, tracker, sizer)
+	tracker.Finish()
+	if err != nil {
+		if changeErr := sourceChanged(file, header); errors.Is(changeErr, ErrSourceChanged) {
+			return 0, fmt.Errorf("%w, err sending file content: %s", changeErr, err)
+		}
+		return 0, fmt.Errorf("err sending file content: %s", err)
+	}
+
+	// CHECK THE FILE DIDN'T CHANGE
+	// the checksum was taken from the content as it went out, so it would
+	// match whatever mix of old and new content the receiver got; one that
+	// can't match makes the receiver discard the file instead
+	changeErr := sourceChanged(file, header)
+	if changeErr != nil && !errors.Is(changeErr, ErrSourceChanged) {
+		return 0, changeErr
+	}
+	if changeErr != nil {
+		for i := range checksum {
+			checksum[i] = ^checksum[i]
+		}
+	}
+
+	// SEND FILE CHECKSUM
+	if _, err := con.Write(checksum); err != nil {
+		return 0, fmt.Errorf("err sending file checksum: %s", err)
+	}
+
+	// WAIT FOR THE RECEIVER TO CONFIRM
+	if err := s.awaitAck(con, entry.name); err != nil {
+		if changeErr != nil {
+			return 0, fmt.Errorf("%w: %w", changeErr, err)
+		}
+		return 0, err
+	}
+	if changeErr != nil {
+		// only a receiver that doesn't check checksums gets here
+		return 0, changeErr
+	}
+	duration := time.Since(start)
+	s.metrics.completed.Inc()
+	s.metrics.bytes.Add(bytesSent)
+	s.metrics.lastDuration.Set(duration.Seconds())
+	stats := TransferStats{FileInfo: info, Bytes: bytesSent, Duration: duration}
+	if fileFlags&(protocol.FlagBlocks|protocol.FlagUDP|protocol.FlagParallel) == 0 {
+		stats.ChunkSize = sizer.Size()
+	}
+	events.Emit(&s.eventQueue, ctx, s.events.TransferCompleted, stats)
+
+	return bytesSent, nil
+}
+
+// awaitReply waits for the receiver's reply to the header of the file name.
+// A rejected or declined file is returned as an error.
+func (s *Sender) awaitReply(con net.Conn, name string) (protocol.Reply, error) {
+	var rep protocol.Reply
+	if err := binary.Read(con, binary.LittleEndian, &rep); err != nil {
+		return rep, fmt.Errorf("err receiving reply: %s", err)
+	}
+	switch rep.Status {
+	case protocol.ReplyAccept:
+	case protocol.ReplySkip:
+		log.Printf("receiver skipped %s", name)
+	case protocol.ReplyTooLarge:
+		return rep, fmt.Errorf("receiver rejected %s: it exceeds the receiver's size limit or free disk space", name)
+	case protocol.ReplyDeclined:
+		return rep, errDeclined
+	default:
+		return rep, fmt.Errorf("unknown reply status %d", rep.Status)
+	}
+
+	return rep, nil
+}
+
+// awaitAck waits for the receiver to confirm it verified and saved the file.
+func (s *Sender) awaitAck(con net.Conn, name string) error {
+	if s.ackTimeout > 0 {
+		con.SetReadDeadline(time.Now().Add(s.ackTimeout))
+		defer con.SetReadDeadline(time.Time{})
+	}
+
+	ack, err := protocol.ReadAck(con)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: the receiver closed the connection before confirming %s", ErrNotConfirmed, name)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%w: no confirmation for %s within %s", ErrNotConfirmed, name, s.ackTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("err receiving confirmation: %w", err)
+	}
+
+	switch ack.Status {
+	case protocol.AckOK:
+		log.Printf("receiver confirmed %s", name)
+		return nil
+	case protocol.AckFailed:
+		return fmt.Errorf("%w: %w %s: %s", ErrNotConfirmed, errSaveFailed, name, ack.Message)
+	default:
+		return fmt.Errorf("unknown ack status %d", ack.Status)
+	}
+}
+
+// fileHeader describes file for the receiver.
+func fileHeader(file *os.File, fileFlags uint8) (protocol.Header, error) {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return protocol.Header{}, fmt.Errorf("err reading file info: %s", err)
+	}
+
+	return protocol.Header{
+		Size:    uint64(fileInfo.Size()),
+		Flags:   fileFlags,
+		Mode:    uint32(fileInfo.Mode().Perm() & protocol.PermMask),
+		ModTime: fileInfo.ModTime().UnixNano(),
+	}, nil
+}
+
+// sourceChanged returns ErrSourceChanged if file no longer has the size and
+// modification time header describes.
+func sourceChanged(file *os.File, header protocol.Header) error {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("err reading file info: %s", err)
+	}
+	if uint64(fileInfo.Size()) != header.Size {
+		return fmt.Errorf("%w: %s went from %d to %d bytes", ErrSourceChanged, file.Name(), header.Size, fileInfo.Size())
+	}
+	if fileInfo.ModTime().UnixNano() != header.ModTime {
+		return fmt.Errorf("%w: %s was modified at %s", ErrSourceChanged, file.Name(), fileInfo.ModTime().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// sendContent sends the rest of the file from offset on, compressing it on
+// the wire, splitting it into checksummed blocks, sending it as datagrams or
+// over parallel connections if fileFlags asks for it. The digest always
+// covers the uncompressed bytes.
+func (s *Sender) sendContent(ctx context.Context, con net.Conn, fileFlags uint8, file *os.File, offset, size uint64, digest hash.Hash, tracker *progress.Tracker, sizer *chunk.Sizer) ([]byte, uint64, error) {
+	if fileFlags&protocol.FlagBlocks != 0 {
+		return s.sendBlocks(con, file, offset, size, digest, tracker)
+	}
+	if fileFlags&protocol.FlagUDP != 0 {
+		return s.sendDatagrams(con, file, offset, size, digest, tracker)
+	}
+	if fileFlags&protocol.FlagParallel != 0 {
+		return s.sendParallel(ctx, con, file, offset, size, digest, tracker)
+	}
+	if fileFlags&protocol.FlagCompressed == 0 {
+		return s.sendFileContent(con, file, offset, size, digest, tracker, sizer)
+	}
+
+	gzipWriter := gzip.NewWriter(con)
+	checksum, bytesSent, err := s.sendFileContent(gzipWriter, file, offset, size, digest, tracker, sizer)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		return nil, 0, fmt.Errorf("err finishing gzip stream: %s", err)
+	}
+
+	return checksum, bytesSent, nil
+}
+
+// sendFileContent streams the file from offset up to size, what it held
+// when its header was sent, to the receiver, feeding it into digest as well,
+// and returns the final digest along with the number of bytes sent. digest
+// already covers any prefix the receiver resumed from. Sent bytes are
+// reported to tracker. Content appended since isn't sent, and a file that
+// shrank is an error; either way sendEntry tells the file changed.
+func (s *Sender) sendFileContent(con io.Writer, file *os.File, offset, size uint64, digest hash.Hash, tracker *progress.Tracker, sizer *chunk.Sizer) ([]byte, uint64, error) {
+	totalBytesSent, err := s.sendFileRange(con, file, offset, size, digest, tracker, sizer)
+	if err != nil {
+		return nil, 0, err
+	}
+	if totalBytesSent != size-offset {
+		return nil, 0, fmt.Errorf("file ended after %d of %d bytes", offset+totalBytesSent, size)
+	}
+
+	log.Printf("sent %d bytes of %s to receiver", totalBytesSent, file.Name())
+
+	return digest.Sum(nil), totalBytesSent, nil
+}
+
+// streamContent copies content to w chunk by chunk until content ends, in
+// chunks sized by sizer, feeding every chunk into digest, unless it is nil,
+// and reporting it to tracker, and returns the number of bytes copied.
+func (s *Sender) streamContent(w io.Writer, content io.Reader, digest hash.Hash, tracker *progress.Tracker, sizer *chunk.Sizer) (uint64, error) {
+	var buf []byte
+
+	totalBytesSent := uint64(0)
+	for {
+		// READ A CHUNK
+		if len(buf) < sizer.Size() {
+			buf = make([]byte, sizer.Size())
+		}
+		start := time.Now()
+		bytesRead, err := content.Read(buf[:sizer.Size()])
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return 0, fmt.Errorf("err reading file chunk: %s", err)
+		}
+
+		// SEND THE CHUNK
+		// Using w.Write(buf[:n]) instead of w.Write(buf) is important because
+		// the content.Read(buf) function doesn’t always fill the buffer
+		// completely. It returns the actual number of bytes read, which can
+		// be less than the buffer size, especially in the last chunk or if the
+		// file is smaller than the buffer size. w.Write(buf) would send the
+		// entire buffer, including any uninitialized or old data, leading to
+		// incorrect data transmission.
+		_, err = w.Write(buf[:bytesRead])
+		if err != nil {
+			return 0, fmt.Errorf("err sending file chunk: %s", err)
+		}
+		sizer.Record(bytesRead, time.Since(start))
+
+		if digest != nil {
+			digest.Write(buf[:bytesRead])
+		}
+		totalBytesSent += uint64(bytesRead)
+		tracker.Add(bytesRead)
+	}
+
+	return totalBytesSent, nil
+}
+
+// chunkSizer returns the sizer of a transfer's chunks, see
+// WithChunkSizeBounds.
+func (s *Sender) chunkSizer() *chunk.Sizer {
+	lo, hi := s.chunkMin, s.chunkMax
+	if hi == 0 {
+		lo, hi = s.chunkSize, max(s.chunkSize, defaultMaxChunkSize)
+	}
+
+	return chunk.NewSizer(int(s.chunkSize), int(lo), int(hi))
+}
+
+func (s *Sender) requestFilePath() string {
+	fmt.Println("enter the filepath: ")
+	var filepath string
+
+	fmt.Scanln(&filepath)
+
+	return filepath
+}
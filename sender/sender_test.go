@@ -0,0 +1,170 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/chunk"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+func TestServeConnRejectsOtherProtocols(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "a.txt", []byte("a"))
+	senderEnd, otherEnd := net.Pipe()
+	defer otherEnd.Close()
+	go otherEnd.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+
+	err := newTestSender(t).ServeConn(senderEnd, []string{path})
+	if !errors.Is(err, protocol.ErrBadMagic) {
+		t.Fatalf("ServeConn = %v, want ErrBadMagic", err)
+	}
+}
+
+func TestSendersOnFreePorts(t *testing.T) {
+	dir := t.TempDir()
+	var ports [2]uint16
+	var contents [2][]byte
+	for i := range ports {
+		contents[i] = []byte(fmt.Sprintf("sent by sender %d", i))
+		path := writeTestFile(t, dir, fmt.Sprintf("from-%d.txt", i), contents[i])
+		ports[i], _ = startSend(t, newTestSender(t), path)
+	}
+	if ports[0] == 0 || ports[0] == ports[1] {
+		t.Fatalf("senders announced ports %d and %d, want two picked by the system", ports[0], ports[1])
+	}
+
+	// each announced port is the one its sender listens on
+	for i, port := range ports {
+		r, dest := newTestReceiver(t)
+		if _, err := receiveFrom(t, r, port, nil); err != nil {
+			t.Fatalf("receiving from port %d: %v", port, err)
+		}
+		assertFile(t, filepath.Join(dest, fmt.Sprintf("from-%d.txt", i)), contents[i])
+	}
+}
+
+func TestSendOnTakenPort(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	path := writeTestFile(t, t.TempDir(), "file.txt", []byte("content"))
+	err = newTestSender(t).Send(context.Background(), uint16(port), []string{path})
+	if err == nil || !strings.Contains(err.Error(), "err starting listener") {
+		t.Errorf("Send on a taken port = %v, want it to fail to listen", err)
+	}
+}
+
+// unconfirmingConn keeps the receiver's confirmation of the file whose
+// checksum is checksum from the sender: once the checksum went out, it
+// hangs up if hangUp is set and leaves the sender waiting until its read
+// deadline otherwise.
+type unconfirmingConn struct {
+	net.Conn
+	checksum []byte
+	hangUp   bool
+	sent     bool
+	deadline time.Time
+}
+
+func (c *unconfirmingConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *unconfirmingConn) Write(p []byte) (int, error) {
+	if bytes.Equal(p, c.checksum) {
+		c.sent = true
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *unconfirmingConn) Read(p []byte) (int, error) {
+	if !c.sent {
+		return c.Conn.Read(p)
+	}
+	if c.hangUp {
+		c.Conn.Close()
+		return 0, io.EOF
+	}
+	time.Sleep(time.Until(c.deadline))
+	return 0, os.ErrDeadlineExceeded
+}
+
+func TestUnconfirmedFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		hangUp  bool
+		wantMsg string
+	}{
+		{"receiver hangs up", true, "closed the connection before confirming file.txt"},
+		{"receiver stays silent", false, "no confirmation for file.txt within 100ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := []byte("saved but never confirmed")
+			checksum := sha256.Sum256(content)
+			path := writeTestFile(t, t.TempDir(), "file.txt", content)
+			s := newTestSender(t, WithAckTimeout(100*time.Millisecond))
+			r, _ := newTestReceiver(t)
+
+			res := pipeTransferThrough(t, s, r, func(con net.Conn) net.Conn {
+				return &unconfirmingConn{Conn: con, checksum: checksum[:], hangUp: tt.hangUp}
+			}, path)
+			if !errors.Is(res.serveErr, ErrNotConfirmed) {
+				t.Fatalf("ServeConn = %v, want ErrNotConfirmed", res.serveErr)
+			}
+			if !strings.Contains(res.serveErr.Error(), tt.wantMsg) {
+				t.Errorf("ServeConn = %q, want it to say %q", res.serveErr, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestNewValidatesChunkSizeBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max uint
+		wantErr  bool
+	}{
+		{"default", 0, 0, false},
+		{"fixed", 4096, 4096, false},
+		{"range", 1024, 1 << 20, false},
+		{"zero min", 0, 1 << 20, true},
+		{"reversed", 8192, 4096, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(WithChunkSizeBounds(tt.min, tt.max))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New(WithChunkSizeBounds(%d, %d)) err = %v, want error %t", tt.min, tt.max, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChunkSizerMinimumIsPositive(t *testing.T) {
+	// NewSender skips validation, so the sizer itself has to keep chunks
+	// from shrinking to nothing
+	s := NewSender(1024, 9410, WithChunkSizeBounds(0, 4096))
+	sizer := s.chunkSizer()
+	for range 20 {
+		sizer.Record(sizer.Size(), chunk.Stall)
+	}
+	if sizer.Size() < 1 {
+		t.Errorf("chunk size = %d, want at least 1", sizer.Size())
+	}
+}
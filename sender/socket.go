@@ -0,0 +1,47 @@
+package sender
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// WithTCPNoDelay sets TCP_NODELAY on the connections to receivers, as Go does
+// by default, so small writes such as the header of every file go out right
+// away instead of being held back for Nagle's algorithm to coalesce, which
+// matters most when sending many small files. False trades that latency for
+// fewer packets.
+func WithTCPNoDelay(noDelay bool) Option {
+	return func(s *Sender) {
+		s.socket.Delay = !noDelay
+	}
+}
+
+// WithSocketBuffers sets the receive and send buffer sizes of the connections
+// to receivers, SO_RCVBUF and SO_SNDBUF, in bytes. The send buffer caps how
+// much content is in flight, so large files over links with a lot of
+// bandwidth and latency want about the bandwidth-delay product, e.g. 8 MiB
+// for a gigabit link with 64ms round trips, where the kernel doesn't grow the
+// buffer that far on its own. Zero, the default, keeps the kernel's sizes.
+func WithSocketBuffers(read, write int) Option {
+	return func(s *Sender) {
+		s.socket.ReadBuffer, s.socket.WriteBuffer = read, write
+	}
+}
+
+// WithKeepAlive sends TCP keep-alive probes on the connections to receivers
+// every interval, keeping connections that sit idle, e.g. while a file waits
+// to be accepted, from being dropped by a NAT or firewall in between. Zero
+// keeps Go's default of 15s, a negative interval turns them off.
+func WithKeepAlive(interval time.Duration) Option {
+	return func(s *Sender) {
+		s.socket.KeepAlive = interval
+	}
+}
+
+// listenTCP listens on addr with the socket buffers set up front, so the
+// connections accepted start out with them.
+func (s *Sender) listenTCP(addr string) (net.Listener, error) {
+	config := net.ListenConfig{Control: s.socket.Control}
+	return config.Listen(context.Background(), "tcp", addr)
+}
@@ -0,0 +1,38 @@
+package sender
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/sockopt"
+)
+
+func TestSocketOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want sockopt.Options
+	}{
+		{"defaults", nil, sockopt.Options{}},
+		{"no delay", []Option{WithTCPNoDelay(true)}, sockopt.Options{}},
+		{"delay", []Option{WithTCPNoDelay(false)}, sockopt.Options{Delay: true}},
+		{"buffers", []Option{WithSocketBuffers(1<<20, 2<<20)}, sockopt.Options{ReadBuffer: 1 << 20, WriteBuffer: 2 << 20}},
+		{"keep-alive", []Option{WithKeepAlive(time.Minute)}, sockopt.Options{KeepAlive: time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSender(t, tt.opts...)
+			if s.socket != tt.want {
+				t.Errorf("socket options = %+v, want %+v", s.socket, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegativeSocketBuffers(t *testing.T) {
+	for _, buffers := range [][2]int{{-1, 0}, {0, -1}} {
+		if _, err := New(WithSocketBuffers(buffers[0], buffers[1])); err == nil {
+			t.Errorf("New accepted socket buffers %d, %d", buffers[0], buffers[1])
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// StdinPath, given among the paths to send, stands for the content read from
+// stdin, e.g. the output of a command piped into the sender. Its size isn't
+// known up front, so it is streamed under protocol.FlagStreaming, as fast as
+// the receiver takes it: a slow connection blocks the writing end of the
+// pipe rather than piling the content up in memory. Stdin can only be read
+// once, so it goes to the first receiver that connects and Send returns once
+// that transfer ended. It is named after WithStdinName.
+const StdinPath = "-"
+
+// defaultStdinName is the name the content read from stdin is sent under
+// unless WithStdinName says otherwise
+const defaultStdinName = "stdin"
+
+// errStdinTaken fails the transfers of receivers connecting after the one
+// that got stdin
+var errStdinTaken = errors.New("stdin was sent to another receiver already")
+
+// WithStdinName sends the content read from stdin, see StdinPath, as a file
+// named name. The default is "stdin".
+func WithStdinName(name string) Option {
+	return func(s *Sender) {
+		s.stdinName = name
+	}
+}
+
+// claimStdin makes con the connection stdin is sent over, unless another
+// one has it already.
+func (s *Sender) claimStdin(con net.Conn) error {
+	if !s.stdinOwner.CompareAndSwap(nil, &con) {
+		return errStdinTaken
+	}
+	return nil
+}
+
+// sentStdin reports whether stdin was sent over con.
+func (s *Sender) sentStdin(con net.Conn) bool {
+	owner := s.stdinOwner.Load()
+	return owner != nil && *owner == con
+}
+
+// sendStdin sends the content read from stdin as the file of entry, whose
+// frame was sent, and returns the number of content bytes sent.
+func (s *Sender) sendStdin(ctx context.Context, con net.Conn, entry entry, batch *progress.Batch) (uint64, error) {
+	header := protocol.Header{Mode: 0o644, ModTime: time.Now().UnixNano()}
+
+	return s.sendStreamed(ctx, con, entry, header, progress.UnknownSize, batch, func(w io.Writer, tracker *progress.Tracker) error {
+		// every read, however short, goes out right away, so a slow writer
+		// doesn't leave what it wrote waiting for a chunk to fill
+		_, err := s.streamContent(w, os.Stdin, nil, tracker, s.chunkSizer())
+		return err
+	})
+}
+
+// lastTransfer ends Send once the only transfer it makes ended, with its
+// error: the one stdin went to, or the one a queued path went to.
+type lastTransfer struct {
+	err error
+}
+
+func (e *lastTransfer) Error() string {
+	return "last transfer ended"
+}
+
+// sendResult returns what Send returns once ctx is done: the error of the
+// only transfer, if that ended it, ErrNoReceivers if Send gave up
+// on receivers and ctx.Err() otherwise.
+func sendResult(ctx context.Context) error {
+	var last *lastTransfer
+	cause := context.Cause(ctx)
+	if errors.As(cause, &last) {
+		return last.err
+	}
+	if errors.Is(cause, ErrNoReceivers) {
+		return cause
+	}
+	return ctx.Err()
+}
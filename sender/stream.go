@@ -0,0 +1,95 @@
+package sender
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/events"
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += uint64(n)
+	return n, err
+}
+
+// sendStreamed sends the content of entry, whose frame was sent, under
+// protocol.FlagStreaming, as write produces it, and returns the number of
+// content bytes sent. header.Flags holds any flags besides
+// protocol.FlagStreaming and total is the progress tracker's, which may be
+// progress.UnknownSize. write reports its progress to the tracker itself.
+func (s *Sender) sendStreamed(ctx context.Context, con net.Conn, entry entry, header protocol.Header, total uint64, batch *progress.Batch, write func(w io.Writer, tracker *progress.Tracker) error) (uint64, error) {
+	// SEND FILE HEADER
+	header.Flags |= protocol.FlagStreaming
+	header.Size = 0
+	if err := protocol.WriteHeader(con, header); err != nil {
+		return 0, fmt.Errorf("err sending file header: %s", err)
+	}
+
+	// WAIT FOR THE RECEIVER'S REPLY
+	rep, err := s.awaitReply(con, entry.name)
+	if err != nil {
+		return 0, err
+	}
+	if rep.Status == protocol.ReplySkip {
+		batch.Skip(uint64(entry.size))
+		return 0, nil
+	}
+	start := time.Now()
+	info := FileInfo{Name: entry.name, Peer: con.RemoteAddr().String()}
+	s.metrics.started.Inc()
+	s.metrics.active.Add(1)
+	defer s.metrics.active.Add(-1)
+	events.Emit(&s.eventQueue, ctx, s.events.TransferStarted, info)
+
+	// streamed content is never resumed
+	if err := binary.Write(con, binary.LittleEndian, uint64(0)); err != nil {
+		return 0, fmt.Errorf("err sending resume offset: %s", err)
+	}
+
+	// SEND FILE CONTENT
+	digest := sha256.New()
+	stream := protocol.NewStreamWriter(con)
+	counter := &countingWriter{w: io.MultiWriter(stream, digest)}
+	tracker := batch.Start(s.progress, entry.file, entry.name, total, 0)
+	err = write(counter, tracker)
+	tracker.Finish()
+	if err == nil {
+		err = stream.Close()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("err sending file content: %s", err)
+	}
+	bytesSent := counter.n
+
+	// SEND FILE CHECKSUM
+	if _, err := con.Write(digest.Sum(nil)); err != nil {
+		return 0, fmt.Errorf("err sending file checksum: %s", err)
+	}
+
+	// WAIT FOR THE RECEIVER TO CONFIRM
+	if err := s.awaitAck(con, entry.name); err != nil {
+		return 0, err
+	}
+	duration := time.Since(start)
+	s.metrics.completed.Inc()
+	s.metrics.bytes.Add(bytesSent)
+	s.metrics.lastDuration.Set(duration.Seconds())
+	info.Size = bytesSent
+	events.Emit(&s.eventQueue, ctx, s.events.TransferCompleted, TransferStats{FileInfo: info, Bytes: bytesSent, Duration: duration})
+
+	return bytesSent, nil
+}
@@ -0,0 +1,61 @@
+package sender
+
+import (
+	"log"
+	"sync"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+)
+
+// summary collects how the transfer to every receiver Send served ended, to
+// be logged once Send returns.
+type summary struct {
+	mu       sync.Mutex
+	outcomes []outcome
+	// filtered is the number of entries the include and exclude patterns
+	// left out of the offer
+	filtered int
+}
+
+// outcome is how the transfer to peer ended: with err, nil if it succeeded,
+// after bytes of content were sent.
+type outcome struct {
+	peer  string
+	bytes uint64
+	err   error
+}
+
+func (s *summary) add(peer string, bytes uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outcomes = append(s.outcomes, outcome{peer: peer, bytes: bytes, err: err})
+}
+
+// log logs a line per receiver, in the order their transfers ended.
+func (s *summary) log() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.outcomes) == 0 {
+		return
+	}
+	failed := 0
+	for _, o := range s.outcomes {
+		if o.err != nil {
+			failed++
+		}
+	}
+	if s.filtered > 0 {
+		log.Printf("summary: %d receivers served, %d failed, %d entries left out by patterns", len(s.outcomes)-failed, failed, s.filtered)
+	} else {
+		log.Printf("summary: %d receivers served, %d failed", len(s.outcomes)-failed, failed)
+	}
+	for _, o := range s.outcomes {
+		if o.err != nil {
+			log.Printf("  %s: failed after %s: %s", o.peer, progress.FormatSize(float64(o.bytes)), o.err)
+			continue
+		}
+		log.Printf("  %s: sent %s", o.peer, progress.FormatSize(float64(o.bytes)))
+	}
+}
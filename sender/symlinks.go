@@ -0,0 +1,226 @@
+package sender
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// SymlinkPolicy selects what becomes of the symlinks found in the
+// directories Send offers. Symlinks given as paths are always followed.
+type SymlinkPolicy int
+
+const (
+	// SkipSymlinks leaves symlinks out, with a warning. This is the
+	// default.
+	SkipSymlinks SymlinkPolicy = iota
+	// FollowSymlinks sends what symlinks point to in their place: a file as
+	// a file and a directory as a directory of that name, unless it contains
+	// the symlink, which would loop. Dangling symlinks are left out.
+	FollowSymlinks
+	// PreserveSymlinks sends symlinks as such, with their targets as they
+	// are, for the receiver to recreate them. A target outside the offered
+	// directory is flagged, and warned about on both ends. Receivers that
+	// can't recreate symlinks get the directories without them.
+	PreserveSymlinks
+)
+
+var symlinkPolicyNames = map[SymlinkPolicy]string{
+	SkipSymlinks:     "skip",
+	FollowSymlinks:   "follow",
+	PreserveSymlinks: "preserve",
+}
+
+func (p SymlinkPolicy) String() string {
+	if name, ok := symlinkPolicyNames[p]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("SymlinkPolicy(%d)", int(p))
+}
+
+// ParseSymlinkPolicy maps "skip", "follow" or "preserve" to the
+// corresponding policy.
+func ParseSymlinkPolicy(name string) (SymlinkPolicy, error) {
+	for policy, policyName := range symlinkPolicyNames {
+		if policyName == name {
+			return policy, nil
+		}
+	}
+
+	return SkipSymlinks, fmt.Errorf("unknown symlink policy: %s", name)
+}
+
+// WithSymlinks sets what becomes of the symlinks in offered directories,
+// entry by entry or in archives. The default is SkipSymlinks.
+func WithSymlinks(policy SymlinkPolicy) Option {
+	return func(s *Sender) {
+		s.symlinks = policy
+	}
+}
+
+// walker walks the offered directories as the include and exclude patterns
+// and the symlink policy say.
+type walker struct {
+	filter   filter
+	symlinks SymlinkPolicy
+}
+
+func (s *Sender) walker() walker {
+	return walker{filter: s.filter, symlinks: s.symlinks}
+}
+
+// walk walks the directory root like filepath.WalkDir, passing fn the
+// entries kept along with their slash-separated path relative to root, "."
+// for root itself. Symlinks are passed as they are under PreserveSymlinks,
+// and under FollowSymlinks the file or directory they point to is passed
+// in their place, with the directory walked below the symlink's path. A
+// directory that doesn't match an include pattern is passed right before
+// the first entry in it that is kept, so none but root is passed empty
+// unless it matches. It returns the number of entries the patterns left
+// out, a directory left out with what is in it counting once.
+func (w walker) walk(root string, fn func(localPath, relPath string, d fs.DirEntry) error) (int, error) {
+	type pendingDir struct {
+		localPath string
+		relPath   string
+		d         fs.DirEntry
+	}
+	var pending []pendingDir
+	skipped := 0
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return 0, err
+	}
+	// followed symlinks resolve to absolute paths
+	absRoot, err := filepath.Abs(realRoot)
+	if err != nil {
+		return 0, err
+	}
+
+	// walkDir walks dir, which is at prefix below root, "" for root
+	// itself; ancestors are the directories walked that dir is in, dir
+	// included, which a followed symlink must not lead back to
+	var walkDir func(dir, prefix string, ancestors []string) error
+	walkDir = func(dir, prefix string, ancestors []string) error {
+		return filepath.WalkDir(dir, func(localPath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			relPath, err := filepath.Rel(dir, localPath)
+			if err != nil {
+				return err
+			}
+			relPath = filepath.ToSlash(relPath)
+			switch {
+			case relPath == "." && prefix == "":
+				return fn(localPath, relPath, d)
+			case relPath == ".":
+				// passed in place of the symlink followed to it
+				return nil
+			case prefix != "":
+				relPath = prefix + "/" + relPath
+			}
+
+			// RESOLVE SYMLINKS
+			// followed is the real path of a directory a symlink leads to
+			var followed string
+			if d.Type()&fs.ModeSymlink != 0 {
+				switch w.symlinks {
+				case PreserveSymlinks:
+				case FollowSymlinks:
+					info, err := os.Stat(localPath)
+					if err != nil {
+						log.Printf("warning: skipping symlink %s: %s", localPath, err)
+						return nil
+					}
+					if info.IsDir() {
+						if followed, err = filepath.EvalSymlinks(localPath); err != nil {
+							return err
+						}
+						if followed, err = filepath.Abs(followed); err != nil {
+							return err
+						}
+						within := filepath.Join(ancestors[len(ancestors)-1], filepath.FromSlash(strings.TrimPrefix(path.Dir(relPath), prefix)))
+						loops := func(dir string) bool {
+							return dir == followed || strings.HasPrefix(dir, followed+string(filepath.Separator))
+						}
+						if loops(within) || slices.ContainsFunc(ancestors, loops) {
+							log.Printf("warning: skipping symlink %s: it leads back to a directory it is in", localPath)
+							return nil
+						}
+					}
+					d = fs.FileInfoToDirEntry(info)
+				default:
+					log.Printf("warning: skipping symlink %s", localPath)
+					return nil
+				}
+			}
+
+			// APPLY THE PATTERNS
+			// pending directories this entry isn't in had nothing kept
+			for len(pending) > 0 && !strings.HasPrefix(relPath, pending[len(pending)-1].relPath+"/") {
+				pending = pending[:len(pending)-1]
+				skipped++
+			}
+			if w.filter.exclude.Match(relPath, d.IsDir()) {
+				skipped++
+				if d.IsDir() && followed == "" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if len(w.filter.include) > 0 && !w.filter.include.MatchWithin(relPath, d.IsDir()) {
+				if !d.IsDir() {
+					skipped++
+					return nil
+				}
+				pending = append(pending, pendingDir{localPath, relPath, d})
+			} else {
+				for _, dir := range pending {
+					if err := fn(dir.localPath, dir.relPath, dir.d); err != nil {
+						return err
+					}
+				}
+				pending = pending[:0]
+				if err := fn(localPath, relPath, d); err != nil {
+					return err
+				}
+			}
+
+			if followed != "" {
+				return walkDir(followed, relPath, append(slices.Clip(ancestors), followed))
+			}
+			return nil
+		})
+	}
+	err = walkDir(realRoot, "", []string{absRoot})
+
+	return skipped + len(pending), err
+}
+
+// linkEntry returns the entry of the symlink at localPath, relPath below
+// the offered directory, to be named name. A target outside the directory
+// is flagged and warned about.
+func linkEntry(localPath, relPath, name string) (entry, error) {
+	target, err := os.Readlink(localPath)
+	if err != nil {
+		return entry{}, err
+	}
+	target = filepath.ToSlash(target)
+
+	link := entry{localPath: localPath, name: name, symlink: true, target: target}
+	if protocol.LinkLeaves(relPath, target) {
+		log.Printf("warning: symlink %s points outside the offered directory: %s", localPath, target)
+		link.linkFlags |= protocol.LinkOutside
+	}
+
+	return link, nil
+}
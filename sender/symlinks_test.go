@@ -0,0 +1,142 @@
+package sender
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+// symlinkTree creates a directory "tree" holding a file, a symlink to it,
+// a symlink to a directory next to it, one to a file outside of it, a
+// dangling one and one looping back to the tree, and returns its path.
+func symlinkTree(t *testing.T) string {
+	t.Helper()
+
+	src := t.TempDir()
+	tree := filepath.Join(src, "tree")
+	writeTestFile(t, tree, "file.txt", []byte("file"))
+	writeTestFile(t, tree, "inner/nested.txt", []byte("nested"))
+	writeTestFile(t, src, "outside.txt", []byte("outside"))
+	for link, target := range map[string]string{
+		"link.txt":     "file.txt",
+		"dirlink":      "inner",
+		"outside.txt":  filepath.Join("..", "outside.txt"),
+		"dangling.txt": "missing.txt",
+		"loop":         ".",
+	} {
+		if err := os.Symlink(target, filepath.Join(tree, link)); err != nil {
+			t.Skipf("can't create symlinks: %v", err)
+		}
+	}
+
+	return tree
+}
+
+func TestCollectSymlinks(t *testing.T) {
+	tests := []struct {
+		policy SymlinkPolicy
+		want   []string
+	}{
+		{SkipSymlinks, []string{"tree/", "tree/file.txt", "tree/inner/", "tree/inner/nested.txt"}},
+		{FollowSymlinks, []string{"tree/", "tree/dirlink/", "tree/dirlink/nested.txt", "tree/file.txt", "tree/inner/", "tree/inner/nested.txt", "tree/link.txt", "tree/outside.txt"}},
+		{PreserveSymlinks, []string{"tree/", "tree/dangling.txt", "tree/dirlink", "tree/file.txt", "tree/inner/", "tree/inner/nested.txt", "tree/link.txt", "tree/loop", "tree/outside.txt"}},
+	}
+	tree := symlinkTree(t)
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			entries, _, err := newTestSender(t, WithSymlinks(tt.policy)).collect([]string{tree}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := entryNames(entries); !slices.Equal(got, tt.want) {
+				t.Fatalf("entries = %q, want %q", got, tt.want)
+			}
+			if tt.policy != PreserveSymlinks {
+				return
+			}
+			for _, e := range entries {
+				if !e.symlink {
+					continue
+				}
+				outside := e.linkFlags&protocol.LinkOutside != 0
+				if want := e.name == "tree/outside.txt"; outside != want {
+					t.Errorf("%s -> %s flagged as pointing outside: %t, want %t", e.name, e.target, outside, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSymlinkRoundTrip(t *testing.T) {
+	tree := symlinkTree(t)
+
+	t.Run("skip", func(t *testing.T) {
+		dest := sendTree(t, tree, SkipSymlinks)
+		assertFile(t, filepath.Join(dest, "tree", "file.txt"), []byte("file"))
+		for _, name := range []string{"link.txt", "dirlink", "outside.txt", "loop"} {
+			if _, err := os.Lstat(filepath.Join(dest, "tree", name)); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("skipped symlink %s was received: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		dest := sendTree(t, tree, FollowSymlinks)
+		assertFile(t, filepath.Join(dest, "tree", "link.txt"), []byte("file"))
+		assertFile(t, filepath.Join(dest, "tree", "dirlink", "nested.txt"), []byte("nested"))
+		assertFile(t, filepath.Join(dest, "tree", "outside.txt"), []byte("outside"))
+		info, err := os.Lstat(filepath.Join(dest, "tree", "link.txt"))
+		if err != nil || !info.Mode().IsRegular() {
+			t.Errorf("followed symlink was received as %v, %v, want a file", info, err)
+		}
+	})
+
+	t.Run("preserve", func(t *testing.T) {
+		dest := sendTree(t, tree, PreserveSymlinks)
+		for link, want := range map[string]string{
+			"link.txt":     "file.txt",
+			"dirlink":      "inner",
+			"outside.txt":  "../outside.txt",
+			"dangling.txt": "missing.txt",
+		} {
+			target, err := os.Readlink(filepath.Join(dest, "tree", link))
+			if err != nil {
+				t.Errorf("symlink %s wasn't recreated: %v", link, err)
+				continue
+			}
+			if filepath.ToSlash(target) != want {
+				t.Errorf("symlink %s points to %s, want %s", link, target, want)
+			}
+		}
+		assertFile(t, filepath.Join(dest, "tree", "link.txt"), []byte("file"))
+	})
+}
+
+// sendTree sends tree under policy to a new receiver and returns where it
+// saved it.
+func sendTree(t *testing.T, tree string, policy SymlinkPolicy) string {
+	t.Helper()
+
+	r, dest := newTestReceiver(t)
+	res := pipeTransfer(t, newTestSender(t, WithSymlinks(policy)), r, tree)
+	if res.receiveErr != nil || res.serveErr != nil {
+		t.Fatalf("ReceiveConn = %v, ServeConn = %v", res.receiveErr, res.serveErr)
+	}
+	return dest
+}
+
+func TestParseSymlinkPolicy(t *testing.T) {
+	for _, policy := range []SymlinkPolicy{SkipSymlinks, FollowSymlinks, PreserveSymlinks} {
+		if got, err := ParseSymlinkPolicy(policy.String()); err != nil || got != policy {
+			t.Errorf("ParseSymlinkPolicy(%q) = %v, %v", policy, got, err)
+		}
+	}
+	if _, err := ParseSymlinkPolicy("copy"); err == nil {
+		t.Error("ParseSymlinkPolicy accepted an unknown policy")
+	}
+}
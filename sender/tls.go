@@ -0,0 +1,84 @@
+package sender
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// tlsConfig loads the configured certificate, or generates a self-signed one
+// if none was given, and returns the listener config along with the SHA-256
+// fingerprint receivers can pin.
+func (s *Sender) tlsConfig() (*tls.Config, string, error) {
+	var cert tls.Certificate
+	var err error
+	if s.tlsCertFile != "" || s.tlsKeyFile != "" {
+		cert, err = tls.LoadX509KeyPair(s.tlsCertFile, s.tlsKeyFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("err loading certificate: %s", err)
+		}
+	} else {
+		cert, err = selfSignedCertificate()
+		if err != nil {
+			return nil, "", fmt.Errorf("err generating self-signed certificate: %s", err)
+		}
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	return config, fingerprint(cert.Certificate[0]), nil
+}
+
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hostname},
+		DNSNames:     []string{hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// fingerprint formats the SHA-256 of a DER certificate as colon separated hex.
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, ":")
+}
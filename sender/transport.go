@@ -0,0 +1,70 @@
+package sender
+
+import "fmt"
+
+// Transport decides how the sender and its receivers are connected and how
+// file content travels between them.
+type Transport int
+
+const (
+	// TransportTCP streams content over the TCP connection. This is the
+	// default.
+	TransportTCP Transport = iota
+	// TransportUDP sends content as datagrams of the size set with WithMTU,
+	// which the receiver acknowledges and the sender retransmits when lost,
+	// while offers, replies and confirmations still go over TCP. On
+	// high-latency links with some loss it keeps the pipe fuller than TCP's
+	// congestion control would. It is experimental: content goes
+	// unencrypted, so receivers connected over TLS get it over TCP, as do
+	// receivers that don't support it.
+	TransportUDP
+	// TransportQUIC serves receivers over QUIC instead of TCP, on the same
+	// port number, with up to four files in flight at once on streams of
+	// their own. QUIC is always encrypted, with the certificate set with
+	// WithCertificate or a self-signed one whose fingerprint is logged; WithTLS
+	// is implied. Only receivers that support QUIC can connect.
+	TransportQUIC
+)
+
+var transportNames = map[Transport]string{
+	TransportTCP:  "tcp",
+	TransportUDP:  "udp",
+	TransportQUIC: "quic",
+}
+
+func (t Transport) String() string {
+	if name, ok := transportNames[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Transport(%d)", int(t))
+}
+
+// ParseTransport maps "tcp", "udp" or "quic" to the corresponding transport.
+func ParseTransport(name string) (Transport, error) {
+	for transport, transportName := range transportNames {
+		if transportName == name {
+			return transport, nil
+		}
+	}
+
+	return TransportTCP, fmt.Errorf("unknown transport: %s", name)
+}
+
+// WithTransport sends file content over transport. The default is
+// TransportTCP.
+func WithTransport(transport Transport) Option {
+	return func(s *Sender) {
+		s.transport = transport
+	}
+}
+
+// WithMTU sizes the datagrams of TransportUDP, headers included but for
+// those of IP and UDP, between protocol.MinMTU and protocol.MaxMTU. The
+// default is protocol.DefaultMTU, which fits an Ethernet frame; larger
+// datagrams get fragmented and are lost as a whole when any fragment is.
+func WithMTU(mtu int) Option {
+	return func(s *Sender) {
+		s.mtu = mtu
+	}
+}
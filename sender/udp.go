@@ -0,0 +1,254 @@
+package sender
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/pjmessi/go_file_share/internal/progress"
+	"github.com/pjmessi/go_file_share/protocol"
+)
+
+const (
+	// udpWindow is how many datagrams may be on their way unacknowledged
+	udpWindow = 512
+	// udpInitialRTO is the retransmission timeout before the round trip
+	// time was measured, udpMinRTO and udpMaxRTO bound it once it is
+	udpInitialRTO = 200 * time.Millisecond
+	udpMinRTO     = 10 * time.Millisecond
+	udpMaxRTO     = 2 * time.Second
+	// udpStallTimeout is how long the sender keeps retransmitting without
+	// the receiver acknowledging anything new
+	udpStallTimeout = 15 * time.Second
+)
+
+// udpSlot is the state of a datagram within the window.
+type udpSlot struct {
+	sentAt        time.Time
+	retransmitted bool
+	acked         bool
+}
+
+// udpWindowState is the sliding window of sendDatagrams: every datagram
+// below base was acknowledged, none from next on was sent yet.
+type udpWindowState struct {
+	slots      [udpWindow]udpSlot
+	base, next uint64
+	// rto is the retransmission timeout, derived from srtt and rttvar as in
+	// RFC 6298
+	rto, srtt, rttvar time.Duration
+}
+
+func (w *udpWindowState) slot(seq uint64) *udpSlot {
+	return &w.slots[seq%udpWindow]
+}
+
+// ack marks seq acknowledged if it is within the window, reporting whether
+// it wasn't before.
+func (w *udpWindowState) ack(seq uint64) bool {
+	if seq < w.base || seq >= w.next || w.slot(seq).acked {
+		return false
+	}
+	w.slot(seq).acked = true
+
+	return true
+}
+
+// measure updates the retransmission timeout with a round trip time sample.
+func (w *udpWindowState) measure(rtt time.Duration) {
+	if w.srtt == 0 {
+		w.srtt, w.rttvar = rtt, rtt/2
+	} else {
+		w.rttvar = (3*w.rttvar + (w.srtt - rtt).Abs()) / 4
+		w.srtt = (7*w.srtt + rtt) / 8
+	}
+	w.settle()
+}
+
+// settle undoes the backing off of the retransmission timeout once the
+// receiver acknowledges something new again.
+func (w *udpWindowState) settle() {
+	if w.srtt != 0 {
+		w.rto = min(max(w.srtt+4*w.rttvar, udpMinRTO), udpMaxRTO)
+	}
+}
+
+// sendDatagrams sends the file from offset to size as protocol.DatagramData
+// to the port the receiver offers, keeping up to udpWindow of them
+// unacknowledged and sending again those that the receiver reports missing
+// or that aren't acknowledged within the retransmission timeout. It returns
+// the final digest along with the number of content bytes sent, not counting
+// retransmissions.
+func (s *Sender) sendDatagrams(con net.Conn, file *os.File, offset, size uint64, digest hash.Hash, tracker *progress.Tracker) ([]byte, uint64, error) {
+	remote, ok := con.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, 0, fmt.Errorf("datagrams can't be sent over a %s connection", con.RemoteAddr().Network())
+	}
+
+	// CONNECT TO THE OFFERED PORT
+	var offer protocol.UDPOffer
+	if err := binary.Read(con, binary.LittleEndian, &offer); err != nil {
+		return nil, 0, fmt.Errorf("err receiving udp offer: %s", err)
+	}
+	remoteIP := remote.AddrPort().Addr().Unmap()
+	udp, err := net.DialUDP("udp", s.localUDPAddr(remoteIP), net.UDPAddrFromAddrPort(netip.AddrPortFrom(remoteIP, offer.Port)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("err connecting to udp port %d: %s", offer.Port, err)
+	}
+	defer udp.Close()
+
+	payloadSize := s.mtu - protocol.DataHeaderSize
+	if err := binary.Write(con, binary.LittleEndian, uint16(payloadSize)); err != nil {
+		return nil, 0, fmt.Errorf("err sending payload size: %s", err)
+	}
+
+	remaining := size - offset
+	count := protocol.PacketCount(remaining, payloadSize)
+	payload := make([]byte, payloadSize)
+	packet := make([]byte, 0, s.mtu)
+	reply := make([]byte, protocol.MaxMTU)
+	w := &udpWindowState{rto: udpInitialRTO}
+	limits := limitsOf(con)
+	progressAt := time.Now()
+	retransmissions := 0
+
+	// send sends datagram seq, reading it from the file unless data is given
+	send := func(seq uint64, data []byte) error {
+		if data == nil {
+			data = payload[:min(uint64(payloadSize), remaining-seq*uint64(payloadSize))]
+			if _, err := file.ReadAt(data, int64(offset+seq*uint64(payloadSize))); err != nil {
+				return fmt.Errorf("err reading datagram %d: %s", seq, err)
+			}
+			w.slot(seq).retransmitted = true
+			retransmissions++
+		}
+		packet = protocol.AppendData(packet[:0], offer.Token, seq, data)
+		limits.wait(len(packet))
+		if _, err := udp.Write(packet); err != nil {
+			return fmt.Errorf("err sending datagram %d: %s", seq, err)
+		}
+		w.slot(seq).sentAt = time.Now()
+
+		return nil
+	}
+
+	for w.base < count {
+		// FILL THE WINDOW
+		// A rate limit paces new datagrams in between reading acks, rather
+		// than holding up the acks until the window is full.
+		var pause time.Duration
+		for w.next < count && w.next < w.base+udpWindow {
+			if pause = limits.delay(s.mtu); pause > 0 {
+				break
+			}
+			data := payload[:min(uint64(payloadSize), remaining-w.next*uint64(payloadSize))]
+			if _, err := io.ReadFull(file, data); err != nil {
+				return nil, 0, fmt.Errorf("err reading datagram %d: %s", w.next, err)
+			}
+			digest.Write(data)
+
+			*w.slot(w.next) = udpSlot{}
+			if err := send(w.next, data); err != nil {
+				return nil, 0, err
+			}
+			tracker.Add(len(data))
+			w.next++
+		}
+
+		// AWAIT ACKNOWLEDGEMENTS
+		deadline := w.slot(w.base).sentAt.Add(w.rto)
+		paced := pause > 0 && (w.base == w.next || time.Now().Add(pause).Before(deadline))
+		if paced {
+			deadline = time.Now().Add(pause)
+		}
+		if err := udp.SetReadDeadline(deadline); err != nil {
+			return nil, 0, fmt.Errorf("err setting ack deadline: %s", err)
+		}
+		n, err := udp.Read(reply)
+		if errors.Is(err, os.ErrDeadlineExceeded) && paced {
+			continue
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if time.Since(progressAt) > udpStallTimeout {
+				return nil, 0, fmt.Errorf("receiver stopped acknowledging datagrams, %d of %d acknowledged", w.base, count)
+			}
+
+			// SEND WHAT TIMED OUT AGAIN
+			// Backing off keeps a congested path from being flooded
+			// further.
+			expired := w.rto
+			w.rto = min(2*w.rto, udpMaxRTO)
+			for seq := w.base; seq < w.next; seq++ {
+				if !w.slot(seq).acked && time.Since(w.slot(seq).sentAt) >= expired {
+					if err := send(seq, nil); err != nil {
+						return nil, 0, err
+					}
+				}
+			}
+			continue
+		}
+		if err != nil {
+			// e.g. ICMP port unreachable, reported on the next read; the
+			// stall timeout decides when to give up on the receiver
+			time.Sleep(w.rto / 4)
+			if time.Since(progressAt) > udpStallTimeout {
+				return nil, 0, fmt.Errorf("err receiving ack: %s", err)
+			}
+			continue
+		}
+		d, err := protocol.ParseDatagram(reply[:n])
+		if err != nil || d.Token != offer.Token {
+			continue
+		}
+
+		switch d.Type {
+		case protocol.DatagramAck:
+			// Only the datagram that prompted the ack makes for a round
+			// trip time sample, those acked along with it may have waited
+			// for a lost one. Of a retransmitted one it's unknown which
+			// sending the ack answers.
+			echoed := d.Echo >= w.base && d.Echo < w.next && !w.slot(d.Echo).acked && !w.slot(d.Echo).retransmitted
+			progressed := false
+			for seq := w.base; seq < min(d.Missing, w.next); seq++ {
+				progressed = w.ack(seq) || progressed
+			}
+			for i := range uint64(64) {
+				if d.Mask&(1<<i) != 0 {
+					progressed = w.ack(d.Missing+1+i) || progressed
+				}
+			}
+			if echoed && w.slot(d.Echo).acked {
+				w.measure(time.Since(w.slot(d.Echo).sentAt))
+			} else if progressed {
+				w.settle()
+			}
+			if progressed {
+				progressAt = time.Now()
+			}
+			for w.base < w.next && w.slot(w.base).acked {
+				w.base++
+			}
+
+		case protocol.DatagramNack:
+			// a nack answering a retransmission that is still on its way
+			// mustn't trigger another one
+			for _, seq := range d.Nacks {
+				if seq >= w.base && seq < w.next && !w.slot(seq).acked && time.Since(w.slot(seq).sentAt) >= w.rto/4 {
+					if err := send(seq, nil); err != nil {
+						return nil, 0, err
+					}
+				}
+			}
+		}
+	}
+	log.Printf("sent %d bytes of %s to receiver in %d datagrams, %d sent again", remaining, file.Name(), count, retransmissions)
+
+	return digest.Sum(nil), remaining, nil
+}
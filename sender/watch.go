@@ -0,0 +1,180 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultWatchGrace is how long a watched file has to stay unchanged before
+// it is sent unless configured otherwise
+const defaultWatchGrace = 2 * time.Second
+
+// watchPoll is how often Watch scans the watched directory
+const watchPoll = time.Second
+
+// WithWatchGrace sends a file showing up in the directory Watch watches once
+// its size and modification time stayed the same for grace, so files still
+// being written or copied in aren't sent half done. The default is 2s.
+func WithWatchGrace(grace time.Duration) Option {
+	return func(s *Sender) {
+		s.watchGrace = grace
+	}
+}
+
+// WithSentDir moves the files Watch sent into dir once the receiver
+// confirmed them, relative to the watched directory unless it is absolute.
+// Empty, the default, leaves them in place.
+func WithSentDir(dir string) Option {
+	return func(s *Sender) {
+		s.sentDir = dir
+	}
+}
+
+// watchedFile is what a scan of the watched directory saw of a file.
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+	// since is when the file was first seen looking like this
+	since time.Time
+}
+
+func (f watchedFile) same(other watchedFile) bool {
+	return f.size == other.size && f.modTime.Equal(other.modTime)
+}
+
+// Watch sends the files showing up in dir, including those in it already,
+// to receivers as they come: the directory is scanned every second, and the
+// files that stayed unchanged for WithWatchGrace are queued, to be sent as
+// ProcessQueue does. Once a receiver confirmed them they are moved to
+// WithSentDir, if set; either way a file is only queued again once it
+// changed, or was removed and showed up again. Only the regular files
+// directly in dir are sent; hidden files and ones ending in "~", ".tmp" or
+// ".part", as editors and downloads write before renaming them into place,
+// are left out, as are those the include and exclude patterns leave out. It
+// returns ctx.Err() once ctx is done.
+func (s *Sender) Watch(ctx context.Context, port uint16, dir string) error {
+	// senders from the deprecated constructor haven't been validated yet
+	if err := s.validate(); err != nil {
+		return err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("err opening watched directory: %s", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	sentDir := s.sentDir
+	if sentDir != "" && !filepath.IsAbs(sentDir) {
+		sentDir = filepath.Join(dir, sentDir)
+	}
+	if sentDir != "" {
+		if err := os.MkdirAll(sentDir, 0o755); err != nil {
+			return fmt.Errorf("err creating sent directory: %s", err)
+		}
+	}
+	log.Printf("watching %s for files to send", dir)
+
+	// QUEUE THE FILES THAT SETTLED
+	go func() {
+		seen := map[string]watchedFile{}
+		queued := map[string]watchedFile{}
+		for {
+			ready, err := s.scanWatched(dir, seen, queued)
+			if err != nil {
+				log.Printf("warning: err scanning %s: %s", dir, err)
+			}
+			for _, filePath := range ready {
+				s.enqueue(ctx, filePath)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(watchPoll):
+			}
+		}
+	}()
+
+	// SEND THE QUEUED FILES
+	var moved func(QueueItem)
+	if sentDir != "" {
+		moved = func(item QueueItem) {
+			if err := os.Rename(item.Path, filepath.Join(sentDir, filepath.Base(item.Path))); err != nil {
+				log.Printf("warning: err moving %s to %s: %s", item.Path, sentDir, err)
+			}
+		}
+	}
+
+	return s.processQueue(ctx, port, moved)
+}
+
+// scanWatched lists the files in dir, noting in seen how they looked and
+// since when, and returns those that stayed unchanged for the grace period
+// and weren't queued like this before, noting them in queued.
+func (s *Sender) scanWatched(dir string, seen, queued map[string]watchedFile) ([]string, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	present := map[string]bool{}
+	var ready []string
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if !dirEntry.Type().IsRegular() || !watchable(name) || !s.filter.keeps(name) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			// renamed or removed since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		filePath := filepath.Join(dir, name)
+		present[filePath] = true
+		file := watchedFile{size: info.Size(), modTime: info.ModTime(), since: now}
+		if previous, ok := seen[filePath]; ok && previous.same(file) {
+			file.since = previous.since
+		}
+		seen[filePath] = file
+		if queuedFile, ok := queued[filePath]; ok && queuedFile.same(file) {
+			continue
+		}
+		if now.Sub(file.since) >= s.watchGrace {
+			queued[filePath] = file
+			ready = append(ready, filePath)
+		}
+	}
+
+	// a file that is gone and comes back is new again
+	for filePath := range seen {
+		if !present[filePath] {
+			delete(seen, filePath)
+			delete(queued, filePath)
+		}
+	}
+	slices.Sort(ready)
+
+	return ready, nil
+}
+
+// watchable reports whether a file named name in the watched directory is
+// meant to be sent rather than a file being written under a temporary name.
+func watchable(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+		return false
+	}
+	return !strings.HasSuffix(name, ".tmp") && !strings.HasSuffix(name, ".part")
+}
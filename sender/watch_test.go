@@ -0,0 +1,269 @@
+package sender
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pjmessi/go_file_share/retry"
+)
+
+// watched returns how a scan sees the file at path, seen first at since.
+func watched(t *testing.T, path string, since time.Time) watchedFile {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return watchedFile{size: info.Size(), modTime: info.ModTime(), since: since}
+}
+
+func TestScanWatched(t *testing.T) {
+	longAgo := time.Now().Add(-2 * time.Hour)
+	tests := []struct {
+		name  string
+		files []string
+		grace time.Duration
+		opts  []Option
+		// prepare returns the maps of earlier scans of dir
+		prepare   func(t *testing.T, dir string) (seen, queued map[string]watchedFile)
+		wantReady []string
+		wantSeen  []string
+	}{
+		{
+			name:      "present without grace",
+			files:     []string{"b.txt", "a.txt"},
+			wantReady: []string{"a.txt", "b.txt"},
+			wantSeen:  []string{"a.txt", "b.txt"},
+		},
+		{
+			name:     "within the grace period",
+			files:    []string{"a.txt"},
+			grace:    time.Hour,
+			wantSeen: []string{"a.txt"},
+		},
+		{
+			name:  "unchanged for the grace period",
+			files: []string{"a.txt"},
+			grace: time.Hour,
+			prepare: func(t *testing.T, dir string) (map[string]watchedFile, map[string]watchedFile) {
+				seen := map[string]watchedFile{filepath.Join(dir, "a.txt"): watched(t, filepath.Join(dir, "a.txt"), longAgo)}
+				return seen, map[string]watchedFile{}
+			},
+			wantReady: []string{"a.txt"},
+			wantSeen:  []string{"a.txt"},
+		},
+		{
+			name:  "changed during the grace period",
+			files: []string{"a.txt"},
+			grace: time.Hour,
+			prepare: func(t *testing.T, dir string) (map[string]watchedFile, map[string]watchedFile) {
+				file := watched(t, filepath.Join(dir, "a.txt"), longAgo)
+				file.size--
+				return map[string]watchedFile{filepath.Join(dir, "a.txt"): file}, map[string]watchedFile{}
+			},
+			wantSeen: []string{"a.txt"},
+		},
+		{
+			name:  "queued before",
+			files: []string{"a.txt"},
+			prepare: func(t *testing.T, dir string) (map[string]watchedFile, map[string]watchedFile) {
+				file := watched(t, filepath.Join(dir, "a.txt"), longAgo)
+				return map[string]watchedFile{filepath.Join(dir, "a.txt"): file}, map[string]watchedFile{filepath.Join(dir, "a.txt"): file}
+			},
+			wantSeen: []string{"a.txt"},
+		},
+		{
+			name:  "changed since it was queued",
+			files: []string{"a.txt"},
+			prepare: func(t *testing.T, dir string) (map[string]watchedFile, map[string]watchedFile) {
+				file := watched(t, filepath.Join(dir, "a.txt"), longAgo)
+				file.modTime = file.modTime.Add(-time.Minute)
+				return map[string]watchedFile{filepath.Join(dir, "a.txt"): file}, map[string]watchedFile{filepath.Join(dir, "a.txt"): file}
+			},
+			wantReady: []string{"a.txt"},
+			wantSeen:  []string{"a.txt"},
+		},
+		{
+			name:  "removed",
+			files: []string{"a.txt"},
+			prepare: func(t *testing.T, dir string) (map[string]watchedFile, map[string]watchedFile) {
+				file := watched(t, filepath.Join(dir, "a.txt"), longAgo)
+				gone := filepath.Join(dir, "gone.txt")
+				return map[string]watchedFile{gone: file}, map[string]watchedFile{gone: file}
+			},
+			wantReady: []string{"a.txt"},
+			wantSeen:  []string{"a.txt"},
+		},
+		{
+			name:  "temporary and hidden names",
+			files: []string{"a.tmp", "a.part", ".a.txt", "a.txt~"},
+		},
+		{
+			name:      "excluded",
+			files:     []string{"a.txt", "a.log"},
+			opts:      []Option{WithExclude("*.log")},
+			wantReady: []string{"a.txt"},
+			wantSeen:  []string{"a.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.files {
+				writeTestFile(t, dir, name, []byte(name))
+			}
+			if err := os.Mkdir(filepath.Join(dir, "sent"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			s := newTestSender(t, append(tt.opts, WithWatchGrace(tt.grace))...)
+			seen, queued := map[string]watchedFile{}, map[string]watchedFile{}
+			if tt.prepare != nil {
+				seen, queued = tt.prepare(t, dir)
+			}
+
+			ready, err := s.scanWatched(dir, seen, queued)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, path := range ready {
+				names = append(names, filepath.Base(path))
+			}
+			if !slices.Equal(names, tt.wantReady) {
+				t.Errorf("ready = %q, want %q", names, tt.wantReady)
+			}
+			var seenNames []string
+			for path := range seen {
+				seenNames = append(seenNames, filepath.Base(path))
+			}
+			slices.Sort(seenNames)
+			if !slices.Equal(seenNames, tt.wantSeen) {
+				t.Errorf("seen = %q, want %q", seenNames, tt.wantSeen)
+			}
+			for path := range queued {
+				if _, ok := seen[path]; !ok {
+					t.Errorf("%s is queued but no longer seen", path)
+				}
+			}
+		})
+	}
+}
+
+// startWatch runs s.Watch on dir, on ports the system picks, announcing
+// them to the test only, and returns the ports of the sessions to come. The
+// test cancels Watch when it ends.
+func startWatch(t *testing.T, s *Sender, dir string) <-chan uint16 {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	announcer := portAnnouncer{port: make(chan uint16, 1)}
+	s.announcers = []Announcer{announcer}
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Watch(ctx, 0, dir)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-stopped; err != context.Canceled {
+			t.Errorf("Watch = %v, want context.Canceled", err)
+		}
+	})
+
+	return announcer.port
+}
+
+// nextSession returns the port of the next session of Watch.
+func nextSession(t *testing.T, ports <-chan uint16) uint16 {
+	t.Helper()
+
+	select {
+	case port := <-ports:
+		return port
+	case <-time.After(testTimeout):
+		t.Fatal("Watch announced no session within the test timeout")
+	}
+	return 0
+}
+
+// waitForItem waits until the queue of s holds path in state.
+func waitForItem(t *testing.T, s *Sender, path string, state ItemState) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		for _, item := range s.Queue() {
+			if item.Path == path && item.State == state {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s didn't become %s, the queue holds %+v", path, state, s.Queue())
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	sentDir := filepath.Join(dir, "sent")
+	failed := writeTestFile(t, dir, "a.txt", testContent(64<<10))
+	confirmed := writeTestFile(t, dir, "b.txt", testContent(1<<10))
+	s := newTestSender(t, WithWatchGrace(0), WithSentDir("sent"), WithTransferRetry(retry.Policy{MaxAttempts: 1}))
+	ports := startWatch(t, s, dir)
+
+	// the files present at startup go in sessions of their own, the one
+	// whose transfer breaks off stays in place
+	pull := startPull(t, nextSession(t, ports), func(con net.Conn) net.Conn {
+		return newStallingConn(con, 4<<10, true)
+	})
+	if err := <-pull.done; err == nil {
+		t.Fatal("the receiver whose connection broke off succeeded")
+	}
+	waitForItem(t, s, failed, ItemFailed)
+	if _, err := os.Stat(failed); err != nil {
+		t.Errorf("the unconfirmed file was moved: %v", err)
+	}
+
+	r, destDir := newTestReceiver(t)
+	if _, err := receiveFrom(t, r, nextSession(t, ports), nil); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(destDir, "b.txt"), testContent(1<<10))
+	waitForItem(t, s, confirmed, ItemDone)
+	assertFile(t, filepath.Join(sentDir, "b.txt"), testContent(1<<10))
+
+	// a file written under a temporary name goes once renamed into place
+	late := filepath.Join(dir, "c.txt")
+	writeTestFile(t, dir, "c.txt.part", []byte("written, then renamed"))
+	if err := os.Rename(filepath.Join(dir, "c.txt.part"), late); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := receiveFrom(t, r, nextSession(t, ports), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Name != "c.txt" {
+		t.Errorf("the renamed file arrived as %+v, want c.txt only", stats)
+	}
+	waitForItem(t, s, late, ItemDone)
+	assertFile(t, filepath.Join(sentDir, "c.txt"), []byte("written, then renamed"))
+
+	// every file went out once, and none of them is announced again
+	for _, item := range s.Queue() {
+		if item.Attempts != 1 {
+			t.Errorf("%s went out %d times", item.Path, item.Attempts)
+		}
+	}
+	if n := len(s.Queue()); n != 3 {
+		t.Errorf("%d files were queued, want 3", n)
+	}
+	select {
+	case <-ports:
+		t.Error("Watch announced another session")
+	case <-time.After(2 * watchPoll):
+	}
+}